@@ -0,0 +1,329 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*antiCensorshipResolver)(nil)
+
+// Reasons a lookup was escalated to encrypted DNS by an anti-censorship
+// resolver, reported as the error of EventCensorshipDetected events.
+var (
+	// ErrBogusAnswer is reported when an answer contains a bogus address (eg.
+	// a sinkhole address injected by a censor).
+	ErrBogusAnswer = errors.New("bogus answer")
+	// ErrSuspiciousTimeout is reported when a lookup timed out, eg. because
+	// the censor injected TCP resets or dropped the response.
+	ErrSuspiciousTimeout = errors.New("suspicious timeout")
+	// ErrSuspiciousNotFound is reported when a name wasn't found (only if
+	// EscalateNotFound is enabled).
+	ErrSuspiciousNotFound = errors.New("suspicious not found")
+)
+
+// DefaultBogusPrefixes are the addresses that poisoned answers commonly point
+// to, and that never belong to a public name (the unspecified, "this network"
+// and loopback addresses).
+var DefaultBogusPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+}
+
+// AntiCensorshipConfig is the configuration of an anti-censorship resolver.
+type AntiCensorshipConfig struct {
+	// Local answers lookups before the plain resolver, without any censorship
+	// detection (eg. the hosts file, which may map names to loopback
+	// addresses). Names it doesn't find are looked up using the plain
+	// resolver. By default, IP literals and the system's hosts file.
+	Local Resolver
+	// Plain is the (unencrypted) resolver that is tried after the local
+	// resolver. By default, the system's DNS servers (see
+	// SystemResolverConfig.DNSOnly).
+	Plain Resolver
+	// Encrypted are the encrypted resolvers lookups are escalated to, tried
+	// in order. By default, Google's DNS over TLS and Cloudflare's and Quad9's
+	// DNS over HTTPS (which uses the HTTPS port, so is harder to block).
+	Encrypted []Resolver
+	// DialContext is used to establish connections to the default encrypted
+	// resolvers.
+	DialContext DialContextFunc
+	// BogusPrefixes are the addresses that indicate a poisoned answer.
+	// By default, DefaultBogusPrefixes.
+	BogusPrefixes []netip.Prefix
+	// EscalateNotFound also escalates lookups of names the plain resolver
+	// didn't find (some censors answer NXDOMAIN for blocked names). This
+	// doubles the cost of looking up names that don't exist.
+	// By default, disabled.
+	EscalateNotFound *bool
+	// RetryInterval is how long lookups are sent directly to the encrypted
+	// resolvers after censorship was detected, before the plain resolver is
+	// tried again. By default, 5 minutes.
+	RetryInterval *time.Duration
+	// Events is an optional event distributor, that is notified whenever
+	// censorship is detected (see EventCensorshipDetected).
+	Events *Events
+}
+
+// antiCensorshipResolver is a resolver that escalates lookups that show signs
+// of censorship to encrypted resolvers.
+type antiCensorshipResolver struct {
+	local            Resolver
+	plain            Resolver
+	encrypted        Resolver
+	bogus            []netip.Prefix
+	escalateNotFound bool
+	retryInterval    time.Duration
+	events           *Events
+
+	mu             sync.Mutex
+	escalatedUntil time.Time
+	detections     int64
+}
+
+// AntiCensorship returns a resolver chain for hostile networks. Lookups not
+// answered locally are sent to the plain resolver first, and are escalated to
+// the encrypted resolvers if the answer shows signs of censorship (poisoned
+// answers pointing to bogus addresses, or timeouts caused by injected
+// resets). After censorship is detected, lookups are sent directly to the
+// encrypted resolvers for a while. Detections are published as events, for
+// telemetry.
+func AntiCensorship(conf *AntiCensorshipConfig) (*antiCensorshipResolver, error) {
+	if conf == nil {
+		conf = &AntiCensorshipConfig{}
+	}
+
+	local := conf.Local
+	if local == nil {
+		hosts, err := Hosts(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create hosts resolver: %w", err)
+		}
+		local = Sequential(Literal(), hosts)
+	}
+
+	plain := conf.Plain
+	if plain == nil {
+		var err error
+		plain, err = System(&SystemResolverConfig{DNSOnly: ptr.To(true)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create system resolver: %w", err)
+		}
+	}
+
+	encrypted := conf.Encrypted
+	if len(encrypted) == 0 {
+		var err error
+		encrypted, err = defaultAntiCensorshipResolvers(conf.DialContext)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bogus := conf.BogusPrefixes
+	if bogus == nil {
+		bogus = DefaultBogusPrefixes
+	}
+
+	retryInterval := 5 * time.Minute
+	if conf.RetryInterval != nil {
+		retryInterval = *conf.RetryInterval
+	}
+
+	if retryInterval < 0 {
+		return nil, fmt.Errorf("retry interval must not be negative")
+	}
+
+	return &antiCensorshipResolver{
+		local:            local,
+		plain:            plain,
+		encrypted:        Sequential(encrypted...),
+		bogus:            bogus,
+		escalateNotFound: conf.EscalateNotFound != nil && *conf.EscalateNotFound,
+		retryInterval:    retryInterval,
+		events:           conf.Events,
+	}, nil
+}
+
+// defaultAntiCensorshipResolvers returns the default encrypted resolvers of
+// an anti-censorship resolver.
+func defaultAntiCensorshipResolvers(dialContext DialContextFunc) ([]Resolver, error) {
+	confs := []DNSResolverConfig{
+		{
+			Server:    netip.MustParseAddrPort("8.8.8.8:853"),
+			Transport: ptr.To(DNSTransportTLS),
+			TLSConfig: &tls.Config{ServerName: "dns.google"},
+		},
+		{
+			Server:    netip.MustParseAddrPort("1.1.1.1:443"),
+			Transport: ptr.To(DNSTransportHTTPS),
+			URL:       "https://cloudflare-dns.com/dns-query",
+		},
+		{
+			Server:    netip.MustParseAddrPort("9.9.9.9:443"),
+			Transport: ptr.To(DNSTransportHTTPS),
+			URL:       "https://dns.quad9.net/dns-query",
+		},
+	}
+
+	resolvers := make([]Resolver, 0, len(confs))
+	for _, conf := range confs {
+		conf.DialContext = dialContext
+
+		res, err := DNS(conf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create encrypted resolver: %w", err)
+		}
+		resolvers = append(resolvers, res)
+	}
+
+	return resolvers, nil
+}
+
+func (r *antiCensorshipResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, err := r.local.LookupNetIP(ctx, network, host)
+	if err == nil || !isNotFound(err) {
+		return addrs, err
+	}
+
+	if r.escalated() {
+		return r.encrypted.LookupNetIP(ctx, network, host)
+	}
+
+	addrs, err = r.plain.LookupNetIP(ctx, network, host)
+
+	reason := r.detect(ctx, err)
+	if reason == nil && err == nil {
+		for _, addr := range addrs {
+			if r.isBogus(addr) {
+				reason = fmt.Errorf("%w %s", ErrBogusAnswer, addr)
+				break
+			}
+		}
+	}
+
+	if reason == nil {
+		return addrs, err
+	}
+
+	r.detected(host, reason)
+
+	return r.encrypted.LookupNetIP(ctx, network, host)
+}
+
+func (r *antiCensorshipResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	names, err := lookupAddr(ctx, r.local, addr)
+	if err == nil || !isNotFound(err) {
+		return names, err
+	}
+
+	if r.escalated() {
+		return lookupAddr(ctx, r.encrypted, addr)
+	}
+
+	names, err = lookupAddr(ctx, r.plain, addr)
+
+	reason := r.detect(ctx, err)
+	if reason == nil {
+		return names, err
+	}
+
+	r.detected(addr, reason)
+
+	return lookupAddr(ctx, r.encrypted, addr)
+}
+
+func (r *antiCensorshipResolver) Describe() Description {
+	r.mu.Lock()
+	detections := r.detections
+	escalated := time.Now().Before(r.escalatedUntil)
+	r.mu.Unlock()
+
+	return Description{
+		Type: "anti-censorship",
+		Attributes: map[string]string{
+			"detections": strconv.FormatInt(detections, 10),
+			"escalated":  strconv.FormatBool(escalated),
+		},
+		Children: []Description{Describe(r.local), Describe(r.plain), Describe(r.encrypted)},
+	}
+}
+
+// detect returns the reason the error of a plain lookup is a sign of
+// censorship, or nil if it isn't.
+func (r *antiCensorshipResolver) detect(ctx context.Context, err error) error {
+	// Lookups the caller gave up on are not a sign of censorship.
+	if err == nil || ctx.Err() != nil {
+		return nil
+	}
+
+	if isNotFound(err) {
+		if r.escalateNotFound {
+			return ErrSuspiciousNotFound
+		}
+		return nil
+	}
+
+	if isTimeout(err) {
+		return ErrSuspiciousTimeout
+	}
+
+	return nil
+}
+
+// isBogus returns true if addr is a bogus address.
+func (r *antiCensorshipResolver) isBogus(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range r.bogus {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// escalated returns true if lookups are currently being sent directly to the
+// encrypted resolvers.
+func (r *antiCensorshipResolver) escalated() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return time.Now().Before(r.escalatedUntil)
+}
+
+// detected records that censorship was detected by a lookup of name.
+func (r *antiCensorshipResolver) detected(name string, reason error) {
+	r.mu.Lock()
+	r.detections++
+	r.escalatedUntil = time.Now().Add(r.retryInterval)
+	r.mu.Unlock()
+
+	r.events.Publish(Event{
+		Type:     EventCensorshipDetected,
+		Resolver: ptr.To(Describe(r.plain)),
+		Name:     name,
+		Err: &net.DNSError{
+			Err:  reason.Error(),
+			Name: name,
+		},
+	})
+}
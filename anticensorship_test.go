@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAntiCensorshipResolver(t *testing.T) {
+	honest := netip.MustParseAddr("93.184.215.14")
+	sinkhole := netip.MustParseAddr("0.0.0.0")
+
+	type chain struct {
+		res       resolver.Resolver
+		local     *resolvertest.Fake
+		plain     *resolvertest.Fake
+		encrypted *resolvertest.Fake
+		events    *[]resolver.Event
+	}
+
+	newChain := func(t *testing.T, conf resolver.AntiCensorshipConfig) *chain {
+		c := &chain{
+			local:     resolvertest.NewFake(),
+			plain:     resolvertest.NewFake(),
+			encrypted: resolvertest.NewFake(),
+			events:    &[]resolver.Event{},
+		}
+		c.encrypted.SetAddrs("example.com", honest)
+
+		events := resolver.NewEvents()
+		events.Subscribe(func(event resolver.Event) {
+			*c.events = append(*c.events, event)
+		})
+
+		conf.Local = c.local
+		conf.Plain = c.plain
+		conf.Encrypted = []resolver.Resolver{c.encrypted}
+		conf.Events = events
+
+		var err error
+		c.res, err = resolver.AntiCensorship(&conf)
+		require.NoError(t, err)
+
+		return c
+	}
+
+	t.Run("Uncensored", func(t *testing.T) {
+		c := newChain(t, resolver.AntiCensorshipConfig{})
+		c.plain.SetAddrs("example.com", honest)
+
+		addrs, err := c.res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{honest}, addrs)
+
+		require.Empty(t, c.encrypted.Calls())
+		require.Empty(t, *c.events)
+	})
+
+	t.Run("Bogus Answer", func(t *testing.T) {
+		c := newChain(t, resolver.AntiCensorshipConfig{})
+		c.plain.SetAddrs("example.com", sinkhole)
+
+		addrs, err := c.res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{honest}, addrs)
+
+		require.Len(t, *c.events, 1)
+		require.Equal(t, resolver.EventCensorshipDetected, (*c.events)[0].Type)
+		require.Equal(t, "example.com", (*c.events)[0].Name)
+		require.ErrorContains(t, (*c.events)[0].Err, resolver.ErrBogusAnswer.Error())
+
+		// Subsequent lookups go straight to the encrypted resolvers.
+		_, err = c.res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+
+		require.Len(t, c.plain.Calls(), 1)
+		require.Len(t, c.encrypted.Calls(), 2)
+
+		require.Equal(t, "true", resolver.Describe(c.res).Attributes["escalated"])
+		require.Equal(t, "1", resolver.Describe(c.res).Attributes["detections"])
+	})
+
+	t.Run("Suspicious Timeout", func(t *testing.T) {
+		c := newChain(t, resolver.AntiCensorshipConfig{
+			RetryInterval: ptr.To(time.Duration(0)),
+		})
+		c.plain.Script("example.com", dns.TypeA, resolvertest.Response{Err: resolvertest.Timeout("example.com")})
+
+		addrs, err := c.res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{honest}, addrs)
+
+		require.Len(t, *c.events, 1)
+		require.ErrorContains(t, (*c.events)[0].Err, resolver.ErrSuspiciousTimeout.Error())
+
+		// Without a retry interval, the plain resolver is tried again.
+		_, err = c.res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Len(t, c.plain.Calls(), 2)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		c := newChain(t, resolver.AntiCensorshipConfig{})
+
+		_, err := c.res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.Error(t, err)
+
+		require.Empty(t, c.encrypted.Calls())
+		require.Empty(t, *c.events)
+	})
+
+	t.Run("Escalate Not Found", func(t *testing.T) {
+		c := newChain(t, resolver.AntiCensorshipConfig{
+			EscalateNotFound: ptr.To(true),
+		})
+
+		addrs, err := c.res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{honest}, addrs)
+
+		require.Len(t, *c.events, 1)
+		require.ErrorContains(t, (*c.events)[0].Err, resolver.ErrSuspiciousNotFound.Error())
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		c := newChain(t, resolver.AntiCensorshipConfig{})
+		c.local.SetAddrs("example.com", netip.MustParseAddr("127.0.0.1"))
+
+		addrs, err := c.res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, addrs)
+
+		require.Empty(t, c.plain.Calls())
+		require.Empty(t, *c.events)
+	})
+}
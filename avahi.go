@@ -0,0 +1,340 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/dbus"
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*AvahiResolver)(nil)
+
+// Avahi D-Bus API constants (see avahi-common/defs.h).
+const (
+	avahiService   = "org.freedesktop.Avahi"
+	avahiInterface = "org.freedesktop.Avahi.Server"
+
+	avahiIfUnspec    int32 = -1
+	avahiProtoInet   int32 = 0
+	avahiProtoInet6  int32 = 1
+	avahiProtoUnspec int32 = -1
+)
+
+// AvahiResolverConfig is the configuration for an Avahi resolver.
+type AvahiResolverConfig struct {
+	// SocketPath is the path of the D-Bus system bus socket.
+	// By default, "/var/run/dbus/system_bus_socket".
+	SocketPath string
+	// Domains are the domains whose names are resolved using Avahi, names in
+	// other domains are not found (so that the lookup can be passed on to
+	// other resolvers). By default, only ".local" names are resolved.
+	Domains []string
+	// DialContext is an optional dialer used to probe source addresses when
+	// ordering the returned addresses.
+	DialContext DialContextFunc
+	// SourceAddrProvider is an optional provider of the source addresses used
+	// when sorting addresses according to RFC 6724. By default, source
+	// addresses are probed using DialContext (if provided) or selected from
+	// the host's interface addresses.
+	SourceAddrProvider SourceAddrProvider
+	// AddressOrder is the policy used to order the returned addresses.
+	// By default, addresses are sorted according to RFC 6724.
+	AddressOrder *AddressOrder
+	// PolicyTable is an optional RFC 6724 address selection policy table used
+	// when sorting addresses. By default, the table from RFC 6724 is used.
+	PolicyTable []PolicyTableEntry
+}
+
+// AvahiResolver is a resolver that resolves multicast DNS names using the
+// D-Bus API of the Avahi daemon. Unlike querying multicast DNS directly, this
+// requires no multicast sockets (which are often not permitted in containers)
+// and shares the daemon's cache.
+type AvahiResolver struct {
+	socketPath string
+	domains    []string
+	sorter     addrSorter
+
+	mu sync.Mutex
+	// conn is the connection to the message bus, dialed on first use (and
+	// redialed if it breaks).
+	conn *dbus.Conn
+}
+
+// Avahi returns a resolver that resolves names using the Avahi daemon. Use
+// Available to check whether the daemon is running, eg. to decide whether to
+// include the resolver in a chain.
+func Avahi(conf *AvahiResolverConfig) (*AvahiResolver, error) {
+	conf, err := defaults.WithDefaults(conf, &AvahiResolverConfig{
+		SocketPath:   dbus.SystemBusSocket,
+		Domains:      []string{"local."},
+		AddressOrder: ptr.To(AddressOrderRFC6724),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to avahi resolver config: %w", err)
+	}
+
+	if err := conf.AddressOrder.validate(); err != nil {
+		return nil, err
+	}
+
+	domains := make([]string, len(conf.Domains))
+	for i, domain := range conf.Domains {
+		domains[i] = dns.CanonicalName(domain)
+	}
+
+	return &AvahiResolver{
+		socketPath: conf.SocketPath,
+		domains:    domains,
+		sorter: newAddrSorter(*conf.AddressOrder,
+			sourceAddrProviderFor(conf.SourceAddrProvider, conf.DialContext), conf.PolicyTable),
+	}, nil
+}
+
+// Available reports whether the Avahi daemon is reachable.
+func (r *AvahiResolver) Available(ctx context.Context) bool {
+	_, err := r.call(ctx, "GetVersionString")
+	return err == nil
+}
+
+// Close closes the connection to the message bus (if any).
+func (r *AvahiResolver) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+
+	err := r.conn.Close()
+	r.conn = nil
+
+	return err
+}
+
+func (r *AvahiResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	dnsErr := &net.DNSError{
+		Name: host,
+	}
+
+	network, supported := ipNetwork(network)
+	if !supported {
+		return nil, extendDNSError(dnsErr, net.DNSError{
+			Err: ErrUnsupportedNetwork.Error(),
+		})
+	}
+
+	name := dns.CanonicalName(host)
+	if !r.inDomains(name) {
+		return nil, extendDNSError(dnsErr, net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			IsNotFound: true,
+		})
+	}
+
+	var protocols []int32
+	switch network {
+	case "ip4":
+		protocols = []int32{avahiProtoInet}
+	case "ip6":
+		protocols = []int32{avahiProtoInet6}
+	default:
+		// Avahi only returns a single address per query.
+		protocols = []int32{avahiProtoInet, avahiProtoInet6}
+	}
+
+	var wg sync.WaitGroup
+	addrs := make([]netip.Addr, len(protocols))
+	errs := make([]error, len(protocols))
+	for i, protocol := range protocols {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs[i], errs[i] = r.resolveHostName(ctx, name, protocol)
+		}()
+	}
+	wg.Wait()
+
+	var found []netip.Addr
+	for i, addr := range addrs {
+		if errs[i] == nil {
+			found = append(found, addr)
+		}
+	}
+
+	if len(found) == 0 {
+		err := errors.Join(errs...)
+		if isNotFound(err) {
+			return nil, extendDNSError(dnsErr, net.DNSError{
+				Err:        ErrNoSuchHost.Error(),
+				IsNotFound: true,
+			})
+		}
+
+		return nil, extendDNSError(dnsErr, net.DNSError{
+			Err:         err.Error(),
+			IsTimeout:   isTimeout(err),
+			IsTemporary: true,
+		})
+	}
+
+	if network != "ip4" {
+		r.sorter.sort(ctx, found)
+	}
+
+	return found, nil
+}
+
+// LookupAddr performs a reverse lookup of addr using Avahi.
+func (r *AvahiResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:  "unrecognized address",
+			Name: addr,
+		}
+	}
+
+	body, err := r.call(ctx, "ResolveAddress", avahiIfUnspec, avahiProtoUnspec,
+		ip.Unmap().WithZone("").String(), uint32(0))
+	if err != nil {
+		return nil, avahiError(addr, err)
+	}
+
+	// Reply: interface, protocol, aprotocol, address, name, flags.
+	if len(body) != 6 {
+		return nil, avahiError(addr, ErrServerMisbehaving)
+	}
+
+	name, ok := body[4].(string)
+	if !ok || name == "" {
+		return nil, avahiError(addr, ErrServerMisbehaving)
+	}
+
+	return []string{dns.Fqdn(name)}, nil
+}
+
+// resolveHostName resolves the address of name for the given protocol.
+func (r *AvahiResolver) resolveHostName(ctx context.Context, name string, protocol int32) (netip.Addr, error) {
+	body, err := r.call(ctx, "ResolveHostName", avahiIfUnspec, avahiProtoUnspec,
+		strings.TrimSuffix(name, "."), protocol, uint32(0))
+	if err != nil {
+		return netip.Addr{}, avahiError(name, err)
+	}
+
+	// Reply: interface, protocol, name, aprotocol, address, flags.
+	if len(body) != 6 {
+		return netip.Addr{}, avahiError(name, ErrServerMisbehaving)
+	}
+
+	ifIndex, _ := body[0].(int32)
+	address, _ := body[4].(string)
+
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return netip.Addr{}, avahiError(name, ErrServerMisbehaving)
+	}
+
+	// Link-local addresses are only usable on the interface they were
+	// resolved on.
+	if addr.Is6() && addr.IsLinkLocalUnicast() && addr.Zone() == "" && ifIndex > 0 {
+		zone := strconv.Itoa(int(ifIndex))
+		if iface, err := net.InterfaceByIndex(int(ifIndex)); err == nil {
+			zone = iface.Name
+		}
+		addr = addr.WithZone(zone)
+	}
+
+	return addr, nil
+}
+
+// call calls a method of the Avahi server.
+func (r *AvahiResolver) call(ctx context.Context, method string, args ...any) ([]any, error) {
+	conn, err := r.bus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.Call(ctx, avahiService, "/", avahiInterface, method, args...)
+}
+
+// bus returns the connection to the message bus, dialing it if there's no
+// usable connection.
+func (r *AvahiResolver) bus(ctx context.Context) (*dbus.Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn != nil && r.conn.Err() == nil {
+		return r.conn, nil
+	}
+
+	conn, err := dbus.Dial(ctx, r.socketPath)
+	if err != nil {
+		return nil, err
+	}
+	r.conn = conn
+
+	return conn, nil
+}
+
+func (r *AvahiResolver) inDomains(name string) bool {
+	for _, domain := range r.domains {
+		if dns.IsSubDomain(domain, name) && name != domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// avahiError converts the error of an Avahi call into a DNS error.
+func avahiError(name string, err error) *net.DNSError {
+	var dbusErr *dbus.Error
+	if errors.As(err, &dbusErr) {
+		switch dbusErr.Name {
+		// Multicast DNS has no negative responses, so names that don't exist
+		// time out.
+		case "org.freedesktop.Avahi.NotFoundError", "org.freedesktop.Avahi.TimeoutError":
+			return &net.DNSError{
+				Err:        ErrNoSuchHost.Error(),
+				Name:       name,
+				IsNotFound: true,
+			}
+		}
+	}
+
+	return &net.DNSError{
+		Err:         err.Error(),
+		Name:        name,
+		IsTimeout:   isTimeout(err),
+		IsTemporary: true,
+	}
+}
+
+func (r *AvahiResolver) Describe() Description {
+	return Description{
+		Type: "avahi",
+		Attributes: map[string]string{
+			"socket-path":   r.socketPath,
+			"domains":       strings.Join(r.domains, ","),
+			"address-order": string(r.sorter.order),
+		},
+	}
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/internal/dbus"
+	"github.com/noisysockets/resolver/internal/dbus/dbustest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvahiResolver(t *testing.T) {
+	hosts := map[string]map[int32]string{
+		"printer.local": {
+			0: "192.168.1.20",
+			1: "fd00::20",
+		},
+		"nas.local": {
+			0: "192.168.1.30",
+		},
+	}
+
+	srv := dbustest.NewServer(t, func(call *dbus.Message) ([]any, *dbus.Error) {
+		switch call.Member {
+		case "GetVersionString":
+			return []any{"avahi 0.8"}, nil
+		case "ResolveHostName":
+			name := call.Body[2].(string)
+			protocol := call.Body[3].(int32)
+
+			address, ok := hosts[name][protocol]
+			if !ok {
+				return nil, &dbus.Error{Name: "org.freedesktop.Avahi.TimeoutError", Message: "Timeout reached"}
+			}
+
+			return []any{int32(2), protocol, name, protocol, address, uint32(0)}, nil
+		case "ResolveAddress":
+			if call.Body[2].(string) != "192.168.1.30" {
+				return nil, &dbus.Error{Name: "org.freedesktop.Avahi.TimeoutError", Message: "Timeout reached"}
+			}
+
+			return []any{int32(2), int32(0), int32(0), "192.168.1.30", "nas.local", uint32(0)}, nil
+		default:
+			return nil, &dbus.Error{Name: "org.freedesktop.DBus.Error.UnknownMethod"}
+		}
+	})
+
+	res, err := resolver.Avahi(&resolver.AvahiResolverConfig{
+		SocketPath:   srv.Path(),
+		AddressOrder: ptr.To(resolver.AddressOrderNone),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, res.Close())
+	})
+
+	ctx := context.Background()
+
+	require.True(t, res.Available(ctx))
+
+	t.Run("LookupNetIP", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip", "printer.local")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("192.168.1.20"),
+			netip.MustParseAddr("fd00::20"),
+		}, addrs)
+
+		addrs, err = res.LookupNetIP(ctx, "ip6", "printer.local")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("fd00::20")}, addrs)
+
+		// Only one address family resolves.
+		addrs, err = res.LookupNetIP(ctx, "ip", "nas.local.")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("192.168.1.30")}, addrs)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		for _, host := range []string{"missing.local", "example.com"} {
+			_, err := res.LookupNetIP(ctx, "ip", host)
+			require.Error(t, err)
+
+			var dnsErr *net.DNSError
+			require.ErrorAs(t, err, &dnsErr)
+			require.True(t, dnsErr.IsNotFound, host)
+		}
+	})
+
+	t.Run("LookupAddr", func(t *testing.T) {
+		name, err := resolver.ReverseHostname(ctx, res, netip.MustParseAddr("192.168.1.30"))
+		require.NoError(t, err)
+
+		require.Equal(t, "nas.local.", name)
+	})
+
+	t.Run("Connection Reuse", func(t *testing.T) {
+		// Every lookup so far shared a single connection.
+		require.Equal(t, 1, srv.Connections())
+
+		require.NoError(t, res.Close())
+
+		_, err := res.LookupNetIP(ctx, "ip", "printer.local")
+		require.NoError(t, err)
+
+		require.Equal(t, 2, srv.Connections())
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		res, err := resolver.Avahi(&resolver.AvahiResolverConfig{
+			SocketPath: filepath.Join(t.TempDir(), "missing"),
+		})
+		require.NoError(t, err)
+
+		require.False(t, res.Available(ctx))
+
+		_, err = res.LookupNetIP(ctx, "ip", "printer.local")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.False(t, dnsErr.IsNotFound)
+		require.True(t, dnsErr.IsTemporary)
+	})
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+// BatchConfig is the configuration for a batch lookup.
+type BatchConfig struct {
+	// MaxConcurrency is the maximum number of hosts looked up concurrently.
+	// By default, 16.
+	MaxConcurrency *int
+	// QueryLimiter is an optional limiter that is shared with other batches
+	// (or resolvers), limiting the total number of concurrent lookups.
+	QueryLimiter *QueryLimiter
+}
+
+// BatchResult is the result of looking up a single host of a batch.
+type BatchResult struct {
+	// Addrs are the addresses of the host.
+	Addrs []netip.Addr
+	// Err is the error that occurred while looking up the host, if any.
+	Err error
+}
+
+// LookupNetIPBatch looks up many hosts concurrently using the resolver (eg.
+// to warm a cache), returning the result of each host. Hosts that only differ
+// in case (or a trailing dot) are looked up once, and share the same result.
+// Lookups are sent through the same resolver, so connections (eg. DNS over
+// TLS or HTTPS) are shared between them.
+func LookupNetIPBatch(ctx context.Context, resolver Resolver, network string, hosts []string, conf *BatchConfig) (map[string]BatchResult, error) {
+	conf, err := defaults.WithDefaults(conf, &BatchConfig{
+		MaxConcurrency: ptr.To(16),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to batch config: %w", err)
+	}
+
+	if *conf.MaxConcurrency < 1 {
+		return nil, fmt.Errorf("max concurrency must be at least 1")
+	}
+
+	if _, ok := ipNetwork(network); !ok {
+		return nil, &net.DNSError{
+			Err: ErrUnsupportedNetwork.Error(),
+		}
+	}
+
+	// Group the hosts by their canonical name, preserving the order of first
+	// occurrence.
+	var names []string
+	byName := make(map[string][]string)
+	for _, host := range hosts {
+		name := dns.CanonicalName(host)
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], host)
+	}
+
+	results := make(map[string]BatchResult, len(hosts))
+	var resultsMu sync.Mutex
+
+	work := make(chan string)
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(*conf.MaxConcurrency, len(names)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for name := range work {
+				result := lookupBatchHost(ctx, resolver, conf.QueryLimiter, network, byName[name][0])
+
+				resultsMu.Lock()
+				for _, host := range byName[name] {
+					results[host] = result
+				}
+				resultsMu.Unlock()
+			}
+		}()
+	}
+
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+
+	wg.Wait()
+
+	return results, nil
+}
+
+// lookupBatchHost looks up a single host of a batch, holding a slot of the
+// limiter (if any) for the duration of the lookup.
+func lookupBatchHost(ctx context.Context, resolver Resolver, limiter *QueryLimiter, network, host string) BatchResult {
+	if limiter != nil {
+		if err := limiter.acquire(ctx); err != nil {
+			return BatchResult{Err: &net.DNSError{
+				Err:         err.Error(),
+				Name:        host,
+				IsTimeout:   isTimeout(err),
+				IsTemporary: true,
+			}}
+		}
+		defer limiter.release()
+	}
+
+	addrs, err := resolver.LookupNetIP(ctx, network, host)
+	return BatchResult{Addrs: addrs, Err: err}
+}
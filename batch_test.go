@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupNetIPBatch(t *testing.T) {
+	t.Run("Results", func(t *testing.T) {
+		fake := resolvertest.NewFake()
+		fake.SetAddrs("a.example.com", netip.MustParseAddr("10.0.0.1"))
+		fake.SetAddrs("b.example.com", netip.MustParseAddr("10.0.0.2"))
+
+		results, err := resolver.LookupNetIPBatch(context.Background(), fake, "ip4",
+			[]string{"a.example.com", "b.example.com", "A.Example.com.", "missing.example.com"}, nil)
+		require.NoError(t, err)
+
+		require.Len(t, results, 4)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, results["a.example.com"].Addrs)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.2")}, results["b.example.com"].Addrs)
+		require.Equal(t, results["a.example.com"], results["A.Example.com."])
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, results["missing.example.com"].Err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+
+		// Overlapping names are only looked up once.
+		require.Len(t, fake.Calls(), 3)
+	})
+
+	t.Run("Max Concurrency", func(t *testing.T) {
+		var inFlight, maxInFlight atomic.Int32
+		res := resolver.Func(func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+
+			for {
+				max := maxInFlight.Load()
+				if n <= max || maxInFlight.CompareAndSwap(max, n) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			return []netip.Addr{netip.MustParseAddr("10.0.0.1")}, nil
+		})
+
+		var hosts []string
+		for i := 0; i < 20; i++ {
+			hosts = append(hosts, fmt.Sprintf("host%d.example.com", i))
+		}
+
+		results, err := resolver.LookupNetIPBatch(context.Background(), res, "ip", hosts, &resolver.BatchConfig{
+			MaxConcurrency: ptr.To(4),
+		})
+		require.NoError(t, err)
+		require.Len(t, results, len(hosts))
+
+		require.LessOrEqual(t, maxInFlight.Load(), int32(4))
+	})
+
+	t.Run("Shared Limiter", func(t *testing.T) {
+		limiter := resolver.NewQueryLimiter(2)
+
+		var inFlight, maxInFlight atomic.Int32
+		res := resolver.Func(func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+
+			for {
+				max := maxInFlight.Load()
+				if n <= max || maxInFlight.CompareAndSwap(max, n) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			return []netip.Addr{netip.MustParseAddr("10.0.0.1")}, nil
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				var hosts []string
+				for j := 0; j < 5; j++ {
+					hosts = append(hosts, fmt.Sprintf("host%d-%d.example.com", i, j))
+				}
+
+				results, err := resolver.LookupNetIPBatch(context.Background(), res, "ip", hosts, &resolver.BatchConfig{
+					QueryLimiter: limiter,
+				})
+				require.NoError(t, err)
+				for _, result := range results {
+					require.NoError(t, result.Err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		require.LessOrEqual(t, maxInFlight.Load(), int32(2))
+	})
+
+	t.Run("Invalid Config", func(t *testing.T) {
+		_, err := resolver.LookupNetIPBatch(context.Background(), resolvertest.NewFake(), "ip", nil, &resolver.BatchConfig{
+			MaxConcurrency: ptr.To(0),
+		})
+		require.Error(t, err)
+
+		_, err = resolver.LookupNetIPBatch(context.Background(), resolvertest.NewFake(), "unix", nil, nil)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// boundDialer returns a dialer that binds connections to the named network
+// interface (if not empty) and/or the local address (if valid). This allows
+// queries to be forced out of a specific interface, eg. in split tunnel VPN
+// setups.
+func boundDialer(iface string, localAddr netip.Addr) DialContextFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		d := &net.Dialer{}
+
+		if localAddr.IsValid() {
+			switch network {
+			case "udp", "udp4", "udp6":
+				d.LocalAddr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(localAddr, 0))
+			default:
+				d.LocalAddr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(localAddr, 0))
+			}
+		}
+
+		if iface != "" {
+			d.Control = func(network, _ string, c syscall.RawConn) error {
+				var bindErr error
+				if err := c.Control(func(fd uintptr) {
+					bindErr = bindToInterface(fd, network, iface)
+				}); err != nil {
+					return err
+				}
+				return bindErr
+			}
+		}
+
+		return d.DialContext(ctx, network, address)
+	}
+}
@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToInterfaceSupported is true if sockets can be bound to an interface.
+const bindToInterfaceSupported = true
+
+// bindToInterface binds the socket to the named interface (IP_BOUND_IF or
+// IPV6_BOUND_IF depending on the address family).
+func bindToInterface(fd uintptr, network, iface string) error {
+	// The interface is looked up on every dial, as its index changes if it is
+	// recreated (eg. when a VPN reconnects).
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %q: %w", iface, err)
+	}
+
+	if strings.HasSuffix(network, "6") {
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, ifi.Index)
+	} else {
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BOUND_IF, ifi.Index)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bind to interface %q: %w", iface, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToInterfaceSupported is true if sockets can be bound to an interface.
+const bindToInterfaceSupported = true
+
+// bindToInterface binds the socket to the named interface (SO_BINDTODEVICE).
+func bindToInterface(fd uintptr, _, iface string) error {
+	if err := unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface); err != nil {
+		return fmt.Errorf("failed to bind to interface %q: %w", iface, err)
+	}
+
+	return nil
+}
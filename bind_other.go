@@ -0,0 +1,25 @@
+//go:build !linux && !darwin
+
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"errors"
+)
+
+// bindToInterfaceSupported is true if sockets can be bound to an interface.
+const bindToInterfaceSupported = false
+
+// bindToInterface is not supported on this platform, bind to the interface's
+// address instead.
+func bindToInterface(_ uintptr, _, _ string) error {
+	return errors.New("binding to an interface is not supported on this platform")
+}
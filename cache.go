@@ -0,0 +1,370 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"hash/maphash"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*cacheResolver)(nil)
+
+// CacheResolverConfig is the configuration for a cache resolver.
+type CacheResolverConfig struct {
+	// Size is the maximum number of entries in the cache, the least recently
+	// used entries are evicted first. By default, 1024 entries are cached.
+	Size *int
+	// TTL is how long successful lookups are cached. As the Resolver interface
+	// doesn't expose record TTLs, a fixed TTL is used. By default, 1 minute.
+	TTL *time.Duration
+	// NegativeTTL is how long not found (NXDOMAIN) results are cached.
+	// By default, 5 seconds. Setting this to 0 disables negative caching.
+	NegativeTTL *time.Duration
+	// SnapshotPath is the optional path of a file the cache is persisted to,
+	// so that short lived processes and frequently restarting daemons start
+	// with a warm cache. The snapshot is loaded when the cache is created
+	// (a missing or corrupt snapshot results in an empty cache) and written
+	// periodically and when the cache is closed. Entries keep their original
+	// expiry time across restarts.
+	SnapshotPath string
+	// SnapshotInterval is how often the cache is persisted to SnapshotPath.
+	// By default, 5 minutes. Setting this to 0 disables periodic snapshots,
+	// the cache is then only persisted when it is closed.
+	SnapshotInterval *time.Duration
+	// Shards is the number of independently locked partitions the cache is
+	// split into, reducing lock contention at high query rates. Each shard
+	// evicts its own least recently used entries. By default, one shard per
+	// 64 entries is used, up to a maximum of 16 shards.
+	Shards *int
+	// Events is an optional event distributor, that is notified when the
+	// cache is flushed.
+	Events *Events
+}
+
+// maxDefaultCacheShards is the maximum number of shards used by default.
+const maxDefaultCacheShards = 16
+
+// minCacheShardSize is the minimum number of entries per shard used by
+// default, smaller shards make least recently used eviction too inaccurate.
+const minCacheShardSize = 64
+
+type cacheKey struct {
+	network string
+	name    string
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	addrs    []netip.Addr
+	notFound bool
+	expires  time.Time
+}
+
+// cacheShard is an independently locked partition of the cache, with its own
+// least recently used eviction order.
+type cacheShard struct {
+	mu      sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[cacheKey]*list.Element
+}
+
+// cacheResolver is a resolver that caches the results of another resolver.
+type cacheResolver struct {
+	resolver    Resolver
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	seed   maphash.Seed
+	shards []*cacheShard
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	events *Events
+
+	snapshotPath string
+	stop         chan struct{}
+	stopOnce     sync.Once
+	wg           sync.WaitGroup
+}
+
+// Cache returns a resolver that caches the results of the provided resolver.
+// Temporary failures are never cached.
+func Cache(resolver Resolver, conf *CacheResolverConfig) (*cacheResolver, error) {
+	// Resolved before applying defaults, as the default depends on the size.
+	var shards *int
+	if conf != nil {
+		shards = conf.Shards
+	}
+
+	conf, err := defaults.WithDefaults(conf, &CacheResolverConfig{
+		Size:             ptr.To(1024),
+		TTL:              ptr.To(time.Minute),
+		NegativeTTL:      ptr.To(5 * time.Second),
+		SnapshotInterval: ptr.To(5 * time.Minute),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to cache resolver config: %w", err)
+	}
+
+	if *conf.Size < 0 || *conf.TTL < 0 || *conf.NegativeTTL < 0 || *conf.SnapshotInterval < 0 {
+		return nil, fmt.Errorf("cache size, ttls, and snapshot interval must not be negative")
+	}
+
+	if shards == nil {
+		shards = ptr.To(min(max(*conf.Size/minCacheShardSize, 1), maxDefaultCacheShards))
+	}
+
+	if *shards < 1 || (*conf.Size > 0 && *shards > *conf.Size) {
+		return nil, fmt.Errorf("cache shards must be positive and not exceed the cache size")
+	}
+
+	r := &cacheResolver{
+		resolver:     resolver,
+		size:         *conf.Size,
+		ttl:          *conf.TTL,
+		negativeTTL:  *conf.NegativeTTL,
+		seed:         maphash.MakeSeed(),
+		shards:       make([]*cacheShard, *shards),
+		snapshotPath: conf.SnapshotPath,
+		stop:         make(chan struct{}),
+		events:       conf.Events,
+	}
+
+	// Distribute the capacity evenly, so the shard sizes add up to the size.
+	for i := range r.shards {
+		size := r.size / *shards
+		if i < r.size%*shards {
+			size++
+		}
+
+		r.shards[i] = &cacheShard{
+			size:    size,
+			lru:     list.New(),
+			entries: make(map[cacheKey]*list.Element),
+		}
+	}
+
+	if r.snapshotPath != "" {
+		r.loadSnapshotFile()
+
+		if *conf.SnapshotInterval > 0 {
+			r.wg.Add(1)
+			go r.snapshotPeriodically(*conf.SnapshotInterval)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *cacheResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	// Equivalent networks (eg. "tcp4" and "ip4") share cache entries.
+	network, _ = ipNetwork(network)
+
+	key := cacheKey{network: network, name: dns.CanonicalName(host)}
+
+	if addrs, notFound, ok := r.get(key); ok {
+		if notFound {
+			return nil, &net.DNSError{
+				Err:        ErrNoSuchHost.Error(),
+				Name:       host,
+				IsNotFound: true,
+			}
+		}
+		return slices.Clone(addrs), nil
+	}
+
+	addrs, err := r.resolver.LookupNetIP(ctx, network, host)
+	if err != nil {
+		if r.negativeTTL > 0 && isNotFound(err) {
+			r.put(key, nil, true, r.negativeTTL)
+		}
+		return nil, err
+	}
+
+	if r.ttl > 0 && len(addrs) > 0 {
+		r.put(key, slices.Clone(addrs), false, r.ttl)
+	}
+
+	return addrs, nil
+}
+
+// LookupAddr performs a reverse lookup, reverse lookups are not cached.
+func (r *cacheResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return lookupAddr(ctx, r.resolver, addr)
+}
+
+// Lookup answers the question, only address (A and AAAA) questions are cached.
+func (r *cacheResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	if q.Type == dns.TypeA || q.Type == dns.TypeAAAA {
+		return lookupBasic(ctx, r, q)
+	}
+
+	return Lookup(ctx, r.resolver, q)
+}
+
+// Flush removes all entries from the cache.
+func (r *cacheResolver) Flush() {
+	for _, shard := range r.shards {
+		shard.mu.Lock()
+		shard.lru.Init()
+		clear(shard.entries)
+		shard.mu.Unlock()
+	}
+
+	r.events.Publish(Event{
+		Type:     EventCacheFlushed,
+		Resolver: ptr.To(r.Describe()),
+	})
+}
+
+// Invalidate removes the entries of the given names from the cache, eg. when
+// notified of changes by a Provider.
+func (r *cacheResolver) Invalidate(names ...string) {
+	for _, name := range names {
+		name = dns.CanonicalName(name)
+
+		for _, network := range []string{"ip", "ip4", "ip6"} {
+			key := cacheKey{network: network, name: name}
+			shard := r.shard(key)
+
+			shard.mu.Lock()
+			if elem, ok := shard.entries[key]; ok {
+				shard.lru.Remove(elem)
+				delete(shard.entries, key)
+			}
+			shard.mu.Unlock()
+		}
+	}
+}
+
+// shard returns the shard that key belongs to.
+func (r *cacheResolver) shard(key cacheKey) *cacheShard {
+	if len(r.shards) == 1 {
+		return r.shards[0]
+	}
+
+	return r.shards[maphash.String(r.seed, key.name)%uint64(len(r.shards))]
+}
+
+func (r *cacheResolver) get(key cacheKey) ([]netip.Addr, bool, bool) {
+	shard := r.shard(key)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	elem, ok := shard.entries[key]
+	if !ok {
+		r.misses.Add(1)
+		return nil, false, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		shard.lru.Remove(elem)
+		delete(shard.entries, key)
+		r.misses.Add(1)
+		return nil, false, false
+	}
+
+	shard.lru.MoveToFront(elem)
+	r.hits.Add(1)
+
+	return entry.addrs, entry.notFound, true
+}
+
+func (r *cacheResolver) put(key cacheKey, addrs []netip.Addr, notFound bool, ttl time.Duration) {
+	if r.size <= 0 {
+		return
+	}
+
+	entry := &cacheEntry{
+		key:      key,
+		addrs:    addrs,
+		notFound: notFound,
+		expires:  time.Now().Add(ttl),
+	}
+
+	shard := r.shard(key)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if elem, ok := shard.entries[key]; ok {
+		elem.Value = entry
+		shard.lru.MoveToFront(elem)
+		return
+	}
+
+	shard.entries[key] = shard.lru.PushFront(entry)
+
+	for shard.lru.Len() > shard.size {
+		oldest := shard.lru.Back()
+		shard.lru.Remove(oldest)
+		delete(shard.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// len returns the number of entries in the cache (including expired entries
+// that haven't been evicted yet).
+func (r *cacheResolver) len() int {
+	var n int
+	for _, shard := range r.shards {
+		shard.mu.Lock()
+		n += shard.lru.Len()
+		shard.mu.Unlock()
+	}
+
+	return n
+}
+
+func (r *cacheResolver) Describe() Description {
+	entries, hits, misses := r.len(), r.hits.Load(), r.misses.Load()
+
+	var hitRate float64
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+
+	d := Description{
+		Type: "cache",
+		Attributes: map[string]string{
+			"size":         strconv.Itoa(r.size),
+			"shards":       strconv.Itoa(len(r.shards)),
+			"ttl":          r.ttl.String(),
+			"negative-ttl": r.negativeTTL.String(),
+			"entries":      strconv.Itoa(entries),
+			"hits":         strconv.FormatInt(hits, 10),
+			"misses":       strconv.FormatInt(misses, 10),
+			"hit-rate":     strconv.FormatFloat(hitRate, 'f', 2, 64),
+		},
+		Children: []Description{Describe(r.resolver)},
+	}
+
+	if r.snapshotPath != "" {
+		d.Attributes["snapshot-path"] = r.snapshotPath
+	}
+
+	return d
+}
@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheSnapshotVersion is the version of the cache snapshot format.
+const cacheSnapshotVersion = 1
+
+type cacheSnapshot struct {
+	Version int                  `json:"version"`
+	Entries []cacheSnapshotEntry `json:"entries"`
+}
+
+type cacheSnapshotEntry struct {
+	Network  string       `json:"network"`
+	Name     string       `json:"name"`
+	Addrs    []netip.Addr `json:"addrs,omitempty"`
+	NotFound bool         `json:"notFound,omitempty"`
+	// Expires is an absolute time, so that TTLs are accounted for across
+	// restarts.
+	Expires time.Time `json:"expires"`
+}
+
+// Save writes a snapshot of the unexpired cache entries to w.
+func (r *cacheResolver) Save(w io.Writer) error {
+	now := time.Now()
+
+	snapshot := cacheSnapshot{
+		Version: cacheSnapshotVersion,
+	}
+
+	for _, shard := range r.shards {
+		shard.mu.Lock()
+		// Most recently used first.
+		for elem := shard.lru.Front(); elem != nil; elem = elem.Next() {
+			entry := elem.Value.(*cacheEntry)
+			if now.After(entry.expires) {
+				continue
+			}
+
+			snapshot.Entries = append(snapshot.Entries, cacheSnapshotEntry{
+				Network:  entry.key.network,
+				Name:     entry.key.name,
+				Addrs:    entry.addrs,
+				NotFound: entry.notFound,
+				Expires:  entry.expires,
+			})
+		}
+		shard.mu.Unlock()
+	}
+
+	return json.NewEncoder(w).Encode(&snapshot)
+}
+
+// Load adds the unexpired entries of a snapshot (previously written by Save)
+// to the cache, entries keep their original expiry time.
+func (r *cacheResolver) Load(rd io.Reader) error {
+	var snapshot cacheSnapshot
+	if err := json.NewDecoder(rd).Decode(&snapshot); err != nil {
+		return fmt.Errorf("failed to decode cache snapshot: %w", err)
+	}
+
+	if snapshot.Version != cacheSnapshotVersion {
+		return fmt.Errorf("unsupported cache snapshot version %d", snapshot.Version)
+	}
+
+	now := time.Now()
+
+	// Insert the least recently used entries first, to preserve the order.
+	for i := len(snapshot.Entries) - 1; i >= 0; i-- {
+		entry := snapshot.Entries[i]
+		if now.After(entry.Expires) || (!entry.NotFound && len(entry.Addrs) == 0) {
+			continue
+		}
+
+		r.put(cacheKey{network: entry.Network, name: entry.Name},
+			entry.Addrs, entry.NotFound, entry.Expires.Sub(now))
+	}
+
+	return nil
+}
+
+// Close stops periodic snapshots and, if a snapshot path is configured,
+// persists the cache a final time.
+func (r *cacheResolver) Close() error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	r.wg.Wait()
+
+	if r.snapshotPath == "" {
+		return nil
+	}
+
+	return r.saveSnapshotFile()
+}
+
+func (r *cacheResolver) snapshotPeriodically(interval time.Duration) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			// Best effort, the final snapshot on close reports any errors.
+			_ = r.saveSnapshotFile()
+		}
+	}
+}
+
+// loadSnapshotFile loads the snapshot file, if it exists. As persistence is
+// only an optimization, an unreadable snapshot results in an empty cache.
+func (r *cacheResolver) loadSnapshotFile() {
+	f, err := os.Open(r.snapshotPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if err := r.Load(f); err != nil {
+		r.Flush()
+	}
+}
+
+// saveSnapshotFile atomically replaces the snapshot file with a snapshot of
+// the cache.
+func (r *cacheResolver) saveSnapshotFile() error {
+	f, err := os.CreateTemp(filepath.Dir(r.snapshotPath), "."+filepath.Base(r.snapshotPath)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache snapshot: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := r.Save(f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), r.snapshotPath); err != nil {
+		return fmt.Errorf("failed to replace cache snapshot: %w", err)
+	}
+
+	return nil
+}
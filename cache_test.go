@@ -0,0 +1,270 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheResolver(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Positive", func(t *testing.T) {
+		upstream := resolvertest.NewFake()
+		upstream.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"))
+
+		res, err := resolver.Cache(upstream, nil)
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			addrs, err := res.LookupNetIP(ctx, "ip4", "example.com")
+			require.NoError(t, err)
+
+			require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+		}
+
+		require.Len(t, upstream.Calls(), 1)
+
+		// Different networks are cached separately.
+		_, err = res.LookupNetIP(ctx, "ip", "example.com")
+		require.NoError(t, err)
+
+		require.Len(t, upstream.Calls(), 2)
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		upstream := resolvertest.NewFake()
+		upstream.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"))
+
+		res, err := resolver.Cache(upstream, &resolver.CacheResolverConfig{
+			TTL: ptr.To(10 * time.Millisecond),
+		})
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		time.Sleep(20 * time.Millisecond)
+
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Len(t, upstream.Calls(), 2)
+	})
+
+	t.Run("Negative", func(t *testing.T) {
+		upstream := resolvertest.NewFake()
+
+		res, err := resolver.Cache(upstream, nil)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			_, err := res.LookupNetIP(ctx, "ip", "missing.example.com")
+			require.Error(t, err)
+
+			var dnsErr *net.DNSError
+			require.ErrorAs(t, err, &dnsErr)
+			require.True(t, dnsErr.IsNotFound)
+		}
+
+		require.Len(t, upstream.Calls(), 1)
+	})
+
+	t.Run("Temporary Failure", func(t *testing.T) {
+		upstream := resolvertest.NewFake()
+		upstream.Script("example.com", dns.TypeA,
+			resolvertest.Response{Err: resolvertest.ServerFailure("example.com")},
+			resolvertest.Response{Addrs: []netip.Addr{netip.MustParseAddr("10.0.0.1")}},
+		)
+
+		res, err := resolver.Cache(upstream, nil)
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.Error(t, err)
+
+		addrs, err := res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		upstream := resolvertest.NewFake()
+		upstream.SetAddrs("a.example.com", netip.MustParseAddr("10.0.0.1"))
+		upstream.SetAddrs("b.example.com", netip.MustParseAddr("10.0.0.2"))
+
+		res, err := resolver.Cache(upstream, &resolver.CacheResolverConfig{
+			Size: ptr.To(1),
+		})
+		require.NoError(t, err)
+
+		for _, host := range []string{"a.example.com", "b.example.com", "a.example.com"} {
+			_, err := res.LookupNetIP(ctx, "ip4", host)
+			require.NoError(t, err)
+		}
+
+		require.Len(t, upstream.Calls(), 3)
+	})
+
+	t.Run("Shards", func(t *testing.T) {
+		upstream := resolvertest.NewFake()
+
+		hosts := make([]string, 64)
+		for i := range hosts {
+			hosts[i] = fmt.Sprintf("host%d.example.com", i)
+			upstream.SetAddrs(hosts[i], netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}))
+		}
+
+		res, err := resolver.Cache(upstream, &resolver.CacheResolverConfig{
+			Size:   ptr.To(4 * len(hosts)),
+			Shards: ptr.To(4),
+		})
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			for _, host := range hosts {
+				_, err := res.LookupNetIP(ctx, "ip4", host)
+				require.NoError(t, err)
+			}
+		}
+
+		require.Len(t, upstream.Calls(), len(hosts))
+		require.Equal(t, "4", res.Describe().Attributes["shards"])
+
+		_, err = resolver.Cache(upstream, &resolver.CacheResolverConfig{
+			Size:   ptr.To(2),
+			Shards: ptr.To(4),
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		snapshotPath := filepath.Join(t.TempDir(), "cache.json")
+
+		upstream := resolvertest.NewFake()
+		upstream.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"))
+
+		res, err := resolver.Cache(upstream, &resolver.CacheResolverConfig{
+			SnapshotPath: snapshotPath,
+		})
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(ctx, "ip4", "missing.example.com")
+		require.Error(t, err)
+
+		require.NoError(t, res.Close())
+
+		// A new cache (eg. after a restart) starts warm.
+		upstream = resolvertest.NewFake()
+
+		res, err = resolver.Cache(upstream, &resolver.CacheResolverConfig{
+			SnapshotPath: snapshotPath,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, res.Close())
+		})
+
+		addrs, err := res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+		_, err = res.LookupNetIP(ctx, "ip4", "missing.example.com")
+		require.Error(t, err)
+
+		require.Empty(t, upstream.Calls())
+	})
+
+	t.Run("Snapshot Expiry", func(t *testing.T) {
+		upstream := resolvertest.NewFake()
+		upstream.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"))
+
+		res, err := resolver.Cache(upstream, &resolver.CacheResolverConfig{
+			TTL: ptr.To(10 * time.Millisecond),
+		})
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, res.Save(&buf))
+
+		time.Sleep(20 * time.Millisecond)
+
+		res, err = resolver.Cache(upstream, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, res.Load(&buf))
+
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Len(t, upstream.Calls(), 2)
+	})
+}
+
+func BenchmarkCacheResolver(b *testing.B) {
+	upstream := resolvertest.NewFake()
+
+	hosts := make([]string, 1024)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("host%d.example.com", i)
+		upstream.SetAddrs(hosts[i], netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}))
+	}
+
+	for _, shards := range []int{1, 16} {
+		// Leave headroom, so that uneven shard sizes don't cause evictions.
+		res, err := resolver.Cache(upstream, &resolver.CacheResolverConfig{
+			Size:   ptr.To(4 * len(hosts)),
+			TTL:    ptr.To(time.Hour),
+			Shards: ptr.To(shards),
+		})
+		require.NoError(b, err)
+
+		// Warm the cache, so that the benchmark measures hits.
+		for _, host := range hosts {
+			_, err := res.LookupNetIP(context.Background(), "ip4", host)
+			require.NoError(b, err)
+		}
+
+		b.Run(fmt.Sprintf("%d Shards", shards), func(b *testing.B) {
+			b.ReportAllocs()
+
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					if _, err := res.LookupNetIP(context.Background(), "ip4", hosts[i%len(hosts)]); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/util/ptr"
+)
+
+// Signs of captive portal DNS interception, reported as the error of
+// EventCaptivePortalDetected events.
+var (
+	// ErrInterceptedAnswer is reported when a probe name resolved to
+	// addresses other than its known addresses (eg. the portal's address).
+	ErrInterceptedAnswer = errors.New("intercepted answer")
+	// ErrInterceptedNotFound is reported when a name that doesn't exist
+	// resolved (portals commonly answer every name with their address).
+	ErrInterceptedNotFound = errors.New("intercepted non-existent name")
+	// ErrUnauthenticatedAnswer is reported when the answer to a probe wasn't
+	// validated using DNSSEC (only if RequireAuthenticatedData is enabled).
+	ErrUnauthenticatedAnswer = errors.New("unauthenticated answer")
+)
+
+// CaptivePortalProbeName is a name with well known addresses, that is looked
+// up to detect DNS interception.
+type CaptivePortalProbeName struct {
+	// Name is the name to look up.
+	Name string
+	// Addrs are the known addresses of the name. The answer is genuine if it
+	// contains any of them.
+	Addrs []netip.Addr
+}
+
+// DefaultCaptivePortalProbeNames are the names of Google's and Cloudflare's
+// public DNS services, whose addresses are (by design) stable.
+var DefaultCaptivePortalProbeNames = []CaptivePortalProbeName{
+	{
+		Name: "dns.google.",
+		Addrs: []netip.Addr{
+			netip.MustParseAddr("8.8.8.8"),
+			netip.MustParseAddr("8.8.4.4"),
+			netip.MustParseAddr("2001:4860:4860::8888"),
+			netip.MustParseAddr("2001:4860:4860::8844"),
+		},
+	},
+	{
+		Name: "one.one.one.one.",
+		Addrs: []netip.Addr{
+			netip.MustParseAddr("1.1.1.1"),
+			netip.MustParseAddr("1.0.0.1"),
+			netip.MustParseAddr("2606:4700:4700::1111"),
+			netip.MustParseAddr("2606:4700:4700::1001"),
+		},
+	},
+}
+
+// CaptivePortalStatus is the result of probing for captive portal DNS
+// interception.
+type CaptivePortalStatus int
+
+const (
+	// CaptivePortalUnknown means the network hasn't been probed yet, or the
+	// probes failed (eg. because the network is unreachable).
+	CaptivePortalUnknown CaptivePortalStatus = iota
+	// CaptivePortalClear means DNS isn't intercepted.
+	CaptivePortalClear
+	// CaptivePortalDetected means DNS is intercepted, eg. by a captive portal
+	// that hasn't been cleared (logged into) yet.
+	CaptivePortalDetected
+)
+
+func (s CaptivePortalStatus) String() string {
+	switch s {
+	case CaptivePortalClear:
+		return "clear"
+	case CaptivePortalDetected:
+		return "detected"
+	default:
+		return "unknown"
+	}
+}
+
+// CaptivePortalDetectorConfig is the configuration of a captive portal
+// detector.
+type CaptivePortalDetectorConfig struct {
+	// Resolver is the (unencrypted) resolver of the network that is probed.
+	// By default, the system's DNS servers (see SystemResolverConfig.DNSOnly).
+	Resolver Resolver
+	// ProbeNames are the names with known addresses that are looked up.
+	// By default, DefaultCaptivePortalProbeNames.
+	ProbeNames []CaptivePortalProbeName
+	// NonexistentDomain is the domain under which a random (non-existent)
+	// name is looked up, that must not resolve. An empty domain disables the
+	// probe. By default, "example.com.".
+	NonexistentDomain *string
+	// RequireAuthenticatedData requires the answers to the probe names to be
+	// validated using DNSSEC (see Metadata.AuthenticatedData), which portals
+	// are unable to forge. The resolver must be a validating server
+	// configured with TrustAD. By default, disabled.
+	RequireAuthenticatedData *bool
+	// InitialInterval is how long WaitClear waits before probing again,
+	// after the first probe detected a portal. The interval doubles after
+	// every probe, up to MaxInterval. By default, 1 second.
+	InitialInterval *time.Duration
+	// MaxInterval is the maximum interval between WaitClear's probes.
+	// By default, 1 minute.
+	MaxInterval *time.Duration
+	// Events is an optional event distributor, that is notified whenever the
+	// status changes (see EventCaptivePortalDetected and
+	// EventCaptivePortalCleared).
+	Events *Events
+}
+
+// CaptivePortalDetector detects captive portal DNS interception, by looking
+// up names with well known addresses (and a name that doesn't exist) and
+// comparing the answers. Applications can use it to avoid exposing encrypted
+// DNS (eg. the SNI of DNS over TLS) to a hostile network until the portal is
+// cleared. It is safe for concurrent use.
+type CaptivePortalDetector struct {
+	resolver                 Resolver
+	probeNames               []CaptivePortalProbeName
+	nonexistentDomain        string
+	requireAuthenticatedData bool
+	initialInterval          time.Duration
+	maxInterval              time.Duration
+	events                   *Events
+
+	mu     sync.Mutex
+	status CaptivePortalStatus
+}
+
+// NewCaptivePortalDetector returns a new captive portal detector.
+func NewCaptivePortalDetector(conf *CaptivePortalDetectorConfig) (*CaptivePortalDetector, error) {
+	if conf == nil {
+		conf = &CaptivePortalDetectorConfig{}
+	}
+
+	res := conf.Resolver
+	if res == nil {
+		var err error
+		res, err = System(&SystemResolverConfig{DNSOnly: ptr.To(true)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create system resolver: %w", err)
+		}
+	}
+
+	probeNames := conf.ProbeNames
+	if probeNames == nil {
+		probeNames = DefaultCaptivePortalProbeNames
+	}
+
+	nonexistentDomain := "example.com."
+	if conf.NonexistentDomain != nil {
+		nonexistentDomain = *conf.NonexistentDomain
+	}
+
+	if len(probeNames) == 0 && nonexistentDomain == "" {
+		return nil, fmt.Errorf("at least one probe is required")
+	}
+
+	initialInterval := time.Second
+	if conf.InitialInterval != nil {
+		initialInterval = *conf.InitialInterval
+	}
+
+	maxInterval := time.Minute
+	if conf.MaxInterval != nil {
+		maxInterval = *conf.MaxInterval
+	}
+
+	if initialInterval <= 0 || maxInterval < initialInterval {
+		return nil, fmt.Errorf("invalid probe intervals")
+	}
+
+	return &CaptivePortalDetector{
+		resolver:                 res,
+		probeNames:               probeNames,
+		nonexistentDomain:        nonexistentDomain,
+		requireAuthenticatedData: conf.RequireAuthenticatedData != nil && *conf.RequireAuthenticatedData,
+		initialInterval:          initialInterval,
+		maxInterval:              maxInterval,
+		events:                   conf.Events,
+	}, nil
+}
+
+// Status returns the result of the last probe.
+func (d *CaptivePortalDetector) Status() CaptivePortalStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.status
+}
+
+// Probe probes the network for DNS interception, and returns (and records)
+// the result. If the status is unknown, the error describes why the probes
+// failed.
+func (d *CaptivePortalDetector) Probe(ctx context.Context) (CaptivePortalStatus, error) {
+	status, err := d.probe(ctx)
+
+	d.mu.Lock()
+	changed := status != d.status
+	d.status = status
+	d.mu.Unlock()
+
+	if changed {
+		switch status {
+		case CaptivePortalDetected:
+			d.events.Publish(Event{Type: EventCaptivePortalDetected, Err: err})
+		case CaptivePortalClear:
+			d.events.Publish(Event{Type: EventCaptivePortalCleared})
+		}
+	}
+
+	if status != CaptivePortalUnknown {
+		return status, nil
+	}
+
+	return status, err
+}
+
+// WaitClear probes the network until DNS is no longer intercepted (eg. once
+// the user has logged into the portal), backing off exponentially between
+// probes. It returns early if the context is done.
+func (d *CaptivePortalDetector) WaitClear(ctx context.Context) error {
+	interval := d.initialInterval
+	for {
+		if status, _ := d.Probe(ctx); status == CaptivePortalClear {
+			return nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		interval = min(2*interval, d.maxInterval)
+	}
+}
+
+// probe performs a round of probes, returning the status along with the sign
+// of interception (if detected), or the errors of the failed probes (if the
+// status is unknown).
+func (d *CaptivePortalDetector) probe(ctx context.Context) (CaptivePortalStatus, error) {
+	var errs []error
+	genuine := false
+
+	for _, pn := range d.probeNames {
+		mdCtx, md := WithMetadata(ctx)
+
+		addrs, err := d.resolver.LookupNetIP(mdCtx, "ip", pn.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if !slices.ContainsFunc(addrs, func(addr netip.Addr) bool {
+			return slices.Contains(pn.Addrs, addr.Unmap())
+		}) {
+			return CaptivePortalDetected, ErrInterceptedAnswer
+		}
+
+		if d.requireAuthenticatedData && !md.AuthenticatedData() {
+			return CaptivePortalDetected, ErrUnauthenticatedAnswer
+		}
+
+		genuine = true
+	}
+
+	if d.nonexistentDomain != "" {
+		_, err := d.resolver.LookupNetIP(ctx, "ip", randomProbeName(d.nonexistentDomain))
+		if err == nil {
+			return CaptivePortalDetected, ErrInterceptedNotFound
+		}
+
+		if isNotFound(err) {
+			genuine = true
+		} else {
+			errs = append(errs, err)
+		}
+	}
+
+	if !genuine {
+		return CaptivePortalUnknown, errors.Join(errs...)
+	}
+
+	return CaptivePortalClear, nil
+}
+
+// randomProbeName returns a random (and so non-existent) name under domain.
+func randomProbeName(domain string) string {
+	return fmt.Sprintf("%016x.%s", rand.Uint64(), dns.Fqdn(domain))
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptivePortalDetector(t *testing.T) {
+	portalAddr := netip.MustParseAddr("192.168.1.1")
+
+	// A portal answers every name with its own address.
+	portal := resolver.Func(func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		return []netip.Addr{portalAddr}, nil
+	})
+
+	genuine := resolvertest.NewFake()
+	genuine.SetAddrs("dns.google.", netip.MustParseAddr("8.8.8.8"), netip.MustParseAddr("2001:4860:4860::8888"))
+	genuine.SetAddrs("one.one.one.one.", netip.MustParseAddr("1.1.1.1"))
+
+	t.Run("Clear", func(t *testing.T) {
+		events := resolver.NewEvents()
+		ch, unsubscribe := events.SubscribeChan(10)
+		t.Cleanup(unsubscribe)
+
+		d, err := resolver.NewCaptivePortalDetector(&resolver.CaptivePortalDetectorConfig{
+			Resolver: genuine,
+			Events:   events,
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, resolver.CaptivePortalUnknown, d.Status())
+
+		status, err := d.Probe(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, resolver.CaptivePortalClear, status)
+		require.Equal(t, resolver.CaptivePortalClear, d.Status())
+
+		ev := <-ch
+		require.Equal(t, resolver.EventCaptivePortalCleared, ev.Type)
+	})
+
+	t.Run("Intercepted Answer", func(t *testing.T) {
+		events := resolver.NewEvents()
+		ch, unsubscribe := events.SubscribeChan(10)
+		t.Cleanup(unsubscribe)
+
+		d, err := resolver.NewCaptivePortalDetector(&resolver.CaptivePortalDetectorConfig{
+			Resolver: portal,
+			Events:   events,
+		})
+		require.NoError(t, err)
+
+		status, err := d.Probe(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, resolver.CaptivePortalDetected, status)
+
+		ev := <-ch
+		require.Equal(t, resolver.EventCaptivePortalDetected, ev.Type)
+		require.ErrorIs(t, ev.Err, resolver.ErrInterceptedAnswer)
+	})
+
+	t.Run("Intercepted Not Found", func(t *testing.T) {
+		events := resolver.NewEvents()
+		ch, unsubscribe := events.SubscribeChan(10)
+		t.Cleanup(unsubscribe)
+
+		d, err := resolver.NewCaptivePortalDetector(&resolver.CaptivePortalDetectorConfig{
+			Resolver:   portal,
+			ProbeNames: []resolver.CaptivePortalProbeName{},
+			Events:     events,
+		})
+		require.NoError(t, err)
+
+		status, err := d.Probe(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, resolver.CaptivePortalDetected, status)
+
+		ev := <-ch
+		require.ErrorIs(t, ev.Err, resolver.ErrInterceptedNotFound)
+	})
+
+	t.Run("Unauthenticated Answer", func(t *testing.T) {
+		d, err := resolver.NewCaptivePortalDetector(&resolver.CaptivePortalDetectorConfig{
+			Resolver:                 genuine,
+			RequireAuthenticatedData: ptr.To(true),
+		})
+		require.NoError(t, err)
+
+		status, err := d.Probe(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, resolver.CaptivePortalDetected, status)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		unreachable := resolver.Func(func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			return nil, resolvertest.Timeout(host)
+		})
+
+		d, err := resolver.NewCaptivePortalDetector(&resolver.CaptivePortalDetectorConfig{
+			Resolver: unreachable,
+		})
+		require.NoError(t, err)
+
+		status, err := d.Probe(context.Background())
+		require.Error(t, err)
+		require.Equal(t, resolver.CaptivePortalUnknown, status)
+	})
+
+	t.Run("Wait Clear", func(t *testing.T) {
+		// The portal is cleared after a few probes.
+		var probes atomic.Int32
+		clearing := resolver.Func(func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			if host == "dns.google." && probes.Add(1) <= 3 {
+				return []netip.Addr{portalAddr}, nil
+			}
+			return genuine.LookupNetIP(ctx, network, host)
+		})
+
+		d, err := resolver.NewCaptivePortalDetector(&resolver.CaptivePortalDetectorConfig{
+			Resolver:        clearing,
+			InitialInterval: ptr.To(10 * time.Millisecond),
+			MaxInterval:     ptr.To(20 * time.Millisecond),
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+
+		require.NoError(t, d.WaitClear(ctx))
+		require.Equal(t, int32(4), probes.Load())
+		require.Equal(t, resolver.CaptivePortalClear, d.Status())
+	})
+
+	t.Run("Wait Clear Canceled", func(t *testing.T) {
+		d, err := resolver.NewCaptivePortalDetector(&resolver.CaptivePortalDetectorConfig{
+			Resolver:        portal,
+			InitialInterval: ptr.To(10 * time.Millisecond),
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		t.Cleanup(cancel)
+
+		require.ErrorIs(t, d.WaitClear(ctx), context.DeadlineExceeded)
+		require.Equal(t, resolver.CaptivePortalDetected, d.Status())
+	})
+}
@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clatAddrs are the IPv4 addresses that common customer side translators
+// (CLAT) of 464XLAT deployments (RFC 6877) assign to the host, "192.0.0.4" by
+// Android's clatd and "192.0.0.2" by macOS and iOS. They're from the IPv4
+// Service Continuity Prefix (RFC 7335), which is shared with the B4 elements
+// of DS-Lite (RFC 6333), so they only identify a CLAT on interfaces that
+// aren't tunnels.
+var clatAddrs = []netip.Addr{
+	netip.MustParseAddr("192.0.0.4"),
+	netip.MustParseAddr("192.0.0.2"),
+}
+
+// CLATMode controls whether DNS64 synthesis is suppressed because the host
+// has a CLAT. With a CLAT, IPv4 destinations are reachable (the CLAT
+// translates the traffic to IPv6 itself), so synthesizing IPv6 addresses
+// would only result in the traffic being translated twice.
+type CLATMode string
+
+const (
+	// CLATModeAbsent assumes the host has no CLAT, addresses are always
+	// synthesized.
+	CLATModeAbsent CLATMode = "absent"
+	// CLATModeAuto suppresses synthesis if the host has a CLAT interface (see
+	// DetectCLAT).
+	CLATModeAuto CLATMode = "auto"
+	// CLATModePresent assumes the host has a CLAT, addresses are never
+	// synthesized. Eg. for userspace network stacks that implement 464XLAT.
+	CLATModePresent CLATMode = "present"
+)
+
+func (m CLATMode) validate() error {
+	switch m {
+	case CLATModeAbsent, CLATModeAuto, CLATModePresent:
+		return nil
+	default:
+		return fmt.Errorf("invalid clat mode %q", m)
+	}
+}
+
+// IsCLATInterface reports whether an interface with the given name, flags and
+// addresses looks like a CLAT, ie. it isn't a (point to point) tunnel and has
+// an address assigned by a common CLAT implementation ("192.0.0.4" or
+// "192.0.0.2"), or it's named like the interfaces created by clatd ("v4-*" on
+// Android, and "clat" on Linux). DS-Lite B4 elements, which assign addresses
+// from the same prefix to their softwire tunnel, aren't CLATs.
+func IsCLATInterface(name string, flags net.Flags, addrs []netip.Prefix) bool {
+	if flags&net.FlagPointToPoint == 0 {
+		for _, addr := range addrs {
+			if slices.Contains(clatAddrs, addr.Addr().Unmap()) {
+				return true
+			}
+		}
+	}
+
+	if runtime.GOOS == "android" && strings.HasPrefix(name, "v4-") {
+		return true
+	}
+
+	return name == "clat"
+}
+
+// DetectCLAT returns the name of the host's (up) CLAT interface, if it has
+// one (see IsCLATInterface).
+func DetectCLAT() (string, bool, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return "", false, err
+		}
+
+		var prefixes []netip.Prefix
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+
+			ip, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+
+			bits, _ := ipNet.Mask.Size()
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), bits))
+		}
+
+		if IsCLATInterface(iface.Name, iface.Flags, prefixes) {
+			return iface.Name, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+var (
+	hostCLATDetectorOnce sync.Once
+	hostCLATDetectorInst *clatDetector
+)
+
+// hostCLATDetector returns a (shared) detector of the host's CLAT, the result
+// of DetectCLAT is cached and refreshed when the interfaces change (on Linux)
+// or periodically (elsewhere).
+func hostCLATDetector() *clatDetector {
+	hostCLATDetectorOnce.Do(func() {
+		// If we're notified of changes, there's no need to poll.
+		d := &clatDetector{}
+
+		if !watchInterfaceAddrs(d.invalidate, func() { d.poll(time.Minute) }) {
+			d.refresh = time.Minute
+		}
+
+		hostCLATDetectorInst = d
+	})
+
+	return hostCLATDetectorInst
+}
+
+type clatDetector struct {
+	mu      sync.Mutex
+	refresh time.Duration
+	present bool
+	valid   bool
+	expires time.Time
+}
+
+// Present reports whether the host has a CLAT interface.
+func (d *clatDetector) Present() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.valid && (d.refresh == 0 || time.Now().Before(d.expires)) {
+		return d.present
+	}
+
+	_, present, err := DetectCLAT()
+	if err != nil {
+		// Keep using the stale result (if any), we'll try again next time.
+		return d.present
+	}
+
+	d.present = present
+	d.valid = true
+	d.expires = time.Now().Add(d.refresh)
+
+	return d.present
+}
+
+func (d *clatDetector) invalidate() {
+	d.mu.Lock()
+	d.valid = false
+	d.mu.Unlock()
+}
+
+// poll refreshes the result periodically, once changes are no longer
+// notified.
+func (d *clatDetector) poll(refresh time.Duration) {
+	d.mu.Lock()
+	d.refresh = refresh
+	d.valid = false
+	d.mu.Unlock()
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"runtime"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsCLATInterface(t *testing.T) {
+	// Android's clatd, the name is only meaningful on Android.
+	require.Equal(t, runtime.GOOS == "android",
+		resolver.IsCLATInterface("v4-rmnet0", net.FlagUp|net.FlagPointToPoint, nil))
+	require.True(t, resolver.IsCLATInterface("clat", net.FlagUp, nil))
+	// Eg. macOS, which assigns the CLAT address to the primary interface.
+	require.True(t, resolver.IsCLATInterface("en0", net.FlagUp, []netip.Prefix{
+		netip.MustParsePrefix("2001:db8::1/64"),
+		netip.MustParsePrefix("192.0.0.2/32"),
+	}))
+	require.True(t, resolver.IsCLATInterface("wlan0", net.FlagUp, []netip.Prefix{
+		netip.MustParsePrefix("192.0.0.4/32"),
+	}))
+
+	require.False(t, resolver.IsCLATInterface("eth0", net.FlagUp, []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.1/24"),
+		netip.MustParsePrefix("2001:db8::1/64"),
+	}))
+	require.False(t, resolver.IsCLATInterface("eth0", net.FlagUp, []netip.Prefix{
+		netip.MustParsePrefix("192.0.0.8/32"),
+	}))
+	require.False(t, resolver.IsCLATInterface("eth0", net.FlagUp, []netip.Prefix{
+		netip.MustParsePrefix("192.0.0.6/29"),
+	}))
+
+	t.Run("DS-Lite", func(t *testing.T) {
+		// The B4 element of DS-Lite assigns an address from the same prefix to
+		// its softwire tunnel.
+		require.False(t, resolver.IsCLATInterface("dslite0", net.FlagUp|net.FlagPointToPoint, []netip.Prefix{
+			netip.MustParsePrefix("192.0.0.2/29"),
+			netip.MustParsePrefix("2001:db8::2/64"),
+		}))
+	})
+}
+
+func TestDetectCLAT(t *testing.T) {
+	name, ok, err := resolver.DetectCLAT()
+	require.NoError(t, err)
+
+	if ok {
+		require.NotEmpty(t, name)
+	}
+}
+
+func TestDNS64ResolverCLAT(t *testing.T) {
+	t.Run("Present", func(t *testing.T) {
+		res, err := resolver.DNS64(resolver.Literal(), &resolver.DNS64ResolverConfig{
+			CLAT: ptr.To(resolver.CLATModePresent),
+		})
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "10.0.0.1")
+		require.NoError(t, err)
+
+		// The IPv4 address is reachable through the CLAT, so no address is
+		// synthesized.
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+		addrs, err = res.LookupNetIP(context.Background(), "ip6", "10.0.0.1")
+		require.NoError(t, err)
+		require.Empty(t, addrs)
+	})
+
+	t.Run("Auto", func(t *testing.T) {
+		res, err := resolver.DNS64(resolver.Literal(), &resolver.DNS64ResolverConfig{
+			CLAT: ptr.To(resolver.CLATModeAuto),
+		})
+		require.NoError(t, err)
+
+		_, present, err := resolver.DetectCLAT()
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip6", "10.0.0.1")
+		require.NoError(t, err)
+
+		if present {
+			require.Empty(t, addrs)
+		} else {
+			require.Equal(t, []netip.Addr{netip.MustParseAddr("64:ff9b::a00:1")}, addrs)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := resolver.DNS64(resolver.Literal(), &resolver.DNS64ResolverConfig{
+			CLAT: ptr.To(resolver.CLATMode("bogus")),
+		})
+		require.Error(t, err)
+	})
+}
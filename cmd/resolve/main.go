@@ -0,0 +1,319 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Command resolve is a dig like tool for querying DNS servers using the
+// resolver package, eg.
+//
+//	resolve example.com AAAA
+//	resolve -server 1.1.1.1 -transport dot example.com MX +dnssec
+//	resolve -x 8.8.8.8 +json
+//
+// Without a server, the system's DNS configuration is used (including its
+// hosts file). Search domains are only applied with +search.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/internal/dnsconfig"
+	"github.com/noisysockets/util/ptr"
+)
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// options are the command line options.
+type options struct {
+	server        string
+	transport     string
+	url           string
+	tlsServerName string
+	caFile        string
+	qType         string
+	reverse       bool
+	search        bool
+	dnssec        bool
+	json          bool
+	describe      bool
+	timeout       time.Duration
+}
+
+// run executes the command with args, returning the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var opts options
+
+	fs := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: resolve [flags] name [type] [+search] [+dnssec] [+json] [+describe]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.server, "server", "", "Address of the DNS server to query (default: the system's DNS configuration)")
+	fs.StringVar(&opts.transport, "transport", "udp", "Transport used to query the server, one of udp, tcp, dot or doh")
+	fs.StringVar(&opts.url, "url", "", "URL of the DNS over HTTPS endpoint (default: https://<server>/dns-query)")
+	fs.StringVar(&opts.tlsServerName, "tls-server-name", "", "Name used to verify the server's certificate (DNS over TLS and HTTPS)")
+	fs.StringVar(&opts.caFile, "ca-file", "", "PEM encoded CA certificates used to verify the server's certificate (DNS over TLS and HTTPS)")
+	fs.StringVar(&opts.qType, "type", "", "Type of the records to query (default: A, or PTR for reverse lookups)")
+	fs.BoolVar(&opts.reverse, "x", false, "Reverse (PTR) lookup of an address")
+	fs.BoolVar(&opts.search, "search", false, "Apply the search domains of the system's DNS configuration to the name")
+	fs.BoolVar(&opts.dnssec, "dnssec", false, "Trust the server to validate responses using DNSSEC, and report the AD bit")
+	fs.BoolVar(&opts.json, "json", false, "Print the result as JSON (see resolver.LookupResult)")
+	fs.BoolVar(&opts.describe, "describe", false, "Print a description of the resolver chain")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Second, "Maximum duration to wait for the lookup to complete")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// Positional arguments are the name, an optional type and dig style
+	// +options.
+	var positional []string
+	for _, arg := range fs.Args() {
+		switch arg {
+		case "+search":
+			opts.search = true
+		case "+nosearch":
+			opts.search = false
+		case "+dnssec":
+			opts.dnssec = true
+		case "+json":
+			opts.json = true
+		case "+describe":
+			opts.describe = true
+		default:
+			if strings.HasPrefix(arg, "+") {
+				fmt.Fprintf(stderr, "unknown option %q\n", arg)
+				return 2
+			}
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) == 0 || len(positional) > 2 {
+		fs.Usage()
+		return 2
+	}
+
+	if len(positional) == 2 {
+		opts.qType = positional[1]
+	}
+
+	q, err := question(positional[0], opts)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	res, err := newResolver(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to create resolver: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	result := resolver.LookupResultFor(ctx, res, q)
+
+	if opts.json {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(result); err != nil {
+			fmt.Fprintf(stderr, "failed to encode result: %v\n", err)
+			return 1
+		}
+	} else {
+		printResult(stdout, result, opts.describe)
+	}
+
+	if result.Error != nil {
+		return 1
+	}
+
+	return 0
+}
+
+// question returns the question for name.
+func question(name string, opts options) (resolver.Question, error) {
+	if opts.reverse {
+		addr, err := netip.ParseAddr(name)
+		if err != nil {
+			return resolver.Question{}, fmt.Errorf("invalid address %q: %w", name, err)
+		}
+
+		name, err = dns.ReverseAddr(addr.Unmap().String())
+		if err != nil {
+			return resolver.Question{}, fmt.Errorf("invalid address %q: %w", name, err)
+		}
+
+		if opts.qType == "" {
+			opts.qType = "PTR"
+		}
+	}
+
+	if opts.qType == "" {
+		opts.qType = "A"
+	}
+
+	qType, ok := dns.StringToType[strings.ToUpper(opts.qType)]
+	if !ok {
+		// Also accept numeric types (eg. "TYPE65" or "65").
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(opts.qType), "TYPE"), 10, 16)
+		if err != nil {
+			return resolver.Question{}, fmt.Errorf("unknown record type %q", opts.qType)
+		}
+		qType = uint16(n)
+	}
+
+	return resolver.Question{Name: name, Type: qType}, nil
+}
+
+// newResolver creates the resolver chain for the options.
+func newResolver(opts options) (resolver.Resolver, error) {
+	if opts.server == "" {
+		conf := &resolver.SystemResolverConfig{}
+		if !opts.search {
+			conf.Search = []string{}
+		}
+
+		return resolver.System(conf)
+	}
+
+	server, err := parseServer(opts.server)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := resolver.DNSResolverConfig{
+		Server:  server,
+		Timeout: ptr.To(opts.timeout),
+		TrustAD: ptr.To(opts.dnssec),
+	}
+
+	if opts.tlsServerName != "" || opts.caFile != "" {
+		conf.TLSConfig = &tls.Config{ServerName: opts.tlsServerName}
+	}
+
+	if opts.caFile != "" {
+		pem, err := os.ReadFile(opts.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file: %w", err)
+		}
+
+		conf.TLSConfig.RootCAs = x509.NewCertPool()
+		if !conf.TLSConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file %q", opts.caFile)
+		}
+	}
+
+	switch opts.transport {
+	case "udp":
+	case "tcp":
+		conf.Transport = ptr.To(resolver.DNSTransportTCP)
+	case "dot", "tls":
+		conf.Transport = ptr.To(resolver.DNSTransportTLS)
+	case "doh", "https":
+		conf.Transport = ptr.To(resolver.DNSTransportHTTPS)
+
+		conf.URL = opts.url
+		if conf.URL == "" {
+			host := opts.tlsServerName
+			if host == "" {
+				// The zone (of link-local servers) is not part of the URL.
+				host = server.Addr().WithZone("").String()
+				if server.Addr().Is6() {
+					host = "[" + host + "]"
+				}
+			}
+			conf.URL = "https://" + host + "/dns-query"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", opts.transport)
+	}
+
+	res, err := resolver.DNS(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.search {
+		return res, nil
+	}
+
+	systemConf, err := dnsconfig.Read(dnsconfig.Location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read system dns config: %w", err)
+	}
+
+	return resolver.Relative(res, &resolver.RelativeResolverConfig{
+		Search: systemConf.Search,
+		NDots:  ptr.To(systemConf.NDots),
+	})
+}
+
+// parseServer parses a server address with an optional port.
+func parseServer(server string) (netip.AddrPort, error) {
+	if addrPort, err := netip.ParseAddrPort(server); err == nil {
+		return addrPort, nil
+	}
+
+	addr, err := netip.ParseAddr(strings.Trim(server, "[]"))
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid server address %q", server)
+	}
+
+	// The default port of the transport is used.
+	return netip.AddrPortFrom(addr, 0), nil
+}
+
+// printResult prints the result in a dig like format.
+func printResult(w io.Writer, result resolver.LookupResult, describe bool) {
+	if describe && result.Resolver != nil {
+		fmt.Fprintf(w, ";; RESOLVER:\n%s\n\n", result.Resolver)
+	}
+
+	fmt.Fprintf(w, ";; QUESTION:\n;%s\tIN\t%s\n\n", dns.Fqdn(result.Question.Name), result.Question.Type)
+
+	if result.Error != nil {
+		fmt.Fprintf(w, ";; ERROR: %s\n", result.Error.Message)
+		if len(result.Error.Tried) > 0 {
+			fmt.Fprintf(w, ";; TRIED: %s\n", strings.Join(result.Error.Tried, " "))
+		}
+		return
+	}
+
+	if result.QueriedName != "" {
+		fmt.Fprintf(w, ";; QUERIED: %s\n\n", result.QueriedName)
+	}
+
+	fmt.Fprintf(w, ";; ANSWER:\n")
+	for _, rr := range result.Answers {
+		fmt.Fprintf(w, "%s\t%d\tIN\t%s\t%s\n", rr.Name, rr.TTL, rr.Type, rr.Data)
+	}
+
+	fmt.Fprintf(w, "\n;; Query time: %s\n", result.Duration.Round(time.Microsecond))
+	if result.AuthenticatedData {
+		fmt.Fprintf(w, ";; flags: ad\n")
+	}
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("2001:db8::1")},
+		},
+		Records: []dns.RR{
+			&dns.MX{
+				Hdr:        dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: 60},
+				Preference: 10,
+				Mx:         "mail.example.com.",
+			},
+		},
+		TLS:   ptr.To(true),
+		HTTPS: ptr.To(true),
+	})
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0o644))
+
+	serverName := srv.ClientTLSConfig().ServerName
+
+	resolve := func(args ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), args, &stdout, &stderr)
+		return code, stdout.String() + stderr.String()
+	}
+
+	transports := []struct {
+		name string
+		args []string
+	}{
+		{name: "UDP", args: []string{"-server", srv.Addr().String()}},
+		{name: "TCP", args: []string{"-server", srv.Addr().String(), "-transport", "tcp"}},
+		{name: "DoT", args: []string{"-server", srv.TLSAddr().String(), "-transport", "dot",
+			"-tls-server-name", serverName, "-ca-file", caFile}},
+		{name: "DoH", args: []string{"-server", srv.HTTPSAddr().String(), "-transport", "doh",
+			"-url", srv.URL(), "-ca-file", caFile}},
+	}
+
+	for _, tc := range transports {
+		t.Run(tc.name, func(t *testing.T) {
+			code, output := resolve(append(tc.args, "example.com", "AAAA")...)
+			require.Equal(t, 0, code, output)
+			require.Contains(t, output, "2001:db8::1")
+
+			code, output = resolve(append(tc.args, "-type", "mx", "example.com")...)
+			require.Equal(t, 0, code, output)
+			require.Contains(t, output, "mail.example.com.")
+		})
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		code, output := resolve("-server", srv.Addr().String(), "example.com", "+json", "+describe")
+		require.Equal(t, 0, code, output)
+
+		var result resolver.LookupResult
+		require.NoError(t, json.Unmarshal([]byte(output), &result))
+		require.Equal(t, resolver.ResultQuestion{Name: "example.com", Type: "A"}, result.Question)
+		require.Equal(t, []resolver.ResultRecord{
+			{Name: "example.com.", Type: "A", TTL: 60, Data: "10.0.0.1"},
+		}, result.Answers)
+		require.NotNil(t, result.Resolver)
+		require.Equal(t, "dns", result.Resolver.Type)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		code, output := resolve("-server", srv.Addr().String(), "missing.example.com", "+json")
+		require.Equal(t, 1, code)
+
+		var result resolver.LookupResult
+		require.NoError(t, json.Unmarshal([]byte(output), &result))
+		require.NotNil(t, result.Error)
+		require.True(t, result.Error.NotFound)
+	})
+
+	t.Run("Invalid Arguments", func(t *testing.T) {
+		code, _ := resolve()
+		require.Equal(t, 2, code)
+
+		code, _ = resolve("example.com", "NOTATYPE")
+		require.Equal(t, 2, code)
+
+		code, _ = resolve("example.com", "+unknown")
+		require.Equal(t, 2, code)
+
+		code, _ = resolve("-x", "not-an-address")
+		require.Equal(t, 2, code)
+	})
+}
@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var _ Resolver = (*consensusResolver)(nil)
+
+// ErrNoConsensus is returned when the resolvers of a consensus resolver don't
+// agree on an answer.
+var ErrNoConsensus = errors.New("no consensus")
+
+// ConsensusResolverConfig is the configuration of a consensus resolver.
+type ConsensusResolverConfig struct {
+	// Quorum is the number of resolvers that must agree on an address (or on
+	// a name not existing) for it to be returned. By default, a majority of
+	// the resolvers.
+	Quorum *int
+	// Events is an optional event distributor, that is notified when the
+	// answers of the resolvers diverge (see EventAnswersDiverged).
+	Events *Events
+}
+
+// consensusResolver is a resolver that only returns answers agreed on by a
+// quorum of resolvers.
+type consensusResolver struct {
+	resolvers []Resolver
+	quorum    int
+	events    *Events
+}
+
+// Consensus returns a resolver that queries every resolver concurrently, and
+// only returns the addresses that are returned by at least a quorum of them.
+// This mitigates a single compromised (or censoring) upstream, eg. by
+// querying several independent DNS over HTTPS providers.
+//
+// Addresses are voted on individually (so that the rotating answers of load
+// balanced names still agree), and are returned in the order of the first
+// resolver that returned them. A name is only reported as not found if a
+// quorum of the resolvers don't find it, otherwise the lookup fails with
+// ErrNoConsensus.
+func Consensus(conf *ConsensusResolverConfig, resolvers ...Resolver) (*consensusResolver, error) {
+	if len(resolvers) == 0 {
+		return nil, fmt.Errorf("at least one resolver is required")
+	}
+
+	var events *Events
+	quorum := len(resolvers)/2 + 1
+	if conf != nil {
+		events = conf.Events
+		if conf.Quorum != nil {
+			quorum = *conf.Quorum
+		}
+	}
+
+	if quorum < 1 || quorum > len(resolvers) {
+		return nil, fmt.Errorf("quorum must be between 1 and %d", len(resolvers))
+	}
+
+	return &consensusResolver{
+		resolvers: resolvers,
+		quorum:    quorum,
+		events:    events,
+	}, nil
+}
+
+func (r *consensusResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	votes := r.vote(ctx, host, func(ctx context.Context, resolver Resolver) ([]string, error) {
+		addrs, err := resolver.LookupNetIP(ctx, network, host)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			keys = append(keys, addr.String())
+		}
+		return keys, nil
+	})
+
+	agreed, err := r.decide(host, votes)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]netip.Addr, 0, len(agreed))
+	for _, key := range agreed {
+		addrs = append(addrs, netip.MustParseAddr(key))
+	}
+
+	return addrs, nil
+}
+
+// LookupAddr performs a reverse lookup, returning the names that are returned
+// by at least a quorum of the resolvers.
+func (r *consensusResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	votes := r.vote(ctx, addr, func(ctx context.Context, resolver Resolver) ([]string, error) {
+		return lookupAddr(ctx, resolver, addr)
+	})
+
+	return r.decide(addr, votes)
+}
+
+func (r *consensusResolver) Describe() Description {
+	return Description{
+		Type: "consensus",
+		Attributes: map[string]string{
+			"quorum": strconv.Itoa(r.quorum),
+		},
+		Children: describeAll(r.resolvers),
+	}
+}
+
+// consensusVote is the answer of one resolver.
+type consensusVote struct {
+	keys []string
+	err  error
+}
+
+// vote looks up the answer of every resolver concurrently.
+func (r *consensusResolver) vote(ctx context.Context, name string, lookup func(context.Context, Resolver) ([]string, error)) []consensusVote {
+	votes := make([]consensusVote, len(r.resolvers))
+
+	var wg sync.WaitGroup
+	for i, resolver := range r.resolvers {
+		wg.Add(1)
+		go func(i int, resolver Resolver) {
+			defer wg.Done()
+
+			keys, err := lookup(ctx, resolver)
+			if err == nil && len(keys) == 0 {
+				err = &net.DNSError{
+					Err:        ErrNoSuchHost.Error(),
+					Name:       name,
+					IsNotFound: true,
+				}
+			}
+
+			votes[i] = consensusVote{keys: keys, err: err}
+		}(i, resolver)
+	}
+	wg.Wait()
+
+	return votes
+}
+
+// decide returns the answers agreed on by a quorum of the votes, and publishes
+// an event if the resolvers that answered disagree.
+func (r *consensusResolver) decide(name string, votes []consensusVote) ([]string, error) {
+	// Answers are compared case insensitively (for names), but returned as
+	// first seen.
+	counts := make(map[string]int)
+	answers := make(map[string]string)
+	var keys []string
+	var notFound, failed int
+	for _, vote := range votes {
+		if vote.err != nil {
+			if isNotFound(vote.err) {
+				notFound++
+			} else {
+				failed++
+			}
+			continue
+		}
+
+		for _, key := range uniqueKeys(vote.keys) {
+			if counts[key] == 0 {
+				keys = append(keys, key)
+				answers[key] = answerFor(vote.keys, key)
+			}
+			counts[key]++
+		}
+	}
+
+	// Answers diverge if a resolver returned an answer that wasn't agreed on,
+	// or if some resolvers found the name while others didn't. Failures to
+	// answer (eg. timeouts) aren't disagreements.
+	answered := len(votes) - notFound - failed
+	diverged := answered > 0 && notFound > 0
+
+	var agreed []string
+	for _, key := range keys {
+		if counts[key] >= r.quorum {
+			agreed = append(agreed, key)
+		} else {
+			diverged = true
+		}
+	}
+
+	if len(agreed) == 0 {
+		var err *net.DNSError
+		if notFound >= r.quorum {
+			err = &net.DNSError{
+				Err:        ErrNoSuchHost.Error(),
+				Name:       name,
+				IsNotFound: true,
+			}
+		} else {
+			err = &net.DNSError{
+				Err:  ErrNoConsensus.Error(),
+				Name: name,
+				// Failed resolvers may answer when retried.
+				IsTemporary: failed > 0,
+			}
+		}
+
+		if diverged {
+			r.diverged(name, err)
+		}
+
+		return nil, err
+	}
+
+	if diverged {
+		r.diverged(name, nil)
+	}
+
+	result := make([]string, 0, len(agreed))
+	for _, key := range agreed {
+		result = append(result, answers[key])
+	}
+
+	return result, nil
+}
+
+// diverged publishes an event for a lookup of name whose answers diverged.
+func (r *consensusResolver) diverged(name string, err error) {
+	r.events.Publish(Event{
+		Type: EventAnswersDiverged,
+		Name: name,
+		Err:  err,
+	})
+}
+
+// uniqueKeys returns the distinct (lower cased) keys, in order.
+func uniqueKeys(keys []string) []string {
+	unique := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if !slices.Contains(unique, key) {
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
+
+// answerFor returns the first answer matching key (ignoring case).
+func answerFor(answers []string, key string) string {
+	for _, answer := range answers {
+		if strings.EqualFold(answer, key) {
+			return answer
+		}
+	}
+	return key
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsensusResolver(t *testing.T) {
+	honest := netip.MustParseAddr("93.184.215.14")
+	bogus := netip.MustParseAddr("10.10.10.10")
+
+	newUpstream := func(addrs ...netip.Addr) *resolvertest.Fake {
+		fake := resolvertest.NewFake()
+		if len(addrs) > 0 {
+			fake.SetAddrs("example.com", addrs...)
+		}
+		return fake
+	}
+
+	newConsensus := func(t *testing.T, quorum *int, upstreams ...resolver.Resolver) (resolver.Resolver, *[]resolver.Event) {
+		events := resolver.NewEvents()
+
+		var published []resolver.Event
+		events.Subscribe(func(event resolver.Event) {
+			published = append(published, event)
+		})
+
+		res, err := resolver.Consensus(&resolver.ConsensusResolverConfig{
+			Quorum: quorum,
+			Events: events,
+		}, upstreams...)
+		require.NoError(t, err)
+
+		return res, &published
+	}
+
+	t.Run("Unanimous", func(t *testing.T) {
+		res, events := newConsensus(t, nil, newUpstream(honest), newUpstream(honest), newUpstream(honest))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{honest}, addrs)
+		require.Empty(t, *events)
+	})
+
+	t.Run("Compromised Upstream", func(t *testing.T) {
+		res, events := newConsensus(t, nil, newUpstream(bogus), newUpstream(honest), newUpstream(honest))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{honest}, addrs)
+
+		require.Len(t, *events, 1)
+		require.Equal(t, resolver.EventAnswersDiverged, (*events)[0].Type)
+		require.Equal(t, "example.com", (*events)[0].Name)
+		require.NoError(t, (*events)[0].Err)
+	})
+
+	t.Run("Censoring Upstream", func(t *testing.T) {
+		res, events := newConsensus(t, nil, newUpstream(), newUpstream(honest), newUpstream(honest))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{honest}, addrs)
+		require.Len(t, *events, 1)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		res, events := newConsensus(t, nil, newUpstream(), newUpstream(), newUpstream())
+
+		_, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+		require.Empty(t, *events)
+	})
+
+	t.Run("No Consensus", func(t *testing.T) {
+		res, events := newConsensus(t, nil, newUpstream(bogus), newUpstream(honest))
+
+		_, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.ErrorContains(t, err, resolver.ErrNoConsensus.Error())
+
+		require.Len(t, *events, 1)
+		require.Error(t, (*events)[0].Err)
+	})
+
+	t.Run("Failures", func(t *testing.T) {
+		failing := resolvertest.NewFake()
+		failing.Script("example.com", dns.TypeA, resolvertest.Response{Err: resolvertest.Timeout("example.com")})
+
+		res, events := newConsensus(t, nil, failing, newUpstream(honest), newUpstream(honest))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{honest}, addrs)
+		require.Empty(t, *events)
+
+		// Without a quorum of answers, the lookup fails (temporarily).
+		res, _ = newConsensus(t, ptr.To(3), failing, newUpstream(honest), newUpstream(honest))
+
+		_, err = res.LookupNetIP(context.Background(), "ip4", "example.com")
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsTemporary)
+	})
+
+	t.Run("Invalid Config", func(t *testing.T) {
+		_, err := resolver.Consensus(nil)
+		require.Error(t, err)
+
+		_, err = resolver.Consensus(&resolver.ConsensusResolverConfig{Quorum: ptr.To(3)}, newUpstream(), newUpstream())
+		require.Error(t, err)
+	})
+
+	t.Run("Describe", func(t *testing.T) {
+		res, _ := newConsensus(t, nil, newUpstream(), newUpstream(), newUpstream())
+
+		description := resolver.Describe(res)
+		require.Equal(t, "consensus", description.Type)
+		require.Equal(t, "2", description.Attributes["quorum"])
+		require.Len(t, description.Children, 3)
+	})
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/resolver/internal/dnsconfig"
+	"github.com/noisysockets/util/ptr"
+)
+
+// ContainerEnvironment is a container environment, as detected from the DNS
+// configuration.
+type ContainerEnvironment string
+
+const (
+	// ContainerEnvironmentNone is not a (recognized) container environment.
+	ContainerEnvironmentNone ContainerEnvironment = "none"
+	// ContainerEnvironmentDocker is a container on a user-defined Docker
+	// network, using Docker's embedded DNS server (127.0.0.11).
+	ContainerEnvironmentDocker ContainerEnvironment = "docker"
+	// ContainerEnvironmentKubernetes is a Kubernetes pod, using the cluster's
+	// DNS service (with "<namespace>.svc.<cluster-domain>" search domains).
+	ContainerEnvironmentKubernetes ContainerEnvironment = "kubernetes"
+)
+
+// dockerEmbeddedDNSServer is the address of Docker's embedded DNS server.
+const dockerEmbeddedDNSServer = "127.0.0.11:53"
+
+// ContainerResolverConfig is the configuration for a container resolver.
+type ContainerResolverConfig struct {
+	// ResolvConfPath is the optional path to the resolv.conf file.
+	// By default, the system's DNS configuration is used.
+	ResolvConfPath string
+	// HostsFilePath is the optional path to the hosts file.
+	// By default, the system's hosts file is used.
+	HostsFilePath string
+	// DialContext is used to establish a connection to a DNS server.
+	DialContext DialContextFunc
+	// Dialers are optional per transport dialers, overriding DialContext for
+	// the transport used to query the DNS servers.
+	Dialers *TransportDialers
+	// SourceAddrProvider is an optional provider of the source addresses used
+	// when sorting addresses according to RFC 6724. By default, source
+	// addresses are selected from the host's interface addresses, rather than
+	// probed using UDP sockets (which sandboxes often forbid).
+	SourceAddrProvider SourceAddrProvider
+	// QueryLog is an optional log that records every query sent to the DNS
+	// servers.
+	QueryLog *QueryLog
+	// QueryLimiter is an optional limiter of the total number of concurrent
+	// queries sent to the DNS servers.
+	QueryLimiter *QueryLimiter
+	// WorkerPool is an optional pool of workers used to send the queries of
+	// a lookup concurrently, capping the total number of goroutines.
+	WorkerPool *WorkerPool
+	// Events is an optional event distributor, that is notified when a DNS
+	// server becomes unhealthy (or healthy again).
+	Events *Events
+	// MaxSearchDomains is the maximum number of search domains tried, further
+	// search domains are ignored. By default, 3 (the search domains of a
+	// Kubernetes namespace).
+	MaxSearchDomains *int
+	// MaxNDots caps the ndots option, so that external names (eg.
+	// "example.com" with ndots:5) are not expanded with every search domain.
+	// By default, 1 (only single label names are expanded).
+	MaxNDots *int
+	// SearchConcurrency is the maximum number of search domain expansions of a
+	// name looked up concurrently, eg. 3 to look up the expansions with every
+	// search domain of a Kubernetes namespace at once.
+	// By default, 1 (expansions are looked up one at a time).
+	SearchConcurrency *int
+	// SearchMissTTL is how long not found results of search domain expansions
+	// (eg. "example.com.default.svc.cluster.local.") are cached, so that
+	// repeated lookups of external names don't walk the whole search list.
+	// By default, 5 seconds. Setting this to 0 disables caching search misses.
+	SearchMissTTL *time.Duration
+	// QueryHook is an optional hook that can rewrite or veto each name tried
+	// when applying the search domains, eg. NoSearchForTLDs.
+	QueryHook QueryHook
+	// OnConfigDiagnostic is an optional callback invoked with each problem
+	// found while parsing the DNS configuration (see SystemResolverConfig).
+	OnConfigDiagnostic func(ConfigDiagnostic)
+}
+
+// Container returns a system resolver with defaults suited to containers
+// (eg. Docker and Kubernetes):
+//
+//   - The number of search domains and the ndots option are capped, avoiding
+//     a cascade of queries for external names.
+//   - Source addresses are not probed when sorting addresses.
+//   - Not found results of search domain expansions are briefly cached.
+func Container(conf *ContainerResolverConfig) (Resolver, error) {
+	conf, err := defaults.WithDefaults(conf, &ContainerResolverConfig{
+		ResolvConfPath:   dnsconfig.Location,
+		MaxSearchDomains: ptr.To(3),
+		MaxNDots:         ptr.To(1),
+		SearchMissTTL:    ptr.To(5 * time.Second),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to container resolver config: %w", err)
+	}
+
+	if *conf.MaxSearchDomains < 0 || *conf.MaxNDots < 0 {
+		return nil, fmt.Errorf("max search domains and max ndots must not be negative")
+	}
+
+	srcAddrs := conf.SourceAddrProvider
+	if srcAddrs == nil {
+		srcAddrs = InterfaceSourceAddrProvider()
+	}
+
+	return system(&SystemResolverConfig{
+		HostsFilePath:      conf.HostsFilePath,
+		ResolvConfPath:     conf.ResolvConfPath,
+		DialContext:        conf.DialContext,
+		Dialers:            conf.Dialers,
+		SourceAddrProvider: srcAddrs,
+		QueryLog:           conf.QueryLog,
+		QueryLimiter:       conf.QueryLimiter,
+		WorkerPool:         conf.WorkerPool,
+		Events:             conf.Events,
+		SearchConcurrency:  conf.SearchConcurrency,
+		SearchMissTTL:      conf.SearchMissTTL,
+		QueryHook:          conf.QueryHook,
+		OnConfigDiagnostic: conf.OnConfigDiagnostic,
+	}, func(systemDNSConf *dnsconfig.Config) {
+		if len(systemDNSConf.Search) > *conf.MaxSearchDomains {
+			systemDNSConf.Search = systemDNSConf.Search[:*conf.MaxSearchDomains]
+		}
+
+		systemDNSConf.NDots = min(systemDNSConf.NDots, *conf.MaxNDots)
+	})
+}
+
+// DetectContainerEnvironment detects the container environment from the
+// resolv.conf file at resolvConfPath (by default, the system's DNS
+// configuration).
+func DetectContainerEnvironment(resolvConfPath string) (ContainerEnvironment, error) {
+	if resolvConfPath == "" {
+		resolvConfPath = dnsconfig.Location
+	}
+
+	systemDNSConf, err := dnsconfig.Read(resolvConfPath)
+	if err != nil {
+		return ContainerEnvironmentNone, fmt.Errorf("failed to read system DNS configuration: %w", err)
+	}
+
+	return detectContainerEnvironment(systemDNSConf), nil
+}
+
+func detectContainerEnvironment(systemDNSConf *dnsconfig.Config) ContainerEnvironment {
+	for _, domain := range systemDNSConf.Search {
+		if strings.HasPrefix(domain, "svc.") {
+			return ContainerEnvironmentKubernetes
+		}
+	}
+
+	if len(systemDNSConf.Servers) == 1 && systemDNSConf.Servers[0] == dockerEmbeddedDNSServer {
+		return ContainerEnvironmentDocker
+	}
+
+	return ContainerEnvironmentNone
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainerResolver(t *testing.T) {
+	srv := resolvertest.NewServer(t, nil)
+	srv.AddAddrs("api.default.svc.cluster.local.", time.Minute, netip.MustParseAddr("10.0.0.1"))
+	srv.AddAddrs("example.com.", time.Minute, netip.MustParseAddr("93.184.216.34"))
+
+	// Only the fourth name server (ignored by libc) is reachable.
+	var queried []string
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if !strings.HasPrefix(address, "10.96.0.13:") {
+			return nil, errors.New("unreachable")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Addr().String())
+	}
+
+	queryLog := resolver.NewQueryLog(100)
+
+	res, err := resolver.Container(&resolver.ContainerResolverConfig{
+		ResolvConfPath: "testdata/kubernetes-resolv.conf",
+		HostsFilePath:  "testdata/hosts",
+		DialContext:    dialContext,
+		QueryLog:       queryLog,
+	})
+	require.NoError(t, err)
+
+	// The search list of resolv.conf is kept (up to the first three domains).
+	d := resolver.Describe(res)
+	for d.Type != "relative" {
+		require.NotEmpty(t, d.Children)
+		d = d.Children[len(d.Children)-1]
+	}
+	require.Equal(t, "default.svc.cluster.local. svc.cluster.local. cluster.local.", d.Attributes["search"])
+
+	ctx := context.Background()
+
+	addrs, err := res.LookupNetIP(ctx, "ip4", "api")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+	addrs, err = res.LookupNetIP(ctx, "ip4", "example.com")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("93.184.216.34")}, addrs)
+
+	// The search domains beyond the first three are not tried.
+	_, err = res.LookupNetIP(ctx, "ip4", "missing")
+	require.Error(t, err)
+
+	for _, entry := range queryLog.Entries() {
+		queried = append(queried, entry.Name)
+	}
+	require.NotContains(t, queried, "missing.example.internal.")
+}
+
+func TestDetectContainerEnvironment(t *testing.T) {
+	env, err := resolver.DetectContainerEnvironment("testdata/kubernetes-resolv.conf")
+	require.NoError(t, err)
+	require.Equal(t, resolver.ContainerEnvironmentKubernetes, env)
+
+	env, err = resolver.DetectContainerEnvironment("testdata/docker-resolv.conf")
+	require.NoError(t, err)
+	require.Equal(t, resolver.ContainerEnvironmentDocker, env)
+
+	env, err = resolver.DetectContainerEnvironment("testdata/musl-resolv.conf")
+	require.NoError(t, err)
+	require.Equal(t, resolver.ContainerEnvironmentNone, env)
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+)
+
+// maxDebugErrors is the maximum number of recent errors reported by the debug
+// handler.
+const maxDebugErrors = 20
+
+// debugState is the JSON document reported by the debug handler.
+type debugState struct {
+	// Resolver describes the resolver chain, including runtime statistics
+	// such as cache hit rates, upstream health, and in-flight queries.
+	Resolver Description `json:"resolver"`
+	// RecentErrors are the most recent failed queries (newest first).
+	RecentErrors []QueryLogEntry `json:"recentErrors,omitempty"`
+}
+
+// DebugHandler returns an http.Handler that reports the state of the resolver
+// chain as JSON (eg. cache hit rates, upstream health, and in-flight queries).
+// If queryLog is not nil, the most recent failed queries are also reported.
+// The handler is intended to be mounted on an application's debug mux.
+func DebugHandler(resolver Resolver, queryLog *QueryLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(debugSnapshot(resolver, queryLog))
+	})
+}
+
+// DebugVar returns an expvar.Var that reports the same state as DebugHandler,
+// eg. for publishing with expvar.Publish.
+func DebugVar(resolver Resolver, queryLog *QueryLog) expvar.Var {
+	return expvar.Func(func() any {
+		return debugSnapshot(resolver, queryLog)
+	})
+}
+
+func debugSnapshot(resolver Resolver, queryLog *QueryLog) debugState {
+	state := debugState{
+		Resolver: Describe(resolver),
+	}
+
+	if queryLog != nil {
+		entries := queryLog.Entries()
+		for i := len(entries) - 1; i >= 0 && len(state.RecentErrors) < maxDebugErrors; i-- {
+			if entries[i].Err != "" {
+				state.RecentErrors = append(state.RecentErrors, entries[i])
+			}
+		}
+	}
+
+	return state
+}
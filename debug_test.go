@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugHandler(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+	})
+
+	queryLog := resolver.NewQueryLog(16)
+
+	dnsRes, err := resolver.NewDNS(srv.Addr(), resolver.WithQueryLog(queryLog))
+	require.NoError(t, err)
+
+	res, err := resolver.Cache(dnsRes, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+	}
+
+	_, err = res.LookupNetIP(context.Background(), "ip4", "missing.example.com")
+	require.Error(t, err)
+
+	rec := httptest.NewRecorder()
+	resolver.DebugHandler(res, queryLog).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var state struct {
+		Resolver     resolver.Description     `json:"resolver"`
+		RecentErrors []resolver.QueryLogEntry `json:"recentErrors"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+
+	require.Equal(t, "cache", state.Resolver.Type)
+	require.Equal(t, "1", state.Resolver.Attributes["hits"])
+	require.Equal(t, "2", state.Resolver.Attributes["misses"])
+	require.Equal(t, "0.33", state.Resolver.Attributes["hit-rate"])
+
+	require.Len(t, state.Resolver.Children, 1)
+	require.Equal(t, "2", state.Resolver.Children[0].Attributes["queries"])
+	require.Equal(t, "1", state.Resolver.Children[0].Attributes["failures"])
+	require.Equal(t, "0", state.Resolver.Children[0].Attributes["in-flight"])
+
+	require.Len(t, state.RecentErrors, 1)
+	require.Equal(t, "missing.example.com.", state.RecentErrors[0].Name)
+	require.Equal(t, "NXDOMAIN", state.RecentErrors[0].RCode)
+}
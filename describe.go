@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Description is a tree structured description of a resolver and the
+// resolvers it wraps. It is intended for logging and support tooling.
+type Description struct {
+	// Type is the kind of resolver (eg. "dns" or "sequential").
+	Type string `json:"type"`
+	// Attributes are type specific properties of the resolver (eg. the server
+	// address and transport of a DNS resolver).
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Children are the descriptions of the resolvers wrapped by this resolver.
+	Children []Description `json:"children,omitempty"`
+}
+
+// String returns a human readable, indented, representation of the tree.
+func (d Description) String() string {
+	var sb strings.Builder
+	d.write(&sb, 0)
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (d Description) write(sb *strings.Builder, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	sb.WriteString(d.Type)
+
+	keys := make([]string, 0, len(d.Attributes))
+	for key := range d.Attributes {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for i, key := range keys {
+		if i == 0 {
+			sb.WriteString(" ")
+		} else {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(sb, "%s=%s", key, d.Attributes[key])
+	}
+	sb.WriteString("\n")
+
+	for _, child := range d.Children {
+		child.write(sb, depth+1)
+	}
+}
+
+// Describer is implemented by resolvers that can describe themselves.
+type Describer interface {
+	// Describe returns a description of the resolver.
+	Describe() Description
+}
+
+// Describe returns a description of the resolver chain rooted at resolver.
+// Resolvers that don't implement Describer are described by their Go type.
+func Describe(resolver Resolver) Description {
+	if d, ok := resolver.(Describer); ok {
+		return d.Describe()
+	}
+
+	return Description{Type: fmt.Sprintf("%T", resolver)}
+}
+
+func describeAll(resolvers []Resolver) []Description {
+	descriptions := make([]Description, 0, len(resolvers))
+	for _, r := range resolvers {
+		descriptions = append(descriptions, Describe(r))
+	}
+	return descriptions
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribe(t *testing.T) {
+	dnsRes, err := resolver.DNS(resolver.DNSResolverConfig{
+		Server:    netip.MustParseAddrPort("192.0.2.53:53"),
+		Transport: ptr.To(resolver.DNSTransportTCP),
+	})
+	require.NoError(t, err)
+
+	penaltyBoxRes, err := resolver.PenaltyBox(dnsRes, nil)
+	require.NoError(t, err)
+
+	retryRes, err := resolver.Retry(resolver.Sequential(
+		resolver.Literal(),
+		penaltyBoxRes,
+		resolvertest.NewFake(),
+	), nil)
+	require.NoError(t, err)
+
+	res, err := resolver.Relative(retryRes, &resolver.RelativeResolverConfig{
+		Search: []string{"example.com."},
+		NDots:  ptr.To(2),
+	})
+	require.NoError(t, err)
+
+	d := resolver.Describe(res)
+
+	require.Equal(t, "relative", d.Type)
+	require.Equal(t, "example.com.", d.Attributes["search"])
+	require.Equal(t, "2", d.Attributes["ndots"])
+
+	require.Len(t, d.Children, 1)
+	retry := d.Children[0]
+	require.Equal(t, "retry", retry.Type)
+
+	require.Len(t, retry.Children, 1)
+	seq := retry.Children[0]
+	require.Equal(t, "sequential", seq.Type)
+
+	require.Len(t, seq.Children, 3)
+	require.Equal(t, "literal", seq.Children[0].Type)
+	require.Equal(t, "penalty-box", seq.Children[1].Type)
+	require.Equal(t, "*resolvertest.Fake", seq.Children[2].Type)
+
+	dns := seq.Children[1].Children[0]
+	require.Equal(t, "dns", dns.Type)
+	require.Equal(t, "192.0.2.53:53", dns.Attributes["server"])
+	require.Equal(t, "tcp", dns.Attributes["transport"])
+
+	require.Equal(t, `relative ndots=2, search=example.com.
+  retry attempts=2
+    sequential
+      literal
+      penalty-box initial-penalty=1s, max-penalty=1m0s, penalized=false
+        dns address-order=rfc6724, failures=0, in-flight=0, queries=0, server=192.0.2.53:53, timeout=5s, transport=tcp
+      *resolvertest.Fake`, d.String())
+}
@@ -0,0 +1,343 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*DHCPResolver)(nil)
+
+var errInvalidDHCPOption = errors.New("invalid dhcp option")
+
+// ParseDHCPv4DNSServers parses the payload of a DHCPv4 Domain Name Server
+// option (code 6), excluding the option code and length.
+func ParseDHCPv4DNSServers(data []byte) ([]netip.Addr, error) {
+	if len(data) == 0 || len(data)%net.IPv4len != 0 {
+		return nil, errInvalidDHCPOption
+	}
+
+	servers := make([]netip.Addr, 0, len(data)/net.IPv4len)
+	for i := 0; i < len(data); i += net.IPv4len {
+		servers = append(servers, netip.AddrFrom4([4]byte(data[i:i+net.IPv4len])))
+	}
+
+	return servers, nil
+}
+
+// ParseDHCPv4DomainSearch parses the payload of a DHCPv4 Domain Search option
+// (code 119, RFC 3397), excluding the option code and length. If the option
+// was split into multiple instances, their payloads must be concatenated (as
+// names may be compressed using pointers into earlier instances).
+func ParseDHCPv4DomainSearch(data []byte) ([]string, error) {
+	return parseDHCPDomainList(data)
+}
+
+// ParseDHCPv6DNSServers parses the payload of a DHCPv6 DNS Recursive Name
+// Server option (code 23, RFC 3646), excluding the option code and length.
+func ParseDHCPv6DNSServers(data []byte) ([]netip.Addr, error) {
+	if len(data) == 0 || len(data)%net.IPv6len != 0 {
+		return nil, errInvalidDHCPOption
+	}
+
+	servers := make([]netip.Addr, 0, len(data)/net.IPv6len)
+	for i := 0; i < len(data); i += net.IPv6len {
+		servers = append(servers, netip.AddrFrom16([16]byte(data[i:i+net.IPv6len])))
+	}
+
+	return servers, nil
+}
+
+// ParseDHCPv6DomainList parses the payload of a DHCPv6 Domain Search List
+// option (code 24, RFC 3646), excluding the option code and length.
+func ParseDHCPv6DomainList(data []byte) ([]string, error) {
+	return parseDHCPDomainList(data)
+}
+
+// parseDHCPDomainList parses a sequence of domain names in DNS wire format.
+func parseDHCPDomainList(data []byte) ([]string, error) {
+	var domains []string
+	for off := 0; off < len(data); {
+		name, n, err := dns.UnpackDomainName(data, off)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dhcp domain name: %w", err)
+		}
+		off = n
+
+		if name != "." {
+			domains = append(domains, dns.CanonicalName(name))
+		}
+	}
+
+	return domains, nil
+}
+
+// DHCPLease is the DNS configuration provided by a DHCP lease.
+type DHCPLease struct {
+	// Servers are the addresses of the DNS servers.
+	Servers []netip.Addr
+	// Search are the search domains (eg. from the Domain Search option, or
+	// the Domain Name option if there is none).
+	Search []string
+	// Expires is when the lease expires, the zero time means never (an
+	// infinite lease).
+	Expires time.Time
+}
+
+// DHCPResolverConfig is the configuration for a DHCP resolver.
+type DHCPResolverConfig struct {
+	// Timeout is the maximum duration to wait for a query to complete.
+	Timeout *time.Duration
+	// DialContext is used to establish a connection to a DNS server.
+	DialContext DialContextFunc
+	// AddressOrder is the policy used to order the returned addresses.
+	// By default, addresses are sorted according to RFC 6724.
+	AddressOrder *AddressOrder
+	// NDots is the number of dots in a name to trigger an absolute lookup,
+	// before the search domains are applied. By default, 1.
+	NDots *int
+}
+
+// DHCPResolver is a resolver that queries the DNS servers provided by DHCPv4
+// and DHCPv6 leases, applying the provided search domains. As this package
+// can't perform DHCP itself, the embedder (eg. a userspace network stack) is
+// responsible for feeding in the leases (eg. using ParseDHCPv4DNSServers and
+// ParseDHCPv4DomainSearch). The configuration of a lease is dropped once it
+// expires, unless renewed.
+type DHCPResolver struct {
+	conf DHCPResolverConfig
+
+	mu       sync.Mutex
+	v4       *DHCPLease
+	v6       *DHCPLease
+	resolver Resolver
+	// stale is true if the resolver must be rebuilt.
+	stale bool
+}
+
+// DHCP returns a resolver that queries the DNS servers provided by DHCP.
+// Until a lease is set (see SetV4Lease and SetV6Lease), all lookups fail.
+func DHCP(conf *DHCPResolverConfig) (*DHCPResolver, error) {
+	conf, err := defaults.WithDefaults(conf, &DHCPResolverConfig{
+		AddressOrder: ptr.To(AddressOrderRFC6724),
+		NDots:        ptr.To(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to dhcp resolver config: %w", err)
+	}
+
+	if err := conf.AddressOrder.validate(); err != nil {
+		return nil, err
+	}
+
+	if *conf.NDots < 0 {
+		return nil, fmt.Errorf("ndots must not be negative")
+	}
+
+	return &DHCPResolver{
+		conf: *conf,
+	}, nil
+}
+
+// SetV4Lease sets (or renews) the DHCPv4 lease, replacing the configuration
+// of any previous lease. A nil lease releases the current lease.
+func (r *DHCPResolver) SetV4Lease(lease *DHCPLease) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Renewals only extend the lease.
+	r.stale = r.stale || !sameDHCPConfig(r.v4, lease)
+	r.v4 = cloneDHCPLease(lease)
+}
+
+// SetV6Lease sets (or renews) the DHCPv6 lease, replacing the configuration
+// of any previous lease. A nil lease releases the current lease.
+func (r *DHCPResolver) SetV6Lease(lease *DHCPLease) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Renewals only extend the lease.
+	r.stale = r.stale || !sameDHCPConfig(r.v6, lease)
+	r.v6 = cloneDHCPLease(lease)
+}
+
+// Servers returns the DNS servers of the leases that haven't expired, those
+// of the DHCPv4 lease first.
+func (r *DHCPResolver) Servers() []netip.Addr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+	servers, _ := r.config()
+	return servers
+}
+
+// Search returns the search domains of the leases that haven't expired,
+// those of the DHCPv4 lease first.
+func (r *DHCPResolver) Search() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+	_, search := r.config()
+	return search
+}
+
+func (r *DHCPResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	resolver, err := r.current(host)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolver.LookupNetIP(ctx, network, host)
+}
+
+func (r *DHCPResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	resolver, err := r.current(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return lookupAddr(ctx, resolver, addr)
+}
+
+func (r *DHCPResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	resolver, err := r.current(q.Name)
+	if err != nil {
+		return Answer{}, err
+	}
+
+	return Lookup(ctx, resolver, q)
+}
+
+func (r *DHCPResolver) Describe() Description {
+	r.mu.Lock()
+	resolver := r.resolver
+	r.mu.Unlock()
+
+	d := Description{Type: "dhcp"}
+	if resolver != nil {
+		d.Children = []Description{Describe(resolver)}
+	}
+
+	return d
+}
+
+// current returns the resolver for the leases that haven't expired,
+// rebuilding it if the leases changed.
+func (r *DHCPResolver) current(name string) (Resolver, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+
+	if r.stale {
+		servers, search := r.config()
+
+		resolver, err := newAdvertisedResolver(servers, search,
+			r.conf.Timeout, r.conf.DialContext, r.conf.AddressOrder, r.conf.NDots)
+		if err != nil {
+			return nil, &net.DNSError{
+				Err:  err.Error(),
+				Name: name,
+			}
+		}
+
+		r.resolver = resolver
+		r.stale = false
+	}
+
+	if r.resolver == nil {
+		return nil, &net.DNSError{
+			Err:         "no dhcp provided resolvers available",
+			Name:        name,
+			IsTemporary: true,
+		}
+	}
+
+	return r.resolver, nil
+}
+
+// expire releases the leases that have expired.
+func (r *DHCPResolver) expire() {
+	now := time.Now()
+
+	if r.v4.expired(now) {
+		r.v4 = nil
+		r.stale = true
+	}
+
+	if r.v6.expired(now) {
+		r.v6 = nil
+		r.stale = true
+	}
+}
+
+// config returns the servers and (deduplicated) search domains of the
+// current leases.
+func (r *DHCPResolver) config() ([]netip.Addr, []string) {
+	var servers []netip.Addr
+	var search []string
+	for _, lease := range []*DHCPLease{r.v4, r.v6} {
+		if lease == nil {
+			continue
+		}
+
+		servers = append(servers, lease.Servers...)
+		for _, domain := range lease.Search {
+			domain = dns.CanonicalName(domain)
+			if !slices.Contains(search, domain) {
+				search = append(search, domain)
+			}
+		}
+	}
+
+	return servers, search
+}
+
+// expired returns true if the lease (if any) has expired by now.
+func (l *DHCPLease) expired(now time.Time) bool {
+	return l != nil && !l.Expires.IsZero() && !now.Before(l.Expires)
+}
+
+// sameDHCPConfig returns true if the leases provide the same DNS
+// configuration.
+func sameDHCPConfig(a, b *DHCPLease) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return slices.Equal(a.Servers, b.Servers) && slices.Equal(a.Search, b.Search)
+}
+
+// cloneDHCPLease returns a copy of the lease, so that later modifications by
+// the caller don't affect the resolver.
+func cloneDHCPLease(lease *DHCPLease) *DHCPLease {
+	if lease == nil {
+		return nil
+	}
+
+	return &DHCPLease{
+		Servers: slices.Clone(lease.Servers),
+		Search:  slices.Clone(lease.Search),
+		Expires: lease.Expires,
+	}
+}
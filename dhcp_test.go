@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDHCP(t *testing.T) {
+	t.Run("DHCPv4 DNS Servers", func(t *testing.T) {
+		servers, err := resolver.ParseDHCPv4DNSServers([]byte{10, 0, 0, 53, 10, 0, 0, 54})
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.53"), netip.MustParseAddr("10.0.0.54")}, servers)
+
+		_, err = resolver.ParseDHCPv4DNSServers([]byte{10, 0, 0})
+		require.Error(t, err)
+	})
+
+	t.Run("DHCPv4 Domain Search", func(t *testing.T) {
+		// The second name is compressed (RFC 3397), pointing to "example.com".
+		data := []byte("\x03eng\x07example\x03com\x00\x05sales\xc0\x04")
+
+		domains, err := resolver.ParseDHCPv4DomainSearch(data)
+		require.NoError(t, err)
+		require.Equal(t, []string{"eng.example.com.", "sales.example.com."}, domains)
+
+		_, err = resolver.ParseDHCPv4DomainSearch(data[:6])
+		require.Error(t, err)
+	})
+
+	t.Run("DHCPv6 DNS Servers", func(t *testing.T) {
+		server := netip.MustParseAddr("2001:db8::53").As16()
+
+		servers, err := resolver.ParseDHCPv6DNSServers(server[:])
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("2001:db8::53")}, servers)
+
+		_, err = resolver.ParseDHCPv6DNSServers(server[:8])
+		require.Error(t, err)
+	})
+
+	t.Run("DHCPv6 Domain List", func(t *testing.T) {
+		domains, err := resolver.ParseDHCPv6DomainList([]byte("\x04corp\x08internal\x00"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"corp.internal."}, domains)
+	})
+}
+
+func TestDHCPResolver(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"www.example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+	})
+
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Addr().String())
+	}
+
+	newDHCP := func(t *testing.T) *resolver.DHCPResolver {
+		res, err := resolver.DHCP(&resolver.DHCPResolverConfig{
+			DialContext:  dialContext,
+			AddressOrder: ptr.To(resolver.AddressOrderNone),
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("No Lease", func(t *testing.T) {
+		res := newDHCP(t)
+
+		_, err := res.LookupNetIP(context.Background(), "ip4", "www.example.com")
+		require.Error(t, err)
+	})
+
+	t.Run("Leases", func(t *testing.T) {
+		res := newDHCP(t)
+
+		res.SetV4Lease(&resolver.DHCPLease{
+			Servers: []netip.Addr{netip.MustParseAddr("10.0.0.53")},
+			Search:  []string{"example.com"},
+			Expires: time.Now().Add(time.Hour),
+		})
+		res.SetV6Lease(&resolver.DHCPLease{
+			Servers: []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+			Search:  []string{"example.com.", "corp.internal."},
+		})
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.53"), netip.MustParseAddr("2001:db8::53")}, res.Servers())
+		require.Equal(t, []string{"example.com.", "corp.internal."}, res.Search())
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip4", "www")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+		// Releasing the DHCPv6 lease leaves the DHCPv4 configuration.
+		res.SetV6Lease(nil)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.53")}, res.Servers())
+		require.Equal(t, []string{"example.com."}, res.Search())
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		res := newDHCP(t)
+
+		res.SetV4Lease(&resolver.DHCPLease{
+			Servers: []netip.Addr{netip.MustParseAddr("10.0.0.53")},
+			Expires: time.Now().Add(500 * time.Millisecond),
+		})
+
+		_, err := res.LookupNetIP(context.Background(), "ip4", "www.example.com")
+		require.NoError(t, err)
+
+		time.Sleep(600 * time.Millisecond)
+
+		require.Empty(t, res.Servers())
+
+		_, err = res.LookupNetIP(context.Background(), "ip4", "www.example.com")
+		require.Error(t, err)
+	})
+}
+
+func TestSystemResolverDHCP(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+	})
+
+	// Only the DHCP provided server is reachable.
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if !strings.HasPrefix(address, "10.0.0.53:") {
+			return nil, errors.New("unreachable")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Addr().String())
+	}
+
+	dhcp, err := resolver.DHCP(&resolver.DHCPResolverConfig{
+		DialContext: dialContext,
+	})
+	require.NoError(t, err)
+
+	dhcp.SetV4Lease(&resolver.DHCPLease{
+		Servers: []netip.Addr{netip.MustParseAddr("10.0.0.53")},
+	})
+
+	res, err := resolver.System(&resolver.SystemResolverConfig{
+		ResolvConfPath: "testdata/kubernetes-resolv.conf",
+		DNSOnly:        ptr.To(true),
+		AddressOrder:   ptr.To(resolver.AddressOrderNone),
+		DialContext:    dialContext,
+		DHCP:           dhcp,
+	})
+	require.NoError(t, err)
+
+	addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com.")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+}
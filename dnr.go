@@ -0,0 +1,495 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*DNRResolver)(nil)
+
+// Service parameter keys used by DNR (RFC 9460).
+const (
+	svcParamKeyALPN    = 1
+	svcParamKeyPort    = 3
+	svcParamKeyDoHPath = 7
+)
+
+var errTruncatedDNROption = errors.New("truncated dnr option")
+
+// DNRInstance is an encrypted DNS resolver advertised by the network using
+// Discovery of Network-designated Resolvers (DNR), as defined in RFC 9463.
+type DNRInstance struct {
+	// Priority is the service priority of the resolver, lower values are
+	// preferred.
+	Priority uint16
+	// ADN is the authentication domain name of the resolver, used to verify
+	// its certificate.
+	ADN string
+	// Addrs are the addresses of the resolver. Instances without addresses
+	// (ADN-only mode) are ignored, as they can't be bootstrapped.
+	Addrs []netip.Addr
+	// ALPN is the set of protocols supported by the resolver (eg. "dot").
+	ALPN []string
+	// Port is the optional port of the resolver.
+	Port uint16
+	// DoHPath is the optional URI template for DNS over HTTPS.
+	DoHPath string
+	// Lifetime is how long the instance is valid for (router advertisements
+	// only), zero means there is no lifetime.
+	Lifetime time.Duration
+}
+
+// ParseDHCPv4DNR parses the payload of a DHCPv4 Encrypted DNS option (code
+// 162), excluding the option code and length. The payload may contain
+// multiple instances.
+func ParseDHCPv4DNR(data []byte) ([]DNRInstance, error) {
+	var instances []DNRInstance
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errTruncatedDNROption
+		}
+
+		n := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if len(data) < n {
+			return nil, errTruncatedDNROption
+		}
+
+		instance, err := parseDHCPv4DNRInstance(data[:n])
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+func parseDHCPv4DNRInstance(data []byte) (DNRInstance, error) {
+	var instance DNRInstance
+
+	if len(data) < 3 {
+		return instance, errTruncatedDNROption
+	}
+
+	instance.Priority = binary.BigEndian.Uint16(data)
+
+	adnLen := int(data[2])
+	data = data[3:]
+	if len(data) < adnLen {
+		return instance, errTruncatedDNROption
+	}
+
+	var err error
+	instance.ADN, err = parseDNRName(data[:adnLen])
+	if err != nil {
+		return instance, err
+	}
+	data = data[adnLen:]
+
+	// ADN-only mode.
+	if len(data) == 0 {
+		return instance, nil
+	}
+
+	addrsLen := int(data[0])
+	data = data[1:]
+	if len(data) < addrsLen || addrsLen%net.IPv4len != 0 {
+		return instance, errTruncatedDNROption
+	}
+
+	for i := 0; i < addrsLen; i += net.IPv4len {
+		instance.Addrs = append(instance.Addrs, netip.AddrFrom4([4]byte(data[i:i+net.IPv4len])))
+	}
+
+	if err := parseSvcParams(&instance, data[addrsLen:]); err != nil {
+		return instance, err
+	}
+
+	return instance, nil
+}
+
+// ParseDHCPv6DNR parses the payload of a DHCPv6 OPTION_V6_DNR option (code
+// 144), excluding the option code and length.
+func ParseDHCPv6DNR(data []byte) (DNRInstance, error) {
+	var instance DNRInstance
+
+	if len(data) < 4 {
+		return instance, errTruncatedDNROption
+	}
+
+	instance.Priority = binary.BigEndian.Uint16(data)
+	data = data[2:]
+
+	data, err := parseDNRv6Body(&instance, data)
+	if err != nil {
+		return instance, err
+	}
+
+	if err := parseSvcParams(&instance, data); err != nil {
+		return instance, err
+	}
+
+	return instance, nil
+}
+
+// ParseRADNR parses an IPv6 Router Advertisement Encrypted DNS option (type
+// 144), including the option type and length.
+func ParseRADNR(data []byte) (DNRInstance, error) {
+	var instance DNRInstance
+
+	if len(data) < 10 {
+		return instance, errTruncatedDNROption
+	}
+
+	// The length is in units of 8 octets.
+	n := int(data[1]) * 8
+	if n < 10 || len(data) < n {
+		return instance, errTruncatedDNROption
+	}
+	data = data[:n]
+
+	instance.Priority = binary.BigEndian.Uint16(data[2:])
+	instance.Lifetime = time.Duration(binary.BigEndian.Uint32(data[4:])) * time.Second
+	data = data[8:]
+
+	data, err := parseDNRv6Body(&instance, data)
+	if err != nil {
+		return instance, err
+	}
+
+	// ADN-only mode (possibly followed by padding).
+	if len(instance.Addrs) == 0 {
+		return instance, nil
+	}
+
+	if len(data) < 2 {
+		return instance, errTruncatedDNROption
+	}
+
+	svcParamsLen := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < svcParamsLen {
+		return instance, errTruncatedDNROption
+	}
+
+	// Anything after the service parameters is padding.
+	if err := parseSvcParams(&instance, data[:svcParamsLen]); err != nil {
+		return instance, err
+	}
+
+	return instance, nil
+}
+
+// parseDNRv6Body parses the ADN and addresses of a DHCPv6 or RA option,
+// returning the remaining data.
+func parseDNRv6Body(instance *DNRInstance, data []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, errTruncatedDNROption
+	}
+
+	adnLen := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < adnLen {
+		return nil, errTruncatedDNROption
+	}
+
+	var err error
+	instance.ADN, err = parseDNRName(data[:adnLen])
+	if err != nil {
+		return nil, err
+	}
+	data = data[adnLen:]
+
+	// ADN-only mode (in RA options, only padding may follow).
+	if len(data) < 2 || isPadding(data) {
+		return nil, nil
+	}
+
+	addrsLen := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < addrsLen || addrsLen%net.IPv6len != 0 {
+		return nil, errTruncatedDNROption
+	}
+
+	for i := 0; i < addrsLen; i += net.IPv6len {
+		instance.Addrs = append(instance.Addrs, netip.AddrFrom16([16]byte(data[i:i+net.IPv6len])))
+	}
+
+	return data[addrsLen:], nil
+}
+
+// parseDNRName parses an uncompressed domain name in DNS wire format.
+func parseDNRName(data []byte) (string, error) {
+	name, n, err := dns.UnpackDomainName(data, 0)
+	if err != nil {
+		return "", fmt.Errorf("invalid dnr authentication domain name: %w", err)
+	}
+
+	if n != len(data) || name == "." {
+		return "", fmt.Errorf("invalid dnr authentication domain name")
+	}
+
+	return name, nil
+}
+
+// parseSvcParams parses the service parameters of a DNR instance, unknown
+// parameters are ignored.
+func parseSvcParams(instance *DNRInstance, data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return errTruncatedDNROption
+		}
+
+		key := binary.BigEndian.Uint16(data)
+		n := int(binary.BigEndian.Uint16(data[2:]))
+		data = data[4:]
+		if len(data) < n {
+			return errTruncatedDNROption
+		}
+		value := data[:n]
+		data = data[n:]
+
+		switch key {
+		case svcParamKeyALPN:
+			for len(value) > 0 {
+				l := int(value[0])
+				if l == 0 || len(value) < 1+l {
+					return errTruncatedDNROption
+				}
+				instance.ALPN = append(instance.ALPN, string(value[1:1+l]))
+				value = value[1+l:]
+			}
+		case svcParamKeyPort:
+			if len(value) != 2 {
+				return errTruncatedDNROption
+			}
+			instance.Port = binary.BigEndian.Uint16(value)
+		case svcParamKeyDoHPath:
+			instance.DoHPath = string(value)
+		}
+	}
+
+	return nil
+}
+
+func isPadding(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// DNRResolverConfig is the configuration for a DNR resolver.
+type DNRResolverConfig struct {
+	// Timeout is the maximum duration to wait for a query to complete.
+	Timeout *time.Duration
+	// DialContext is used to establish a connection to a DNS server.
+	DialContext DialContextFunc
+	// AddressOrder is the policy used to order the returned addresses.
+	// By default, addresses are sorted according to RFC 6724.
+	AddressOrder *AddressOrder
+	// TLSConfig is an optional base configuration for the TLS client, the
+	// server name is set to the authentication domain name of each resolver.
+	TLSConfig *tls.Config
+	// Zone is the optional zone (ie. the name or index of the network
+	// interface) the instances were learned on, eg. from a router
+	// advertisement. It's applied to link-local resolver addresses without a
+	// zone, which are otherwise unreachable.
+	Zone string
+}
+
+// DNRResolver is a resolver that queries the encrypted resolvers advertised by
+// the network using Discovery of Network-designated Resolvers (RFC 9463).
+// As this package can't observe DHCP or router advertisements itself, the
+// embedder is responsible for feeding in the advertised resolvers (eg. using
+// ParseDHCPv4DNR, ParseDHCPv6DNR, or ParseRADNR).
+type DNRResolver struct {
+	conf DNRResolverConfig
+
+	mu        sync.RWMutex
+	instances []DNRInstance
+	resolver  Resolver
+}
+
+// DNR returns a resolver that queries the network-designated encrypted
+// resolvers. Only DNS over TLS ("dot") resolvers are currently supported,
+// other instances are ignored. Until SetInstances is called, all lookups
+// fail.
+func DNR(conf *DNRResolverConfig) (*DNRResolver, error) {
+	conf, err := defaults.WithDefaults(conf, &DNRResolverConfig{
+		AddressOrder: ptr.To(AddressOrderRFC6724),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to dnr resolver config: %w", err)
+	}
+
+	if err := conf.AddressOrder.validate(); err != nil {
+		return nil, err
+	}
+
+	return &DNRResolver{
+		conf: *conf,
+	}, nil
+}
+
+// SetInstances replaces the set of advertised resolvers (eg. after a DHCP
+// lease is renewed). Resolvers are tried in order of priority.
+func (r *DNRResolver) SetInstances(instances []DNRInstance) error {
+	instances = slices.Clone(instances)
+	slices.SortStableFunc(instances, func(a, b DNRInstance) int {
+		return int(a.Priority) - int(b.Priority)
+	})
+
+	var resolvers []Resolver
+	for _, instance := range instances {
+		if len(instance.Addrs) == 0 || !slices.Contains(instance.ALPN, "dot") {
+			continue
+		}
+
+		var tlsConfig *tls.Config
+		if r.conf.TLSConfig != nil {
+			tlsConfig = r.conf.TLSConfig.Clone()
+		} else {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.ServerName = strings.TrimSuffix(instance.ADN, ".")
+
+		port := instance.Port
+		if port == 0 {
+			port = 853
+		}
+
+		for _, addr := range instance.Addrs {
+			if addr.Is6() && addr.IsLinkLocalUnicast() && addr.Zone() == "" {
+				addr = addr.WithZone(r.conf.Zone)
+			}
+
+			dnsResolver, err := DNS(DNSResolverConfig{
+				Server:       netip.AddrPortFrom(addr, port),
+				Transport:    ptr.To(DNSTransportTLS),
+				Timeout:      r.conf.Timeout,
+				DialContext:  r.conf.DialContext,
+				AddressOrder: r.conf.AddressOrder,
+				TLSConfig:    tlsConfig,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create dns resolver for %s: %w", instance.ADN, err)
+			}
+
+			penaltyBoxResolver, err := PenaltyBox(dnsResolver, nil)
+			if err != nil {
+				return fmt.Errorf("failed to create penalty box resolver: %w", err)
+			}
+
+			resolvers = append(resolvers, penaltyBoxResolver)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.instances = instances
+	r.resolver = nil
+	if len(resolvers) > 0 {
+		r.resolver = Sequential(resolvers...)
+	}
+
+	return nil
+}
+
+// Instances returns the currently advertised resolvers, in order of
+// priority.
+func (r *DNRResolver) Instances() []DNRInstance {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.instances)
+}
+
+func (r *DNRResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	r.mu.RLock()
+	resolver := r.resolver
+	r.mu.RUnlock()
+
+	if resolver == nil {
+		return nil, &net.DNSError{
+			Err:         "no network-designated resolvers available",
+			Name:        host,
+			IsTemporary: true,
+		}
+	}
+
+	return resolver.LookupNetIP(ctx, network, host)
+}
+
+func (r *DNRResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.mu.RLock()
+	resolver := r.resolver
+	r.mu.RUnlock()
+
+	if resolver == nil {
+		return nil, &net.DNSError{
+			Err:         "no network-designated resolvers available",
+			Name:        addr,
+			IsTemporary: true,
+		}
+	}
+
+	return lookupAddr(ctx, resolver, addr)
+}
+
+func (r *DNRResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	r.mu.RLock()
+	resolver := r.resolver
+	r.mu.RUnlock()
+
+	if resolver == nil {
+		return Answer{}, &net.DNSError{
+			Err:         "no network-designated resolvers available",
+			Name:        q.Name,
+			IsTemporary: true,
+		}
+	}
+
+	return Lookup(ctx, resolver, q)
+}
+
+func (r *DNRResolver) Describe() Description {
+	r.mu.RLock()
+	resolver := r.resolver
+	r.mu.RUnlock()
+
+	d := Description{Type: "dnr"}
+	if resolver != nil {
+		d.Children = []Description{Describe(resolver)}
+	}
+
+	return d
+}
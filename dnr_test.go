@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDNR(t *testing.T) {
+	// "dns.example.net." in DNS wire format.
+	adn := []byte("\x03dns\x07example\x03net\x00")
+
+	// alpn=dot,h2 port=8853
+	svcParams := []byte{0, 1, 0, 7, 3, 'd', 'o', 't', 2, 'h', '2', 0, 3, 0, 2, 0x22, 0x95}
+
+	t.Run("DHCPv4", func(t *testing.T) {
+		instance := []byte{0, 1, byte(len(adn))}
+		instance = append(instance, adn...)
+		instance = append(instance, 8, 192, 0, 2, 1, 192, 0, 2, 2)
+		instance = append(instance, svcParams...)
+
+		data := binary.BigEndian.AppendUint16(nil, uint16(len(instance)))
+		data = append(data, instance...)
+
+		// An ADN-only instance.
+		adnOnly := []byte{0, 2, byte(len(adn))}
+		adnOnly = append(adnOnly, adn...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(adnOnly)))
+		data = append(data, adnOnly...)
+
+		instances, err := resolver.ParseDHCPv4DNR(data)
+		require.NoError(t, err)
+
+		require.Equal(t, []resolver.DNRInstance{
+			{
+				Priority: 1,
+				ADN:      "dns.example.net.",
+				Addrs:    []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")},
+				ALPN:     []string{"dot", "h2"},
+				Port:     8853,
+			},
+			{
+				Priority: 2,
+				ADN:      "dns.example.net.",
+			},
+		}, instances)
+
+		_, err = resolver.ParseDHCPv4DNR(data[:len(data)-1])
+		require.Error(t, err)
+	})
+
+	t.Run("DHCPv6", func(t *testing.T) {
+		data := []byte{0, 1}
+		data = binary.BigEndian.AppendUint16(data, uint16(len(adn)))
+		data = append(data, adn...)
+		data = binary.BigEndian.AppendUint16(data, 16)
+		data = append(data, netip.MustParseAddr("2001:db8::53").AsSlice()...)
+		data = append(data, svcParams...)
+
+		instance, err := resolver.ParseDHCPv6DNR(data)
+		require.NoError(t, err)
+
+		require.Equal(t, resolver.DNRInstance{
+			Priority: 1,
+			ADN:      "dns.example.net.",
+			Addrs:    []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+			ALPN:     []string{"dot", "h2"},
+			Port:     8853,
+		}, instance)
+	})
+
+	t.Run("Router Advertisement", func(t *testing.T) {
+		data := []byte{144, 0, 0, 1, 0, 0, 0x0e, 0x10}
+		data = binary.BigEndian.AppendUint16(data, uint16(len(adn)))
+		data = append(data, adn...)
+		data = binary.BigEndian.AppendUint16(data, 16)
+		data = append(data, netip.MustParseAddr("2001:db8::53").AsSlice()...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(svcParams)))
+		data = append(data, svcParams...)
+		for len(data)%8 != 0 {
+			data = append(data, 0)
+		}
+		data[1] = byte(len(data) / 8)
+
+		instance, err := resolver.ParseRADNR(data)
+		require.NoError(t, err)
+
+		require.Equal(t, resolver.DNRInstance{
+			Priority: 1,
+			ADN:      "dns.example.net.",
+			Addrs:    []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+			ALPN:     []string{"dot", "h2"},
+			Port:     8853,
+			Lifetime: time.Hour,
+		}, instance)
+	})
+}
+
+func TestDNRResolver(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+		TLS: ptr.To(true),
+	})
+
+	res, err := resolver.DNR(&resolver.DNRResolverConfig{
+		TLSConfig: srv.ClientTLSConfig(),
+	})
+	require.NoError(t, err)
+
+	_, err = res.LookupNetIP(context.Background(), "ip4", "example.com")
+	require.Error(t, err)
+
+	err = res.SetInstances([]resolver.DNRInstance{
+		{
+			// Not supported.
+			Priority: 1,
+			ADN:      "dns.resolvertest.",
+			Addrs:    []netip.Addr{srv.TLSAddr().Addr()},
+			ALPN:     []string{"h3"},
+		},
+		{
+			Priority: 2,
+			ADN:      "dns.resolvertest.",
+			Addrs:    []netip.Addr{srv.TLSAddr().Addr()},
+			ALPN:     []string{"dot"},
+			Port:     srv.TLSAddr().Port(),
+		},
+	})
+	require.NoError(t, err)
+
+	addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+	require.NoError(t, err)
+
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+	require.Len(t, res.Instances(), 2)
+}
+
+func TestDNRResolverZone(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+		TLS: ptr.To(true),
+	})
+
+	var dialed []string
+	res, err := resolver.DNR(&resolver.DNRResolverConfig{
+		TLSConfig: srv.ClientTLSConfig(),
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed = append(dialed, address)
+			return (&net.Dialer{}).DialContext(ctx, network, srv.TLSAddr().String())
+		},
+		Zone: "eth0",
+	})
+	require.NoError(t, err)
+
+	// Router advertisements commonly advertise link-local resolvers.
+	err = res.SetInstances([]resolver.DNRInstance{
+		{
+			Priority: 1,
+			ADN:      "dns.resolvertest.",
+			Addrs:    []netip.Addr{netip.MustParseAddr("fe80::53")},
+			ALPN:     []string{"dot"},
+		},
+	})
+	require.NoError(t, err)
+
+	addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+	require.NotEmpty(t, dialed)
+	require.Equal(t, "[fe80::53%eth0]:853", dialed[0])
+}
@@ -44,20 +44,43 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
 	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
-	"github.com/noisysockets/resolver/internal/addrselect"
-	"github.com/noisysockets/util/defaults"
+	"github.com/noisysockets/resolver/internal/defaults"
 	"github.com/noisysockets/util/ptr"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 var _ Resolver = (*dnsResolver)(nil)
 
+var (
+	qTypesIP       = []uint16{dns.TypeA, dns.TypeAAAA}
+	qTypesIP6First = []uint16{dns.TypeAAAA, dns.TypeA}
+	qTypesIP4      = []uint16{dns.TypeA}
+	qTypesIP6      = []uint16{dns.TypeAAAA}
+)
+
+// msgPool reduces allocations by reusing query messages.
+var msgPool = sync.Pool{
+	New: func() any {
+		return new(dns.Msg)
+	},
+}
+
+// tlsSessionCacheSize is the number of TLS sessions cached per DNS over TLS
+// resolver.
+const tlsSessionCacheSize = 8
+
 // DNSTransport is the transport protocol used for DNS resolution.
 type DNSTransport string
 
@@ -68,6 +91,19 @@ const (
 	DNSTransportTCP DNSTransport = "tcp"
 	// DNSTransportTLS is DNS over TLS as defined in RFC 7858.
 	DNSTransportTLS DNSTransport = "tcp-tls"
+	// DNSTransportHTTPS is DNS over HTTPS as defined in RFC 8484.
+	DNSTransportHTTPS DNSTransport = "https"
+)
+
+// DNSQueryOrder is the order in which A and AAAA queries are issued when
+// looking up both address families.
+type DNSQueryOrder string
+
+const (
+	// DNSQueryOrderAFirst issues the A query before the AAAA query.
+	DNSQueryOrderAFirst DNSQueryOrder = "a-first"
+	// DNSQueryOrderAAAAFirst issues the AAAA query before the A query.
+	DNSQueryOrderAAAAFirst DNSQueryOrder = "aaaa-first"
 )
 
 // DNSResolverConfig is the configuration for a DNS resolver.
@@ -77,74 +113,498 @@ type DNSResolverConfig struct {
 	// Transport is the optional transport protocol used for DNS resolution.
 	// By default, plain DNS over UDP is used.
 	Transport *DNSTransport
+	// URL is the URL (or RFC 8484 URI template) of the DNS over HTTPS
+	// endpoint, eg. "https://dns.example/dns-query{?dns}". Required by (and
+	// only used with) the DNS over HTTPS transport. Requests are always sent
+	// to Server, the host of the URL is only used for the Host header and
+	// (by default) the TLS server name.
+	URL string
+	// HTTP3RoundTripper is an optional HTTP/3 transport (eg. quic-go's
+	// http3.Transport) for DNS over HTTPS, it must connect to Server (rather
+	// than the host of the URL) using TLSConfig. Queries are sent over HTTP/2
+	// until the server advertises HTTP/3 support (using Alt-Svc, RFC 7838),
+	// and fall back to HTTP/2 for a while if HTTP/3 fails (eg. when UDP is
+	// blocked).
+	HTTP3RoundTripper http.RoundTripper
+	// HTTPHeader contains additional HTTP headers sent with DNS over HTTPS
+	// requests, eg. a User-Agent, or an Authorization header for private
+	// resolver gateways. The Content-Type and Accept headers can't be
+	// overridden.
+	HTTPHeader http.Header
 	// Timeout is the maximum duration to wait for a query to complete.
 	Timeout *time.Duration
 	// DialContext is used to establish a connection to a DNS server.
 	DialContext DialContextFunc
-	// TLSConfig is the configuration for the TLS client used for DNS over TLS.
+	// Dialers are optional per transport dialers, overriding DialContext for
+	// the selected transport (and source address probes).
+	Dialers *TransportDialers
+	// Interface is the optional name of a network interface that queries
+	// (over any transport) are bound to, eg. to force queries out of a
+	// specific interface in split tunnel VPN setups. This uses
+	// SO_BINDTODEVICE on Linux and IP_BOUND_IF/IPV6_BOUND_IF on macOS, it is
+	// not supported on other platforms (use LocalAddr instead). Can't be
+	// combined with DialContext or Dialers. It's also used as the zone of a
+	// link-local Server without one.
+	Interface string
+	// LocalAddr is the optional local (source) address that queries (over any
+	// transport) are sent from. Can't be combined with DialContext or
+	// Dialers.
+	LocalAddr netip.Addr
+	// AddressOrder is the policy used to order the returned addresses.
+	// By default, addresses are sorted according to RFC 6724.
+	AddressOrder *AddressOrder
+	// PolicyTable is an optional RFC 6724 address selection policy table used
+	// when sorting addresses. By default, the table from RFC 6724 is used.
+	PolicyTable []PolicyTableEntry
+	// SourceAddrProvider is an optional provider of the source addresses used
+	// when sorting addresses according to RFC 6724. By default, source
+	// addresses are probed using DialContext (if provided) or selected from
+	// the host's interface addresses.
+	SourceAddrProvider SourceAddrProvider
+	// TLSConfig is the configuration for the TLS client used for DNS over TLS
+	// and DNS over HTTPS.
 	TLSConfig *tls.Config
+	// NoTLSSessionResumption disables TLS session resumption for DNS over TLS.
+	// By default, sessions are resumed, which avoids a full handshake when
+	// reconnecting to the server. Sessions are cached per resolver, unless
+	// TLSConfig provides a ClientSessionCache. Note that TLS 1.3 early data
+	// (0-RTT) is not supported by the Go TLS client.
+	NoTLSSessionResumption *bool
+	// SPKIPins is an optional set of base64 encoded SHA-256 hashes of trusted
+	// certificate public keys (see SPKIHash). If set, DNS over TLS connections
+	// are only accepted if a certificate presented by the server matches one
+	// of the pins. If TLSConfig doesn't specify a ServerName, the pins replace
+	// certificate chain and hostname verification (useful for servers that are
+	// addressed by IP).
+	SPKIPins []string
+	// VerifyConnection is an optional hook that is called after the TLS
+	// handshake (and any pin checks) of a DNS over TLS connection. If it
+	// returns an error, the connection is aborted.
+	VerifyConnection func(cs tls.ConnectionState) error
 	// SingleRequest is used to query A and AAAA records sequentially.
 	// This is mostly useful for avoiding conntrack race issues with DNS over UDP.
 	// If you feel the need to enable this, you should probably just use
 	// DNS over TCP instead.
 	SingleRequest *bool
+	// QueryOrder is the order in which A and AAAA queries are issued. This
+	// only matters when queries are sent sequentially or when the number of
+	// in-flight queries is limited. By default, A queries are sent first.
+	QueryOrder *DNSQueryOrder
+	// MaxInFlightQueries is the maximum number of concurrent queries that will
+	// be sent to the server, excess queries will wait for a slot to become
+	// available. This is useful on constrained links (eg. mobile or
+	// satellite). By default, there is no limit.
+	MaxInFlightQueries *int
+	// TrustAD trusts the server to validate responses using DNSSEC (like the
+	// trust-ad option of glibc). The AD (authenticated data) flag is set on
+	// queries and the AD bit of responses is exposed using WithMetadata.
+	// Otherwise, the AD bit of responses is cleared. By default, disabled.
+	TrustAD *bool
+	// TCPFallback escalates DNS over UDP queries that fail without a response
+	// from the server (eg. a timeout) by retrying them over TCP to the same
+	// server, before the failure is returned (eg. so that Sequential can move
+	// on to the next server). By default, disabled.
+	TCPFallback *bool
+	// QueryLimiter is an optional limiter that is shared with other resolvers,
+	// limiting the total number of concurrent queries sent to all of them.
+	// MaxInFlightQueries can be used in addition, as a per server sub-limit.
+	QueryLimiter *QueryLimiter
+	// WorkerPool is an optional pool that is shared with other resolvers,
+	// limiting the total number of goroutines spawned to send the A and AAAA
+	// queries of lookups concurrently. Once every worker is busy, the queries
+	// are sent sequentially instead. By default, there is no limit.
+	WorkerPool *WorkerPool
+	// MaxResponseSize is the maximum size in bytes of a response message.
+	// Larger responses are rejected before they are parsed.
+	MaxResponseSize *int
+	// MaxAnswers is the maximum number of answer records accepted in a
+	// response. By default (or if zero), the number of answer records is
+	// unlimited, as large RRsets (eg. of round robin pools) are legitimate.
+	MaxAnswers *int
+	// MaxCNAMEChain is the maximum number of CNAME records accepted in a
+	// response.
+	MaxCNAMEChain *int
+	// LowAllocation enables a low allocation wire format implementation
+	// (based on golang.org/x/net/dns/dnsmessage) for A and AAAA queries.
+	// This is useful for high query rate applications that are sensitive to
+	// per query garbage. It is not used with StrictResponseMatching.
+	LowAllocation *bool
+	// CaseRandomization randomizes the case of the letters in query names
+	// (DNS 0x20 encoding), adding entropy an off-path attacker has to guess
+	// to spoof a response. Servers echo the query name as is, so responses
+	// that don't match the randomized case are dropped. This implies
+	// StrictResponseMatching. By default, disabled.
+	CaseRandomization *bool
+	// StrictResponseMatching verifies that responses match the query (the
+	// ID, the question including the case of the name, and the owner names of
+	// the answer records). Mismatched responses received over UDP (eg. spoofed
+	// responses, or late replies to earlier queries) are silently dropped
+	// while waiting for a matching response, rather than failing the lookup.
+	// Over TCP and TLS, a mismatched response fails the lookup.
+	// By default, disabled.
+	StrictResponseMatching *bool
+	// NoPadding disables the padding of queries sent over encrypted
+	// transports (DNS over TLS and DNS over HTTPS). By default, queries are
+	// padded using the EDNS(0) padding option (RFC 7830), to a multiple of 128
+	// bytes as recommended by RFC 8467. This hides the length of query names
+	// from on-path observers. Queries sent over unencrypted transports are
+	// never padded.
+	NoPadding *bool
+	// QueryLog is an optional log that records every query sent to the
+	// server. It can be shared between multiple resolvers.
+	QueryLog *QueryLog
+	// AdaptiveTimeout derives the timeout of each query from the measured
+	// round trip time of the server (see Stats), bounded by Timeout. Queries
+	// to fast servers fail over sooner, and the timeout backs off
+	// exponentially while the server doesn't respond.
+	// By default, disabled (every query uses Timeout).
+	AdaptiveTimeout *bool
 }
 
 // dnsResolver is a DNS resolver.
 type dnsResolver struct {
-	server        netip.AddrPort
-	transport     DNSTransport
-	timeout       time.Duration
-	dialContext   DialContextFunc
-	tlsConfig     *tls.Config
-	singleRequest bool
+	server          netip.AddrPort
+	serverAddr      string
+	transport       DNSTransport
+	timeout         time.Duration
+	dialContext     DialContextFunc
+	sorter          addrSorter
+	tlsConfig       *tls.Config
+	dohURL          string
+	dohClient       *http.Client
+	dohHeader       http.Header
+	singleRequest   bool
+	client          *dns.Client
+	maxResponseSize int
+	maxAnswers      int
+	maxCNAMEChain   int
+	lowAllocation   bool
+	caseRandomize   bool
+	padding         bool
+	trustAD         bool
+	strictMatching  bool
+	queryOrder      DNSQueryOrder
+	inFlight        *semaphore.Weighted
+	queryLimiter    *QueryLimiter
+	workers         *WorkerPool
+	tcpFallback     *dnsResolver
+	queryLog        *QueryLog
+	adaptiveTimeout bool
+	iface           string
+	localAddr       netip.Addr
+	origConf        DNSResolverConfig
+	// overrides caches the resolvers created for upstream overrides (see
+	// WithUpstream), keyed by upstream, so that their connections, TLS
+	// sessions, in-flight limits and round trip times are reused.
+	overrides sync.Map
+
+	// Statistics (reported by Describe and Stats).
+	rtt           rttEstimator
+	activeQueries atomic.Int64
+	queries       atomic.Int64
+	failures      atomic.Int64
 }
 
-// DNS creates a new DNS resolver.
-func DNS(conf DNSResolverConfig) *dnsResolver {
+// DNS creates a new DNS resolver. Like net.Resolver, IP literals are returned
+// as is, without querying the server.
+func DNS(conf DNSResolverConfig) (*dnsResolver, error) {
+	if !conf.Server.IsValid() {
+		return nil, fmt.Errorf("invalid server address %q", conf.Server)
+	}
+
+	// The TCP fallback (and upstream override) resolvers are created from the
+	// caller's configuration.
+	origConf := conf
+
 	// Make sure the server port is set.
-	server := conf.Server
-	if server.Port() == 0 {
-		if conf.Transport != nil && *conf.Transport == DNSTransportTLS {
-			server = netip.AddrPortFrom(server.Addr(), 853)
-		} else {
-			server = netip.AddrPortFrom(server.Addr(), 53)
+	server := withDefaultPort(conf.Server, conf.Transport)
+
+	// Link-local servers are only reachable through the interface (zone) they
+	// are on, which is implied by the interface queries are bound to.
+	if addr := server.Addr(); addr.Is6() && addr.IsLinkLocalUnicast() && conf.Interface != "" {
+		switch addr.Zone() {
+		case "":
+			server = netip.AddrPortFrom(addr.WithZone(conf.Interface), server.Port())
+		case conf.Interface:
+		default:
+			return nil, fmt.Errorf("zone of server %q doesn't match interface %q", server, conf.Interface)
+		}
+	}
+
+	if conf.Interface != "" || conf.LocalAddr.IsValid() {
+		if conf.DialContext != nil || !conf.Dialers.empty() {
+			return nil, fmt.Errorf("interface or local address binding can't be combined with a custom dialer")
+		}
+
+		if conf.Interface != "" && !bindToInterfaceSupported {
+			return nil, fmt.Errorf("binding to an interface is not supported on this platform")
+		}
+
+		if conf.LocalAddr.IsValid() && conf.LocalAddr.Unmap().Is4() != server.Addr().Unmap().Is4() {
+			return nil, fmt.Errorf("local address %q is not of the same address family as server %q",
+				conf.LocalAddr, server.Addr())
+		}
+
+		conf.DialContext = boundDialer(conf.Interface, conf.LocalAddr.Unmap())
+	}
+
+	srcAddrs := sourceAddrProviderFor(conf.SourceAddrProvider, conf.Dialers.probe(conf.DialContext))
+
+	// Pins can only replace chain verification if the caller hasn't asked for
+	// a specific server name to be verified.
+	skipChainVerification := len(conf.SPKIPins) > 0 &&
+		(conf.TLSConfig == nil || conf.TLSConfig.ServerName == "")
+
+	// The TLS server name of DNS over HTTPS is the host of the URL. Otherwise
+	// it's the server's address, without its zone (which is only meaningful
+	// locally, eg. for link-local servers).
+	var dohURL *url.URL
+	tlsServerName := server.Addr().WithZone("").String()
+	if conf.Transport != nil && *conf.Transport == DNSTransportHTTPS {
+		var err error
+		dohURL, err = parseDoHURL(conf.URL)
+		if err != nil {
+			return nil, err
 		}
+
+		tlsServerName = dohURL.Hostname()
 	}
 
 	withDefaults, err := defaults.WithDefaults(&conf, &DNSResolverConfig{
-		Transport:   ptr.To(DNSTransportUDP),
-		Timeout:     ptr.To(5 * time.Second),
-		DialContext: (&net.Dialer{}).DialContext,
+		Transport:    ptr.To(DNSTransportUDP),
+		Timeout:      ptr.To(5 * time.Second),
+		DialContext:  (&net.Dialer{}).DialContext,
+		AddressOrder: ptr.To(AddressOrderRFC6724),
 		TLSConfig: &tls.Config{
-			ServerName: server.String(),
+			ServerName: tlsServerName,
 		},
-		SingleRequest: ptr.To(false),
+		NoTLSSessionResumption: ptr.To(false),
+		SingleRequest:          ptr.To(false),
+		MaxResponseSize:        ptr.To(dns.MaxMsgSize),
+		MaxAnswers:             ptr.To(0),
+		MaxCNAMEChain:          ptr.To(16),
+		LowAllocation:          ptr.To(false),
+		CaseRandomization:      ptr.To(false),
+		StrictResponseMatching: ptr.To(false),
+		AdaptiveTimeout:        ptr.To(false),
+		QueryOrder:             ptr.To(DNSQueryOrderAFirst),
+		MaxInFlightQueries:     ptr.To(0),
+		TCPFallback:            ptr.To(false),
+		TrustAD:                ptr.To(false),
+		NoPadding:              ptr.To(false),
 	})
 	if err != nil {
-		// Should never happen.
-		panic(err)
+		return nil, fmt.Errorf("failed to apply defaults to dns resolver config: %w", err)
 	}
 	conf = *withDefaults
 
+	switch *conf.Transport {
+	case DNSTransportUDP, DNSTransportTCP, DNSTransportTLS, DNSTransportHTTPS:
+	default:
+		return nil, fmt.Errorf("invalid transport %q", *conf.Transport)
+	}
+
+	dialContext := conf.Dialers.forTransport(*conf.Transport, conf.DialContext)
+
+	switch *conf.QueryOrder {
+	case DNSQueryOrderAFirst, DNSQueryOrderAAAAFirst:
+	default:
+		return nil, fmt.Errorf("invalid query order %q", *conf.QueryOrder)
+	}
+
+	if err := conf.AddressOrder.validate(); err != nil {
+		return nil, err
+	}
+
+	if *conf.Timeout <= 0 {
+		return nil, fmt.Errorf("timeout must be positive")
+	}
+
+	if *conf.MaxResponseSize <= 0 || *conf.MaxAnswers < 0 ||
+		*conf.MaxCNAMEChain < 0 || *conf.MaxInFlightQueries < 0 {
+		return nil, fmt.Errorf("invalid response or query limits")
+	}
+
+	if len(conf.SPKIPins) > 0 || conf.VerifyConnection != nil {
+		pins, err := parseSPKIPins(conf.SPKIPins)
+		if err != nil {
+			return nil, err
+		}
+
+		conf.TLSConfig = withConnectionVerification(conf.TLSConfig, pins,
+			conf.VerifyConnection, skipChainVerification)
+	}
+
+	if *conf.Transport == DNSTransportTLS || *conf.Transport == DNSTransportHTTPS {
+		conf.TLSConfig = conf.TLSConfig.Clone()
+
+		if conf.TLSConfig.ServerName == "" {
+			conf.TLSConfig.ServerName = tlsServerName
+		}
+
+		if *conf.NoTLSSessionResumption {
+			conf.TLSConfig.SessionTicketsDisabled = true
+		} else if conf.TLSConfig.ClientSessionCache == nil {
+			// As this is a single server, only a handful of sessions are needed.
+			conf.TLSConfig.ClientSessionCache = tls.NewLRUClientSessionCache(tlsSessionCacheSize)
+		}
+	}
+
+	var tcpFallback *dnsResolver
+	if *conf.TCPFallback && *conf.Transport == DNSTransportUDP {
+		fallbackConf := origConf
+		fallbackConf.Transport = ptr.To(DNSTransportTCP)
+		fallbackConf.TCPFallback = nil
+
+		tcpFallback, err = DNS(fallbackConf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tcp fallback resolver: %w", err)
+		}
+	}
+
+	var dohClient *http.Client
+	var dohEndpoint string
+	if dohURL != nil {
+		dohClient = newDoHClient(dialContext, server, conf.TLSConfig, conf.HTTP3RoundTripper)
+		dohEndpoint = dohURL.String()
+	}
+
+	var inFlight *semaphore.Weighted
+	if *conf.MaxInFlightQueries > 0 {
+		inFlight = semaphore.NewWeighted(int64(*conf.MaxInFlightQueries))
+	}
+
 	return &dnsResolver{
 		server:        server,
+		serverAddr:    server.String(),
 		transport:     *conf.Transport,
 		timeout:       *conf.Timeout,
-		dialContext:   conf.DialContext,
+		dialContext:   dialContext,
+		sorter:        newAddrSorter(*conf.AddressOrder, srcAddrs, conf.PolicyTable),
 		tlsConfig:     conf.TLSConfig,
+		dohURL:        dohEndpoint,
+		dohClient:     dohClient,
+		dohHeader:     conf.HTTPHeader.Clone(),
 		singleRequest: *conf.SingleRequest,
+		client: &dns.Client{
+			Net:       string(*conf.Transport),
+			TLSConfig: conf.TLSConfig,
+			Timeout:   *conf.Timeout,
+		},
+		maxResponseSize: *conf.MaxResponseSize,
+		maxAnswers:      *conf.MaxAnswers,
+		maxCNAMEChain:   *conf.MaxCNAMEChain,
+		lowAllocation:   *conf.LowAllocation,
+		caseRandomize:   *conf.CaseRandomization,
+		padding:         !*conf.NoPadding && (*conf.Transport == DNSTransportTLS || *conf.Transport == DNSTransportHTTPS),
+		trustAD:         *conf.TrustAD,
+		strictMatching:  *conf.StrictResponseMatching || *conf.CaseRandomization,
+		queryOrder:      *conf.QueryOrder,
+		inFlight:        inFlight,
+		queryLimiter:    conf.QueryLimiter,
+		workers:         conf.WorkerPool,
+		tcpFallback:     tcpFallback,
+		queryLog:        conf.QueryLog,
+		adaptiveTimeout: *conf.AdaptiveTimeout,
+		iface:           conf.Interface,
+		localAddr:       conf.LocalAddr,
+		origConf:        origConf,
+	}, nil
+}
+
+// forUpstream returns the resolver that sends the queries of a lookup of
+// host, which is a resolver for the upstream of ctx (see WithUpstream) if it
+// isn't the server. Resolvers for upstreams are created once and then reused.
+func (r *dnsResolver) forUpstream(ctx context.Context, host string) (*dnsResolver, error) {
+	upstream, ok := upstreamFromContext(ctx)
+	if !ok {
+		return r, nil
+	}
+
+	upstream = withDefaultPort(upstream, &r.transport)
+	if upstream == r.server {
+		return r, nil
+	}
+
+	if override, ok := r.overrides.Load(upstream); ok {
+		return override.(*dnsResolver), nil
 	}
+
+	conf := r.origConf
+	conf.Server = upstream
+
+	override, err := DNS(conf)
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:    err.Error(),
+			Name:   host,
+			Server: upstream.String(),
+		}
+	}
+
+	if existing, loaded := r.overrides.LoadOrStore(upstream, override); loaded {
+		// Another lookup created a resolver for the upstream first.
+		_ = override.Close()
+		return existing.(*dnsResolver), nil
+	}
+
+	return override, nil
+}
+
+// Close closes the idle connections of the resolver, and of the resolvers
+// created for upstream overrides (see WithUpstream).
+func (r *dnsResolver) Close() error {
+	if r.dohClient != nil {
+		r.dohClient.CloseIdleConnections()
+	}
+
+	if r.tcpFallback != nil {
+		_ = r.tcpFallback.Close()
+	}
+
+	r.overrides.Range(func(upstream, override any) bool {
+		r.overrides.Delete(upstream)
+		_ = override.(*dnsResolver).Close()
+		return true
+	})
+
+	return nil
+}
+
+// withDefaultPort returns server with the default port of the transport, if
+// no port is set.
+func withDefaultPort(server netip.AddrPort, transport *DNSTransport) netip.AddrPort {
+	if server.Port() != 0 {
+		return server
+	}
+
+	if transport != nil && *transport == DNSTransportTLS {
+		return netip.AddrPortFrom(server.Addr(), 853)
+	} else if transport != nil && *transport == DNSTransportHTTPS {
+		return netip.AddrPortFrom(server.Addr(), 443)
+	}
+
+	return netip.AddrPortFrom(server.Addr(), 53)
 }
 
 func (r *dnsResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
-	dnsErr := &net.DNSError{
-		Name: host,
+	network, _ = ipNetwork(network)
+
+	if _, err := netip.ParseAddr(host); err == nil {
+		return Literal().LookupNetIP(ctx, network, host)
+	}
+
+	upstream, err := r.forUpstream(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if upstream != r {
+		return upstream.LookupNetIP(ctx, network, host)
 	}
 
 	// If the host is not a valid domain name, return an error.
 	if _, ok := dns.IsDomainName(host); !ok {
-		return nil, extendDNSError(dnsErr, net.DNSError{
+		return nil, extendDNSError(&net.DNSError{Name: host}, net.DNSError{
 			Err:        ErrNoSuchHost.Error(),
 			IsNotFound: true,
 		})
@@ -155,72 +615,86 @@ func (r *dnsResolver) LookupNetIP(ctx context.Context, network, host string) ([]
 	var qTypes []uint16
 	switch network {
 	case "ip":
-		qTypes = []uint16{dns.TypeA, dns.TypeAAAA}
+		qTypes = qTypesIP
+		if r.queryOrder == DNSQueryOrderAAAAFirst {
+			qTypes = qTypesIP6First
+		}
 	case "ip4":
-		qTypes = []uint16{dns.TypeA}
+		qTypes = qTypesIP4
 	case "ip6":
-		qTypes = []uint16{dns.TypeAAAA}
+		qTypes = qTypesIP6
 	default:
-		return nil, extendDNSError(dnsErr, net.DNSError{
+		return nil, extendDNSError(&net.DNSError{Name: host}, net.DNSError{
 			Err: ErrUnsupportedNetwork.Error(),
 		})
 	}
 
-	client := &dns.Client{
-		Net:       string(r.transport),
-		TLSConfig: r.tlsConfig,
-		Timeout:   r.timeout,
-	}
-
-	var addrsMu sync.Mutex
-	var addrs []netip.Addr
+	// Results are stored per query, so that they can be combined in query
+	// order (rather than the order the responses arrived in).
+	results := make([][]netip.Addr, len(qTypes))
 
-	tryOneNameAndAppendResults := func(ctx context.Context, qType uint16) error {
-		reply, err := r.tryOneName(ctx, client, name, qType)
+	tryOneNameAndStoreResults := func(ctx context.Context, i int) error {
+		answerAddrs, err := r.tryOneName(ctx, name, qTypes[i])
 		if err != nil {
 			return err
 		}
 
-		// We asked for recursion, so it should have included all the
-		// answers we need in this one packet.
-		//
-		// Further, RFC 1034 section 4.3.1 says that "the recursive
-		// response to a query will be... The answer to the query,
-		// possibly preface by one or more CNAME RRs that specify
-		// aliases encountered on the way to an answer."
-		//
-		// Therefore, we should be able to assume that we can ignore
-		// CNAMEs and that the A and AAAA records we requested are
-		// for the canonical name.
-
-		addrsMu.Lock()
-		defer addrsMu.Unlock()
-
-		for _, rr := range reply.Answer {
-			switch rr := rr.(type) {
-			case *dns.A:
-				addrs = append(addrs, netip.AddrFrom4([4]byte(rr.A.To4())))
-			case *dns.AAAA:
-				addrs = append(addrs, netip.AddrFrom16([16]byte(rr.AAAA.To16())))
-			}
-		}
+		results[i] = answerAddrs
 
 		return nil
 	}
 
-	if r.singleRequest {
-		for _, qType := range qTypes {
-			if err := tryOneNameAndAppendResults(ctx, qType); err != nil {
+	if r.singleRequest || len(qTypes) == 1 {
+		for i := range qTypes {
+			release, dnsErr := r.acquire(ctx, name)
+			if dnsErr != nil {
+				return nil, dnsErr
+			}
+
+			err := tryOneNameAndStoreResults(ctx, i)
+			release()
+			if err != nil {
 				return nil, err
 			}
 		}
 	} else {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
 		g, ctx := errgroup.WithContext(ctx)
 
-		for _, qType := range qTypes {
-			qType := qType
+		for i := range qTypes {
+			i := i
+
+			// Acquire in-flight slots in order, so that the query order is
+			// respected even when queries are limited.
+			release, dnsErr := r.acquire(ctx, name)
+			if dnsErr != nil {
+				// Prefer the error that caused the group to be cancelled.
+				if err := g.Wait(); err != nil {
+					return nil, err
+				}
+				return nil, dnsErr
+			}
+
+			// If every shared worker is busy, send the query from this
+			// goroutine instead.
+			if !r.workers.tryAcquire() {
+				err := tryOneNameAndStoreResults(ctx, i)
+				release()
+				if err != nil {
+					cancel()
+					_ = g.Wait()
+					return nil, err
+				}
+				continue
+			}
+
 			g.Go(func() error {
-				return tryOneNameAndAppendResults(ctx, qType)
+				defer r.workers.release()
+				defer release()
+
+				return tryOneNameAndStoreResults(ctx, i)
 			})
 		}
 
@@ -229,84 +703,714 @@ func (r *dnsResolver) LookupNetIP(ctx context.Context, network, host string) ([]
 		}
 	}
 
+	var addrs []netip.Addr
+	for _, answerAddrs := range results {
+		if addrs == nil {
+			addrs = answerAddrs
+		} else {
+			addrs = append(addrs, answerAddrs...)
+		}
+	}
+
 	if len(addrs) > 0 {
-		if network != "ip4" {
-			dial := func(network, address string) (net.Conn, error) {
-				return r.dialContext(ctx, network, address)
+		// Link-local addresses are only meaningful on the link they were
+		// learned from, ie. the link of a link-local server.
+		if zone := r.server.Addr().Zone(); zone != "" {
+			for i, addr := range addrs {
+				if addr.Is6() && addr.IsLinkLocalUnicast() && addr.Zone() == "" {
+					addrs[i] = addr.WithZone(zone)
+				}
 			}
+		}
 
-			addrselect.SortByRFC6724(dial, addrs)
+		if network != "ip4" {
+			r.sorter.sort(ctx, addrs)
 		}
 
 		return addrs, nil
 	}
 
-	return nil, extendDNSError(dnsErr, net.DNSError{
+	return nil, extendDNSError(&net.DNSError{Name: host}, net.DNSError{
 		Err:        ErrNoSuchHost.Error(),
 		IsNotFound: true,
 	})
 }
 
-func (r *dnsResolver) tryOneName(ctx context.Context, client *dns.Client, name string, qType uint16) (*dns.Msg, *net.DNSError) {
-	dnsErr := &net.DNSError{
-		Name:   name,
-		Server: r.server.String(),
+// LookupAddr performs a reverse (PTR) lookup of addr, returning the names that
+// map to it.
+func (r *dnsResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	upstream, err := r.forUpstream(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if upstream != r {
+		return upstream.LookupAddr(ctx, addr)
+	}
+
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:  "unrecognized address",
+			Name: addr,
+		}
+	}
+
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:  err.Error(),
+			Name: addr,
+		}
+	}
+
+	release, dnsErr := r.acquire(ctx, name)
+	if dnsErr != nil {
+		return nil, dnsErr
+	}
+	defer release()
+
+	records, err := r.tryRecords(ctx, name, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, rr := range records {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			names = append(names, ptr.Ptr)
+		}
+	}
+
+	return names, nil
+}
+
+// Lookup sends a query of any type to the server, returning the records in
+// the answer section of the response.
+func (r *dnsResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	upstream, err := r.forUpstream(ctx, q.Name)
+	if err != nil {
+		return Answer{}, err
+	}
+	if upstream != r {
+		return upstream.Lookup(ctx, q)
+	}
+
+	if _, ok := dns.IsDomainName(q.Name); !ok {
+		return Answer{}, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       q.Name,
+			IsNotFound: true,
+		}
+	}
+
+	name := dns.Fqdn(q.Name)
+
+	release, dnsErr := r.acquire(ctx, name)
+	if dnsErr != nil {
+		return Answer{}, dnsErr
+	}
+	defer release()
+
+	records, err := r.tryRecords(ctx, name, q.Type)
+	if err != nil {
+		return Answer{}, err
+	}
+
+	return Answer{Records: records}, nil
+}
+
+func (r *dnsResolver) tryRecords(ctx context.Context, name string, qType uint16) ([]dns.RR, error) {
+	var records []dns.RR
+	responseRcode := -1
+	dnsErr := r.instrument(name, qType, func(rcode *int) *net.DNSError {
+		var dnsErr *net.DNSError
+		records, dnsErr = r.exchangeRecords(ctx, name, qType, rcode)
+		responseRcode = *rcode
+		return dnsErr
+	})
+
+	if r.escalate(ctx, dnsErr, responseRcode >= 0) {
+		return r.tcpFallback.tryRecords(ctx, name, qType)
+	}
+
+	if dnsErr != nil {
+		return nil, responseError(dnsErr, responseRcode)
+	}
+
+	return records, nil
+}
+
+// exchangeRecords sends a query for name to the server and returns the
+// records in the answer section of the response. Responses without any
+// records of the queried type are reported as not found.
+func (r *dnsResolver) exchangeRecords(ctx context.Context, name string, qType uint16, rcode *int) ([]dns.RR, *net.DNSError) {
+	if timeout := r.queryTimeout(); timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	conn, dnsErr := r.dial(ctx, name)
+	if dnsErr != nil {
+		return nil, dnsErr
+	}
+	defer conn.Close()
+
+	req := new(dns.Msg)
+	req.SetQuestion(r.queryName(name), qType)
+	req.AuthenticatedData = r.trustAD
+	if r.padding {
+		padQuery(req)
+	}
+
+	reply, err := r.exchangeMsg(ctx, conn, req)
+	if err != nil {
+		return nil, r.queryError(name, net.DNSError{
+			Err:         err.Error(),
+			IsTimeout:   isTimeout(err),
+			IsTemporary: true,
+		})
+	}
+
+	if rcode != nil {
+		*rcode = reply.Rcode
+	}
+
+	if reply.Rcode != dns.RcodeSuccess {
+		return nil, r.rcodeError(name, reply.Rcode)
+	}
+
+	if err := r.validateReply(reply); err != nil {
+		return nil, r.queryError(name, net.DNSError{
+			Err: err.Error(),
+		})
+	}
+
+	answer := answersFor(name, reply.Answer)
+
+	if !slices.ContainsFunc(answer, func(rr dns.RR) bool {
+		return rr.Header().Rrtype == qType
+	}) {
+		return nil, r.queryError(name, net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			IsNotFound: true,
+		})
+	}
+
+	r.recordResponse(ctx, reply.AuthenticatedData)
+
+	return answer, nil
+}
+
+func (r *dnsResolver) tryOneName(ctx context.Context, name string, qType uint16) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	responseRcode := -1
+	dnsErr := r.instrument(name, qType, func(rcode *int) *net.DNSError {
+		var dnsErr *net.DNSError
+		addrs, dnsErr = r.exchange(ctx, name, qType, rcode)
+		responseRcode = *rcode
+		return dnsErr
+	})
+
+	if r.escalate(ctx, dnsErr, responseRcode >= 0) {
+		return r.tcpFallback.tryOneName(ctx, name, qType)
+	}
+
+	if dnsErr != nil {
+		return nil, responseError(dnsErr, responseRcode)
+	}
+
+	return addrs, nil
+}
+
+// escalate reports whether a failed query should be retried over TCP.
+func (r *dnsResolver) escalate(ctx context.Context, dnsErr *net.DNSError, responded bool) bool {
+	return dnsErr != nil && r.tcpFallback != nil && !responded && ctx.Err() == nil
+}
+
+// instrument updates the query statistics and query log (if configured) for
+// a query of name, performed by the query function. The query function must
+// set rcode to the response code of the response (if one was received).
+func (r *dnsResolver) instrument(name string, qType uint16, query func(rcode *int) *net.DNSError) *net.DNSError {
+	r.queries.Add(1)
+	r.activeQueries.Add(1)
+	defer r.activeQueries.Add(-1)
+
+	rcode := -1
+	start := time.Now()
+	dnsErr := query(&rcode)
+	latency := time.Since(start)
+	if dnsErr != nil {
+		r.failures.Add(1)
+	}
+
+	if rcode >= 0 {
+		r.rtt.observe(latency)
+	} else if dnsErr != nil && dnsErr.IsTimeout {
+		r.rtt.timedOut()
+	}
+
+	if r.queryLog == nil {
+		return dnsErr
+	}
+
+	entry := QueryLogEntry{
+		Time:      start,
+		Name:      name,
+		Type:      dns.TypeToString[qType],
+		Server:    r.serverAddr,
+		Transport: r.transport,
+		Latency:   latency,
+	}
+	if rcode >= 0 {
+		entry.RCode = dns.RcodeToString[rcode]
+	}
+	if dnsErr != nil {
+		entry.Err = dnsErr.Err
+	}
+	r.queryLog.record(entry)
+
+	return dnsErr
+}
+
+// queryTimeout returns the timeout of the next query.
+func (r *dnsResolver) queryTimeout() time.Duration {
+	if r.adaptiveTimeout {
+		return r.rtt.timeout(r.timeout)
+	}
+
+	return r.timeout
+}
+
+// Stats returns the runtime statistics of the server, including its smoothed
+// round trip time (which is measured whether or not adaptive timeouts are
+// enabled).
+func (r *dnsResolver) Stats() DNSServerStats {
+	srtt, rttvar, samples := r.rtt.stats()
+
+	return DNSServerStats{
+		Server:    r.serverAddr,
+		Transport: r.transport,
+		Queries:   r.queries.Load(),
+		Failures:  r.failures.Load(),
+		InFlight:  r.activeQueries.Load(),
+		Samples:   samples,
+		SRTT:      srtt,
+		RTTVar:    rttvar,
+		Timeout:   r.queryTimeout(),
 	}
+}
 
-	if client.Timeout != 0 {
+// exchange sends a query for name to the server and returns the addresses in
+// the answer section of the response. If rcode is not nil, it is set to the
+// response code of the response (if one was received).
+func (r *dnsResolver) exchange(ctx context.Context, name string, qType uint16, rcode *int) ([]netip.Addr, *net.DNSError) {
+	if timeout := r.queryTimeout(); timeout != 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, client.Timeout)
+		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
-	conn, err := r.dialContext(ctx, strings.TrimSuffix(client.Net, "-tls"), r.server.String())
+	conn, dnsErr := r.dial(ctx, name)
+	if dnsErr != nil {
+		return nil, dnsErr
+	}
+	defer conn.Close()
+
+	if r.lowAllocation && !r.strictMatching {
+		return r.exchangeLowAlloc(ctx, conn, name, qType, rcode)
+	}
+
+	req := msgPool.Get().(*dns.Msg)
+	defer msgPool.Put(req)
+
+	// Equivalent to req.SetQuestion() but reuses the question slice.
+	req.Id = dns.Id()
+	req.RecursionDesired = true
+	req.AuthenticatedData = r.trustAD
+	req.Question = append(req.Question[:0], dns.Question{
+		Name:   r.queryName(name),
+		Qtype:  qType,
+		Qclass: dns.ClassINET,
+	})
+	req.Extra = req.Extra[:0]
+	if r.padding {
+		padQuery(req)
+	}
+
+	reply, err := r.exchangeMsg(ctx, conn, req)
+	if err != nil {
+		return nil, r.queryError(name, net.DNSError{
+			Err:         err.Error(),
+			IsTimeout:   isTimeout(err),
+			IsTemporary: true,
+		})
+	}
+
+	if rcode != nil {
+		*rcode = reply.Rcode
+	}
+
+	if reply.Rcode != dns.RcodeSuccess {
+		return nil, r.rcodeError(name, reply.Rcode)
+	}
+
+	if err := r.validateReply(reply); err != nil {
+		return nil, r.queryError(name, net.DNSError{
+			Err: err.Error(),
+		})
+	}
+
+	// We asked for recursion, so it should have included all the
+	// answers we need in this one packet.
+	//
+	// Further, RFC 1034 section 4.3.1 says that "the recursive
+	// response to a query will be... The answer to the query,
+	// possibly preface by one or more CNAME RRs that specify
+	// aliases encountered on the way to an answer."
+	//
+	// Therefore, once records for names outside of the CNAME chain
+	// are dropped, we can ignore CNAMEs and assume that the A and
+	// AAAA records we requested are for the canonical name.
+
+	addrs, err := r.addrsFromAnswer(answersFor(name, reply.Answer))
+	if err != nil {
+		return nil, r.queryError(name, net.DNSError{
+			Err: err.Error(),
+		})
+	}
+
+	r.recordResponse(ctx, reply.AuthenticatedData)
+
+	return addrs, nil
+}
+
+// recordResponse records a successful response in the metadata of the lookup
+// (if requested). The AD bit is cleared unless the server is trusted.
+func (r *dnsResolver) recordResponse(ctx context.Context, authenticatedData bool) {
+	if md := metadataFromContext(ctx); md != nil {
+		md.recordResponse(r.trustAD && authenticatedData)
+	}
+}
+
+// queryName returns the name to send in the question of a query for name.
+func (r *dnsResolver) queryName(name string) string {
+	if r.caseRandomize {
+		return randomizeCase(name)
+	}
+
+	return name
+}
+
+// dial establishes a connection to the server (performing the TLS handshake
+// if required), for a query of name.
+func (r *dnsResolver) dial(ctx context.Context, name string) (net.Conn, *net.DNSError) {
+	if r.transport == DNSTransportHTTPS {
+		// Connections are established (and reused) by the HTTP client.
+		return limitResponseSize(&dohConn{
+			ctx:    ctx,
+			client: r.dohClient,
+			url:    r.dohURL,
+			header: r.dohHeader,
+			server: r.server,
+		}, r.maxResponseSize), nil
+	}
+
+	conn, err := r.dialContext(ctx, strings.TrimSuffix(string(r.transport), "-tls"), r.serverAddr)
 	if err != nil {
-		return nil, extendDNSError(dnsErr, net.DNSError{
+		return nil, r.queryError(name, net.DNSError{
 			Err:         err.Error(),
 			IsTimeout:   isTimeout(err),
 			IsTemporary: true,
 		})
 	}
 
-	if strings.HasSuffix(client.Net, "-tls") {
+	if r.transport == DNSTransportTLS {
 		conn = tls.Client(conn, r.tlsConfig)
 		if err := conn.(*tls.Conn).HandshakeContext(ctx); err != nil {
 			_ = conn.Close()
 			// Handshake errors are not likely to be temporary.
-			return nil, extendDNSError(dnsErr, net.DNSError{
+			return nil, r.queryError(name, net.DNSError{
 				Err:       err.Error(),
 				IsTimeout: isTimeout(err),
 			})
 		}
 	}
-	defer conn.Close()
 
-	req := &dns.Msg{}
-	req.SetQuestion(name, qType)
+	// Reject oversized responses before they are read into memory.
+	return limitResponseSize(conn, r.maxResponseSize), nil
+}
 
-	reply, _, err := client.ExchangeWithConn(req, &dns.Conn{Conn: conn})
-	if err != nil {
-		return nil, extendDNSError(dnsErr, net.DNSError{
-			Err:         err.Error(),
-			IsTimeout:   isTimeout(err),
-			IsTemporary: true,
-		})
+// addrsFromAnswer returns the addresses in the A and AAAA records of an
+// answer section. Records with malformed data (eg. an empty RDATA section)
+// result in an error.
+func (r *dnsResolver) addrsFromAnswer(answer []dns.RR) ([]netip.Addr, error) {
+	addrs := make([]netip.Addr, 0, len(answer))
+	for _, rr := range answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip := rr.A.To4()
+			if ip == nil || len(rr.A) != net.IPv4len {
+				return nil, r.malformedAnswerError(dns.TypeA)
+			}
+			addrs = append(addrs, netip.AddrFrom4([4]byte(ip)))
+		case *dns.AAAA:
+			if len(rr.AAAA) != net.IPv6len {
+				return nil, r.malformedAnswerError(dns.TypeAAAA)
+			}
+			addrs = append(addrs, netip.AddrFrom16([16]byte(rr.AAAA)))
+		}
 	}
 
-	switch reply.Rcode {
-	case dns.RcodeSuccess:
-		return reply, nil
-	case dns.RcodeNameError:
-		return nil, extendDNSError(dnsErr, net.DNSError{
+	return addrs, nil
+}
+
+// malformedAnswerError returns an error for an answer record of rrType with
+// invalid data.
+func (r *dnsResolver) malformedAnswerError(rrType uint16) error {
+	return fmt.Errorf("malformed answer from server %s: invalid %s record data: %w",
+		r.serverAddr, dns.TypeToString[rrType], ErrServerMisbehaving)
+}
+
+// rcodeError returns a DNS error for a response with a non-success return
+// code.
+func (r *dnsResolver) rcodeError(name string, rcode int) *net.DNSError {
+	if rcode == dns.RcodeNameError {
+		return r.queryError(name, net.DNSError{
 			Err:        ErrNoSuchHost.Error(),
 			IsNotFound: true,
 		})
-	default:
-		return nil, extendDNSError(dnsErr, net.DNSError{
-			Err: fmt.Errorf("unexpected return code %s: %w",
-				dns.RcodeToString[reply.Rcode], ErrServerMisbehaving).Error(),
-			// SERVFAIL is not cached.
-			IsTemporary: reply.Rcode == dns.RcodeServerFailure,
-		})
+	}
+
+	return r.queryError(name, net.DNSError{
+		Err: fmt.Errorf("unexpected return code %s: %w",
+			dns.RcodeToString[rcode], ErrServerMisbehaving).Error(),
+		// SERVFAIL is not cached.
+		IsTemporary: rcode == dns.RcodeServerFailure,
+	})
+}
+
+// acquire waits for an in-flight query slot to become available (if queries
+// are limited). The per server limit is acquired first, so that queries
+// waiting for a busy server don't hold on to a slot of the shared limiter.
+// Time spent waiting does not count against the query timeout.
+func (r *dnsResolver) acquire(ctx context.Context, name string) (func(), *net.DNSError) {
+	if r.inFlight == nil && r.queryLimiter == nil {
+		return func() {}, nil
+	}
+
+	if r.inFlight != nil {
+		if err := r.inFlight.Acquire(ctx, 1); err != nil {
+			return nil, r.acquireError(name, err)
+		}
+	}
+
+	if r.queryLimiter != nil {
+		if err := r.queryLimiter.acquire(ctx); err != nil {
+			if r.inFlight != nil {
+				r.inFlight.Release(1)
+			}
+			return nil, r.acquireError(name, err)
+		}
+	}
+
+	return func() {
+		if r.queryLimiter != nil {
+			r.queryLimiter.release()
+		}
+		if r.inFlight != nil {
+			r.inFlight.Release(1)
+		}
+	}, nil
+}
+
+func (r *dnsResolver) acquireError(name string, err error) *net.DNSError {
+	return r.queryError(name, net.DNSError{
+		Err:         err.Error(),
+		IsTimeout:   isTimeout(err),
+		IsTemporary: true,
+	})
+}
+
+// queryError returns a DNS error for a failed query of name against the
+// server.
+func (r *dnsResolver) queryError(name string, src net.DNSError) *net.DNSError {
+	return extendDNSError(&net.DNSError{
+		Name:   name,
+		Server: r.serverAddr,
+	}, src)
+}
+
+// validateReply rejects pathological responses that exceed the configured
+// limits.
+func (r *dnsResolver) validateReply(reply *dns.Msg) error {
+	var cnames int
+	for _, rr := range reply.Answer {
+		if rr.Header().Rrtype == dns.TypeCNAME {
+			cnames++
+		}
+	}
+
+	return r.checkLimits(len(reply.Answer), cnames)
+}
+
+// checkLimits checks the number of answer and CNAME records in a response
+// against the configured limits.
+func (r *dnsResolver) checkLimits(answers, cnames int) error {
+	if r.maxAnswers > 0 && answers > r.maxAnswers {
+		return fmt.Errorf("too many answers (%d > %d): %w",
+			answers, r.maxAnswers, ErrServerMisbehaving)
+	}
+
+	if cnames > r.maxCNAMEChain {
+		return fmt.Errorf("cname chain too long (%d > %d): %w",
+			cnames, r.maxCNAMEChain, ErrServerMisbehaving)
+	}
+
+	return nil
+}
+
+// limitResponseSize wraps conn so that it refuses to read responses larger
+// than limit bytes.
+func limitResponseSize(conn net.Conn, limit int) net.Conn {
+	if pc, ok := conn.(net.PacketConn); ok {
+		// Must remain a net.PacketConn so that it is treated as a datagram
+		// transport by the DNS client.
+		return &sizeLimitedPacketConn{Conn: conn, pc: pc, limit: limit}
+	}
+
+	return &sizeLimitedStreamConn{Conn: conn, limit: limit}
+}
+
+type sizeLimitedPacketConn struct {
+	net.Conn
+	pc    net.PacketConn
+	limit int
+}
+
+func (c *sizeLimitedPacketConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	// Each read is a complete datagram.
+	if n > c.limit {
+		return 0, fmt.Errorf("response too large (%d > %d): %w", n, c.limit, ErrServerMisbehaving)
+	}
+	return n, err
+}
+
+func (c *sizeLimitedPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.pc.ReadFrom(p)
+	if n > c.limit {
+		return 0, addr, fmt.Errorf("response too large (%d > %d): %w", n, c.limit, ErrServerMisbehaving)
+	}
+	return n, addr, err
+}
+
+func (c *sizeLimitedPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.pc.WriteTo(p, addr)
+}
+
+type sizeLimitedStreamConn struct {
+	net.Conn
+	limit       int
+	length      int
+	lengthBytes int
+}
+
+func (c *sizeLimitedStreamConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+
+	// Stream transports carry a two byte length prefix, this lets us reject
+	// oversized responses before a buffer is allocated for them. We only ever
+	// read a single response per connection.
+	for _, b := range p[:n] {
+		if c.lengthBytes == 2 {
+			break
+		}
+		c.length = c.length<<8 | int(b)
+		c.lengthBytes++
+	}
+	if c.lengthBytes == 2 && c.length > c.limit {
+		return 0, fmt.Errorf("response too large (%d > %d): %w", c.length, c.limit, ErrServerMisbehaving)
+	}
+
+	return n, err
+}
+
+func (r *dnsResolver) Describe() Description {
+	attrs := map[string]string{
+		"server":        r.serverAddr,
+		"transport":     string(r.transport),
+		"timeout":       r.timeout.String(),
+		"address-order": string(r.sorter.order),
+		"in-flight":     strconv.FormatInt(r.activeQueries.Load(), 10),
+		"queries":       strconv.FormatInt(r.queries.Load(), 10),
+		"failures":      strconv.FormatInt(r.failures.Load(), 10),
+	}
+
+	if r.singleRequest {
+		attrs["single-request"] = "true"
+	}
+
+	if r.tcpFallback != nil {
+		attrs["tcp-fallback"] = "true"
+	}
+
+	if r.trustAD {
+		attrs["trust-ad"] = "true"
+	}
+
+	if r.caseRandomize {
+		attrs["case-randomization"] = "true"
+	}
+
+	if r.padding {
+		attrs["padding"] = "true"
+	}
+
+	if r.strictMatching {
+		attrs["strict-response-matching"] = "true"
+	}
+
+	if r.adaptiveTimeout {
+		attrs["adaptive-timeout"] = "true"
+	}
+
+	if srtt, _, samples := r.rtt.stats(); samples > 0 {
+		attrs["srtt"] = srtt.String()
+	}
+
+	if r.iface != "" {
+		attrs["interface"] = r.iface
+	}
+
+	if r.localAddr.IsValid() {
+		attrs["local-addr"] = r.localAddr.String()
+	}
+
+	if r.queryOrder != DNSQueryOrderAFirst {
+		attrs["query-order"] = string(r.queryOrder)
+	}
+
+	encrypted := r.transport == DNSTransportTLS || r.transport == DNSTransportHTTPS
+
+	if encrypted && r.tlsConfig != nil && r.tlsConfig.ServerName != "" {
+		attrs["tls-server-name"] = r.tlsConfig.ServerName
+	}
+
+	if encrypted && r.tlsConfig != nil && r.tlsConfig.SessionTicketsDisabled {
+		attrs["tls-session-resumption"] = "false"
+	}
+
+	if r.dohURL != "" {
+		attrs["url"] = r.dohURL
+
+		if t, ok := r.dohClient.Transport.(*dohTransport); ok {
+			attrs["http3"] = strconv.FormatBool(t.useHTTP3())
+		}
+	}
+
+	return Description{
+		Type:       "dns",
+		Attributes: attrs,
 	}
 }
@@ -11,11 +11,12 @@ package resolver
 
 import (
 	"context"
-	"net"
+	"fmt"
 	"net/netip"
+	"strconv"
 
-	"github.com/noisysockets/resolver/internal/addrselect"
-	"github.com/noisysockets/util/defaults"
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
 	"github.com/noisysockets/util/ptr"
 )
 
@@ -26,38 +27,79 @@ type DNS64ResolverConfig struct {
 	// Prefix is the IPv6 prefix to use.
 	// If not set, the well-known prefix "64:ff9b::/96" is used.
 	Prefix *netip.Prefix
-	// DialContext is used to establish a connection to a DNS server.
+	// DialContext is an optional dialer used to probe source addresses when
+	// ordering the returned addresses.
 	DialContext DialContextFunc
+	// AddressOrder is the policy used to order the returned addresses.
+	// By default, addresses are sorted according to RFC 6724.
+	AddressOrder *AddressOrder
+	// PolicyTable is an optional RFC 6724 address selection policy table used
+	// when sorting addresses. By default, the table from RFC 6724 is used.
+	PolicyTable []PolicyTableEntry
+	// SourceAddrProvider is an optional provider of the source addresses used
+	// when sorting addresses according to RFC 6724. By default, source
+	// addresses are probed using DialContext (if provided) or selected from
+	// the host's interface addresses.
+	SourceAddrProvider SourceAddrProvider
+	// CLAT controls whether synthesis is suppressed because the host has a
+	// CLAT (464XLAT, RFC 6877), to avoid translating traffic twice.
+	// By default, CLATModeAbsent.
+	CLAT *CLATMode
 }
 
 // dns64Resolver is a resolver that synthesizes IPv6 addresses from IPv4 addresses
 // using DNS64 (RFC 6147).
 type dns64Resolver struct {
-	resolver    Resolver
-	prefix      netip.Prefix
-	dialContext DialContextFunc
+	resolver Resolver
+	prefix   netip.Prefix
+	sorter   addrSorter
+	clatMode CLATMode
+	clat     *clatDetector
 }
 
 // DNS64 returns a resolver that synthesizes IPv6 addresses from IPv4 addresses
 // using DNS64 (RFC 6147).
-func DNS64(resolver Resolver, conf *DNS64ResolverConfig) *dns64Resolver {
+func DNS64(resolver Resolver, conf *DNS64ResolverConfig) (*dns64Resolver, error) {
 	conf, err := defaults.WithDefaults(conf, &DNS64ResolverConfig{
-		Prefix:      ptr.To(netip.MustParsePrefix("64:ff9b::/96")),
-		DialContext: (&net.Dialer{}).DialContext,
+		Prefix:       ptr.To(netip.MustParsePrefix("64:ff9b::/96")),
+		AddressOrder: ptr.To(AddressOrderRFC6724),
+		CLAT:         ptr.To(CLATModeAbsent),
 	})
 	if err != nil {
-		// Should never happen.
-		panic(err)
+		return nil, fmt.Errorf("failed to apply defaults to dns64 resolver config: %w", err)
 	}
 
-	return &dns64Resolver{
-		resolver:    resolver,
-		prefix:      *conf.Prefix,
-		dialContext: conf.DialContext,
+	// Only the (most common) /96 prefix length is supported.
+	if !conf.Prefix.Addr().Is6() || conf.Prefix.Addr().Is4In6() || conf.Prefix.Bits() != 96 {
+		return nil, fmt.Errorf("invalid dns64 prefix %q: must be an IPv6 /96 prefix", *conf.Prefix)
+	}
+
+	if err := conf.AddressOrder.validate(); err != nil {
+		return nil, err
 	}
+
+	if err := conf.CLAT.validate(); err != nil {
+		return nil, err
+	}
+
+	var clat *clatDetector
+	if *conf.CLAT == CLATModeAuto {
+		clat = hostCLATDetector()
+	}
+
+	return &dns64Resolver{
+		resolver: resolver,
+		prefix:   *conf.Prefix,
+		sorter: newAddrSorter(*conf.AddressOrder,
+			sourceAddrProviderFor(conf.SourceAddrProvider, conf.DialContext), conf.PolicyTable),
+		clatMode: *conf.CLAT,
+		clat:     clat,
+	}, nil
 }
 
 func (r *dns64Resolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	network, _ = ipNetwork(network)
+
 	addrs, err := r.resolver.LookupNetIP(ctx, "ip", host)
 	if err != nil {
 		return nil, err
@@ -76,8 +118,9 @@ func (r *dns64Resolver) LookupNetIP(ctx context.Context, network, host string) (
 		return ipv4Addrs, nil
 	}
 
-	// Add synthesized IPv6 addresses (if no IPv6 addresses were present).
-	if len(ipv6Addrs) == 0 {
+	// Add synthesized IPv6 addresses (if no IPv6 addresses were present, and
+	// the IPv4 addresses aren't reachable through a CLAT).
+	if len(ipv6Addrs) == 0 && !r.clatPresent() {
 		for _, addr := range ipv4Addrs {
 			ipv6Addrs = append(ipv6Addrs, r.synthesizeAddr(addr))
 		}
@@ -89,11 +132,7 @@ func (r *dns64Resolver) LookupNetIP(ctx context.Context, network, host string) (
 		addrs = append(ipv4Addrs, ipv6Addrs...)
 	}
 
-	dial := func(network, address string) (net.Conn, error) {
-		return r.dialContext(ctx, network, address)
-	}
-
-	addrselect.SortByRFC6724(dial, addrs)
+	r.sorter.sort(ctx, addrs)
 
 	return addrs, nil
 }
@@ -110,3 +149,93 @@ func (r *dns64Resolver) synthesizeAddr(addr netip.Addr) netip.Addr {
 
 	return netip.AddrFrom16(ipv6Addr)
 }
+
+// clatPresent reports whether synthesis is suppressed because the host has a
+// CLAT.
+func (r *dns64Resolver) clatPresent() bool {
+	switch r.clatMode {
+	case CLATModePresent:
+		return true
+	case CLATModeAuto:
+		return r.clat.Present()
+	default:
+		return false
+	}
+}
+
+// unsynthesizeAddr returns the IPv4 address embedded in a synthesized IPv6
+// address, or false if the address wasn't synthesized using the prefix.
+func (r *dns64Resolver) unsynthesizeAddr(addr netip.Addr) (netip.Addr, bool) {
+	if !addr.Is6() || addr.Is4In6() || !r.prefix.Contains(addr.WithZone("")) {
+		return netip.Addr{}, false
+	}
+
+	ipv6Addr := addr.As16()
+	return netip.AddrFrom4([4]byte(ipv6Addr[12:])), true
+}
+
+// LookupAddr performs a reverse lookup, synthesized IPv6 addresses are looked
+// up using the IPv4 address they embed (RFC 6147 section 5.3.1).
+func (r *dns64Resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if ip, err := netip.ParseAddr(addr); err == nil {
+		if ipv4Addr, ok := r.unsynthesizeAddr(ip); ok {
+			addr = ipv4Addr.String()
+		}
+	}
+
+	return lookupAddr(ctx, r.resolver, addr)
+}
+
+// Lookup answers the question, AAAA questions are answered with synthesized
+// addresses (if necessary). PTR questions for synthesized addresses are
+// answered with a CNAME record to the corresponding in-addr.arpa. name,
+// followed by the answer to the PTR question for that name (RFC 6147 section
+// 5.3.1).
+func (r *dns64Resolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	switch q.Type {
+	case dns.TypeAAAA:
+		return lookupBasic(ctx, r, q)
+	case dns.TypePTR:
+		addr, ok := addrFromReverseName(q.Name)
+		if !ok {
+			break
+		}
+
+		ipv4Addr, ok := r.unsynthesizeAddr(addr)
+		if !ok {
+			break
+		}
+
+		target, err := dns.ReverseAddr(ipv4Addr.String())
+		if err != nil {
+			return Answer{}, err
+		}
+
+		answer, err := Lookup(ctx, r.resolver, Question{Name: target, Type: dns.TypePTR})
+		if err != nil {
+			return Answer{}, err
+		}
+
+		cname := &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: dns.Fqdn(q.Name), Rrtype: dns.TypeCNAME, Class: dns.ClassINET},
+			Target: target,
+		}
+
+		return Answer{Records: append([]dns.RR{cname}, answer.Records...)}, nil
+	}
+
+	return Lookup(ctx, r.resolver, q)
+}
+
+func (r *dns64Resolver) Describe() Description {
+	return Description{
+		Type: "dns64",
+		Attributes: map[string]string{
+			"prefix":        r.prefix.String(),
+			"address-order": string(r.sorter.order),
+			"clat":          string(r.clatMode),
+			"clat-present":  strconv.FormatBool(r.clatPresent()),
+		},
+		Children: []Description{Describe(r.resolver)},
+	}
+}
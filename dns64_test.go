@@ -14,12 +14,15 @@ import (
 	"net/netip"
 	"testing"
 
+	"github.com/miekg/dns"
 	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/util/ptr"
 	"github.com/stretchr/testify/require"
 )
 
 func TestDNS64Resolver(t *testing.T) {
-	res := resolver.DNS64(resolver.Literal(), nil)
+	res, err := resolver.DNS64(resolver.Literal(), nil)
+	require.NoError(t, err)
 
 	t.Run("IPv4", func(t *testing.T) {
 		addrs, err := res.LookupNetIP(context.Background(), "ip6", "10.0.0.1")
@@ -35,3 +38,66 @@ func TestDNS64Resolver(t *testing.T) {
 		require.Equal(t, []netip.Addr{netip.MustParseAddr("2001:db8:85a3::8a2e:370:7334")}, addrs)
 	})
 }
+
+func TestDNS64ResolverReverse(t *testing.T) {
+	hosts, err := resolver.Hosts(nil)
+	require.NoError(t, err)
+
+	hosts.AddHost("ipv4.example.com", netip.MustParseAddr("192.0.2.1"))
+	hosts.AddHost("ipv6.example.com", netip.MustParseAddr("2001:db8::1"))
+
+	res, err := resolver.DNS64(hosts, nil)
+	require.NoError(t, err)
+
+	t.Run("LookupAddr", func(t *testing.T) {
+		names, err := res.LookupAddr(context.Background(), "64:ff9b::c000:201")
+		require.NoError(t, err)
+
+		require.Equal(t, []string{"ipv4.example.com."}, names)
+	})
+
+	t.Run("LookupAddr (Not Synthesized)", func(t *testing.T) {
+		names, err := res.LookupAddr(context.Background(), "2001:db8::1")
+		require.NoError(t, err)
+
+		require.Equal(t, []string{"ipv6.example.com."}, names)
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		name, err := dns.ReverseAddr("64:ff9b::c000:201")
+		require.NoError(t, err)
+
+		answer, err := res.Lookup(context.Background(), resolver.Question{Name: name, Type: dns.TypePTR})
+		require.NoError(t, err)
+
+		require.Len(t, answer.Records, 2)
+
+		cname, ok := answer.Records[0].(*dns.CNAME)
+		require.True(t, ok)
+		require.Equal(t, name, cname.Hdr.Name)
+		require.Equal(t, "1.2.0.192.in-addr.arpa.", cname.Target)
+
+		ptr, ok := answer.Records[1].(*dns.PTR)
+		require.True(t, ok)
+		require.Equal(t, "1.2.0.192.in-addr.arpa.", ptr.Hdr.Name)
+		require.Equal(t, "ipv4.example.com.", ptr.Ptr)
+	})
+
+	t.Run("Lookup (Not Synthesized)", func(t *testing.T) {
+		name, err := dns.ReverseAddr("2001:db8::1")
+		require.NoError(t, err)
+
+		answer, err := res.Lookup(context.Background(), resolver.Question{Name: name, Type: dns.TypePTR})
+		require.NoError(t, err)
+
+		require.Len(t, answer.Records, 1)
+		require.Equal(t, "ipv6.example.com.", answer.Records[0].(*dns.PTR).Ptr)
+	})
+}
+
+func TestDNS64ResolverInvalidPrefix(t *testing.T) {
+	_, err := resolver.DNS64(resolver.Literal(), &resolver.DNS64ResolverConfig{
+		Prefix: ptr.To(netip.MustParsePrefix("64:ff9b::/64")),
+	})
+	require.Error(t, err)
+}
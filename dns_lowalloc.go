@@ -0,0 +1,302 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// bufPool reduces allocations by reusing wire format message buffers.
+var bufPool = sync.Pool{
+	New: func() any {
+		// Large enough for a UDP response (plus the TCP length prefix).
+		buf := make([]byte, 0, dns.MinMsgSize+2)
+		return &buf
+	},
+}
+
+// exchangeLowAlloc sends an A or AAAA query for name over conn and returns
+// the addresses in the answer section of the response. Unlike the miekg/dns
+// based implementation, messages are built and parsed in place using pooled
+// buffers. If rcode is not nil, it is set to the response code of the response.
+func (r *dnsResolver) exchangeLowAlloc(ctx context.Context, conn net.Conn, name string, qType uint16, rcode *int) ([]netip.Addr, *net.DNSError) {
+	transportError := func(err error) *net.DNSError {
+		return r.queryError(name, net.DNSError{
+			Err:         err.Error(),
+			IsTimeout:   isTimeout(err),
+			IsTemporary: true,
+		})
+	}
+
+	qName, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, r.queryError(name, net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			IsNotFound: true,
+		})
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, transportError(err)
+		}
+	}
+
+	bufp := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bufp)
+
+	_, isPacket := conn.(net.PacketConn)
+
+	buf := (*bufp)[:0]
+	if !isPacket {
+		// Leave room for the length prefix.
+		buf = append(buf, 0, 0)
+	}
+
+	id := uint16(rand.Uint32())
+	b := dnsmessage.NewBuilder(buf, dnsmessage.Header{
+		ID:               id,
+		RecursionDesired: true,
+		AuthenticData:    r.trustAD,
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, transportError(err)
+	}
+	if err := b.Question(dnsmessage.Question{
+		Name:  qName,
+		Type:  dnsmessage.Type(qType),
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, transportError(err)
+	}
+	if r.padding {
+		// The header, and the question (the name plus its type and class).
+		if err := padQueryLowAlloc(&b, 12+wireNameLength(qName)+4); err != nil {
+			return nil, transportError(err)
+		}
+	}
+	buf, err = b.Finish()
+	if err != nil {
+		return nil, transportError(err)
+	}
+
+	if !isPacket {
+		binary.BigEndian.PutUint16(buf, uint16(len(buf)-2))
+	}
+
+	if _, err := conn.Write(buf); err != nil {
+		return nil, transportError(err)
+	}
+
+	var p dnsmessage.Parser
+	var h dnsmessage.Header
+	for {
+		buf, err = readWireMsg(conn, isPacket, buf)
+		// Hold on to the (possibly grown) buffer for reuse.
+		*bufp = buf[:0]
+		if err != nil {
+			return nil, transportError(err)
+		}
+
+		h, err = p.Start(buf)
+		if err != nil {
+			return nil, transportError(fmt.Errorf("failed to parse response: %w", err))
+		}
+
+		if h.ID == id {
+			break
+		}
+
+		// Ignore replies with mismatched IDs on datagram transports because
+		// they might be responses to earlier queries that timed out.
+		if !isPacket {
+			return nil, transportError(dns.ErrId)
+		}
+	}
+
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, transportError(fmt.Errorf("failed to parse response: %w", err))
+	}
+
+	if rcode != nil {
+		*rcode = int(h.RCode)
+	}
+
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return nil, r.rcodeError(name, int(h.RCode))
+	}
+
+	// Addresses of names other than the queried name are only kept if they
+	// belong to its CNAME chain (see answersFor).
+	var addrs []netip.Addr
+	var others []ownedAddr
+	var aliases []alias
+	var answers, cnames int
+	for {
+		hdr, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, transportError(fmt.Errorf("failed to parse response: %w", err))
+		}
+
+		answers++
+		if hdr.Type == dnsmessage.TypeCNAME {
+			cnames++
+		}
+
+		// Check the limits as we go so that we can bail out early.
+		if err := r.checkLimits(answers, cnames); err != nil {
+			return nil, r.queryError(name, net.DNSError{
+				Err: err.Error(),
+			})
+		}
+
+		// The parser doesn't validate the RDATA length of A and AAAA records.
+		if (hdr.Type == dnsmessage.TypeA && hdr.Length != net.IPv4len) ||
+			(hdr.Type == dnsmessage.TypeAAAA && hdr.Length != net.IPv6len) {
+			return nil, r.queryError(name, net.DNSError{
+				Err: r.malformedAnswerError(uint16(hdr.Type)).Error(),
+			})
+		}
+
+		var addr netip.Addr
+		switch hdr.Type {
+		case dnsmessage.TypeA:
+			rr, err := p.AResource()
+			if err != nil {
+				return nil, transportError(fmt.Errorf("failed to parse response: %w", err))
+			}
+			addr = netip.AddrFrom4(rr.A)
+		case dnsmessage.TypeAAAA:
+			rr, err := p.AAAAResource()
+			if err != nil {
+				return nil, transportError(fmt.Errorf("failed to parse response: %w", err))
+			}
+			addr = netip.AddrFrom16(rr.AAAA)
+		case dnsmessage.TypeCNAME:
+			rr, err := p.CNAMEResource()
+			if err != nil {
+				return nil, transportError(fmt.Errorf("failed to parse response: %w", err))
+			}
+			aliases = append(aliases, alias{name: hdr.Name, target: rr.CNAME})
+			continue
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return nil, transportError(fmt.Errorf("failed to parse response: %w", err))
+			}
+			continue
+		}
+
+		if equalNames(hdr.Name, qName) {
+			addrs = append(addrs, addr)
+		} else {
+			others = append(others, ownedAddr{name: hdr.Name, addr: addr})
+		}
+	}
+
+	if len(others) > 0 {
+		// Follow the CNAME chain, regardless of the order of the records.
+		chain := []dnsmessage.Name{qName}
+		for found := true; found; {
+			found = false
+			for _, a := range aliases {
+				if containsName(chain, a.name) && !containsName(chain, a.target) {
+					chain = append(chain, a.target)
+					found = true
+				}
+			}
+		}
+
+		for _, other := range others {
+			if containsName(chain, other.name) {
+				addrs = append(addrs, other.addr)
+			}
+		}
+	}
+
+	r.recordResponse(ctx, h.AuthenticData)
+
+	return addrs, nil
+}
+
+// ownedAddr is an address record of a name other than the queried name.
+type ownedAddr struct {
+	name dnsmessage.Name
+	addr netip.Addr
+}
+
+// alias is a CNAME record.
+type alias struct {
+	name   dnsmessage.Name
+	target dnsmessage.Name
+}
+
+// containsName reports whether names contains name, ignoring case.
+func containsName(names []dnsmessage.Name, name dnsmessage.Name) bool {
+	return slices.ContainsFunc(names, func(n dnsmessage.Name) bool {
+		return equalNames(n, name)
+	})
+}
+
+// equalNames reports whether two names are equal, ignoring case.
+func equalNames(a, b dnsmessage.Name) bool {
+	return bytes.EqualFold(bytes.TrimSuffix(a.Data[:a.Length], []byte(".")),
+		bytes.TrimSuffix(b.Data[:b.Length], []byte(".")))
+}
+
+// readWireMsg reads a single wire format DNS message from conn into buf,
+// growing it if necessary.
+func readWireMsg(conn net.Conn, isPacket bool, buf []byte) ([]byte, error) {
+	if isPacket {
+		buf = buf[:cap(buf)]
+		if len(buf) < dns.MinMsgSize {
+			buf = make([]byte, dns.MinMsgSize)
+		}
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			return buf[:0], err
+		}
+
+		return buf[:n], nil
+	}
+
+	buf = buf[:2]
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return buf[:0], err
+	}
+
+	length := int(binary.BigEndian.Uint16(buf))
+	if cap(buf) < length {
+		buf = make([]byte, length)
+	}
+	buf = buf[:length]
+
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return buf[:0], err
+	}
+
+	return buf, nil
+}
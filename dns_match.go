@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// errResponseMismatch is returned when a response received over a stream
+// transport doesn't match the query.
+var errResponseMismatch = fmt.Errorf("response does not match query: %w", ErrServerMisbehaving)
+
+// exchangeMsg sends req over conn and returns the response. With strict
+// response matching, mismatched responses received over UDP (eg. spoofed
+// responses, or late replies to earlier queries) are silently dropped while
+// waiting for a matching response.
+func (r *dnsResolver) exchangeMsg(ctx context.Context, conn net.Conn, req *dns.Msg) (*dns.Msg, error) {
+	if !r.strictMatching {
+		reply, _, err := r.client.ExchangeWithConnContext(ctx, req, &dns.Conn{Conn: conn})
+		return reply, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(r.timeout)
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	co := &dns.Conn{Conn: conn}
+	if err := co.WriteMsg(req); err != nil {
+		return nil, err
+	}
+
+	_, isPacket := conn.(net.PacketConn)
+	for {
+		reply, err := co.ReadMsg()
+		if err == nil && responseMatches(req, reply) {
+			return reply, nil
+		}
+
+		if !isPacket {
+			if err == nil {
+				err = errResponseMismatch
+			}
+			return nil, err
+		}
+
+		// A read error (rather than a malformed datagram) ends the wait.
+		if err != nil && reply == nil {
+			return nil, err
+		}
+	}
+}
+
+// responseMatches reports whether reply is a response to req. The name in the
+// question must match exactly (including its case, which may have been
+// randomized) and every answer record must belong to the queried name or one
+// of its CNAME targets.
+func responseMatches(req, reply *dns.Msg) bool {
+	if reply.Id != req.Id || !reply.Response || reply.Opcode != req.Opcode ||
+		len(reply.Question) != 1 || len(req.Question) != 1 {
+		return false
+	}
+
+	q, rq := req.Question[0], reply.Question[0]
+	if rq.Name != q.Name || rq.Qtype != q.Qtype || rq.Qclass != q.Qclass {
+		return false
+	}
+
+	names := chainNames(q.Name, reply.Answer)
+	for _, rr := range reply.Answer {
+		if !slices.Contains(names, dns.CanonicalName(rr.Header().Name)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// chainNames returns the canonical names of name and of the targets of the
+// CNAME chain starting at name, regardless of the order of the records.
+func chainNames(name string, answer []dns.RR) []string {
+	names := []string{dns.CanonicalName(name)}
+	for found := true; found; {
+		found = false
+		for _, rr := range answer {
+			cname, ok := rr.(*dns.CNAME)
+			if !ok || !slices.Contains(names, dns.CanonicalName(cname.Hdr.Name)) {
+				continue
+			}
+
+			if target := dns.CanonicalName(cname.Target); !slices.Contains(names, target) {
+				names = append(names, target)
+				found = true
+			}
+		}
+	}
+
+	return names
+}
+
+// answersFor returns the records of the answer section that belong to name or
+// to one of its CNAME targets. Servers can include arbitrary records in their
+// responses, records for names that weren't asked about (eg. an attempt at
+// cache poisoning) are dropped rather than surfaced.
+func answersFor(name string, answer []dns.RR) []dns.RR {
+	names := chainNames(name, answer)
+
+	// Avoid copying the common case of a response without foreign records.
+	if !slices.ContainsFunc(answer, func(rr dns.RR) bool {
+		return !slices.Contains(names, dns.CanonicalName(rr.Header().Name))
+	}) {
+		return answer
+	}
+
+	filtered := make([]dns.RR, 0, len(answer))
+	for _, rr := range answer {
+		if slices.Contains(names, dns.CanonicalName(rr.Header().Name)) {
+			filtered = append(filtered, rr)
+		}
+	}
+
+	return filtered
+}
+
+// randomizeCase randomizes the case of the letters in name (DNS 0x20
+// encoding, see draft-vixie-dnsext-dns0x20).
+func randomizeCase(name string) string {
+	b := []byte(name)
+
+	var bits uint64
+	var n int
+	for i, c := range b {
+		if lower := c | 0x20; lower < 'a' || lower > 'z' {
+			continue
+		}
+
+		if n == 0 {
+			bits, n = rand.Uint64(), 64
+		}
+
+		if bits&1 == 1 {
+			b[i] ^= 0x20
+		}
+		bits >>= 1
+		n--
+	}
+
+	return string(b)
+}
@@ -0,0 +1,284 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+// DNSOption configures a DNS resolver created with NewDNS.
+type DNSOption func(conf *DNSResolverConfig)
+
+// NewDNS creates a new DNS resolver for server, configured using functional
+// options. This is equivalent to calling DNS with the corresponding
+// DNSResolverConfig.
+func NewDNS(server netip.AddrPort, opts ...DNSOption) (*dnsResolver, error) {
+	conf := DNSResolverConfig{
+		Server: server,
+	}
+
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	return DNS(conf)
+}
+
+// WithTransport sets the transport protocol used for DNS resolution.
+func WithTransport(transport DNSTransport) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.Transport = &transport
+	}
+}
+
+// WithURL sets the URL of the DNS over HTTPS endpoint.
+func WithURL(url string) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.URL = url
+	}
+}
+
+// WithHTTP3RoundTripper sets the HTTP/3 transport used for DNS over HTTPS once
+// the server advertises HTTP/3 support (see
+// DNSResolverConfig.HTTP3RoundTripper).
+func WithHTTP3RoundTripper(http3 http.RoundTripper) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.HTTP3RoundTripper = http3
+	}
+}
+
+// WithHTTPHeader sets additional HTTP headers sent with DNS over HTTPS
+// requests, eg. a User-Agent or an Authorization header.
+func WithHTTPHeader(header http.Header) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.HTTPHeader = header
+	}
+}
+
+// WithTimeout sets the maximum duration to wait for a query to complete.
+func WithTimeout(timeout time.Duration) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.Timeout = &timeout
+	}
+}
+
+// WithDialContext sets the dialer used to establish a connection to the
+// server.
+func WithDialContext(dialContext DialContextFunc) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.DialContext = dialContext
+	}
+}
+
+// WithDialers sets per transport dialers, overriding the dialer set with
+// WithDialContext.
+func WithDialers(dialers *TransportDialers) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.Dialers = dialers
+	}
+}
+
+// WithInterface binds queries to the named network interface.
+func WithInterface(name string) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.Interface = name
+	}
+}
+
+// WithLocalAddr sets the local (source) address that queries are sent from.
+func WithLocalAddr(addr netip.Addr) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.LocalAddr = addr
+	}
+}
+
+// WithTLSConfig sets the configuration of the TLS client used for DNS over
+// TLS.
+func WithTLSConfig(tlsConfig *tls.Config) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.TLSConfig = tlsConfig
+	}
+}
+
+// WithoutTLSSessionResumption disables TLS session resumption for DNS over
+// TLS.
+func WithoutTLSSessionResumption() DNSOption {
+	return func(conf *DNSResolverConfig) {
+		noTLSSessionResumption := true
+		conf.NoTLSSessionResumption = &noTLSSessionResumption
+	}
+}
+
+// WithoutPadding disables the EDNS(0) padding of queries sent over encrypted
+// transports.
+func WithoutPadding() DNSOption {
+	return func(conf *DNSResolverConfig) {
+		noPadding := true
+		conf.NoPadding = &noPadding
+	}
+}
+
+// WithSPKIPins only accepts DNS over TLS connections to servers presenting a
+// certificate whose public key matches one of the pins (see SPKIHash).
+func WithSPKIPins(pins ...string) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.SPKIPins = pins
+	}
+}
+
+// WithVerifyConnection sets a hook that is called after the TLS handshake of a
+// DNS over TLS connection.
+func WithVerifyConnection(verifyConnection func(cs tls.ConnectionState) error) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.VerifyConnection = verifyConnection
+	}
+}
+
+// WithAddressOrder sets the policy used to order the returned addresses.
+func WithAddressOrder(order AddressOrder) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.AddressOrder = &order
+	}
+}
+
+// WithPolicyTable sets the RFC 6724 address selection policy table.
+func WithPolicyTable(policyTable []PolicyTableEntry) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.PolicyTable = policyTable
+	}
+}
+
+// WithSourceAddrProvider sets the provider of the source addresses used when
+// sorting addresses according to RFC 6724.
+func WithSourceAddrProvider(srcAddrs SourceAddrProvider) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.SourceAddrProvider = srcAddrs
+	}
+}
+
+// WithSingleRequest queries A and AAAA records sequentially.
+func WithSingleRequest() DNSOption {
+	return func(conf *DNSResolverConfig) {
+		singleRequest := true
+		conf.SingleRequest = &singleRequest
+	}
+}
+
+// WithQueryOrder sets the order in which A and AAAA queries are issued.
+func WithQueryOrder(order DNSQueryOrder) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.QueryOrder = &order
+	}
+}
+
+// WithMaxInFlightQueries limits the number of concurrent queries sent to the
+// server.
+func WithMaxInFlightQueries(n int) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.MaxInFlightQueries = &n
+	}
+}
+
+// WithTCPFallback retries DNS over UDP queries that fail without a response
+// over TCP.
+func WithTCPFallback() DNSOption {
+	return func(conf *DNSResolverConfig) {
+		tcpFallback := true
+		conf.TCPFallback = &tcpFallback
+	}
+}
+
+// WithTrustAD trusts the server to validate responses using DNSSEC, see
+// DNSResolverConfig.TrustAD.
+func WithTrustAD() DNSOption {
+	return func(conf *DNSResolverConfig) {
+		trustAD := true
+		conf.TrustAD = &trustAD
+	}
+}
+
+// WithQueryLimiter shares a limiter of concurrent queries with other
+// resolvers.
+func WithQueryLimiter(limiter *QueryLimiter) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.QueryLimiter = limiter
+	}
+}
+
+// WithWorkerPool shares a pool of workers, used to send the queries of a
+// lookup concurrently, with other resolvers (see DNSResolverConfig.WorkerPool).
+func WithWorkerPool(pool *WorkerPool) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.WorkerPool = pool
+	}
+}
+
+// WithResponseLimits sets the maximum response size (in bytes), the maximum
+// number of answer records and the maximum CNAME chain length accepted in a
+// response. Zero values leave the corresponding default in place.
+func WithResponseLimits(maxResponseSize, maxAnswers, maxCNAMEChain int) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		if maxResponseSize > 0 {
+			conf.MaxResponseSize = &maxResponseSize
+		}
+		if maxAnswers > 0 {
+			conf.MaxAnswers = &maxAnswers
+		}
+		if maxCNAMEChain > 0 {
+			conf.MaxCNAMEChain = &maxCNAMEChain
+		}
+	}
+}
+
+// WithQueryLog records every query sent to the server in log.
+func WithQueryLog(log *QueryLog) DNSOption {
+	return func(conf *DNSResolverConfig) {
+		conf.QueryLog = log
+	}
+}
+
+// WithCaseRandomization randomizes the case of query names (DNS 0x20
+// encoding), this implies strict response matching.
+func WithCaseRandomization() DNSOption {
+	return func(conf *DNSResolverConfig) {
+		caseRandomization := true
+		conf.CaseRandomization = &caseRandomization
+	}
+}
+
+// WithStrictResponseMatching drops (over UDP) or rejects (over TCP and TLS)
+// responses that don't match the query.
+func WithStrictResponseMatching() DNSOption {
+	return func(conf *DNSResolverConfig) {
+		strictResponseMatching := true
+		conf.StrictResponseMatching = &strictResponseMatching
+	}
+}
+
+// WithAdaptiveTimeout derives the timeout of each query from the measured
+// round trip time of the server, bounded by the configured timeout.
+func WithAdaptiveTimeout() DNSOption {
+	return func(conf *DNSResolverConfig) {
+		adaptiveTimeout := true
+		conf.AdaptiveTimeout = &adaptiveTimeout
+	}
+}
+
+// WithLowAllocation enables the low allocation wire format implementation for
+// A and AAAA queries.
+func WithLowAllocation() DNSOption {
+	return func(conf *DNSResolverConfig) {
+		lowAllocation := true
+		conf.LowAllocation = &lowAllocation
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"github.com/miekg/dns"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// paddingBlockLength is the block length queries are padded to, as
+	// recommended by RFC 8467, section 4.1.
+	paddingBlockLength = 128
+	// paddingUDPSize is the UDP payload size advertised by the OPT record
+	// carrying the padding option. Padding is only used over stream based
+	// transports, so this is never relied upon.
+	paddingUDPSize = dns.DefaultMsgSize
+	// paddingOptLength is the length of an OPT record with an empty padding
+	// option (the root owner name, type, class, TTL, RDLENGTH, and the option
+	// code and length).
+	paddingOptLength = 1 + 2 + 2 + 4 + 2 + 4
+)
+
+// paddingZeros is the (read only) source of padding bytes.
+var paddingZeros [paddingBlockLength]byte
+
+// paddingLength returns the length of the padding needed to bring a message
+// of the given length (including an empty padding option) to a multiple of
+// the block length.
+func paddingLength(msgLen int) int {
+	return (paddingBlockLength - msgLen%paddingBlockLength) % paddingBlockLength
+}
+
+// padQuery adds an OPT record with an EDNS(0) padding option (RFC 7830) to
+// req, replacing any existing additional records.
+func padQuery(req *dns.Msg) {
+	opt := &dns.OPT{
+		Hdr: dns.RR_Header{
+			Name:   ".",
+			Rrtype: dns.TypeOPT,
+		},
+	}
+	opt.SetUDPSize(paddingUDPSize)
+
+	req.Extra = append(req.Extra[:0], opt)
+
+	padding := &dns.EDNS0_PADDING{}
+	opt.Option = append(opt.Option, padding)
+	padding.Padding = paddingZeros[:paddingLength(req.Len())]
+}
+
+// padQueryLowAlloc adds an OPT record with an EDNS(0) padding option to a
+// query being built, given the length of the header and question sections.
+func padQueryLowAlloc(b *dnsmessage.Builder, msgLen int) error {
+	if err := b.StartAdditionals(); err != nil {
+		return err
+	}
+
+	var h dnsmessage.ResourceHeader
+	if err := h.SetEDNS0(paddingUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return err
+	}
+
+	return b.OPTResource(h, dnsmessage.OPTResource{
+		Options: []dnsmessage.Option{{
+			Code: dns.EDNS0PADDING,
+			Data: paddingZeros[:paddingLength(msgLen+paddingOptLength)],
+		}},
+	})
+}
+
+// wireNameLength returns the uncompressed wire format length of a fully
+// qualified name.
+func wireNameLength(name dnsmessage.Name) int {
+	if name.Length == 1 {
+		// The root name is a single zero length label.
+		return 1
+	}
+
+	return int(name.Length) + 1
+}
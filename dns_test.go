@@ -11,11 +11,26 @@ package resolver_test
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"net/netip"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
 	"github.com/noisysockets/util/ptr"
 	"github.com/stretchr/testify/require"
 )
@@ -28,10 +43,19 @@ func TestDNSResolver(t *testing.T) {
 		netip.MustParseAddr("8.8.4.4"),
 	}
 
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"dns.google": expected,
+		},
+		TLS:   ptr.To(true),
+		HTTPS: ptr.To(true),
+	})
+
 	t.Run("UDP", func(t *testing.T) {
-		res := resolver.DNS(resolver.DNSResolverConfig{
-			Server: netip.AddrPortFrom(netip.MustParseAddr("8.8.8.8"), 0),
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server: srv.Addr(),
 		})
+		require.NoError(t, err)
 
 		addrs, err := res.LookupNetIP(context.Background(), "ip", "dns.google")
 		require.NoError(t, err)
@@ -40,10 +64,11 @@ func TestDNSResolver(t *testing.T) {
 	})
 
 	t.Run("TCP", func(t *testing.T) {
-		res := resolver.DNS(resolver.DNSResolverConfig{
-			Server:    netip.AddrPortFrom(netip.MustParseAddr("8.8.8.8"), 0),
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:    srv.Addr(),
 			Transport: ptr.To(resolver.DNSTransportTCP),
 		})
+		require.NoError(t, err)
 
 		addrs, err := res.LookupNetIP(context.Background(), "ip", "dns.google")
 		require.NoError(t, err)
@@ -52,17 +77,1521 @@ func TestDNSResolver(t *testing.T) {
 	})
 
 	t.Run("TLS", func(t *testing.T) {
-		res := resolver.DNS(resolver.DNSResolverConfig{
-			Server:    netip.AddrPortFrom(netip.MustParseAddr("8.8.8.8"), 0),
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:    srv.TLSAddr(),
 			Transport: ptr.To(resolver.DNSTransportTLS),
-			TLSConfig: &tls.Config{
-				ServerName: "dns.google",
-			},
+			TLSConfig: srv.ClientTLSConfig(),
+		})
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "dns.google")
+		require.NoError(t, err)
+
+		require.ElementsMatch(t, expected, addrs)
+	})
+
+	t.Run("HTTPS", func(t *testing.T) {
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:    srv.HTTPSAddr(),
+			Transport: ptr.To(resolver.DNSTransportHTTPS),
+			URL:       srv.URL() + "{?dns}",
+			TLSConfig: srv.ClientTLSConfig(),
 		})
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "dns.google")
+		require.NoError(t, err)
+
+		require.ElementsMatch(t, expected, addrs)
+
+		_, err = res.LookupNetIP(context.Background(), "ip", "nonexistent.google")
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+
+		d := resolver.Describe(res)
+		require.Equal(t, "https", d.Attributes["transport"])
+		require.Equal(t, srv.URL(), d.Attributes["url"])
+
+		t.Run("Invalid URL", func(t *testing.T) {
+			_, err := resolver.DNS(resolver.DNSResolverConfig{
+				Server:    srv.HTTPSAddr(),
+				Transport: ptr.To(resolver.DNSTransportHTTPS),
+				URL:       "http://dns.resolvertest/dns-query",
+			})
+			require.Error(t, err)
+		})
+	})
+
+	t.Run("Transport Dialers", func(t *testing.T) {
+		var mu sync.Mutex
+		dialed := map[string]int{}
+		countingDialer := func(name string) resolver.DialContextFunc {
+			return func(ctx context.Context, network, address string) (net.Conn, error) {
+				mu.Lock()
+				dialed[name]++
+				mu.Unlock()
+
+				return (&net.Dialer{}).DialContext(ctx, network, address)
+			}
+		}
+
+		dialers := &resolver.TransportDialers{
+			UDP:   countingDialer("udp"),
+			TCP:   countingDialer("tcp"),
+			TLS:   countingDialer("tls"),
+			Probe: countingDialer("probe"),
+		}
+
+		for _, transport := range []resolver.DNSTransport{resolver.DNSTransportUDP, resolver.DNSTransportTCP, resolver.DNSTransportTLS} {
+			server := srv.Addr()
+			if transport == resolver.DNSTransportTLS {
+				server = srv.TLSAddr()
+			}
+
+			res, err := resolver.NewDNS(server,
+				resolver.WithTransport(transport),
+				resolver.WithTLSConfig(srv.ClientTLSConfig()),
+				resolver.WithDialContext(countingDialer("default")),
+				resolver.WithDialers(dialers),
+			)
+			require.NoError(t, err)
+
+			addrs, err := res.LookupNetIP(context.Background(), "ip", "dns.google")
+			require.NoError(t, err)
+
+			require.ElementsMatch(t, expected, addrs)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		require.Equal(t, 2, dialed["udp"])
+		require.Equal(t, 2, dialed["tcp"])
+		require.Equal(t, 2, dialed["tls"])
+		require.NotZero(t, dialed["probe"])
+		require.Zero(t, dialed["default"])
+	})
+
+	t.Run("Local Address", func(t *testing.T) {
+		for _, transport := range []resolver.DNSTransport{resolver.DNSTransportUDP, resolver.DNSTransportTCP, resolver.DNSTransportTLS} {
+			t.Run(string(transport), func(t *testing.T) {
+				server := srv.Addr()
+				if transport == resolver.DNSTransportTLS {
+					server = srv.TLSAddr()
+				}
+
+				res, err := resolver.NewDNS(server,
+					resolver.WithTransport(transport),
+					resolver.WithTLSConfig(srv.ClientTLSConfig()),
+					resolver.WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+				)
+				require.NoError(t, err)
+
+				addrs, err := res.LookupNetIP(context.Background(), "ip", "dns.google")
+				require.NoError(t, err)
+
+				require.ElementsMatch(t, expected, addrs)
+			})
+		}
+	})
+
+	t.Run("Interface", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("loopback interface name is platform specific")
+		}
+
+		res, err := resolver.NewDNS(srv.Addr(), resolver.WithInterface("lo"))
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "dns.google")
+		require.NoError(t, err)
+
+		require.ElementsMatch(t, expected, addrs)
+
+		res, err = resolver.NewDNS(srv.Addr(), resolver.WithInterface("nonexistent0"))
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip", "dns.google")
+		require.Error(t, err)
+	})
+
+	t.Run("TLS SPKI Pins", func(t *testing.T) {
+		res, err := resolver.NewDNS(srv.TLSAddr(),
+			resolver.WithTransport(resolver.DNSTransportTLS),
+			resolver.WithSPKIPins(resolver.SPKIHash(srv.Certificate())),
+		)
+		require.NoError(t, err)
 
 		addrs, err := res.LookupNetIP(context.Background(), "ip", "dns.google")
 		require.NoError(t, err)
 
 		require.ElementsMatch(t, expected, addrs)
+
+		res, err = resolver.NewDNS(srv.TLSAddr(),
+			resolver.WithTransport(resolver.DNSTransportTLS),
+			resolver.WithSPKIPins(base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))),
+		)
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip", "dns.google")
+		require.ErrorContains(t, err, "does not match any spki pin")
+
+		_, err = resolver.NewDNS(srv.TLSAddr(),
+			resolver.WithTransport(resolver.DNSTransportTLS),
+			resolver.WithSPKIPins("not a pin"),
+		)
+		require.Error(t, err)
+	})
+
+	t.Run("TLS Session Resumption", func(t *testing.T) {
+		for _, resumption := range []bool{true, false} {
+			var resumed []bool
+			opts := []resolver.DNSOption{
+				resolver.WithTransport(resolver.DNSTransportTLS),
+				resolver.WithTLSConfig(srv.ClientTLSConfig()),
+				resolver.WithSingleRequest(),
+				resolver.WithVerifyConnection(func(cs tls.ConnectionState) error {
+					resumed = append(resumed, cs.DidResume)
+					return nil
+				}),
+			}
+			if !resumption {
+				opts = append(opts, resolver.WithoutTLSSessionResumption())
+			}
+
+			res, err := resolver.NewDNS(srv.TLSAddr(), opts...)
+			require.NoError(t, err)
+
+			for i := 0; i < 2; i++ {
+				_, err = res.LookupNetIP(context.Background(), "ip4", "dns.google")
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, []bool{false, resumption}, resumed)
+		}
+	})
+
+	t.Run("TLS Verify Connection", func(t *testing.T) {
+		var called bool
+		res, err := resolver.NewDNS(srv.TLSAddr(),
+			resolver.WithTransport(resolver.DNSTransportTLS),
+			resolver.WithTLSConfig(srv.ClientTLSConfig()),
+			resolver.WithSingleRequest(),
+			resolver.WithVerifyConnection(func(cs tls.ConnectionState) error {
+				called = true
+				if cs.ServerName != "dns.resolvertest" {
+					return errors.New("unexpected server name")
+				}
+				return nil
+			}),
+		)
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip", "dns.google")
+		require.NoError(t, err)
+		require.True(t, called)
+
+		res, err = resolver.NewDNS(srv.TLSAddr(),
+			resolver.WithTransport(resolver.DNSTransportTLS),
+			resolver.WithTLSConfig(srv.ClientTLSConfig()),
+			resolver.WithVerifyConnection(func(cs tls.ConnectionState) error {
+				return errors.New("rejected")
+			}),
+		)
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip", "dns.google")
+		require.ErrorContains(t, err, "rejected")
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		res, err := resolver.NewDNS(srv.TLSAddr(),
+			resolver.WithTransport(resolver.DNSTransportTLS),
+			resolver.WithTLSConfig(srv.ClientTLSConfig()),
+			resolver.WithTimeout(2*time.Second),
+			resolver.WithSingleRequest(),
+			resolver.WithQueryOrder(resolver.DNSQueryOrderAAAAFirst),
+		)
+		require.NoError(t, err)
+
+		d := resolver.Describe(res)
+		require.Equal(t, "tcp-tls", d.Attributes["transport"])
+		require.Equal(t, "2s", d.Attributes["timeout"])
+		require.Equal(t, "true", d.Attributes["single-request"])
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "dns.google")
+		require.NoError(t, err)
+
+		require.ElementsMatch(t, expected, addrs)
+	})
+
+	t.Run("Server Order", func(t *testing.T) {
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:       srv.Addr(),
+			AddressOrder: ptr.To(resolver.AddressOrderNone),
+		})
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			addrs, err := res.LookupNetIP(context.Background(), "ip", "dns.google")
+			require.NoError(t, err)
+
+			// A answers are returned before AAAA answers.
+			require.Equal(t, []netip.Addr{
+				netip.MustParseAddr("8.8.8.8"),
+				netip.MustParseAddr("8.8.4.4"),
+				netip.MustParseAddr("2001:4860:4860::8888"),
+				netip.MustParseAddr("2001:4860:4860::8844"),
+			}, addrs)
+		}
+	})
+
+	t.Run("CNAME", func(t *testing.T) {
+		srv.AddRecords(&dns.CNAME{
+			Hdr:    dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+			Target: "dns.google.",
+		})
+
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server: srv.Addr(),
+		})
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "www.example.com")
+		require.NoError(t, err)
+
+		require.ElementsMatch(t, expected, addrs)
+	})
+
+	t.Run("IP Literal", func(t *testing.T) {
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server: srv.Addr(),
+		})
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "8.8.8.8")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("8.8.8.8")}, addrs)
+
+		_, err = res.LookupNetIP(context.Background(), "ip6", "8.8.8.8")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server: srv.Addr(),
+		})
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip", "missing.example.com")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+}
+
+func TestDNSResolverInvalidConfig(t *testing.T) {
+	server := netip.MustParseAddrPort("192.0.2.53:53")
+
+	tests := []struct {
+		name string
+		conf resolver.DNSResolverConfig
+	}{
+		{"Missing Server", resolver.DNSResolverConfig{}},
+		{"Invalid Transport", resolver.DNSResolverConfig{
+			Server:    server,
+			Transport: ptr.To(resolver.DNSTransport("quic")),
+		}},
+		{"Invalid Timeout", resolver.DNSResolverConfig{
+			Server:  server,
+			Timeout: ptr.To(-time.Second),
+		}},
+		{"Invalid Address Order", resolver.DNSResolverConfig{
+			Server:       server,
+			AddressOrder: ptr.To(resolver.AddressOrder("random")),
+		}},
+		{"Invalid Limits", resolver.DNSResolverConfig{
+			Server:     server,
+			MaxAnswers: ptr.To(-1),
+		}},
+		{"Local Address Family Mismatch", resolver.DNSResolverConfig{
+			Server:    server,
+			LocalAddr: netip.MustParseAddr("2001:db8::1"),
+		}},
+		{"Interface With Dialer", resolver.DNSResolverConfig{
+			Server:      server,
+			Interface:   "eth0",
+			DialContext: (&net.Dialer{}).DialContext,
+		}},
+		{"Server Zone Interface Mismatch", resolver.DNSResolverConfig{
+			Server:    netip.MustParseAddrPort("[fe80::1%eth1]:53"),
+			Interface: "eth0",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolver.DNS(tt.conf)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDNSResolverScopedServer(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+	})
+
+	server := netip.MustParseAddrPort("[fe80::1%eth0]:53")
+
+	t.Run("Zone", func(t *testing.T) {
+		var dialed []string
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server: server,
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialed = append(dialed, address)
+				return (&net.Dialer{}).DialContext(ctx, network, srv.Addr().String())
+			},
+		})
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+		require.NotEmpty(t, dialed)
+		require.Equal(t, "[fe80::1%eth0]:53", dialed[0])
+	})
+
+	t.Run("TLS Server Name", func(t *testing.T) {
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:    server,
+			Transport: ptr.To(resolver.DNSTransportTLS),
+		})
+		require.NoError(t, err)
+
+		// The zone is not part of the name verified against the certificate.
+		require.Equal(t, "fe80::1", resolver.Describe(res).Attributes["tls-server-name"])
+	})
+
+	t.Run("Interface", func(t *testing.T) {
+		if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+			t.Skip("binding to an interface is not supported on this platform")
+		}
+
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:    netip.MustParseAddrPort("[fe80::1]:53"),
+			Interface: "eth0",
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, server.String(), resolver.Describe(res).Attributes["server"])
+	})
+}
+
+func TestDNSResolverLimits(t *testing.T) {
+	server := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(req)
+			reply.Compress = true
+
+			switch req.Question[0].Name {
+			case "many.example.com.":
+				for i := 0; i < 20; i++ {
+					reply.Answer = append(reply.Answer, &dns.A{
+						Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+						A:   net.IPv4(10, 0, 0, byte(i+1)),
+					})
+				}
+			case "pool.example.com.":
+				for i := 0; i < 200; i++ {
+					reply.Answer = append(reply.Answer, &dns.A{
+						Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+						A:   net.IPv4(10, 0, 1, byte(i+1)),
+					})
+				}
+			case "chain.example.com.":
+				name := req.Question[0].Name
+				for i := 0; i < 5; i++ {
+					target := fmt.Sprintf("hop%d.example.com.", i)
+					reply.Answer = append(reply.Answer, &dns.CNAME{
+						Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+						Target: target,
+					})
+					name = target
+				}
+				reply.Answer = append(reply.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.IPv4(10, 0, 0, 1),
+				})
+			}
+
+			_ = w.WriteMsg(reply)
+		},
+	}).Addr()
+
+	for _, tc := range []struct {
+		transport     resolver.DNSTransport
+		lowAllocation bool
+	}{
+		{resolver.DNSTransportUDP, false},
+		{resolver.DNSTransportTCP, false},
+		{resolver.DNSTransportUDP, true},
+		{resolver.DNSTransportTCP, true},
+	} {
+		transport := tc.transport
+
+		name := string(transport)
+		if tc.lowAllocation {
+			name += " (Low Allocation)"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			t.Run("Within Limits", func(t *testing.T) {
+				res, err := resolver.DNS(resolver.DNSResolverConfig{
+					Server:        server,
+					Transport:     ptr.To(transport),
+					LowAllocation: ptr.To(tc.lowAllocation),
+				})
+				require.NoError(t, err)
+
+				addrs, err := res.LookupNetIP(context.Background(), "ip4", "many.example.com")
+				require.NoError(t, err)
+
+				require.Len(t, addrs, 20)
+			})
+
+			t.Run("Large RRset", func(t *testing.T) {
+				if transport != resolver.DNSTransportTCP {
+					t.Skip("large responses are truncated over UDP")
+				}
+
+				res, err := resolver.DNS(resolver.DNSResolverConfig{
+					Server:        server,
+					Transport:     ptr.To(transport),
+					LowAllocation: ptr.To(tc.lowAllocation),
+				})
+				require.NoError(t, err)
+
+				// The number of answers is unlimited by default.
+				addrs, err := res.LookupNetIP(context.Background(), "ip4", "pool.example.com")
+				require.NoError(t, err)
+
+				require.Len(t, addrs, 200)
+			})
+
+			t.Run("Too Many Answers", func(t *testing.T) {
+				res, err := resolver.DNS(resolver.DNSResolverConfig{
+					Server:        server,
+					Transport:     ptr.To(transport),
+					MaxAnswers:    ptr.To(10),
+					LowAllocation: ptr.To(tc.lowAllocation),
+				})
+				require.NoError(t, err)
+
+				_, err = res.LookupNetIP(context.Background(), "ip4", "many.example.com")
+				require.ErrorContains(t, err, "too many answers")
+			})
+
+			t.Run("CNAME Chain Too Long", func(t *testing.T) {
+				res, err := resolver.DNS(resolver.DNSResolverConfig{
+					Server:        server,
+					Transport:     ptr.To(transport),
+					MaxCNAMEChain: ptr.To(3),
+					LowAllocation: ptr.To(tc.lowAllocation),
+				})
+				require.NoError(t, err)
+
+				_, err = res.LookupNetIP(context.Background(), "ip4", "chain.example.com")
+				require.ErrorContains(t, err, "cname chain too long")
+			})
+
+			t.Run("Response Too Large", func(t *testing.T) {
+				res, err := resolver.DNS(resolver.DNSResolverConfig{
+					Server:          server,
+					Transport:       ptr.To(transport),
+					MaxResponseSize: ptr.To(128),
+					LowAllocation:   ptr.To(tc.lowAllocation),
+				})
+				require.NoError(t, err)
+
+				_, err = res.LookupNetIP(context.Background(), "ip4", "many.example.com")
+				require.ErrorContains(t, err, "response too large")
+			})
+		})
+	}
+}
+
+func TestDNSResolverMalformedAnswer(t *testing.T) {
+	server := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(req)
+
+			q := req.Question[0]
+			hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 60}
+
+			switch q.Name {
+			case "empty.example.com.":
+				// No RDATA.
+				if q.Qtype == dns.TypeA {
+					reply.Answer = append(reply.Answer, &dns.A{Hdr: hdr})
+				} else {
+					reply.Answer = append(reply.Answer, &dns.AAAA{Hdr: hdr})
+				}
+			case "short.example.com.":
+				reply.Answer = append(reply.Answer, &dns.A{Hdr: hdr, A: net.IPv4(10, 0, 0, 1)})
+
+				buf, err := reply.Pack()
+				require.NoError(t, err)
+
+				// Truncate the RDATA of the (last) A record to 2 bytes.
+				binary.BigEndian.PutUint16(buf[len(buf)-6:], 2)
+				_, _ = w.Write(buf[:len(buf)-2])
+				return
+			}
+
+			_ = w.WriteMsg(reply)
+		},
+	}).Addr()
+
+	for _, lowAllocation := range []bool{false, true} {
+		name := "Default"
+		if lowAllocation {
+			name = "Low Allocation"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			res, err := resolver.DNS(resolver.DNSResolverConfig{
+				Server:        server,
+				LowAllocation: ptr.To(lowAllocation),
+			})
+			require.NoError(t, err)
+
+			t.Run("Empty RDATA", func(t *testing.T) {
+				for _, network := range []string{"ip4", "ip6"} {
+					_, err := res.LookupNetIP(context.Background(), network, "empty.example.com")
+					require.ErrorContains(t, err, "malformed answer from server "+server.String())
+
+					var dnsErr *net.DNSError
+					require.ErrorAs(t, err, &dnsErr)
+					require.False(t, dnsErr.IsNotFound)
+				}
+			})
+
+			t.Run("Truncated RDATA", func(t *testing.T) {
+				_, err := res.LookupNetIP(context.Background(), "ip4", "short.example.com")
+				require.Error(t, err)
+			})
+		})
+	}
+}
+
+func TestDNSResolverForeignRecords(t *testing.T) {
+	server := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(req)
+
+			q := req.Question[0]
+			rr := func(s string) dns.RR {
+				rr, err := dns.NewRR(s)
+				require.NoError(t, err)
+				return rr
+			}
+
+			switch strings.ToLower(q.Name) {
+			case "www.example.com.":
+				reply.Answer = append(reply.Answer,
+					// Records for names that weren't asked about.
+					rr("bank.example.net. 60 IN A 192.0.2.66"),
+					rr("www.example.com. 60 IN A 10.0.0.1"),
+					rr("bank.example.net. 60 IN TXT \"poisoned\""),
+				)
+			case "alias.example.com.":
+				reply.Answer = append(reply.Answer,
+					rr("target.example.com. 60 IN A 10.0.0.2"),
+					rr("bank.example.net. 60 IN A 192.0.2.66"),
+					rr("alias.example.com. 60 IN CNAME target.example.com."),
+				)
+			case "only-foreign.example.com.":
+				reply.Answer = append(reply.Answer,
+					rr("bank.example.net. 60 IN A 192.0.2.66"),
+					rr("bank.example.net. 60 IN TXT \"poisoned\""),
+				)
+			}
+
+			_ = w.WriteMsg(reply)
+		},
+	}).Addr()
+
+	ctx := context.Background()
+
+	for _, lowAllocation := range []bool{false, true} {
+		name := "Default"
+		if lowAllocation {
+			name = "Low Allocation"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			res, err := resolver.DNS(resolver.DNSResolverConfig{
+				Server:        server,
+				LowAllocation: ptr.To(lowAllocation),
+			})
+			require.NoError(t, err)
+
+			addrs, err := res.LookupNetIP(ctx, "ip4", "www.example.com")
+			require.NoError(t, err)
+			require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+			addrs, err = res.LookupNetIP(ctx, "ip4", "alias.example.com")
+			require.NoError(t, err)
+			require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.2")}, addrs)
+
+			_, err = res.LookupNetIP(ctx, "ip4", "only-foreign.example.com")
+			require.Error(t, err)
+		})
+	}
+
+	t.Run("Lookup", func(t *testing.T) {
+		res, err := resolver.NewDNS(server)
+		require.NoError(t, err)
+
+		answer, err := resolver.Lookup(ctx, res, resolver.Question{Name: "alias.example.com.", Type: dns.TypeA})
+		require.NoError(t, err)
+		require.Len(t, answer.Records, 2)
+		for _, rr := range answer.Records {
+			require.NotEqual(t, "bank.example.net.", rr.Header().Name)
+		}
+
+		_, err = resolver.Lookup(ctx, res, resolver.Question{Name: "only-foreign.example.com.", Type: dns.TypeTXT})
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+}
+
+func TestDNSResolverResponseMatching(t *testing.T) {
+	answer := func(req *dns.Msg, name string, a net.IP) *dns.Msg {
+		reply := new(dns.Msg)
+		reply.SetReply(req)
+		reply.Answer = append(reply.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   a,
+		})
+		return reply
+	}
+
+	// Sends a mismatched (spoofed) response before the genuine one.
+	spoofingServer := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+			_ = w.WriteMsg(answer(req, "attacker.example.", net.IPv4(192, 0, 2, 66)))
+			_ = w.WriteMsg(answer(req, req.Question[0].Name, net.IPv4(10, 0, 0, 1)))
+		},
+	}).Addr()
+
+	t.Run("Strict", func(t *testing.T) {
+		res, err := resolver.NewDNS(spoofingServer, resolver.WithStrictResponseMatching())
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+	})
+
+	t.Run("Strict TCP", func(t *testing.T) {
+		res, err := resolver.NewDNS(spoofingServer,
+			resolver.WithTransport(resolver.DNSTransportTCP),
+			resolver.WithStrictResponseMatching())
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.Error(t, err)
+	})
+
+	t.Run("Case Randomization", func(t *testing.T) {
+		var mu sync.Mutex
+		var names []string
+
+		server := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+			Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+				mu.Lock()
+				names = append(names, req.Question[0].Name)
+				mu.Unlock()
+
+				_ = w.WriteMsg(answer(req, req.Question[0].Name, net.IPv4(10, 0, 0, 1)))
+			},
+		}).Addr()
+
+		res, err := resolver.NewDNS(server, resolver.WithCaseRandomization())
+		require.NoError(t, err)
+
+		for i := 0; i < 8; i++ {
+			addrs, err := res.LookupNetIP(context.Background(), "ip4", "abcdefghijklmnopqrstuvwxyz.example.com")
+			require.NoError(t, err)
+
+			require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		var randomized bool
+		for _, name := range names {
+			require.True(t, strings.EqualFold("abcdefghijklmnopqrstuvwxyz.example.com.", name))
+			randomized = randomized || name != "abcdefghijklmnopqrstuvwxyz.example.com."
+		}
+		require.True(t, randomized)
+	})
+
+	t.Run("Case Not Echoed", func(t *testing.T) {
+		server := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+			Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+				req.Question[0].Name = strings.ToLower(req.Question[0].Name)
+				_ = w.WriteMsg(answer(req, req.Question[0].Name, net.IPv4(10, 0, 0, 1)))
+			},
+		}).Addr()
+
+		res, err := resolver.NewDNS(server,
+			resolver.WithCaseRandomization(),
+			resolver.WithTimeout(100*time.Millisecond))
+		require.NoError(t, err)
+
+		// The odds of none of the letters being flipped are negligible.
+		_, err = res.LookupNetIP(context.Background(), "ip4", "abcdefghijklmnopqrstuvwxyz.example.com")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsTimeout)
+	})
+}
+
+func TestDNSResolverTCPFallback(t *testing.T) {
+	// Drops UDP queries (eg. a firewall blocking UDP), but answers over TCP.
+	server := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+			if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+				return
+			}
+
+			reply := new(dns.Msg)
+			reply.SetReply(req)
+			reply.Answer = append(reply.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 0, 1),
+			})
+
+			_ = w.WriteMsg(reply)
+		},
+	}).Addr()
+
+	t.Run("Disabled", func(t *testing.T) {
+		res, err := resolver.NewDNS(server, resolver.WithTimeout(50*time.Millisecond))
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsTimeout)
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		queryLog := resolver.NewQueryLog(8)
+
+		res, err := resolver.NewDNS(server,
+			resolver.WithTimeout(50*time.Millisecond),
+			resolver.WithTCPFallback(),
+			resolver.WithQueryLog(queryLog))
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+		entries := queryLog.Entries()
+		require.Len(t, entries, 2)
+		require.Equal(t, resolver.DNSTransportUDP, entries[0].Transport)
+		require.NotEmpty(t, entries[0].Err)
+		require.Equal(t, resolver.DNSTransportTCP, entries[1].Transport)
+		require.Empty(t, entries[1].Err)
+	})
+}
+
+func TestDNSResolverTrustAD(t *testing.T) {
+	var mu sync.Mutex
+	var queryAD []bool
+
+	// Claims to have validated every response.
+	server := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+			mu.Lock()
+			queryAD = append(queryAD, req.AuthenticatedData)
+			mu.Unlock()
+
+			reply := new(dns.Msg)
+			reply.SetReply(req)
+			reply.AuthenticatedData = true
+			reply.Answer = append(reply.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 0, 1),
+			})
+
+			_ = w.WriteMsg(reply)
+		},
+	}).Addr()
+
+	for _, lowAllocation := range []bool{false, true} {
+		for _, trustAD := range []bool{false, true} {
+			t.Run(fmt.Sprintf("Trust AD %v Low Allocation %v", trustAD, lowAllocation), func(t *testing.T) {
+				mu.Lock()
+				queryAD = nil
+				mu.Unlock()
+
+				res, err := resolver.DNS(resolver.DNSResolverConfig{
+					Server:        server,
+					TrustAD:       ptr.To(trustAD),
+					LowAllocation: ptr.To(lowAllocation),
+				})
+				require.NoError(t, err)
+
+				ctx, md := resolver.WithMetadata(context.Background())
+
+				_, err = res.LookupNetIP(ctx, "ip", "example.com")
+				require.NoError(t, err)
+
+				require.Equal(t, trustAD, md.AuthenticatedData())
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				require.Equal(t, []bool{trustAD, trustAD}, queryAD)
+			})
+		}
+	}
+}
+
+func TestDNSResolverInFlightQueries(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	var qTypes []uint16
+
+	server := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+			mu.Lock()
+			inFlight++
+			maxInFlight = max(maxInFlight, inFlight)
+			qTypes = append(qTypes, req.Question[0].Qtype)
+			mu.Unlock()
+
+			// Give any concurrent queries a chance to arrive.
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+
+			reply := new(dns.Msg)
+			reply.SetReply(req)
+			reply.Rcode = dns.RcodeNameError
+
+			_ = w.WriteMsg(reply)
+		},
+	}).Addr()
+
+	reset := func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		maxInFlight = 0
+		qTypes = nil
+	}
+
+	getMaxInFlight := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return maxInFlight
+	}
+
+	t.Run("Unlimited", func(t *testing.T) {
+		t.Cleanup(reset)
+
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server: server,
+		})
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.Error(t, err)
+
+		require.Equal(t, 2, getMaxInFlight())
+	})
+
+	t.Run("Limited", func(t *testing.T) {
+		t.Cleanup(reset)
+
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:             server,
+			MaxInFlightQueries: ptr.To(1),
+		})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, _ = res.LookupNetIP(context.Background(), "ip", "example.com")
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, 1, getMaxInFlight())
+	})
+
+	t.Run("Shared Limiter", func(t *testing.T) {
+		t.Cleanup(reset)
+
+		limiter := resolver.NewQueryLimiter(1)
+
+		var resolvers []resolver.Resolver
+		for i := 0; i < 2; i++ {
+			res, err := resolver.DNS(resolver.DNSResolverConfig{
+				Server:       server,
+				QueryLimiter: limiter,
+			})
+			require.NoError(t, err)
+
+			resolvers = append(resolvers, res)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(res resolver.Resolver) {
+				defer wg.Done()
+
+				_, _ = res.LookupNetIP(context.Background(), "ip", "example.com")
+			}(resolvers[i%len(resolvers)])
+		}
+		wg.Wait()
+
+		require.Equal(t, 1, getMaxInFlight())
+		require.Zero(t, limiter.InFlight())
+		require.Zero(t, limiter.Queued())
+	})
+
+	t.Run("Shared Worker Pool", func(t *testing.T) {
+		t.Cleanup(reset)
+
+		pool := resolver.NewWorkerPool(1)
+
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:     server,
+			WorkerPool: pool,
+		})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, _ = res.LookupNetIP(context.Background(), "ip", "example.com")
+			}()
+		}
+		wg.Wait()
+
+		// Lookups send their queries themselves once the single worker is
+		// busy (like SingleRequest).
+		require.LessOrEqual(t, getMaxInFlight(), 5)
+		require.Zero(t, pool.Busy())
+	})
+
+	t.Run("AAAA First", func(t *testing.T) {
+		t.Cleanup(reset)
+
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:             server,
+			QueryOrder:         ptr.To(resolver.DNSQueryOrderAAAAFirst),
+			MaxInFlightQueries: ptr.To(1),
+		})
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.Error(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		require.Equal(t, dns.TypeAAAA, qTypes[0])
+	})
+}
+
+func FuzzDNSResolverResponse(f *testing.F) {
+	seed := func(answer ...dns.RR) []byte {
+		req := new(dns.Msg)
+		req.SetQuestion("example.com.", dns.TypeA)
+
+		reply := new(dns.Msg)
+		reply.SetReply(req)
+		reply.Answer = answer
+
+		buf, err := reply.Pack()
+		require.NoError(f, err)
+
+		return buf
+	}
+
+	hdr := dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}
+	f.Add(seed(&dns.A{Hdr: hdr, A: net.ParseIP("10.0.0.1")}))
+	// Empty RDATA.
+	f.Add(seed(&dns.A{Hdr: hdr}))
+	f.Add(seed(&dns.CNAME{
+		Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+		Target: "www.example.com.",
+	}, &dns.A{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("10.0.0.1")}))
+
+	f.Fuzz(func(t *testing.T, response []byte) {
+		// Respond to every query with the fuzzed message (using the ID from the
+		// query, so that it isn't immediately rejected).
+		dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+
+			go func() {
+				defer server.Close()
+
+				var length [2]byte
+				if _, err := io.ReadFull(server, length[:]); err != nil {
+					return
+				}
+
+				req := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(server, req); err != nil {
+					return
+				}
+
+				reply := append([]byte(nil), response...)
+				if len(reply) >= 2 && len(req) >= 2 {
+					copy(reply, req[:2])
+				}
+
+				_, _ = server.Write(binary.BigEndian.AppendUint16(nil, uint16(len(reply))))
+				_, _ = server.Write(reply)
+			}()
+
+			return client, nil
+		}
+
+		for _, lowAllocation := range []bool{false, true} {
+			res, err := resolver.DNS(resolver.DNSResolverConfig{
+				Server:        netip.MustParseAddrPort("127.0.0.1:53"),
+				Transport:     ptr.To(resolver.DNSTransportTCP),
+				Timeout:       ptr.To(time.Second),
+				DialContext:   dialContext,
+				AddressOrder:  ptr.To(resolver.AddressOrderNone),
+				LowAllocation: ptr.To(lowAllocation),
+			})
+			require.NoError(t, err)
+
+			addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+			if err != nil {
+				var dnsErr *net.DNSError
+				require.ErrorAs(t, err, &dnsErr)
+				continue
+			}
+
+			for _, addr := range addrs {
+				require.True(t, addr.Is4())
+			}
+		}
+	})
+}
+
+func BenchmarkDNSResolver(b *testing.B) {
+	server := resolvertest.NewServer(b, &resolvertest.ServerConfig{
+		Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(req)
+
+			switch req.Question[0].Qtype {
+			case dns.TypeA:
+				reply.Answer = append(reply.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.IPv4(10, 0, 0, 1),
+				})
+			case dns.TypeAAAA:
+				reply.Answer = append(reply.Answer, &dns.AAAA{
+					Hdr:  dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+					AAAA: net.ParseIP("fd00::1"),
+				})
+			}
+
+			_ = w.WriteMsg(reply)
+		},
+	}).Addr()
+
+	for _, lowAllocation := range []bool{false, true} {
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:        server,
+			LowAllocation: ptr.To(lowAllocation),
+		})
+		require.NoError(b, err)
+
+		for _, network := range []string{"ip", "ip4"} {
+			name := network
+			if lowAllocation {
+				name += " (Low Allocation)"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				b.ReportAllocs()
+
+				for i := 0; i < b.N; i++ {
+					if _, err := res.LookupNetIP(context.Background(), network, "example.com"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDNSResolverHTTP3(t *testing.T) {
+	expected := []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": expected,
+		},
+		HTTPS:  ptr.To(true),
+		AltSvc: `h3=":443"; ma=3600, h2=":443"`,
+	})
+
+	// Stands in for an HTTP/3 transport, sending requests to the server over
+	// HTTP/2.
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, srv.HTTPSAddr().String())
+		},
+		TLSClientConfig:   srv.ClientTLSConfig(),
+		ForceAttemptHTTP2: true,
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	newResolver := func(t *testing.T, http3 http.RoundTripper) resolver.Resolver {
+		res, err := resolver.NewDNS(srv.HTTPSAddr(),
+			resolver.WithTransport(resolver.DNSTransportHTTPS),
+			resolver.WithURL(srv.URL()),
+			resolver.WithTLSConfig(srv.ClientTLSConfig()),
+			resolver.WithHTTP3RoundTripper(http3))
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("Upgrade", func(t *testing.T) {
+		var http3Requests int
+		res := newResolver(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			http3Requests++
+			return transport.RoundTrip(req)
+		}))
+
+		require.Equal(t, "false", resolver.Describe(res).Attributes["http3"])
+
+		// The first query is sent over HTTP/2, which advertises HTTP/3.
+		addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, expected, addrs)
+		require.Zero(t, http3Requests)
+
+		require.Equal(t, "true", resolver.Describe(res).Attributes["http3"])
+
+		addrs, err = res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, expected, addrs)
+		require.Equal(t, 1, http3Requests)
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		var http3Requests int
+		res := newResolver(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			http3Requests++
+			return nil, errors.New("udp blocked")
+		}))
+
+		for i := 0; i < 3; i++ {
+			addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+			require.NoError(t, err)
+			require.Equal(t, expected, addrs)
+		}
+
+		// HTTP/3 is only tried once, until it's no longer considered broken.
+		require.Equal(t, 1, http3Requests)
+		require.Equal(t, "false", resolver.Describe(res).Attributes["http3"])
+	})
+}
+
+func TestDNSResolverHTTPHeader(t *testing.T) {
+	expected := []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": expected,
+		},
+		HTTPS: ptr.To(true),
+		RequiredHTTPHeader: http.Header{
+			"Authorization": []string{"Bearer secret"},
+			"User-Agent":    []string{"resolver-test/1.0"},
+		},
+	})
+
+	newResolver := func(t *testing.T, header http.Header) resolver.Resolver {
+		res, err := resolver.NewDNS(srv.HTTPSAddr(),
+			resolver.WithTransport(resolver.DNSTransportHTTPS),
+			resolver.WithURL(srv.URL()),
+			resolver.WithTLSConfig(srv.ClientTLSConfig()),
+			resolver.WithHTTPHeader(header))
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("Authorized", func(t *testing.T) {
+		res := newResolver(t, http.Header{
+			"Authorization": []string{"Bearer secret"},
+			"User-Agent":    []string{"resolver-test/1.0"},
+			// Can't be overridden.
+			"Content-Type": []string{"text/plain"},
+		})
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, expected, addrs)
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		res := newResolver(t, nil)
+
+		_, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.Error(t, err)
+	})
+}
+
+func TestDNSResolverPadding(t *testing.T) {
+	expected := []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+
+	var mu sync.Mutex
+	var queries []*dns.Msg
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"a.example.com": expected,
+			"a-much-longer-name.subdomain.example.com": expected,
+		},
+		TLS:   ptr.To(true),
+		HTTPS: ptr.To(true),
+		OnQuery: func(req *dns.Msg) {
+			mu.Lock()
+			defer mu.Unlock()
+			queries = append(queries, req)
+		},
+	})
+
+	testCases := []struct {
+		name          string
+		server        netip.AddrPort
+		opts          []resolver.DNSOption
+		expectPadding bool
+	}{
+		{
+			name:   "TLS",
+			server: srv.TLSAddr(),
+			opts: []resolver.DNSOption{
+				resolver.WithTransport(resolver.DNSTransportTLS),
+				resolver.WithTLSConfig(srv.ClientTLSConfig()),
+			},
+			expectPadding: true,
+		},
+		{
+			name:   "TLS (low allocation)",
+			server: srv.TLSAddr(),
+			opts: []resolver.DNSOption{
+				resolver.WithTransport(resolver.DNSTransportTLS),
+				resolver.WithTLSConfig(srv.ClientTLSConfig()),
+				resolver.WithLowAllocation(),
+			},
+			expectPadding: true,
+		},
+		{
+			name:   "HTTPS",
+			server: srv.HTTPSAddr(),
+			opts: []resolver.DNSOption{
+				resolver.WithTransport(resolver.DNSTransportHTTPS),
+				resolver.WithURL(srv.URL()),
+				resolver.WithTLSConfig(srv.ClientTLSConfig()),
+			},
+			expectPadding: true,
+		},
+		{
+			name:   "TLS (disabled)",
+			server: srv.TLSAddr(),
+			opts: []resolver.DNSOption{
+				resolver.WithTransport(resolver.DNSTransportTLS),
+				resolver.WithTLSConfig(srv.ClientTLSConfig()),
+				resolver.WithoutPadding(),
+			},
+		},
+		{
+			name:   "UDP",
+			server: srv.Addr(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mu.Lock()
+			queries = nil
+			mu.Unlock()
+
+			res, err := resolver.NewDNS(tc.server, tc.opts...)
+			require.NoError(t, err)
+
+			for _, name := range []string{"a.example.com", "a-much-longer-name.subdomain.example.com"} {
+				addrs, err := res.LookupNetIP(context.Background(), "ip4", name)
+				require.NoError(t, err)
+				require.Equal(t, expected, addrs)
+
+				_, err = resolver.Lookup(context.Background(), res, resolver.Question{Name: name, Type: dns.TypeA})
+				require.NoError(t, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			require.Len(t, queries, 4)
+			for _, req := range queries {
+				opt := req.IsEdns0()
+				if !tc.expectPadding {
+					require.Nil(t, opt)
+					continue
+				}
+
+				require.NotNil(t, opt)
+				require.True(t, slices.ContainsFunc(opt.Option, func(o dns.EDNS0) bool {
+					return o.Option() == dns.EDNS0PADDING
+				}))
+				require.Zero(t, req.Len()%128, "query length %d", req.Len())
+			}
+
+			_, padded := resolver.Describe(res).Attributes["padding"]
+			require.Equal(t, tc.expectPadding, padded)
+		})
+	}
+}
+
+func TestDNSResolverWithUpstream(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+	})
+
+	other := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.2")},
+		},
+	})
+
+	res, err := resolver.DNS(resolver.DNSResolverConfig{
+		Server:    srv.Addr(),
+		Transport: ptr.To(resolver.DNSTransportTCP),
+	})
+	require.NoError(t, err)
+
+	addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+	ctx := resolver.WithUpstream(context.Background(), other.Addr())
+
+	addrs, err = res.LookupNetIP(ctx, "ip4", "example.com")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.2")}, addrs)
+
+	answer, err := resolver.Lookup(ctx, res, resolver.Question{Name: "example.com", Type: dns.TypeA})
+	require.NoError(t, err)
+	require.Len(t, answer.Records, 1)
+	require.Equal(t, "10.0.0.2", answer.Records[0].(*dns.A).A.String())
+
+	// Queries sent to the override don't count towards the server's stats.
+	require.Equal(t, int64(1), res.Stats().Queries)
+
+	t.Run("Reused", func(t *testing.T) {
+		tlsSrv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+			Addrs: map[string][]netip.Addr{
+				"example.com": {netip.MustParseAddr("10.0.0.3")},
+			},
+			TLS: ptr.To(true),
+		})
+
+		// The override resolver (and its TLS session cache) is reused, so the
+		// second lookup resumes the session of the first.
+		var resumed []bool
+		res, err := resolver.NewDNS(srv.Addr(),
+			resolver.WithTransport(resolver.DNSTransportTLS),
+			resolver.WithTLSConfig(tlsSrv.ClientTLSConfig()),
+			resolver.WithVerifyConnection(func(cs tls.ConnectionState) error {
+				resumed = append(resumed, cs.DidResume)
+				return nil
+			}),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, res.Close())
+		})
+
+		ctx := resolver.WithUpstream(context.Background(), tlsSrv.TLSAddr())
+
+		for i := 0; i < 2; i++ {
+			addrs, err := res.LookupNetIP(ctx, "ip4", "example.com")
+			require.NoError(t, err)
+			require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.3")}, addrs)
+		}
+
+		require.Equal(t, []bool{false, true}, resumed)
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(resolver.WithUpstream(context.Background(), netip.MustParseAddrPort("127.0.0.1:1")), time.Second)
+		defer cancel()
+
+		_, err := res.LookupNetIP(ctx, "ip4", "example.com")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.Equal(t, "127.0.0.1:1", dnsErr.Server)
 	})
 }
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package dnsadmin programs the DNS configuration of network interfaces (eg.
+// to install the DNS servers and domains of a VPN tunnel) through the
+// operating system's DNS management service, rather than by rewriting
+// resolv.conf (see the resolvconf package for that). Backends are provided for
+// systemd-resolved, NetworkManager, and the macOS SystemConfiguration dynamic
+// store.
+package dnsadmin
+
+import (
+	"context"
+	"net/netip"
+)
+
+// DNSOverTLSMode is the DNS over TLS mode of an interface.
+type DNSOverTLSMode string
+
+const (
+	// DNSOverTLSDefault uses the system wide DNS over TLS mode.
+	DNSOverTLSDefault DNSOverTLSMode = ""
+	// DNSOverTLSNo disables DNS over TLS.
+	DNSOverTLSNo DNSOverTLSMode = "no"
+	// DNSOverTLSOpportunistic uses DNS over TLS if the servers support it,
+	// falling back to unencrypted DNS.
+	DNSOverTLSOpportunistic DNSOverTLSMode = "opportunistic"
+	// DNSOverTLSYes requires DNS over TLS.
+	DNSOverTLSYes DNSOverTLSMode = "yes"
+)
+
+// DNSSECMode is the DNSSEC validation mode of an interface.
+type DNSSECMode string
+
+const (
+	// DNSSECDefault uses the system wide DNSSEC mode.
+	DNSSECDefault DNSSECMode = ""
+	// DNSSECNo disables DNSSEC validation.
+	DNSSECNo DNSSECMode = "no"
+	// DNSSECAllowDowngrade validates if the servers support DNSSEC.
+	DNSSECAllowDowngrade DNSSECMode = "allow-downgrade"
+	// DNSSECYes requires DNSSEC validation.
+	DNSSECYes DNSSECMode = "yes"
+)
+
+// LinkConfig is the DNS configuration of a network interface.
+type LinkConfig struct {
+	// Servers are the DNS servers of the interface, a zero port means the
+	// standard port (53, or 853 for DNS over TLS).
+	Servers []netip.AddrPort
+	// TLSServerName is the optional name used to authenticate the servers
+	// when using DNS over TLS.
+	TLSServerName string
+	// Domains are the search domains of the interface. Domains prefixed with
+	// "~" are routing only domains, lookups of names within them are sent to
+	// the interface's servers but they are not searched (eg. "~." routes all
+	// lookups to the interface).
+	Domains []string
+	// DefaultRoute, if not nil, sets whether lookups of names outside of the
+	// interface's domains are sent to the interface's servers.
+	DefaultRoute *bool
+	// DNSOverTLS is the DNS over TLS mode of the interface.
+	DNSOverTLS DNSOverTLSMode
+	// DNSSEC is the DNSSEC validation mode of the interface.
+	DNSSEC DNSSECMode
+}
+
+// Backend is a DNS management service.
+type Backend interface {
+	// Available reports whether the service is running.
+	Available(ctx context.Context) bool
+	// Link returns the DNS configuration of the interface.
+	Link(ctx context.Context, iface string) (*LinkConfig, error)
+	// SetLink replaces the DNS configuration of the interface.
+	SetLink(ctx context.Context, iface string, conf *LinkConfig) error
+	// RevertLink removes the DNS configuration of the interface set by
+	// SetLink.
+	RevertLink(ctx context.Context, iface string) error
+}
@@ -0,0 +1,396 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dnsadmin
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/dbus"
+	"github.com/noisysockets/resolver/internal/defaults"
+)
+
+var _ Backend = (*NetworkManager)(nil)
+
+// NetworkManager D-Bus API constants (see the NetworkManager D-Bus API
+// reference).
+const (
+	nmService         = "org.freedesktop.NetworkManager"
+	nmPath            = "/org/freedesktop/NetworkManager"
+	nmInterface       = "org.freedesktop.NetworkManager"
+	nmDeviceInterface = "org.freedesktop.NetworkManager.Device"
+
+	// Values of the connection.dns-over-tls setting.
+	nmDNSOverTLSDefault       int32 = -1
+	nmDNSOverTLSNo            int32 = 0
+	nmDNSOverTLSOpportunistic int32 = 1
+	nmDNSOverTLSYes           int32 = 2
+)
+
+// NetworkManagerConfig is the configuration of a NetworkManager backend.
+type NetworkManagerConfig struct {
+	// SocketPath is the path of the D-Bus system bus socket.
+	// By default, "/var/run/dbus/system_bus_socket".
+	SocketPath string
+}
+
+// NetworkManager programs the DNS configuration of the connection applied to
+// an interface managed by NetworkManager (which owns resolv.conf on most
+// desktop Linux distributions). Changes are made to the applied connection
+// only (like `nmcli device modify`), the connection's profile is unchanged.
+//
+// NetworkManager has no per connection DNSSEC mode, nor does it support
+// server ports or names (using the legacy dns setting), so LinkConfigs using
+// them are rejected.
+type NetworkManager struct {
+	socketPath string
+}
+
+// NewNetworkManager returns a NetworkManager backend.
+func NewNetworkManager(conf *NetworkManagerConfig) (*NetworkManager, error) {
+	conf, err := defaults.WithDefaults(conf, &NetworkManagerConfig{
+		SocketPath: dbus.SystemBusSocket,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to networkmanager config: %w", err)
+	}
+
+	return &NetworkManager{
+		socketPath: conf.SocketPath,
+	}, nil
+}
+
+// Available reports whether NetworkManager is reachable.
+func (nm *NetworkManager) Available(ctx context.Context) bool {
+	conn, err := dbus.Dial(ctx, nm.socketPath)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	_, err = conn.Call(ctx, nmService, nmPath, "org.freedesktop.DBus.Peer", "Ping")
+	return err == nil
+}
+
+// Link returns the DNS configuration of the connection applied to the
+// interface.
+func (nm *NetworkManager) Link(ctx context.Context, iface string) (*LinkConfig, error) {
+	conn, err := dbus.Dial(ctx, nm.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+	defer conn.Close()
+
+	settings, _, err := nm.appliedConnection(ctx, conn, iface)
+	if err != nil {
+		return nil, err
+	}
+
+	return nmLinkConfig(settings), nil
+}
+
+// SetLink replaces the DNS servers, domains, and DNS over TLS mode of the
+// connection applied to the interface.
+func (nm *NetworkManager) SetLink(ctx context.Context, iface string, conf *LinkConfig) error {
+	if conf == nil {
+		conf = &LinkConfig{}
+	}
+
+	conn, err := dbus.Dial(ctx, nm.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+	defer conn.Close()
+
+	settings, versionID, err := nm.appliedConnection(ctx, conn, iface)
+	if err != nil {
+		return err
+	}
+
+	if err := nmApplyLinkConfig(settings, conf); err != nil {
+		return err
+	}
+
+	return nm.reapply(ctx, conn, iface, settings, versionID)
+}
+
+// RevertLink reapplies the connection profile of the interface, discarding
+// the changes made by SetLink.
+func (nm *NetworkManager) RevertLink(ctx context.Context, iface string) error {
+	conn, err := dbus.Dial(ctx, nm.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+	defer conn.Close()
+
+	// Reapplying without settings uses those of the connection profile.
+	return nm.reapply(ctx, conn, iface, nmSettings{}, 0)
+}
+
+// device returns the object path of the device of the interface.
+func (nm *NetworkManager) device(ctx context.Context, conn *dbus.Conn, iface string) (dbus.ObjectPath, error) {
+	body, err := conn.Call(ctx, nmService, nmPath, nmInterface, "GetDeviceByIpIface", iface)
+	if err != nil {
+		return "", fmt.Errorf("failed to find device of interface %q: %w", iface, err)
+	}
+
+	if len(body) != 1 {
+		return "", fmt.Errorf("unexpected reply to GetDeviceByIpIface")
+	}
+
+	path, ok := body[0].(dbus.ObjectPath)
+	if !ok {
+		return "", fmt.Errorf("unexpected reply to GetDeviceByIpIface")
+	}
+
+	return path, nil
+}
+
+// appliedConnection returns the settings (and their version) of the connection
+// applied to the interface.
+func (nm *NetworkManager) appliedConnection(ctx context.Context, conn *dbus.Conn, iface string) (nmSettings, uint64, error) {
+	device, err := nm.device(ctx, conn, iface)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	body, err := conn.Call(ctx, nmService, device, nmDeviceInterface, "GetAppliedConnection", uint32(0))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get applied connection of interface %q: %w", iface, err)
+	}
+
+	if len(body) != 2 {
+		return nil, 0, fmt.Errorf("unexpected reply to GetAppliedConnection")
+	}
+
+	arr, ok := body[0].(dbus.Array)
+	if !ok {
+		return nil, 0, fmt.Errorf("unexpected reply to GetAppliedConnection")
+	}
+	versionID, _ := body[1].(uint64)
+
+	settings, err := decodeNMSettings(arr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return settings, versionID, nil
+}
+
+// reapply applies the settings to the interface's device.
+func (nm *NetworkManager) reapply(ctx context.Context, conn *dbus.Conn, iface string, settings nmSettings, versionID uint64) error {
+	device, err := nm.device(ctx, conn, iface)
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Call(ctx, nmService, device, nmDeviceInterface, "Reapply",
+		settings.encode(), versionID, uint32(0)); err != nil {
+		return fmt.Errorf("failed to reapply connection of interface %q: %w", iface, err)
+	}
+
+	return nil
+}
+
+// nmSettings are the settings of a connection (a{sa{sv}}), keyed by setting
+// name (eg. "ipv4") and then property name (eg. "dns").
+type nmSettings map[string]map[string]dbus.Variant
+
+func decodeNMSettings(arr dbus.Array) (nmSettings, error) {
+	if arr.Elem != "{sa{sv}}" {
+		return nil, fmt.Errorf("unexpected connection settings of type %q", arr.Elem)
+	}
+
+	// The element signature guarantees the types of the entries.
+	settings := nmSettings{}
+	for _, elem := range arr.Elems {
+		entry := elem.(dbus.DictEntry)
+		props := map[string]dbus.Variant{}
+		for _, prop := range entry.Value.(dbus.Array).Elems {
+			propEntry := prop.(dbus.DictEntry)
+			props[propEntry.Key.(string)] = propEntry.Value.(dbus.Variant)
+		}
+		settings[entry.Key.(string)] = props
+	}
+
+	return settings, nil
+}
+
+func (s nmSettings) encode() dbus.Array {
+	arr := dbus.Array{Elem: "{sa{sv}}"}
+	for _, name := range sortedKeys(s) {
+		props := dbus.Array{Elem: "{sv}"}
+		for _, key := range sortedKeys(s[name]) {
+			props.Elems = append(props.Elems, dbus.DictEntry{Key: key, Value: s[name][key]})
+		}
+		arr.Elems = append(arr.Elems, dbus.DictEntry{Key: name, Value: props})
+	}
+
+	return arr
+}
+
+// set sets a property, creating the setting if needed.
+func (s nmSettings) set(name, key string, value any) {
+	if s[name] == nil {
+		s[name] = map[string]dbus.Variant{}
+	}
+	s[name][key] = dbus.Variant{Value: value}
+}
+
+// nmApplyLinkConfig replaces the DNS properties of the settings.
+func nmApplyLinkConfig(settings nmSettings, conf *LinkConfig) error {
+	if conf.DNSSEC != DNSSECDefault {
+		return fmt.Errorf("per connection DNSSEC mode: %w", errors.ErrUnsupported)
+	}
+
+	if conf.TLSServerName != "" {
+		return fmt.Errorf("DNS server names: %w", errors.ErrUnsupported)
+	}
+
+	ipv4Servers := dbus.Array{Elem: "u"}
+	ipv6Servers := dbus.Array{Elem: "ay"}
+	for _, server := range conf.Servers {
+		if server.Port() != 0 && server.Port() != 53 {
+			return fmt.Errorf("DNS server ports: %w", errors.ErrUnsupported)
+		}
+
+		addr := server.Addr().Unmap()
+		switch {
+		case addr.Is4():
+			// Addresses are in network byte order, as stored in a (native
+			// endian) uint32.
+			a := addr.As4()
+			ipv4Servers.Elems = append(ipv4Servers.Elems, binary.NativeEndian.Uint32(a[:]))
+		case addr.Is6():
+			ipv6Servers.Elems = append(ipv6Servers.Elems, addr.AsSlice())
+		default:
+			return fmt.Errorf("invalid server address %q", server)
+		}
+	}
+
+	search := dbus.Array{Elem: "s"}
+	for _, domain := range conf.Domains {
+		name, routingOnly := strings.CutPrefix(domain, "~")
+		if _, ok := dns.IsDomainName(name); !ok {
+			return fmt.Errorf("invalid domain %q", domain)
+		}
+
+		if name != "." {
+			name = strings.TrimSuffix(name, ".")
+		}
+
+		// NetworkManager uses the same "~" prefix for routing only domains.
+		if routingOnly {
+			name = "~" + name
+		}
+
+		search.Elems = append(search.Elems, name)
+	}
+
+	if conf.DefaultRoute != nil && *conf.DefaultRoute && !slices.Contains(search.Elems, any("~.")) {
+		search.Elems = append(search.Elems, "~.")
+	}
+
+	for _, name := range []string{"ipv4", "ipv6"} {
+		servers := ipv4Servers
+		if name == "ipv6" {
+			servers = ipv6Servers
+		}
+
+		settings.set(name, "dns", servers)
+		settings.set(name, "dns-search", search)
+		// Replace, rather than add to, the servers from DHCP and router
+		// advertisements.
+		settings.set(name, "ignore-auto-dns", len(conf.Servers) > 0)
+	}
+
+	dnsOverTLS := nmDNSOverTLSDefault
+	switch conf.DNSOverTLS {
+	case DNSOverTLSNo:
+		dnsOverTLS = nmDNSOverTLSNo
+	case DNSOverTLSOpportunistic:
+		dnsOverTLS = nmDNSOverTLSOpportunistic
+	case DNSOverTLSYes:
+		dnsOverTLS = nmDNSOverTLSYes
+	}
+	settings.set("connection", "dns-over-tls", dnsOverTLS)
+
+	return nil
+}
+
+// nmLinkConfig returns the DNS configuration of the settings.
+func nmLinkConfig(settings nmSettings) *LinkConfig {
+	conf := &LinkConfig{}
+
+	if servers, ok := settings["ipv4"]["dns"].Value.(dbus.Array); ok {
+		for _, elem := range servers.Elems {
+			if n, ok := elem.(uint32); ok {
+				var a [4]byte
+				binary.NativeEndian.PutUint32(a[:], n)
+				conf.Servers = append(conf.Servers, netip.AddrPortFrom(netip.AddrFrom4(a), 53))
+			}
+		}
+	}
+
+	if servers, ok := settings["ipv6"]["dns"].Value.(dbus.Array); ok {
+		for _, elem := range servers.Elems {
+			if b, ok := elem.([]byte); ok {
+				if addr, ok := netip.AddrFromSlice(b); ok {
+					conf.Servers = append(conf.Servers, netip.AddrPortFrom(addr, 53))
+				}
+			}
+		}
+	}
+
+	for _, name := range []string{"ipv4", "ipv6"} {
+		search, ok := settings[name]["dns-search"].Value.(dbus.Array)
+		if !ok {
+			continue
+		}
+
+		for _, elem := range search.Elems {
+			if domain, ok := elem.(string); ok && !slices.Contains(conf.Domains, domain) {
+				conf.Domains = append(conf.Domains, domain)
+			}
+		}
+	}
+
+	if slices.Contains(conf.Domains, "~.") {
+		defaultRoute := true
+		conf.DefaultRoute = &defaultRoute
+	}
+
+	switch settings["connection"]["dns-over-tls"].Value {
+	case nmDNSOverTLSNo:
+		conf.DNSOverTLS = DNSOverTLSNo
+	case nmDNSOverTLSOpportunistic:
+		conf.DNSOverTLS = DNSOverTLSOpportunistic
+	case nmDNSOverTLSYes:
+		conf.DNSOverTLS = DNSOverTLSYes
+	}
+
+	return conf
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
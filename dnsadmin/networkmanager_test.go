@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dnsadmin_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/noisysockets/resolver/dnsadmin"
+	"github.com/noisysockets/resolver/internal/dbus"
+	"github.com/noisysockets/resolver/internal/dbus/dbustest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkManager(t *testing.T) {
+	const devicePath = dbus.ObjectPath("/org/freedesktop/NetworkManager/Devices/3")
+
+	var mu sync.Mutex
+	// The applied connection of the device, with a DHCP configured profile.
+	applied := dbus.Array{Elem: "{sa{sv}}", Elems: []any{
+		dbus.DictEntry{Key: "connection", Value: dbus.Array{Elem: "{sv}", Elems: []any{
+			dbus.DictEntry{Key: "id", Value: dbus.Variant{Value: "Wired connection 1"}},
+		}}},
+		dbus.DictEntry{Key: "ipv4", Value: dbus.Array{Elem: "{sv}", Elems: []any{
+			dbus.DictEntry{Key: "method", Value: dbus.Variant{Value: "auto"}},
+		}}},
+	}}
+	var reapplied []any
+
+	srv := dbustest.NewServer(t, func(call *dbus.Message) ([]any, *dbus.Error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch call.Member {
+		case "Ping":
+			return nil, nil
+		case "GetDeviceByIpIface":
+			if call.Body[0] != "eth0" {
+				return nil, &dbus.Error{Name: "org.freedesktop.NetworkManager.UnknownDevice", Message: "No device found for the requested iface."}
+			}
+			return []any{devicePath}, nil
+		case "GetAppliedConnection":
+			if call.Path != devicePath {
+				return nil, &dbus.Error{Name: "org.freedesktop.DBus.Error.UnknownObject"}
+			}
+			return []any{applied, uint64(7)}, nil
+		case "Reapply":
+			reapplied = call.Body
+			if settings := call.Body[0].(dbus.Array); len(settings.Elems) > 0 {
+				applied = settings
+			}
+			return nil, nil
+		default:
+			return nil, &dbus.Error{Name: "org.freedesktop.DBus.Error.UnknownMethod"}
+		}
+	})
+
+	nm, err := dnsadmin.NewNetworkManager(&dnsadmin.NetworkManagerConfig{
+		SocketPath: srv.Path(),
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	require.True(t, nm.Available(ctx))
+
+	conf := &dnsadmin.LinkConfig{
+		Servers:      []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:53"), netip.MustParseAddrPort("[fd00::1]:0")},
+		Domains:      []string{"corp.example.com"},
+		DefaultRoute: ptr.To(true),
+		DNSOverTLS:   dnsadmin.DNSOverTLSOpportunistic,
+	}
+
+	require.NoError(t, nm.SetLink(ctx, "eth0", conf))
+
+	mu.Lock()
+	require.Equal(t, uint64(7), reapplied[1])
+	mu.Unlock()
+
+	got, err := nm.Link(ctx, "eth0")
+	require.NoError(t, err)
+
+	require.Equal(t, &dnsadmin.LinkConfig{
+		Servers: []netip.AddrPort{
+			netip.MustParseAddrPort("10.0.0.1:53"),
+			netip.MustParseAddrPort("[fd00::1]:53"),
+		},
+		Domains:      []string{"corp.example.com", "~."},
+		DefaultRoute: ptr.To(true),
+		DNSOverTLS:   dnsadmin.DNSOverTLSOpportunistic,
+	}, got)
+
+	// The other settings of the connection are preserved.
+	mu.Lock()
+	require.Contains(t, applied.Elems, dbus.DictEntry{Key: "connection", Value: dbus.Array{Elem: "{sv}", Elems: []any{
+		dbus.DictEntry{Key: "dns-over-tls", Value: dbus.Variant{Value: int32(1)}},
+		dbus.DictEntry{Key: "id", Value: dbus.Variant{Value: "Wired connection 1"}},
+	}}})
+	mu.Unlock()
+
+	// Reverting reapplies the connection profile.
+	require.NoError(t, nm.RevertLink(ctx, "eth0"))
+
+	mu.Lock()
+	require.Equal(t, []any{dbus.Array{Elem: "{sa{sv}}"}, uint64(0), uint32(0)}, reapplied)
+	mu.Unlock()
+
+	err = nm.SetLink(ctx, "eth0", &dnsadmin.LinkConfig{DNSSEC: dnsadmin.DNSSECYes})
+	require.True(t, errors.Is(err, errors.ErrUnsupported))
+
+	var dbusErr *dbus.Error
+	require.ErrorAs(t, nm.SetLink(ctx, "wlan9", conf), &dbusErr)
+}
@@ -0,0 +1,325 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dnsadmin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/dbus"
+	"github.com/noisysockets/resolver/internal/defaults"
+)
+
+var _ Backend = (*Resolved)(nil)
+
+// systemd-resolved D-Bus API constants (see org.freedesktop.resolve1(5)).
+const (
+	resolvedService       = "org.freedesktop.resolve1"
+	resolvedPath          = "/org/freedesktop/resolve1"
+	resolvedInterface     = "org.freedesktop.resolve1.Manager"
+	resolvedLinkInterface = "org.freedesktop.resolve1.Link"
+
+	afInet  int32 = 2
+	afInet6 int32 = 10
+)
+
+// ResolvedConfig is the configuration of a systemd-resolved backend.
+type ResolvedConfig struct {
+	// SocketPath is the path of the D-Bus system bus socket.
+	// By default, "/var/run/dbus/system_bus_socket".
+	SocketPath string
+}
+
+// Resolved programs the per interface DNS configuration of systemd-resolved,
+// using its D-Bus API. This is the preferred way to install the DNS servers of
+// a VPN on modern Linux distributions. Programming resolved requires
+// CAP_NET_ADMIN (or a polkit authorization).
+type Resolved struct {
+	socketPath string
+}
+
+// NewResolved returns a systemd-resolved backend.
+func NewResolved(conf *ResolvedConfig) (*Resolved, error) {
+	conf, err := defaults.WithDefaults(conf, &ResolvedConfig{
+		SocketPath: dbus.SystemBusSocket,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to resolved config: %w", err)
+	}
+
+	return &Resolved{
+		socketPath: conf.SocketPath,
+	}, nil
+}
+
+// Available reports whether systemd-resolved is reachable.
+func (r *Resolved) Available(ctx context.Context) bool {
+	conn, err := dbus.Dial(ctx, r.socketPath)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	_, err = conn.Call(ctx, resolvedService, resolvedPath, "org.freedesktop.DBus.Peer", "Ping")
+	return err == nil
+}
+
+// Link returns the DNS configuration of the interface.
+func (r *Resolved) Link(ctx context.Context, iface string) (*LinkConfig, error) {
+	ifIndex, err := interfaceIndex(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dbus.Dial(ctx, r.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+	defer conn.Close()
+
+	body, err := conn.Call(ctx, resolvedService, resolvedPath, resolvedInterface, "GetLink", ifIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call GetLink: %w", err)
+	}
+
+	var link dbus.ObjectPath
+	if len(body) == 1 {
+		link, _ = body[0].(dbus.ObjectPath)
+	}
+	if link == "" {
+		return nil, fmt.Errorf("unexpected reply to GetLink")
+	}
+
+	body, err = conn.Call(ctx, resolvedService, link, "org.freedesktop.DBus.Properties", "GetAll", resolvedLinkInterface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get link properties: %w", err)
+	}
+
+	var props dbus.Array
+	if len(body) == 1 {
+		props, _ = body[0].(dbus.Array)
+	}
+	if props.Elem != "{sv}" {
+		return nil, fmt.Errorf("unexpected reply to GetAll")
+	}
+
+	return resolvedLinkConfig(props), nil
+}
+
+// SetLink replaces the DNS servers, domains, and DNS over TLS and DNSSEC
+// modes of the interface.
+func (r *Resolved) SetLink(ctx context.Context, iface string, conf *LinkConfig) error {
+	ifIndex, err := interfaceIndex(iface)
+	if err != nil {
+		return err
+	}
+
+	calls, err := resolvedLinkCalls(ifIndex, conf)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dbus.Dial(ctx, r.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+	defer conn.Close()
+
+	for _, call := range calls {
+		if _, err := conn.Call(ctx, resolvedService, resolvedPath, resolvedInterface, call.method, call.args...); err != nil {
+			return fmt.Errorf("failed to call %s: %w", call.method, err)
+		}
+	}
+
+	return nil
+}
+
+// RevertLink reverts the DNS configuration of the interface to its defaults.
+func (r *Resolved) RevertLink(ctx context.Context, iface string) error {
+	ifIndex, err := interfaceIndex(iface)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dbus.Dial(ctx, r.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Call(ctx, resolvedService, resolvedPath, resolvedInterface, "RevertLink", ifIndex); err != nil {
+		return fmt.Errorf("failed to call RevertLink: %w", err)
+	}
+
+	return nil
+}
+
+type resolvedCall struct {
+	method string
+	args   []any
+}
+
+// resolvedLinkCalls returns the method calls that program the configuration
+// of the interface.
+func resolvedLinkCalls(ifIndex int32, conf *LinkConfig) ([]resolvedCall, error) {
+	if conf == nil {
+		conf = &LinkConfig{}
+	}
+
+	// SetLinkDNSEx (systemd 246) supports ports and server names, the older
+	// SetLinkDNS is used when they aren't needed.
+	extended := conf.TLSServerName != ""
+	for _, server := range conf.Servers {
+		if server.Port() != 0 && server.Port() != 53 {
+			extended = true
+		}
+	}
+
+	servers := dbus.Array{Elem: "(iay)"}
+	if extended {
+		servers.Elem = "(iayqs)"
+	}
+
+	for _, server := range conf.Servers {
+		addr := server.Addr().Unmap()
+		if !addr.IsValid() {
+			return nil, fmt.Errorf("invalid server address %q", server)
+		}
+
+		family := afInet
+		if addr.Is6() {
+			family = afInet6
+		}
+
+		if extended {
+			servers.Elems = append(servers.Elems, dbus.Struct{family, addr.AsSlice(), server.Port(), conf.TLSServerName})
+		} else {
+			servers.Elems = append(servers.Elems, dbus.Struct{family, addr.AsSlice()})
+		}
+	}
+
+	domains := dbus.Array{Elem: "(sb)"}
+	for _, domain := range conf.Domains {
+		name, routingOnly := strings.CutPrefix(domain, "~")
+		if _, ok := dns.IsDomainName(name); !ok {
+			return nil, fmt.Errorf("invalid domain %q", domain)
+		}
+
+		if name != "." {
+			name = strings.TrimSuffix(name, ".")
+		}
+
+		domains.Elems = append(domains.Elems, dbus.Struct{name, routingOnly})
+	}
+
+	method := "SetLinkDNS"
+	if extended {
+		method = "SetLinkDNSEx"
+	}
+
+	calls := []resolvedCall{
+		{method: method, args: []any{ifIndex, servers}},
+		{method: "SetLinkDomains", args: []any{ifIndex, domains}},
+	}
+
+	if conf.DefaultRoute != nil {
+		calls = append(calls, resolvedCall{method: "SetLinkDefaultRoute", args: []any{ifIndex, *conf.DefaultRoute}})
+	}
+
+	// An empty mode resets the interface to the system wide mode.
+	calls = append(calls,
+		resolvedCall{method: "SetLinkDNSOverTLS", args: []any{ifIndex, string(conf.DNSOverTLS)}},
+		resolvedCall{method: "SetLinkDNSSEC", args: []any{ifIndex, string(conf.DNSSEC)}},
+	)
+
+	return calls, nil
+}
+
+// resolvedLinkConfig returns the DNS configuration from the properties of a
+// link.
+func resolvedLinkConfig(props dbus.Array) *LinkConfig {
+	values := map[string]any{}
+	for _, elem := range props.Elems {
+		entry := elem.(dbus.DictEntry)
+		values[entry.Key.(string)] = entry.Value.(dbus.Variant).Value
+	}
+
+	conf := &LinkConfig{}
+
+	// DNSEx (systemd 246) includes the ports and server names.
+	servers, ok := values["DNSEx"].(dbus.Array)
+	if !ok {
+		servers, _ = values["DNS"].(dbus.Array)
+	}
+
+	for _, elem := range servers.Elems {
+		fields, ok := elem.(dbus.Struct)
+		if !ok || len(fields) < 2 {
+			continue
+		}
+
+		b, _ := fields[1].([]byte)
+		addr, ok := netip.AddrFromSlice(b)
+		if !ok {
+			continue
+		}
+
+		var port uint16
+		if len(fields) >= 4 {
+			port, _ = fields[2].(uint16)
+			if name, _ := fields[3].(string); name != "" {
+				conf.TLSServerName = name
+			}
+		}
+
+		conf.Servers = append(conf.Servers, netip.AddrPortFrom(addr, port))
+	}
+
+	if domains, ok := values["Domains"].(dbus.Array); ok {
+		for _, elem := range domains.Elems {
+			fields, ok := elem.(dbus.Struct)
+			if !ok || len(fields) != 2 {
+				continue
+			}
+
+			name, _ := fields[0].(string)
+			if routingOnly, _ := fields[1].(bool); routingOnly {
+				name = "~" + name
+			}
+
+			conf.Domains = append(conf.Domains, name)
+		}
+	}
+
+	if defaultRoute, ok := values["DefaultRoute"].(bool); ok {
+		conf.DefaultRoute = &defaultRoute
+	}
+
+	dnsOverTLS, _ := values["DNSOverTLS"].(string)
+	conf.DNSOverTLS = DNSOverTLSMode(dnsOverTLS)
+
+	dnssec, _ := values["DNSSEC"].(string)
+	conf.DNSSEC = DNSSECMode(dnssec)
+
+	return conf
+}
+
+func interfaceIndex(iface string) (int32, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find interface %q: %w", iface, err)
+	}
+
+	return int32(ifi.Index), nil
+}
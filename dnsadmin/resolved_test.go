@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dnsadmin_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/noisysockets/resolver/dnsadmin"
+	"github.com/noisysockets/resolver/internal/dbus"
+	"github.com/noisysockets/resolver/internal/dbus/dbustest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolved(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	if len(ifaces) == 0 {
+		t.Skip("no network interfaces")
+	}
+	iface := ifaces[0]
+
+	var mu sync.Mutex
+	var calls []*dbus.Message
+	srv := dbustest.NewServer(t, func(call *dbus.Message) ([]any, *dbus.Error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if call.Member != "Ping" {
+			calls = append(calls, call)
+		}
+		return nil, nil
+	})
+
+	res, err := dnsadmin.NewResolved(&dnsadmin.ResolvedConfig{
+		SocketPath: srv.Path(),
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	require.True(t, res.Available(ctx))
+
+	err = res.SetLink(ctx, iface.Name, &dnsadmin.LinkConfig{
+		Servers:      []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:53"), netip.MustParseAddrPort("[fd00::1]:0")},
+		Domains:      []string{"corp.example.com.", "~."},
+		DefaultRoute: ptr.To(true),
+		DNSSEC:       dnsadmin.DNSSECAllowDowngrade,
+	})
+	require.NoError(t, err)
+
+	ifIndex := int32(iface.Index)
+
+	mu.Lock()
+	require.Len(t, calls, 5)
+
+	require.Equal(t, "SetLinkDNS", calls[0].Member)
+	require.Equal(t, []any{ifIndex, dbus.Array{Elem: "(iay)", Elems: []any{
+		dbus.Struct{int32(2), []byte{10, 0, 0, 1}},
+		dbus.Struct{int32(10), netip.MustParseAddr("fd00::1").AsSlice()},
+	}}}, calls[0].Body)
+
+	require.Equal(t, "SetLinkDomains", calls[1].Member)
+	require.Equal(t, []any{ifIndex, dbus.Array{Elem: "(sb)", Elems: []any{
+		dbus.Struct{"corp.example.com", false},
+		dbus.Struct{".", true},
+	}}}, calls[1].Body)
+
+	require.Equal(t, "SetLinkDefaultRoute", calls[2].Member)
+	require.Equal(t, []any{ifIndex, true}, calls[2].Body)
+
+	require.Equal(t, "SetLinkDNSOverTLS", calls[3].Member)
+	require.Equal(t, []any{ifIndex, ""}, calls[3].Body)
+
+	require.Equal(t, "SetLinkDNSSEC", calls[4].Member)
+	require.Equal(t, []any{ifIndex, "allow-downgrade"}, calls[4].Body)
+
+	calls = nil
+	mu.Unlock()
+
+	// DNS over TLS with a server name requires SetLinkDNSEx.
+	err = res.SetLink(ctx, iface.Name, &dnsadmin.LinkConfig{
+		Servers:       []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:853")},
+		TLSServerName: "dns.example.com",
+		DNSOverTLS:    dnsadmin.DNSOverTLSYes,
+	})
+	require.NoError(t, err)
+
+	mu.Lock()
+	require.Equal(t, "SetLinkDNSEx", calls[0].Member)
+	require.Equal(t, []any{ifIndex, dbus.Array{Elem: "(iayqs)", Elems: []any{
+		dbus.Struct{int32(2), []byte{10, 0, 0, 1}, uint16(853), "dns.example.com"},
+	}}}, calls[0].Body)
+	calls = nil
+	mu.Unlock()
+
+	require.NoError(t, res.RevertLink(ctx, iface.Name))
+
+	mu.Lock()
+	require.Len(t, calls, 1)
+	require.Equal(t, "RevertLink", calls[0].Member)
+	require.Equal(t, []any{ifIndex}, calls[0].Body)
+	mu.Unlock()
+
+	require.Error(t, res.SetLink(ctx, "does-not-exist0", &dnsadmin.LinkConfig{}))
+}
+
+func TestResolvedLink(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	if len(ifaces) == 0 {
+		t.Skip("no network interfaces")
+	}
+	iface := ifaces[0]
+
+	srv := dbustest.NewServer(t, func(call *dbus.Message) ([]any, *dbus.Error) {
+		switch call.Member {
+		case "GetLink":
+			return []any{dbus.ObjectPath("/org/freedesktop/resolve1/link/_32")}, nil
+		case "GetAll":
+			return []any{dbus.Array{Elem: "{sv}", Elems: []any{
+				dbus.DictEntry{Key: "DNS", Value: dbus.Variant{Value: dbus.Array{Elem: "(iay)", Elems: []any{
+					dbus.Struct{int32(2), []byte{10, 0, 0, 1}},
+				}}}},
+				dbus.DictEntry{Key: "Domains", Value: dbus.Variant{Value: dbus.Array{Elem: "(sb)", Elems: []any{
+					dbus.Struct{"corp.example.com", false},
+					dbus.Struct{".", true},
+				}}}},
+				dbus.DictEntry{Key: "DefaultRoute", Value: dbus.Variant{Value: true}},
+				dbus.DictEntry{Key: "DNSOverTLS", Value: dbus.Variant{Value: "opportunistic"}},
+				dbus.DictEntry{Key: "DNSSEC", Value: dbus.Variant{Value: "no"}},
+			}}}, nil
+		default:
+			return nil, &dbus.Error{Name: "org.freedesktop.DBus.Error.UnknownMethod"}
+		}
+	})
+
+	res, err := dnsadmin.NewResolved(&dnsadmin.ResolvedConfig{
+		SocketPath: srv.Path(),
+	})
+	require.NoError(t, err)
+
+	conf, err := res.Link(context.Background(), iface.Name)
+	require.NoError(t, err)
+
+	require.Equal(t, &dnsadmin.LinkConfig{
+		Servers:      []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:0")},
+		Domains:      []string{"corp.example.com", "~."},
+		DefaultRoute: ptr.To(true),
+		DNSOverTLS:   dnsadmin.DNSOverTLSOpportunistic,
+		DNSSEC:       dnsadmin.DNSSECNo,
+	}, conf)
+}
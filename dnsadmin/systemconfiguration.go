@@ -0,0 +1,317 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dnsadmin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
+)
+
+var _ Backend = (*SystemConfiguration)(nil)
+
+// SystemConfigurationConfig is the configuration of a macOS
+// SystemConfiguration backend.
+type SystemConfigurationConfig struct {
+	// Command is the path of the scutil(8) command.
+	// By default, "/usr/sbin/scutil".
+	Command string
+}
+
+// SystemConfiguration programs the DNS configuration of network services in
+// the macOS SystemConfiguration dynamic store (using scutil), eg. to install
+// the split DNS configuration of a tunnel. The interface passed to its methods
+// is used as the ID of the network service (eg. "utun4"), the configuration is
+// stored under "State:/Network/Service/<iface>/DNS". Modifying the dynamic
+// store requires root.
+//
+// Search domains are also used as supplemental match domains, so that lookups
+// of names within them are sent to the service's servers. The DNS over TLS
+// and DNSSEC modes, server names, and per server ports are not supported.
+type SystemConfiguration struct {
+	command string
+}
+
+// NewSystemConfiguration returns a macOS SystemConfiguration backend.
+func NewSystemConfiguration(conf *SystemConfigurationConfig) (*SystemConfiguration, error) {
+	conf, err := defaults.WithDefaults(conf, &SystemConfigurationConfig{
+		Command: "/usr/sbin/scutil",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to systemconfiguration config: %w", err)
+	}
+
+	return &SystemConfiguration{
+		command: conf.Command,
+	}, nil
+}
+
+// Available reports whether scutil is available.
+func (sc *SystemConfiguration) Available(ctx context.Context) bool {
+	_, err := sc.run(ctx, "quit\n")
+	return err == nil
+}
+
+// Link returns the DNS configuration of the network service.
+func (sc *SystemConfiguration) Link(ctx context.Context, iface string) (*LinkConfig, error) {
+	key, err := serviceDNSKey(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := sc.run(ctx, "show "+key+"\n")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSCDNSConfig(out)
+}
+
+// SetLink replaces the DNS configuration of the network service.
+func (sc *SystemConfiguration) SetLink(ctx context.Context, iface string, conf *LinkConfig) error {
+	key, err := serviceDNSKey(iface)
+	if err != nil {
+		return err
+	}
+
+	if conf == nil {
+		conf = &LinkConfig{}
+	}
+
+	script, err := scDNSScript(key, conf)
+	if err != nil {
+		return err
+	}
+
+	_, err = sc.run(ctx, script)
+	return err
+}
+
+// RevertLink removes the DNS configuration of the network service.
+func (sc *SystemConfiguration) RevertLink(ctx context.Context, iface string) error {
+	key, err := serviceDNSKey(iface)
+	if err != nil {
+		return err
+	}
+
+	_, err = sc.run(ctx, "remove "+key+"\n")
+	return err
+}
+
+// run runs the scutil script, returning its output.
+func (sc *SystemConfiguration) run(ctx context.Context, script string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, sc.command)
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("scutil failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("scutil failed: %w", err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func serviceDNSKey(iface string) (string, error) {
+	if iface == "" || !isSCToken(iface) {
+		return "", fmt.Errorf("invalid network service ID %q", iface)
+	}
+
+	return "State:/Network/Service/" + iface + "/DNS", nil
+}
+
+// scDNSScript returns the scutil script that sets the DNS configuration.
+func scDNSScript(key string, conf *LinkConfig) (string, error) {
+	if conf.DNSOverTLS != DNSOverTLSDefault || conf.DNSSEC != DNSSECDefault {
+		return "", fmt.Errorf("DNS over TLS and DNSSEC modes: %w", errors.ErrUnsupported)
+	}
+
+	if conf.TLSServerName != "" {
+		return "", fmt.Errorf("DNS server names: %w", errors.ErrUnsupported)
+	}
+
+	var port uint16
+	var servers []string
+	for _, server := range conf.Servers {
+		if !server.Addr().IsValid() {
+			return "", fmt.Errorf("invalid server address %q", server)
+		}
+
+		if server.Port() != 0 && server.Port() != 53 {
+			if port != 0 && port != server.Port() {
+				return "", fmt.Errorf("per server ports: %w", errors.ErrUnsupported)
+			}
+			port = server.Port()
+		}
+
+		servers = append(servers, strconv.Quote(server.Addr().String()))
+	}
+
+	var search, match []string
+	for _, domain := range conf.Domains {
+		name, routingOnly := strings.CutPrefix(domain, "~")
+		if _, ok := dns.IsDomainName(name); !ok || !isSCToken(name) {
+			return "", fmt.Errorf("invalid domain %q", domain)
+		}
+
+		// The empty match domain matches every name.
+		name = strings.TrimSuffix(name, ".")
+		if name == "" && !routingOnly {
+			return "", fmt.Errorf("invalid search domain %q", domain)
+		}
+
+		if !routingOnly {
+			search = append(search, strconv.Quote(name))
+		}
+		if !slices.Contains(match, name) {
+			match = append(match, name)
+		}
+	}
+
+	if conf.DefaultRoute != nil && *conf.DefaultRoute && !slices.Contains(match, "") {
+		match = append(match, "")
+	}
+
+	var b strings.Builder
+	b.WriteString("d.init\n")
+	if len(servers) > 0 {
+		fmt.Fprintf(&b, "d.add ServerAddresses * %s\n", strings.Join(servers, " "))
+	}
+	if port != 0 {
+		fmt.Fprintf(&b, "d.add ServerPort # %d\n", port)
+	}
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "d.add SearchDomains * %s\n", strings.Join(search, " "))
+	}
+	if len(match) > 0 {
+		quoted := make([]string, len(match))
+		for i, name := range match {
+			quoted[i] = strconv.Quote(name)
+		}
+		fmt.Fprintf(&b, "d.add SupplementalMatchDomains * %s\n", strings.Join(quoted, " "))
+	}
+	fmt.Fprintf(&b, "set %s\n", key)
+
+	return b.String(), nil
+}
+
+// isSCToken reports whether s only contains letters, digits, hyphens,
+// underscores and dots. Values written to scutil scripts are restricted to
+// these, as domains are often pushed by VPN or DHCP servers, and whitespace,
+// quotes or newlines would inject arguments or commands into the script.
+func isSCToken(s string) bool {
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseSCDNSConfig parses the output of scutil's show command for a DNS
+// configuration, eg.
+//
+//	<dictionary> {
+//	  SearchDomains : <array> {
+//	    0 : corp.example.com
+//	  }
+//	  ServerAddresses : <array> {
+//	    0 : 10.0.0.1
+//	  }
+//	}
+func parseSCDNSConfig(out []byte) (*LinkConfig, error) {
+	values := map[string][]string{}
+
+	var array string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "No such key":
+			return &LinkConfig{}, nil
+		case line == "}":
+			array = ""
+		case array != "":
+			if _, value, ok := strings.Cut(line, " : "); ok {
+				values[array] = append(values[array], value)
+			} else if strings.HasSuffix(line, " :") {
+				// An empty string value.
+				values[array] = append(values[array], "")
+			}
+		default:
+			name, value, ok := strings.Cut(line, " : ")
+			if !ok {
+				continue
+			}
+
+			if value == "<array> {" {
+				array = name
+			} else {
+				values[name] = []string{value}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	conf := &LinkConfig{}
+
+	var port uint16
+	if ports := values["ServerPort"]; len(ports) == 1 {
+		n, err := strconv.ParseUint(ports[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server port %q", ports[0])
+		}
+		port = uint16(n)
+	}
+
+	for _, server := range values["ServerAddresses"] {
+		addr, err := netip.ParseAddr(server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server address %q: %w", server, err)
+		}
+		conf.Servers = append(conf.Servers, netip.AddrPortFrom(addr, port))
+	}
+
+	conf.Domains = values["SearchDomains"]
+	for _, name := range values["SupplementalMatchDomains"] {
+		if name == "" {
+			defaultRoute := true
+			conf.DefaultRoute = &defaultRoute
+			name = "."
+		}
+
+		if !slices.Contains(conf.Domains, name) {
+			conf.Domains = append(conf.Domains, "~"+name)
+		}
+	}
+
+	return conf, nil
+}
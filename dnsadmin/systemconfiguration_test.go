@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dnsadmin_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/noisysockets/resolver/dnsadmin"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemConfiguration(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+
+	// A fake scutil that records its input, and replies with canned output.
+	dir := t.TempDir()
+	command := filepath.Join(dir, "scutil")
+	script := fmt.Sprintf("#!/bin/sh\ncat > %q\ncat %q 2>/dev/null || true\n",
+		filepath.Join(dir, "stdin"), filepath.Join(dir, "stdout"))
+	require.NoError(t, os.WriteFile(command, []byte(script), 0o755))
+
+	sc, err := dnsadmin.NewSystemConfiguration(&dnsadmin.SystemConfigurationConfig{
+		Command: command,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	require.True(t, sc.Available(ctx))
+
+	err = sc.SetLink(ctx, "utun4", &dnsadmin.LinkConfig{
+		Servers:      []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:53"), netip.MustParseAddrPort("[fd00::1]:0")},
+		Domains:      []string{"corp.example.com.", "~internal"},
+		DefaultRoute: ptr.To(true),
+	})
+	require.NoError(t, err)
+
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	require.NoError(t, err)
+
+	require.Equal(t, `d.init
+d.add ServerAddresses * "10.0.0.1" "fd00::1"
+d.add SearchDomains * "corp.example.com"
+d.add SupplementalMatchDomains * "corp.example.com" "internal" ""
+set State:/Network/Service/utun4/DNS
+`, string(stdin))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stdout"), []byte(`<dictionary> {
+  SearchDomains : <array> {
+    0 : corp.example.com
+  }
+  ServerAddresses : <array> {
+    0 : 10.0.0.1
+    1 : fd00::1
+  }
+  SupplementalMatchDomains : <array> {
+    0 : corp.example.com
+    1 : internal
+    2 : 
+  }
+}
+`), 0o644))
+
+	conf, err := sc.Link(ctx, "utun4")
+	require.NoError(t, err)
+
+	require.Equal(t, &dnsadmin.LinkConfig{
+		Servers:      []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:0"), netip.MustParseAddrPort("[fd00::1]:0")},
+		Domains:      []string{"corp.example.com", "~internal", "~."},
+		DefaultRoute: ptr.To(true),
+	}, conf)
+
+	require.NoError(t, sc.RevertLink(ctx, "utun4"))
+
+	stdin, err = os.ReadFile(filepath.Join(dir, "stdin"))
+	require.NoError(t, err)
+	require.Equal(t, "remove State:/Network/Service/utun4/DNS\n", string(stdin))
+
+	err = sc.SetLink(ctx, "utun4", &dnsadmin.LinkConfig{DNSOverTLS: dnsadmin.DNSOverTLSYes})
+	require.True(t, errors.Is(err, errors.ErrUnsupported))
+
+	require.Error(t, sc.SetLink(ctx, "../utun4", &dnsadmin.LinkConfig{}))
+	require.Error(t, sc.SetLink(ctx, "utun4\nremove State:/Network/Global/DNS", &dnsadmin.LinkConfig{}))
+
+	// Domains (eg. pushed by a VPN server) must not inject scutil commands or
+	// arguments.
+	for _, domain := range []string{
+		"corp\nset State:/Network/Global/DNS",
+		"a b.com",
+		`corp".example.com`,
+		"~corp\tinternal",
+	} {
+		err := sc.SetLink(ctx, "utun4", &dnsadmin.LinkConfig{Domains: []string{domain}})
+		require.Error(t, err, domain)
+	}
+}
@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dohContentType is the media type of DNS over HTTPS messages (RFC 8484).
+const dohContentType = "application/dns-message"
+
+// parseDoHURL parses the URL (or RFC 8484 URI template, eg.
+// "https://dns.example/dns-query{?dns}") of a DNS over HTTPS endpoint.
+func parseDoHURL(template string) (*url.URL, error) {
+	// Queries are sent using POST requests, so the dns variable is unused.
+	u, err := url.Parse(strings.Replace(template, "{?dns}", "", 1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns over https url %q: %w", template, err)
+	}
+
+	if u.Scheme != "https" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid dns over https url %q", template)
+	}
+
+	return u, nil
+}
+
+// newDoHClient returns an HTTP client that sends requests to server. The host
+// of the request URL is only used for the Host header and TLS server name, so
+// that no resolver is needed to bootstrap the connection. If http3 is not
+// nil, it is used once the server advertises HTTP/3 support.
+func newDoHClient(dialContext DialContextFunc, server netip.AddrPort, tlsConfig *tls.Config, http3 http.RoundTripper) *http.Client {
+	var transport http.RoundTripper = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialContext(ctx, "tcp", server.String())
+		},
+		TLSClientConfig:   tlsConfig,
+		ForceAttemptHTTP2: true,
+		IdleConnTimeout:   90 * time.Second,
+	}
+
+	if http3 != nil {
+		transport = &dohTransport{http2: transport, http3: http3}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		// Redirects are not part of RFC 8484, and would be resolved using
+		// the system resolver.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// dohConn adapts a DNS over HTTPS endpoint to the length prefixed framing of
+// DNS over TCP, so that queries are exchanged by the same code as the other
+// transports. Each connection carries a single query, the underlying
+// connections are pooled by the HTTP client.
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	header   http.Header
+	server   netip.AddrPort
+	deadline time.Time
+	query    bytes.Buffer
+	response *bytes.Reader
+}
+
+func (c *dohConn) Write(p []byte) (int, error) {
+	return c.query.Write(p)
+}
+
+func (c *dohConn) Read(p []byte) (int, error) {
+	if c.response == nil {
+		if err := c.roundTrip(); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.response.Read(p)
+}
+
+// roundTrip sends the query written to the connection using a POST request.
+func (c *dohConn) roundTrip() error {
+	msg := c.query.Bytes()
+	if len(msg) < 2 || int(binary.BigEndian.Uint16(msg)) != len(msg)-2 {
+		return errors.New("incomplete dns over https query")
+	}
+
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(msg[2:]))
+	if err != nil {
+		return err
+	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected http status %q: %w", resp.Status, ErrServerMisbehaving)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize+1))
+	if err != nil {
+		return err
+	}
+
+	if len(body) > dns.MaxMsgSize {
+		return fmt.Errorf("response too large (> %d): %w", dns.MaxMsgSize, ErrServerMisbehaving)
+	}
+
+	response := make([]byte, 2, 2+len(body))
+	binary.BigEndian.PutUint16(response, uint16(len(body)))
+	c.response = bytes.NewReader(append(response, body...))
+
+	return nil
+}
+
+func (c *dohConn) Close() error {
+	return nil
+}
+
+func (c *dohConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *dohConn) RemoteAddr() net.Addr {
+	return net.TCPAddrFromAddrPort(c.server)
+}
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+// SetWriteDeadline is a no-op, as writes are buffered until the response is
+// read.
+func (c *dohConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+const (
+	// dohAltSvcMaxAge is how long an Alt-Svc advertisement is valid for
+	// without an explicit max age (RFC 7838, section 3.1).
+	dohAltSvcMaxAge = 24 * time.Hour
+	// dohHTTP3BrokenDuration is how long HTTP/2 is used after HTTP/3 fails,
+	// before HTTP/3 is tried again.
+	dohHTTP3BrokenDuration = 5 * time.Minute
+)
+
+// dohTransport sends DNS over HTTPS requests over HTTP/2, upgrading to HTTP/3
+// while the server advertises it (using Alt-Svc) and falling back to HTTP/2
+// if HTTP/3 fails.
+type dohTransport struct {
+	http2 http.RoundTripper
+	http3 http.RoundTripper
+
+	mu sync.Mutex
+	// advertisedUntil is when the server's HTTP/3 advertisement expires.
+	advertisedUntil time.Time
+	// brokenUntil is when HTTP/3 is tried again after failing.
+	brokenUntil time.Time
+}
+
+func (t *dohTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.useHTTP3() {
+		resp, err := t.http3.RoundTrip(req)
+		if err == nil {
+			t.observeAltSvc(resp)
+			return resp, nil
+		}
+
+		// The caller gave up, which says nothing about HTTP/3.
+		if req.Context().Err() != nil || req.GetBody == nil {
+			return nil, err
+		}
+
+		t.mu.Lock()
+		t.brokenUntil = time.Now().Add(dohHTTP3BrokenDuration)
+		t.mu.Unlock()
+
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+
+	resp, err := t.http2.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.observeAltSvc(resp)
+
+	return resp, nil
+}
+
+// CloseIdleConnections closes the idle connections of both transports.
+func (t *dohTransport) CloseIdleConnections() {
+	for _, rt := range []http.RoundTripper{t.http2, t.http3} {
+		if closer, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+}
+
+// useHTTP3 reports whether requests are currently sent over HTTP/3.
+func (t *dohTransport) useHTTP3() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	return now.Before(t.advertisedUntil) && !now.Before(t.brokenUntil)
+}
+
+// observeAltSvc records the server's HTTP/3 advertisement (if any).
+func (t *dohTransport) observeAltSvc(resp *http.Response) {
+	altSvc := resp.Header.Get("Alt-Svc")
+	if altSvc == "" {
+		return
+	}
+
+	maxAge, ok := http3MaxAge(altSvc)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ok {
+		t.advertisedUntil = time.Now().Add(maxAge)
+	} else {
+		t.advertisedUntil = time.Time{}
+	}
+}
+
+// http3MaxAge returns how long the HTTP/3 ("h3") alternative of an Alt-Svc
+// header value is valid for. The alternative's authority is ignored, as
+// queries are always sent to the configured server.
+func http3MaxAge(altSvc string) (time.Duration, bool) {
+	for _, alternative := range strings.Split(altSvc, ",") {
+		params := strings.Split(alternative, ";")
+
+		protocol, _, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+		if !ok || protocol != "h3" {
+			continue
+		}
+
+		maxAge := dohAltSvcMaxAge
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if name != "ma" {
+				continue
+			}
+
+			seconds, err := strconv.ParseUint(strings.Trim(value, `"`), 10, 32)
+			if err != nil {
+				continue
+			}
+			maxAge = time.Duration(seconds) * time.Second
+		}
+
+		return maxAge, maxAge > 0
+	}
+
+	return 0, false
+}
@@ -10,24 +10,46 @@
 package resolver
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net"
+	"os"
 	"strings"
 
 	"github.com/miekg/dns"
-	"github.com/noisysockets/resolver/internal/fqdn"
+	"github.com/noisysockets/resolver/fqdn"
+	"github.com/noisysockets/resolver/internal/dnsconfig"
 )
 
-// Domain returns the domain of the local machine.
-func Domain() (string, error) {
-	hn, err := fqdn.Hostname()
-	if err != nil {
-		return "", err
+// Domain returns the domain of the local machine, similar to `hostname -d`.
+// The domain is taken from the fully qualified hostname (see fqdn.Hostname),
+// using resolver for any DNS lookups (if nil, net.DefaultResolver is used).
+// If the hostname has no domain, the domain (or first search domain) from the
+// system DNS configuration is used. If no domain can be determined, an empty
+// string is returned.
+func Domain(ctx context.Context, resolver Resolver) (string, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
 	}
 
-	labels := dns.SplitDomainName(hn)
-	if len(labels) < 1 {
-		return "", errors.New("invalid hostname")
+	hn, err := fqdn.Hostname(ctx, &fqdn.Config{
+		Resolver: resolver,
+	})
+	if err == nil {
+		if labels := dns.SplitDomainName(hn); len(labels) > 1 {
+			return dns.CanonicalName(strings.Join(labels[1:], ".")), nil
+		}
 	}
 
-	return dns.CanonicalName(strings.Join(labels[1:], ".")), nil
+	systemDNSConf, err := dnsconfig.Read(dnsconfig.Location)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read system DNS configuration: %w", err)
+	}
+
+	if len(systemDNSConf.Search) > 0 {
+		return dns.CanonicalName(systemDNSConf.Search[0]), nil
+	}
+
+	return "", nil
 }
@@ -10,6 +10,8 @@
 package resolver_test
 
 import (
+	"context"
+	"strings"
 	"testing"
 
 	"github.com/noisysockets/resolver"
@@ -17,9 +19,12 @@ import (
 )
 
 func TestDomain(t *testing.T) {
-	domain, err := resolver.Domain()
+	domain, err := resolver.Domain(context.Background(), nil)
 	require.NoError(t, err)
 
-	require.NotEmpty(t, domain)
-	require.NotEqual(t, ".", domain)
+	// Hosts without a domain have an empty domain (like `hostname -d`).
+	if domain != "" {
+		require.True(t, strings.HasSuffix(domain, "."))
+		require.NotEqual(t, ".", domain)
+	}
 }
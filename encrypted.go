@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*encryptedResolver)(nil)
+
+// EncryptedDNSMode determines what happens when encrypted DNS is unavailable.
+type EncryptedDNSMode string
+
+const (
+	// EncryptedDNSModeStrict never falls back to unencrypted DNS, lookups fail
+	// if encrypted DNS is unavailable.
+	EncryptedDNSModeStrict EncryptedDNSMode = "strict"
+	// EncryptedDNSModeOpportunistic tries encrypted DNS first and falls back
+	// to unencrypted DNS if it is unavailable (mirroring the opportunistic
+	// mode of systemd-resolved).
+	EncryptedDNSModeOpportunistic EncryptedDNSMode = "opportunistic"
+)
+
+// EncryptedResolverConfig is the configuration for an encrypted resolver.
+type EncryptedResolverConfig struct {
+	// Mode determines whether lookups may fall back to unencrypted DNS.
+	// By default, strict mode is used.
+	Mode *EncryptedDNSMode
+	// RetryInterval is how long lookups are sent directly to the unencrypted
+	// resolver after a downgrade, before encrypted DNS is tried again. Only
+	// used in opportunistic mode. By default, 5 minutes.
+	RetryInterval *time.Duration
+	// OnDowngrade is an optional hook that is called whenever a lookup is
+	// downgraded to unencrypted DNS, with the error returned by the encrypted
+	// resolver.
+	OnDowngrade func(host string, err error)
+	// Events is an optional event distributor, that is notified whenever a
+	// lookup is downgraded to unencrypted DNS.
+	Events *Events
+}
+
+// encryptedResolver is a resolver that prefers an encrypted resolver,
+// optionally falling back to an unencrypted resolver.
+type encryptedResolver struct {
+	encrypted     Resolver
+	unencrypted   Resolver
+	mode          EncryptedDNSMode
+	retryInterval time.Duration
+	onDowngrade   func(host string, err error)
+	events        *Events
+
+	mu              sync.Mutex
+	downgradedUntil time.Time
+	downgrades      int64
+}
+
+// Encrypted returns a resolver that sends lookups to the encrypted resolver
+// (eg. DNS over TLS). In opportunistic mode, lookups that fail because the
+// encrypted resolver is unavailable (eg. a timeout or TLS error, but not a
+// not found error) are retried using the unencrypted resolver. In strict mode,
+// the unencrypted resolver is never used (and may be nil).
+func Encrypted(encrypted, unencrypted Resolver, conf *EncryptedResolverConfig) (*encryptedResolver, error) {
+	conf, err := defaults.WithDefaults(conf, &EncryptedResolverConfig{
+		Mode:          ptr.To(EncryptedDNSModeStrict),
+		RetryInterval: ptr.To(5 * time.Minute),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to encrypted resolver config: %w", err)
+	}
+
+	switch *conf.Mode {
+	case EncryptedDNSModeStrict:
+	case EncryptedDNSModeOpportunistic:
+		if unencrypted == nil {
+			return nil, fmt.Errorf("an unencrypted resolver is required in opportunistic mode")
+		}
+	default:
+		return nil, fmt.Errorf("invalid encrypted dns mode %q", *conf.Mode)
+	}
+
+	if *conf.RetryInterval < 0 {
+		return nil, fmt.Errorf("retry interval must not be negative")
+	}
+
+	return &encryptedResolver{
+		encrypted:     encrypted,
+		unencrypted:   unencrypted,
+		mode:          *conf.Mode,
+		retryInterval: *conf.RetryInterval,
+		onDowngrade:   conf.OnDowngrade,
+		events:        conf.Events,
+	}, nil
+}
+
+func (r *encryptedResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if r.mode == EncryptedDNSModeStrict {
+		return r.encrypted.LookupNetIP(ctx, network, host)
+	}
+
+	if r.downgraded() {
+		return r.unencrypted.LookupNetIP(ctx, network, host)
+	}
+
+	addrs, err := r.encrypted.LookupNetIP(ctx, network, host)
+	// Names that don't exist, and lookups the caller gave up on, are not a
+	// sign that encrypted DNS is unavailable.
+	if err == nil || isNotFound(err) || ctx.Err() != nil {
+		return addrs, err
+	}
+
+	r.downgrade(host, err)
+
+	return r.unencrypted.LookupNetIP(ctx, network, host)
+}
+
+func (r *encryptedResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if r.mode == EncryptedDNSModeStrict {
+		return lookupAddr(ctx, r.encrypted, addr)
+	}
+
+	if r.downgraded() {
+		return lookupAddr(ctx, r.unencrypted, addr)
+	}
+
+	names, err := lookupAddr(ctx, r.encrypted, addr)
+	if err == nil || isNotFound(err) || ctx.Err() != nil {
+		return names, err
+	}
+
+	r.downgrade(addr, err)
+
+	return lookupAddr(ctx, r.unencrypted, addr)
+}
+
+func (r *encryptedResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	if r.mode == EncryptedDNSModeStrict {
+		return Lookup(ctx, r.encrypted, q)
+	}
+
+	if r.downgraded() {
+		return Lookup(ctx, r.unencrypted, q)
+	}
+
+	answer, err := Lookup(ctx, r.encrypted, q)
+	if err == nil || isNotFound(err) || ctx.Err() != nil {
+		return answer, err
+	}
+
+	r.downgrade(q.Name, err)
+
+	return Lookup(ctx, r.unencrypted, q)
+}
+
+// downgraded returns true if lookups are currently being sent directly to the
+// unencrypted resolver.
+func (r *encryptedResolver) downgraded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return time.Now().Before(r.downgradedUntil)
+}
+
+func (r *encryptedResolver) downgrade(host string, err error) {
+	r.mu.Lock()
+	r.downgrades++
+	r.downgradedUntil = time.Now().Add(r.retryInterval)
+	r.mu.Unlock()
+
+	if r.onDowngrade != nil {
+		r.onDowngrade(host, err)
+	}
+
+	r.events.Publish(Event{
+		Type:     EventTransportDowngraded,
+		Resolver: ptr.To(Describe(r.encrypted)),
+		Name:     host,
+		Err:      err,
+	})
+}
+
+func (r *encryptedResolver) Describe() Description {
+	r.mu.Lock()
+	downgrades := r.downgrades
+	r.mu.Unlock()
+
+	d := Description{
+		Type: "encrypted",
+		Attributes: map[string]string{
+			"mode": string(r.mode),
+		},
+		Children: []Description{Describe(r.encrypted)},
+	}
+
+	if r.mode == EncryptedDNSModeOpportunistic {
+		d.Attributes["downgrades"] = strconv.FormatInt(downgrades, 10)
+		d.Attributes["downgraded"] = strconv.FormatBool(r.downgraded())
+		d.Children = append(d.Children, Describe(r.unencrypted))
+	}
+
+	return d
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedResolver(t *testing.T) {
+	ctx := context.Background()
+
+	newUpstreams := func() (*resolvertest.Fake, *resolvertest.Fake) {
+		encrypted := resolvertest.NewFake()
+		encrypted.Script("example.com", dns.TypeA,
+			resolvertest.Response{Err: resolvertest.Timeout("example.com")})
+
+		unencrypted := resolvertest.NewFake()
+		unencrypted.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"))
+
+		return encrypted, unencrypted
+	}
+
+	t.Run("Strict", func(t *testing.T) {
+		encrypted, unencrypted := newUpstreams()
+
+		res, err := resolver.Encrypted(encrypted, unencrypted, nil)
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.Error(t, err)
+
+		require.Empty(t, unencrypted.Calls())
+	})
+
+	t.Run("Opportunistic", func(t *testing.T) {
+		encrypted, unencrypted := newUpstreams()
+
+		var downgrades []string
+		res, err := resolver.Encrypted(encrypted, unencrypted, &resolver.EncryptedResolverConfig{
+			Mode:          ptr.To(resolver.EncryptedDNSModeOpportunistic),
+			RetryInterval: ptr.To(50 * time.Millisecond),
+			OnDowngrade: func(host string, err error) {
+				downgrades = append(downgrades, host)
+			},
+		})
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+		require.Equal(t, []string{"example.com"}, downgrades)
+
+		// While downgraded, lookups go straight to the unencrypted resolver.
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Len(t, encrypted.Calls(), 1)
+		require.Len(t, unencrypted.Calls(), 2)
+
+		d := resolver.Describe(res)
+		require.Equal(t, "1", d.Attributes["downgrades"])
+		require.Equal(t, "true", d.Attributes["downgraded"])
+
+		// Encrypted DNS is tried again after the retry interval.
+		time.Sleep(100 * time.Millisecond)
+
+		encrypted.SetAddrs("example.com", netip.MustParseAddr("10.0.0.2"))
+
+		addrs, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.2")}, addrs)
+		require.Len(t, downgrades, 1)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		encrypted, unencrypted := newUpstreams()
+
+		res, err := resolver.Encrypted(encrypted, unencrypted, &resolver.EncryptedResolverConfig{
+			Mode: ptr.To(resolver.EncryptedDNSModeOpportunistic),
+		})
+		require.NoError(t, err)
+
+		// Not found errors are not a reason to downgrade.
+		_, err = res.LookupNetIP(ctx, "ip4", "missing.example.com")
+		require.Error(t, err)
+
+		require.Empty(t, unencrypted.Calls())
+	})
+
+	t.Run("Invalid Config", func(t *testing.T) {
+		_, err := resolver.Encrypted(resolvertest.NewFake(), nil, &resolver.EncryptedResolverConfig{
+			Mode: ptr.To(resolver.EncryptedDNSModeOpportunistic),
+		})
+		require.Error(t, err)
+	})
+}
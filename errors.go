@@ -73,3 +73,21 @@ func isTemporary(err error) bool {
 	}
 	return false
 }
+
+// isNotFound reports whether err is a not found error. Errors joined by
+// composite resolvers are only considered not found if every one of them is,
+// as otherwise another resolver may have been able to answer the query.
+func isNotFound(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, err := range errs {
+			if !isNotFound(err) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the type of a resolver event.
+type EventType string
+
+const (
+	// EventUpstreamUnhealthy is published when a penalty box resolver starts
+	// penalizing its upstream (after a timeout).
+	EventUpstreamUnhealthy EventType = "upstream-unhealthy"
+	// EventUpstreamHealthy is published when a penalized upstream answers
+	// again.
+	EventUpstreamHealthy EventType = "upstream-healthy"
+	// EventTransportDowngraded is published when an (opportunistic) encrypted
+	// resolver falls back to unencrypted DNS.
+	EventTransportDowngraded EventType = "transport-downgraded"
+	// EventAnswersDiverged is published when the resolvers of a consensus
+	// resolver disagree on the answer to a lookup (the error is set if no
+	// consensus was reached).
+	EventAnswersDiverged EventType = "answers-diverged"
+	// EventCensorshipDetected is published when an anti-censorship resolver
+	// escalates a lookup to encrypted DNS (the error describes what was
+	// detected, eg. ErrBogusAnswer).
+	EventCensorshipDetected EventType = "censorship-detected"
+	// EventCaptivePortalDetected is published when a captive portal detector
+	// detects DNS interception (the error describes what was detected, eg.
+	// ErrInterceptedAnswer).
+	EventCaptivePortalDetected EventType = "captive-portal-detected"
+	// EventCaptivePortalCleared is published when a captive portal detector
+	// no longer detects DNS interception.
+	EventCaptivePortalCleared EventType = "captive-portal-cleared"
+	// EventHostsFileInvalidEntry is published when a hosts resolver skips a
+	// malformed line of the hosts file (the error describes the line).
+	EventHostsFileInvalidEntry EventType = "hosts-file-invalid-entry"
+	// EventCacheFlushed is published when a cache is flushed.
+	EventCacheFlushed EventType = "cache-flushed"
+	// EventConfigReloaded is published by embedders, eg. after rebuilding a
+	// resolver chain from an updated configuration (see the resolverconfig
+	// package).
+	EventConfigReloaded EventType = "config-reloaded"
+)
+
+// Event is a notable decision made by a resolver, eg. for health reporting.
+type Event struct {
+	// Type is the type of the event.
+	Type EventType
+	// Time is when the event occurred.
+	Time time.Time
+	// Resolver describes the resolver the event is about (eg. the upstream
+	// that is unhealthy), if any.
+	Resolver *Description
+	// Name is the name whose lookup triggered the event, if any.
+	Name string
+	// Err is the error that triggered the event, if any.
+	Err error
+}
+
+// Events distributes resolver events to subscribers. Resolvers publish events
+// to the Events set in their configuration. It is safe for concurrent use.
+type Events struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(Event)
+	next        int
+}
+
+// NewEvents returns a new event distributor without any subscribers.
+func NewEvents() *Events {
+	return &Events{
+		subscribers: make(map[int]func(Event)),
+	}
+}
+
+// Subscribe calls fn with every event published after it returns, until
+// unsubscribe is called. Events are delivered synchronously (from the
+// goroutine of the lookup that triggered them), so fn must not block.
+func (e *Events) Subscribe(fn func(Event)) (unsubscribe func()) {
+	e.mu.Lock()
+	id := e.next
+	e.next++
+	e.subscribers[id] = fn
+	e.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			e.mu.Lock()
+			delete(e.subscribers, id)
+			e.mu.Unlock()
+		})
+	}
+}
+
+// SubscribeChan returns a channel that receives the events published after it
+// returns, until unsubscribe is called (which closes the channel). Events are
+// dropped if the channel's buffer (of the given size) is full.
+func (e *Events) SubscribeChan(size int) (events <-chan Event, unsubscribe func()) {
+	ch := make(chan Event, size)
+
+	// Held while sending, so that the channel isn't closed mid-send.
+	var mu sync.Mutex
+	closed := false
+
+	unsubscribeFn := e.Subscribe(func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if closed {
+			return
+		}
+
+		select {
+		case ch <- ev:
+		default:
+		}
+	})
+
+	return ch, func() {
+		unsubscribeFn()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+}
+
+// Publish delivers ev to every subscriber, setting its time if unset. It is a
+// no-op on a nil Events.
+func (e *Events) Publish(ev Event) {
+	if e == nil {
+		return
+	}
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	e.mu.RLock()
+	subscribers := make([]func(Event), 0, len(e.subscribers))
+	for _, fn := range e.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	e.mu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(ev)
+	}
+}
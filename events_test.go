@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Subscribe", func(t *testing.T) {
+		events := resolver.NewEvents()
+
+		var received []resolver.EventType
+		unsubscribe := events.Subscribe(func(ev resolver.Event) {
+			require.False(t, ev.Time.IsZero())
+			received = append(received, ev.Type)
+		})
+
+		events.Publish(resolver.Event{Type: resolver.EventConfigReloaded})
+		unsubscribe()
+		events.Publish(resolver.Event{Type: resolver.EventConfigReloaded})
+
+		require.Equal(t, []resolver.EventType{resolver.EventConfigReloaded}, received)
+	})
+
+	t.Run("Channel", func(t *testing.T) {
+		events := resolver.NewEvents()
+
+		ch, unsubscribe := events.SubscribeChan(1)
+
+		events.Publish(resolver.Event{Type: resolver.EventCacheFlushed})
+		// Dropped, as the buffer is full.
+		events.Publish(resolver.Event{Type: resolver.EventConfigReloaded})
+
+		unsubscribe()
+
+		var received []resolver.EventType
+		for ev := range ch {
+			received = append(received, ev.Type)
+		}
+
+		require.Equal(t, []resolver.EventType{resolver.EventCacheFlushed}, received)
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		var events *resolver.Events
+		events.Publish(resolver.Event{Type: resolver.EventConfigReloaded})
+	})
+
+	t.Run("Upstream Health", func(t *testing.T) {
+		events := resolver.NewEvents()
+
+		var received []resolver.Event
+		events.Subscribe(func(ev resolver.Event) {
+			received = append(received, ev)
+		})
+
+		upstream := resolvertest.NewFake()
+		upstream.Script("example.com", dns.TypeA,
+			resolvertest.Response{Err: resolvertest.Timeout("example.com")},
+			resolvertest.Response{Err: resolvertest.Timeout("example.com")},
+			resolvertest.Response{Addrs: []netip.Addr{netip.MustParseAddr("93.184.216.34")}},
+		)
+
+		res, err := resolver.PenaltyBox(upstream, &resolver.PenaltyBoxResolverConfig{
+			Events: events,
+		})
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, _ = res.LookupNetIP(ctx, "ip4", "example.com")
+		}
+
+		// Only changes in health are published.
+		require.Len(t, received, 2)
+
+		require.Equal(t, resolver.EventUpstreamUnhealthy, received[0].Type)
+		require.Equal(t, "example.com", received[0].Name)
+		require.Error(t, received[0].Err)
+		require.NotNil(t, received[0].Resolver)
+
+		require.Equal(t, resolver.EventUpstreamHealthy, received[1].Type)
+		require.NoError(t, received[1].Err)
+	})
+
+	t.Run("Transport Downgraded", func(t *testing.T) {
+		events := resolver.NewEvents()
+
+		var received []resolver.Event
+		events.Subscribe(func(ev resolver.Event) {
+			received = append(received, ev)
+		})
+
+		encrypted := resolvertest.NewFake()
+		encrypted.Script("example.com", dns.TypeA,
+			resolvertest.Response{Err: resolvertest.Timeout("example.com")})
+
+		unencrypted := resolvertest.NewFake()
+		unencrypted.SetAddrs("example.com", netip.MustParseAddr("93.184.216.34"))
+
+		res, err := resolver.Encrypted(encrypted, unencrypted, &resolver.EncryptedResolverConfig{
+			Mode:   ptr.To(resolver.EncryptedDNSModeOpportunistic),
+			Events: events,
+		})
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Len(t, received, 1)
+		require.Equal(t, resolver.EventTransportDowngraded, received[0].Type)
+		require.Equal(t, "example.com", received[0].Name)
+	})
+
+	t.Run("Cache Flushed", func(t *testing.T) {
+		events := resolver.NewEvents()
+
+		ch, unsubscribe := events.SubscribeChan(1)
+		t.Cleanup(unsubscribe)
+
+		res, err := resolver.Cache(resolvertest.NewFake(), &resolver.CacheResolverConfig{
+			Events: events,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, res.Close())
+		})
+
+		res.Flush()
+
+		ev := <-ch
+		require.Equal(t, resolver.EventCacheFlushed, ev.Type)
+		require.Equal(t, "cache", ev.Resolver.Type)
+	})
+}
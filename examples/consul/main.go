@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: MIT
+
+// Package main implements a simple example that resolves the names of Consul
+// services using a local Consul agent's DNS interface, while all other names
+// are resolved using the system's DNS configuration (ie. split horizon DNS).
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/netip"
+	"os"
+
+	"github.com/noisysockets/resolver"
+)
+
+func main() {
+	logger := slog.Default()
+
+	consulAddr := flag.String("consul", "127.0.0.1:8600", "Address of the Consul agent's DNS interface")
+	flag.Parse()
+
+	consulDNS, err := resolver.DNS(resolver.DNSResolverConfig{
+		Server: netip.MustParseAddrPort(*consulAddr),
+	})
+	if err != nil {
+		logger.Error("Failed to create Consul resolver", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	systemDNS, err := resolver.System(nil)
+	if err != nil {
+		logger.Error("Failed to create system resolver", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	res := resolver.Sequential(resolver.Forward("consul.", consulDNS), systemDNS)
+
+	names := flag.Args()
+	if len(names) == 0 {
+		names = []string{"consul.service.consul", "google.com"}
+	}
+
+	ctx := context.Background()
+	for _, name := range names {
+		addrs, err := res.LookupNetIP(ctx, "ip", name)
+		if err != nil {
+			logger.Error("Failed to resolve", slog.String("name", name), slog.Any("error", err))
+			continue
+		}
+
+		logger.Info("Resolved", slog.String("name", name), slog.Any("addrs", addrs))
+	}
+}
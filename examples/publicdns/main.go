@@ -22,18 +22,22 @@ func main() {
 		ServerName: "dns.google",
 	}
 
-	res := resolver.Sequential(resolver.Literal(), resolver.RoundRobin(
-		resolver.DNS(resolver.DNSResolverConfig{
-			Server:    netip.MustParseAddrPort("8.8.8.8:853"),
+	var upstreams []resolver.Resolver
+	for _, server := range []string{"8.8.8.8:853", "8.8.4.4:853"} {
+		upstream, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:    netip.MustParseAddrPort(server),
 			Transport: ptr.To(resolver.DNSTransportTLS),
 			TLSConfig: &tlsConfig,
-		}),
-		resolver.DNS(resolver.DNSResolverConfig{
-			Server:    netip.MustParseAddrPort("8.8.4.4:853"),
-			Transport: ptr.To(resolver.DNSTransportTLS),
-			TLSConfig: &tlsConfig,
-		}),
-	))
+		})
+		if err != nil {
+			logger.Error("Failed to create resolver", slog.Any("error", err))
+			os.Exit(1)
+		}
+
+		upstreams = append(upstreams, upstream)
+	}
+
+	res := resolver.Sequential(resolver.Literal(), resolver.RoundRobin(upstreams...))
 
 	ctx := context.Background()
 	addrs, err := res.LookupNetIP(ctx, "ip", "google.com")
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"errors"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// RcodeError is the error of a lookup that failed because the server responded
+// with an unexpected return code (other than NOERROR and NXDOMAIN), eg.
+// SERVFAIL or REFUSED. It wraps the *net.DNSError describing the failure.
+type RcodeError struct {
+	*net.DNSError
+	// Rcode is the return code of the response, eg. dns.RcodeServerFailure.
+	Rcode int
+}
+
+func (e *RcodeError) Unwrap() error {
+	return e.DNSError
+}
+
+// responseError returns dnsErr, wrapped in an *RcodeError if it was caused by
+// a response with an unexpected return code (or -1 if there was no response).
+func responseError(dnsErr *net.DNSError, rcode int) error {
+	if rcode <= dns.RcodeSuccess || rcode == dns.RcodeNameError {
+		return dnsErr
+	}
+
+	return &RcodeError{DNSError: dnsErr, Rcode: rcode}
+}
+
+// FailoverPolicy decides whether a composite resolver (Sequential or
+// RoundRobin) moves on to its next resolver after a resolver fails with err.
+// If it returns false the lookup ends with err.
+type FailoverPolicy func(err error) bool
+
+// FailoverOnAnyError moves on to the next resolver after any error, including
+// not found errors (eg. so that a later resolver can answer for names that an
+// earlier one doesn't know about). This is the default policy.
+func FailoverOnAnyError(err error) bool {
+	return true
+}
+
+// FailoverOnServerError moves on to the next resolver only when the server
+// could not answer the query, the way BIND and most stub resolvers treat
+// partially broken upstreams: after timeouts and transport errors, and after
+// responses with the SERVFAIL, REFUSED, NOTIMP or FORMERR return codes. Any
+// other response (eg. NXDOMAIN, or a response without any records of the
+// queried type) is authoritative and ends the lookup.
+func FailoverOnServerError(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			if FailoverOnServerError(err) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		return true
+	}
+
+	if dnsErr.IsTimeout || dnsErr.IsTemporary {
+		return true
+	}
+
+	if dnsErr.IsNotFound {
+		return false
+	}
+
+	var rcodeErr *RcodeError
+	if !errors.As(err, &rcodeErr) {
+		// Not a response (eg. a TLS handshake failure), or a malformed one.
+		return true
+	}
+
+	switch rcodeErr.Rcode {
+	case dns.RcodeServerFailure, dns.RcodeRefused, dns.RcodeNotImplemented, dns.RcodeFormatError:
+		return true
+	default:
+		return false
+	}
+}
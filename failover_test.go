@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailoverPolicy(t *testing.T) {
+	// A partially broken server.
+	broken := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(req)
+
+			switch req.Question[0].Name {
+			case "refused.example.com.":
+				reply.Rcode = dns.RcodeRefused
+			case "notimp.example.com.":
+				reply.Rcode = dns.RcodeNotImplemented
+			case "formerr.example.com.":
+				reply.Rcode = dns.RcodeFormatError
+			case "servfail.example.com.":
+				reply.Rcode = dns.RcodeServerFailure
+			case "yxdomain.example.com.":
+				reply.Rcode = dns.RcodeYXDomain
+			default:
+				reply.Rcode = dns.RcodeNameError
+			}
+
+			_ = w.WriteMsg(reply)
+		},
+	}).Addr()
+
+	var queries atomic.Int64
+	healthy := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Handler: func(w dns.ResponseWriter, req *dns.Msg) {
+			queries.Add(1)
+
+			reply := new(dns.Msg)
+			reply.SetReply(req)
+
+			q := req.Question[0]
+			reply.Answer = append(reply.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 0, 2),
+			})
+
+			_ = w.WriteMsg(reply)
+		},
+	}).Addr()
+
+	res1, err := resolver.NewDNS(broken)
+	require.NoError(t, err)
+
+	res2, err := resolver.NewDNS(healthy)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	t.Run("Any Error", func(t *testing.T) {
+		res := resolver.Sequential(res1, res2)
+
+		for _, host := range []string{"refused.example.com", "missing.example.com"} {
+			addrs, err := res.LookupNetIP(ctx, "ip4", host)
+			require.NoError(t, err)
+			require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.2")}, addrs)
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		res  resolver.Resolver
+	}{
+		{
+			name: "Sequential",
+			res: resolver.SequentialWithConfig(&resolver.SequentialResolverConfig{
+				Failover: resolver.FailoverOnServerError,
+			}, res1, res2),
+		},
+		{
+			name: "Round Robin",
+			res: resolver.RoundRobinWithConfig(&resolver.RoundRobinResolverConfig{
+				Strategy: fixedOrder{},
+				Failover: resolver.FailoverOnServerError,
+			}, res1, res2),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, host := range []string{"refused.example.com", "notimp.example.com", "formerr.example.com", "servfail.example.com"} {
+				addrs, err := tc.res.LookupNetIP(ctx, "ip4", host)
+				require.NoError(t, err, host)
+				require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.2")}, addrs)
+			}
+
+			before := queries.Load()
+
+			_, err := tc.res.LookupNetIP(ctx, "ip4", "missing.example.com")
+			var dnsErr *net.DNSError
+			require.True(t, errors.As(err, &dnsErr))
+			require.True(t, dnsErr.IsNotFound)
+
+			_, err = tc.res.LookupNetIP(ctx, "ip4", "yxdomain.example.com")
+			require.Error(t, err)
+
+			// Authoritative responses end the lookup.
+			require.Equal(t, before, queries.Load())
+		})
+	}
+
+	t.Run("Rcode Error", func(t *testing.T) {
+		_, err := res1.LookupNetIP(ctx, "ip4", "refused.example.com")
+
+		var rcodeErr *resolver.RcodeError
+		require.True(t, errors.As(err, &rcodeErr))
+		require.Equal(t, dns.RcodeRefused, rcodeErr.Rcode)
+
+		var dnsErr *net.DNSError
+		require.True(t, errors.As(err, &dnsErr))
+		require.Equal(t, "refused.example.com.", dnsErr.Name)
+
+		// Only the typed error decides, not the wording of the message.
+		require.True(t, resolver.FailoverOnServerError(&resolver.RcodeError{
+			DNSError: &net.DNSError{Err: "refused"},
+			Rcode:    dns.RcodeRefused,
+		}))
+	})
+
+	t.Run("Transport Errors", func(t *testing.T) {
+		require.True(t, resolver.FailoverOnServerError(&net.DNSError{Err: "i/o timeout", IsTimeout: true}))
+		require.True(t, resolver.FailoverOnServerError(errors.New("connection refused")))
+		require.False(t, resolver.FailoverOnServerError(errors.Join(&net.DNSError{Err: "no such host", IsNotFound: true})))
+	})
+}
+
+// fixedOrder tries the resolvers in the order they were given.
+type fixedOrder struct{}
+
+func (fixedOrder) Order(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
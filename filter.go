@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/domaintrie"
+)
+
+var _ Resolver = (*filterResolver)(nil)
+
+// FilterResolverConfig is the configuration for a filter resolver.
+type FilterResolverConfig struct {
+	// Block is a list of domains to block, subdomains of a blocked domain are
+	// also blocked.
+	Block []string
+}
+
+// FilterStats describes the blocked domains of a filter resolver.
+type FilterStats struct {
+	// Blocked is the number of blocked domains.
+	Blocked int `json:"blocked"`
+	// Duplicates is the number of domains that were listed more than once.
+	Duplicates int `json:"duplicates"`
+	// Redundant is the number of domains that were dropped, as they are
+	// subdomains of another blocked domain.
+	Redundant int `json:"redundant"`
+	// Bytes is the approximate memory used to store the blocked domains.
+	Bytes int `json:"bytes"`
+}
+
+// filterResolver is a resolver that blocks lookups of a set of domains.
+type filterResolver struct {
+	resolver Resolver
+	blocked  *domaintrie.Trie
+}
+
+// Filter returns a resolver that blocks lookups of the configured domains (and
+// their subdomains), responding as if they don't exist. All other lookups are
+// passed to the provided resolver. Blocked domains are stored in a compact
+// suffix trie (deduplicated as they are loaded), so that large blocklists use
+// little memory and names are matched in O(labels).
+func Filter(resolver Resolver, conf *FilterResolverConfig) (*filterResolver, error) {
+	b := domaintrie.NewBuilder()
+	if conf != nil {
+		for _, domain := range conf.Block {
+			if _, ok := dns.IsDomainName(domain); !ok {
+				return nil, fmt.Errorf("invalid blocked domain %q", domain)
+			}
+			b.Add(domain)
+		}
+	}
+
+	return &filterResolver{
+		resolver: resolver,
+		blocked:  b.Build(),
+	}, nil
+}
+
+func (r *filterResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if r.isBlocked(host) {
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       host,
+			IsNotFound: true,
+		}
+	}
+
+	return r.resolver.LookupNetIP(ctx, network, host)
+}
+
+// LookupAddr performs a reverse lookup, omitting any blocked names from the
+// result.
+func (r *filterResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	names, err := lookupAddr(ctx, r.resolver, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := names[:0:0]
+	for _, name := range names {
+		if !r.isBlocked(name) {
+			allowed = append(allowed, name)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       addr,
+			IsNotFound: true,
+		}
+	}
+
+	return allowed, nil
+}
+
+// Lookup answers the question, unless the name is blocked. Reverse lookup
+// (PTR) answers are filtered like LookupAddr.
+func (r *filterResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	if q.Type == dns.TypePTR {
+		return lookupBasic(ctx, r, q)
+	}
+
+	if r.isBlocked(q.Name) {
+		return Answer{}, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       q.Name,
+			IsNotFound: true,
+		}
+	}
+
+	return Lookup(ctx, r.resolver, q)
+}
+
+// isBlocked reports whether host, or any of its parent domains, is blocked.
+func (r *filterResolver) isBlocked(host string) bool {
+	return r.blocked.Match(host)
+}
+
+// Stats returns statistics about the blocked domains.
+func (r *filterResolver) Stats() FilterStats {
+	stats := r.blocked.Stats()
+
+	return FilterStats{
+		Blocked:    stats.Domains,
+		Duplicates: stats.Duplicates,
+		Redundant:  stats.Redundant,
+		Bytes:      stats.Bytes,
+	}
+}
+
+func (r *filterResolver) Describe() Description {
+	return Description{
+		Type: "filter",
+		Attributes: map[string]string{
+			"blocked": strconv.Itoa(r.blocked.Stats().Domains),
+		},
+		Children: []Description{Describe(r.resolver)},
+	}
+}
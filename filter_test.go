@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterResolver(t *testing.T) {
+	upstream := resolvertest.NewFake()
+	upstream.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"))
+	upstream.SetAddrs("ads.example.com", netip.MustParseAddr("10.0.0.2"))
+	upstream.SetAddrs("tracker.ads.example.com", netip.MustParseAddr("10.0.0.3"))
+
+	res, err := resolver.Filter(upstream, &resolver.FilterResolverConfig{
+		Block: []string{"ads.example.com"},
+	})
+	require.NoError(t, err)
+
+	addrs, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+	for _, host := range []string{"ads.example.com", "Tracker.Ads.Example.com."} {
+		_, err := res.LookupNetIP(context.Background(), "ip", host)
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	}
+
+	// Blocked lookups never reach the upstream resolver.
+	require.Len(t, upstream.Calls(), 1)
+}
+
+func TestFilterResolverStats(t *testing.T) {
+	res, err := resolver.Filter(resolvertest.NewFake(), &resolver.FilterResolverConfig{
+		Block: []string{
+			"ads.example.com",
+			"ADS.example.com.",
+			"tracker.ads.example.com",
+			"tracker.example.net",
+		},
+	})
+	require.NoError(t, err)
+
+	stats := res.Stats()
+	require.Equal(t, 2, stats.Blocked)
+	require.Equal(t, 1, stats.Duplicates)
+	require.Equal(t, 1, stats.Redundant)
+	require.NotZero(t, stats.Bytes)
+
+	require.Equal(t, "2", resolver.Describe(res).Attributes["blocked"])
+}
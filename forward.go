@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+var _ Resolver = (*forwardResolver)(nil)
+
+type forwardResolver struct {
+	suffix   string
+	resolver Resolver
+}
+
+// Forward returns a resolver that forwards lookups of names under suffix (eg.
+// "consul.") to the upstream resolver, lookups of any other names are not
+// found. This is the building block of split horizon configurations, eg.
+//
+//	res := resolver.Sequential(
+//		resolver.Forward("consul.", consulDNS),
+//		resolver.Forward("10.in-addr.arpa.", corporateDNS),
+//		systemDNS,
+//	)
+//
+// Note that in a sequential chain, names under suffix that upstream fails to
+// resolve are passed on to the following resolvers.
+//
+// Reverse lookups are forwarded if the reverse name of the address (eg.
+// "4.3.2.10.in-addr.arpa.") is under suffix.
+func Forward(suffix string, upstream Resolver) *forwardResolver {
+	return &forwardResolver{
+		suffix:   dns.CanonicalName(suffix),
+		resolver: upstream,
+	}
+}
+
+func (r *forwardResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if !r.forwarded(host) {
+		return nil, notForwardedError(host)
+	}
+
+	return r.resolver.LookupNetIP(ctx, network, host)
+}
+
+func (r *forwardResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	name, err := dns.ReverseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:  "unrecognized address",
+			Name: addr,
+		}
+	}
+
+	if !r.forwarded(name) {
+		return nil, notForwardedError(addr)
+	}
+
+	return lookupAddr(ctx, r.resolver, addr)
+}
+
+func (r *forwardResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	if !r.forwarded(q.Name) {
+		return Answer{}, notForwardedError(q.Name)
+	}
+
+	return Lookup(ctx, r.resolver, q)
+}
+
+func (r *forwardResolver) forwarded(name string) bool {
+	return dns.IsSubDomain(r.suffix, dns.CanonicalName(name))
+}
+
+func notForwardedError(name string) *net.DNSError {
+	return &net.DNSError{
+		Err:        ErrNoSuchHost.Error(),
+		Name:       name,
+		IsNotFound: true,
+	}
+}
+
+func (r *forwardResolver) Describe() Description {
+	return Description{
+		Type: "forward",
+		Attributes: map[string]string{
+			"suffix": r.suffix,
+		},
+		Children: []Description{Describe(r.resolver)},
+	}
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardResolver(t *testing.T) {
+	ctx := context.Background()
+
+	// A Consul agent's DNS interface.
+	consulSrv := resolvertest.NewServer(t, nil)
+	consulSrv.AddAddrs("web.service.consul.", 0, netip.MustParseAddr("10.0.0.5"))
+	consulSrv.AddRecords(&dns.SRV{
+		Hdr:    dns.RR_Header{Name: "web.service.consul.", Rrtype: dns.TypeSRV, Class: dns.ClassINET},
+		Port:   8080,
+		Target: "node1.node.dc1.consul.",
+	}, &dns.PTR{
+		Hdr: dns.RR_Header{Name: "5.0.0.10.in-addr.arpa.", Rrtype: dns.TypePTR, Class: dns.ClassINET},
+		Ptr: "node1.node.dc1.consul.",
+	})
+
+	// Everything else, consul names are answered differently to catch leaks.
+	publicSrv := resolvertest.NewServer(t, nil)
+	publicSrv.AddAddrs("example.com.", time.Minute, netip.MustParseAddr("93.184.216.34"))
+	publicSrv.AddAddrs("web.service.consul.", time.Minute, netip.MustParseAddr("192.0.2.1"))
+
+	consulRes, err := resolver.DNS(resolver.DNSResolverConfig{
+		Server: consulSrv.Addr(),
+	})
+	require.NoError(t, err)
+
+	publicRes, err := resolver.DNS(resolver.DNSResolverConfig{
+		Server: publicSrv.Addr(),
+	})
+	require.NoError(t, err)
+
+	res := resolver.Sequential(
+		resolver.Forward("consul", consulRes),
+		resolver.Forward("10.in-addr.arpa", consulRes),
+		publicRes,
+	)
+
+	addrs, err := res.LookupNetIP(ctx, "ip4", "web.service.consul")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.5")}, addrs)
+
+	addrs, err = res.LookupNetIP(ctx, "ip4", "example.com")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("93.184.216.34")}, addrs)
+
+	answer, err := resolver.Lookup(ctx, res, resolver.Question{Name: "web.service.consul", Type: dns.TypeSRV})
+	require.NoError(t, err)
+	require.Len(t, answer.Records, 1)
+	require.Equal(t, uint16(8080), answer.Records[0].(*dns.SRV).Port)
+
+	name, err := resolver.ReverseHostname(ctx, res, netip.MustParseAddr("10.0.0.5"))
+	require.NoError(t, err)
+	require.Equal(t, "node1.node.dc1.consul.", name)
+
+	// Names outside of the suffix are not found.
+	_, err = resolver.Forward("consul.", consulRes).LookupNetIP(ctx, "ip4", "example.com")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	require.True(t, dnsErr.IsNotFound)
+}
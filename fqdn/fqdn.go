@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ *
+ * Portions of this file are based on code originally:
+ *
+ * Copyright since 2015 Showmax s.r.o.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package fqdn determines the fully qualified domain name of the local
+// machine, similar to `hostname -f`.
+package fqdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/resolver/internal/hostsfile"
+)
+
+// ErrNotFound is returned when the fully qualified hostname cannot be found.
+var ErrNotFound = errors.New("fqdn not found")
+
+// Resolver is used to look up the hostname when it can't be found in the
+// hosts file. It is implemented by net.Resolver and by the resolvers in the
+// parent package. If the resolver also implements CNAMEResolver or
+// AddrResolver, canonical names and reverse lookups are used as well.
+type Resolver interface {
+	// LookupNetIP looks up the IP addresses of host.
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// CNAMEResolver is implemented by resolvers that can look up the canonical
+// name of a host (eg. net.Resolver).
+type CNAMEResolver interface {
+	// LookupCNAME returns the canonical name of host.
+	LookupCNAME(ctx context.Context, host string) (string, error)
+}
+
+// AddrResolver is implemented by resolvers that can perform reverse lookups
+// (eg. net.Resolver).
+type AddrResolver interface {
+	// LookupAddr returns the names mapping to addr.
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// Config is the configuration used to determine the fully qualified hostname.
+type Config struct {
+	// Hostname is the optional hostname to canonicalize.
+	// By default, the hostname reported by the kernel is used.
+	Hostname string
+	// HostsFilePath is the optional path to the hosts file.
+	// By default, the system's hosts file is used.
+	HostsFilePath string
+	// Resolver is used to look up the hostname when it can't be found in the
+	// hosts file. By default, net.DefaultResolver is used.
+	Resolver Resolver
+}
+
+// Hostname tries to get the fully qualified hostname of the current machine.
+//
+// It tries to mimic how `hostname -f` works, so except for few edge cases you
+// should get the same result from both. One thing that needs to be mentioned is
+// that it does not guarantee that you get back fqdn. There is no way to do that
+// and `hostname -f` can also return non-fqdn hostname if your /etc/hosts is
+// malformed.
+//
+// It checks few sources in this order:
+//
+//  1. hosts file
+//     It parses hosts file if present and readable and returns first canonical
+//     hostname that also references your hostname. See hosts(5) for more
+//     details.
+//  2. dns lookup
+//     If lookup in hosts file fails, it tries to ask dns (using the configured
+//     resolver).
+func Hostname(ctx context.Context, conf *Config) (string, error) {
+	conf, err := defaults.WithDefaults(conf, &Config{
+		HostsFilePath: hostsfile.Location,
+		Resolver:      net.DefaultResolver,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to apply defaults to fqdn config: %w", err)
+	}
+
+	host := conf.Hostname
+	if host == "" {
+		host, err = os.Hostname()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	fqdn, err := fromHosts(conf.HostsFilePath, host)
+	if err == nil {
+		return fqdn, nil
+	}
+
+	fqdn, err = fromLookup(ctx, conf.Resolver, host)
+	if err == nil {
+		return fqdn, nil
+	}
+
+	return "", ErrNotFound
+}
+
+// Reads hosts(5) file and tries to get canonical name for host.
+func fromHosts(path, host string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open hosts file: %w", err)
+	}
+	defer f.Close()
+
+	h, err := hostsfile.Decode(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse hosts file: %w", err)
+	}
+
+	for _, record := range h.Records() {
+		if record.Matches(host) {
+			// The first hostname should always be canonical.
+			return record.Hostnames[0], nil
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+func fromLookup(ctx context.Context, resolver Resolver, host string) (string, error) {
+	if cnameResolver, ok := resolver.(CNAMEResolver); ok {
+		fqdn, err := cnameResolver.LookupCNAME(ctx, host)
+		if err == nil && len(fqdn) != 0 {
+			return fqdn, nil
+		}
+	}
+
+	addrResolver, ok := resolver.(AddrResolver)
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", ErrNotFound
+	}
+
+	for _, addr := range addrs {
+		hosts, err := addrResolver.LookupAddr(ctx, addr.String())
+		// On windows it can return err == nil but empty list of hosts.
+		if err != nil || len(hosts) == 0 {
+			continue
+		}
+
+		// First one should be the canonical hostname.
+		return hosts[0], nil
+	}
+
+	return "", ErrNotFound
+}
@@ -26,8 +26,12 @@
 package fqdn
 
 import (
+	"context"
 	"net"
+	"net/netip"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -38,7 +42,7 @@ import (
 // This package is hard to reasonably test in isolation, so take a shortcut and
 // assume that no one will set their hostname to localhost.
 func TestHostname(t *testing.T) {
-	fqdnHost, err := Hostname()
+	fqdnHost, err := Hostname(context.Background(), nil)
 	require.NoError(t, err)
 
 	require.NotEqual(t, "localhost", fqdnHost)
@@ -47,27 +51,94 @@ func TestHostname(t *testing.T) {
 	require.Nil(t, net.ParseIP(fqdnHost))
 }
 
+func TestHostnameFromHosts(t *testing.T) {
+	hostsFilePath := filepath.Join(t.TempDir(), "hosts")
+	require.NoError(t, os.WriteFile(hostsFilePath, []byte("10.0.0.1 myhost.example.com myhost\n"), 0o644))
+
+	fqdn, err := Hostname(context.Background(), &Config{
+		Hostname:      "myhost",
+		HostsFilePath: hostsFilePath,
+		Resolver:      &stubResolver{},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, "myhost.example.com.", fqdn)
+}
+
 func TestFromLookup(t *testing.T) {
-	t.Run("IPv4", func(t *testing.T) {
-		fqdn, err := fromLookup("ipv4.google.com")
+	res := &stubResolver{
+		cnames: map[string]string{
+			"alias": "canonical.example.com.",
+		},
+		addrs: map[string][]netip.Addr{
+			"myhost": {netip.MustParseAddr("10.0.0.1")},
+		},
+		ptrs: map[string][]string{
+			"10.0.0.1": {"myhost.example.com."},
+		},
+	}
+
+	t.Run("CNAME", func(t *testing.T) {
+		fqdn, err := fromLookup(context.Background(), res, "alias")
 		require.NoError(t, err)
 
-		require.Equal(t, "ipv4.l.google.com.", fqdn)
+		require.Equal(t, "canonical.example.com.", fqdn)
 	})
 
-	t.Run("IPv6", func(t *testing.T) {
-		fqdn, err := fromLookup("ipv6.google.com")
+	t.Run("PTR", func(t *testing.T) {
+		fqdn, err := fromLookup(context.Background(), res, "myhost")
 		require.NoError(t, err)
 
-		require.Equal(t, "ipv6.l.google.com.", fqdn)
+		require.Equal(t, "myhost.example.com.", fqdn)
 	})
 
 	t.Run("NotFound", func(t *testing.T) {
-		_, err := fromLookup("makwjefalurgaf8")
-		require.ErrorIs(t, err, ErrFqdnNotFound)
+		_, err := fromLookup(context.Background(), res, "makwjefalurgaf8")
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Address Only Resolver", func(t *testing.T) {
+		_, err := fromLookup(context.Background(), addrOnlyResolver{res}, "myhost")
+		require.ErrorIs(t, err, ErrNotFound)
 	})
 }
 
+type stubResolver struct {
+	cnames map[string]string
+	addrs  map[string][]netip.Addr
+	ptrs   map[string][]string
+}
+
+func (r *stubResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	if addrs, ok := r.addrs[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *stubResolver) LookupCNAME(_ context.Context, host string) (string, error) {
+	if cname, ok := r.cnames[host]; ok {
+		return cname, nil
+	}
+	return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *stubResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	if names, ok := r.ptrs[addr]; ok {
+		return names, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+// addrOnlyResolver hides the optional methods of a resolver.
+type addrOnlyResolver struct {
+	res Resolver
+}
+
+func (r addrOnlyResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return r.res.LookupNetIP(ctx, network, host)
+}
+
 // In order to behave in expected way, we should verify that we are producing
 // same output has hostname utility.
 func TestMatchHostname(t *testing.T) {
@@ -77,7 +148,7 @@ func TestMatchHostname(t *testing.T) {
 	}
 	outS := dns.CanonicalName(strings.TrimSpace(string(out)))
 
-	fqdn, err := Hostname()
+	fqdn, err := Hostname(context.Background(), nil)
 	if err != nil {
 		t.Fatalf("Could not fqdn hostname: %v", err)
 	}
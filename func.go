@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+)
+
+var _ Resolver = Func(nil)
+
+// Func is an adapter that allows an ordinary function (eg. a database lookup
+// or an API call) to be used as a Resolver, analogous to http.HandlerFunc.
+// The network passed to the function is always one of "ip", "ip4" or "ip6"
+// (transport networks, eg. "tcp4", are mapped to the address family they
+// imply).
+type Func func(ctx context.Context, network, host string) ([]netip.Addr, error)
+
+// LookupNetIP calls f(ctx, network, host).
+func (f Func) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	network, supported := ipNetwork(network)
+	if !supported {
+		return nil, &net.DNSError{
+			Err:  ErrUnsupportedNetwork.Error(),
+			Name: host,
+		}
+	}
+
+	return f(ctx, network, host)
+}
+
+func (f Func) Describe() Description {
+	return Description{Type: "func"}
+}
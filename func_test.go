@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuncResolver(t *testing.T) {
+	var networks []string
+	res := resolver.Func(func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		networks = append(networks, network)
+
+		if host != "db.example.com" {
+			return nil, &net.DNSError{
+				Err:        resolver.ErrNoSuchHost.Error(),
+				Name:       host,
+				IsNotFound: true,
+			}
+		}
+
+		return []netip.Addr{netip.MustParseAddr("10.0.0.1")}, nil
+	})
+
+	t.Run("Sequential", func(t *testing.T) {
+		networks = nil
+
+		addrs, err := resolver.Sequential(resolver.Literal(), res).LookupNetIP(context.Background(), "tcp4", "db.example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+		require.Equal(t, []string{"ip4"}, networks)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		_, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("Unsupported Network", func(t *testing.T) {
+		networks = nil
+
+		_, err := res.LookupNetIP(context.Background(), "unix", "db.example.com")
+		require.Error(t, err)
+		require.Empty(t, networks)
+	})
+}
@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package getaddrinfo provides a resolver that calls the C library's
+// getaddrinfo (and getnameinfo) using cgo, like the cgo resolver of the Go
+// runtime. Names are resolved using the host's NSS configuration (eg. sssd,
+// libnss-mdns, or LDAP hosts), so that deployments relying on NSS plugins can
+// still use the composition layers of the resolver package.
+//
+// It is a separate package so that the resolver package itself remains pure
+// Go. Without cgo (or on non-Unix platforms), New returns an error wrapping
+// errors.ErrUnsupported.
+package getaddrinfo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/noisysockets/resolver"
+)
+
+var _ resolver.Resolver = (*Resolver)(nil)
+
+// maxConcurrentCalls is the maximum number of concurrent (blocking) C library
+// calls, each of which occupies an OS thread (the Go runtime uses the same
+// limit).
+const maxConcurrentCalls = 500
+
+var threadLimit = make(chan struct{}, maxConcurrentCalls)
+
+// family is the address family of a lookup.
+type family int
+
+const (
+	familyUnspec family = iota
+	familyIPv4
+	familyIPv6
+)
+
+// Resolver is a resolver that uses the C library's getaddrinfo.
+type Resolver struct{}
+
+// New returns a resolver that uses the C library's getaddrinfo. Addresses are
+// returned in the order chosen by the C library (eg. according to gai.conf).
+func New() (*Resolver, error) {
+	if !supported {
+		return nil, fmt.Errorf("getaddrinfo resolver: %w", errors.ErrUnsupported)
+	}
+
+	return &Resolver{}, nil
+}
+
+func (r *Resolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	var fam family
+	network, _, _ = strings.Cut(network, ":")
+	switch network {
+	case "ip", "tcp", "udp":
+		fam = familyUnspec
+	case "ip4", "tcp4", "udp4":
+		fam = familyIPv4
+	case "ip6", "tcp6", "udp6":
+		fam = familyIPv6
+	default:
+		return nil, &net.DNSError{
+			Err:  resolver.ErrUnsupportedNetwork.Error(),
+			Name: host,
+		}
+	}
+
+	if host == "" || strings.ContainsRune(host, 0) {
+		return nil, &net.DNSError{
+			Err:        resolver.ErrNoSuchHost.Error(),
+			Name:       host,
+			IsNotFound: true,
+		}
+	}
+
+	return call(ctx, host, func() ([]netip.Addr, *net.DNSError) {
+		return lookupHost(host, fam)
+	})
+}
+
+// LookupAddr performs a reverse lookup of addr using getnameinfo.
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:  "unrecognized address",
+			Name: addr,
+		}
+	}
+
+	return call(ctx, addr, func() ([]string, *net.DNSError) {
+		return lookupAddr(ip)
+	})
+}
+
+// call performs a blocking C library call in its own goroutine, so that the
+// lookup can be abandoned when ctx is done (the call itself can't be
+// cancelled and runs to completion in the background).
+func call[T any](ctx context.Context, name string, fn func() (T, *net.DNSError)) (T, error) {
+	type result struct {
+		value T
+		err   *net.DNSError
+	}
+
+	var zero T
+	if ctx.Err() != nil {
+		return zero, contextError(ctx, name)
+	}
+
+	select {
+	case threadLimit <- struct{}{}:
+	case <-ctx.Done():
+		return zero, contextError(ctx, name)
+	}
+
+	results := make(chan result, 1)
+	go func() {
+		defer func() { <-threadLimit }()
+
+		value, err := fn()
+		results <- result{value: value, err: err}
+	}()
+
+	select {
+	case res := <-results:
+		if res.err != nil {
+			res.err.Name = name
+			return zero, res.err
+		}
+		return res.value, nil
+	case <-ctx.Done():
+		return zero, contextError(ctx, name)
+	}
+}
+
+func contextError(ctx context.Context, name string) *net.DNSError {
+	return &net.DNSError{
+		Err:       ctx.Err().Error(),
+		Name:      name,
+		IsTimeout: errors.Is(ctx.Err(), context.DeadlineExceeded),
+	}
+}
+
+func (r *Resolver) Describe() resolver.Description {
+	return resolver.Description{
+		Type: "getaddrinfo",
+	}
+}
@@ -0,0 +1,152 @@
+//go:build cgo && unix
+
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package getaddrinfo
+
+/*
+#include <stdlib.h>
+#include <string.h>
+#include <sys/types.h>
+#include <sys/socket.h>
+#include <netinet/in.h>
+#include <netdb.h>
+*/
+import "C"
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/noisysockets/resolver"
+)
+
+const supported = true
+
+func lookupHost(host string, fam family) ([]netip.Addr, *net.DNSError) {
+	chost := C.CString(host)
+	defer C.free(unsafe.Pointer(chost))
+
+	var hints C.struct_addrinfo
+	// Without a socket type, every address is returned once per socket type.
+	hints.ai_socktype = C.SOCK_STREAM
+	switch fam {
+	case familyIPv4:
+		hints.ai_family = C.AF_INET
+	case familyIPv6:
+		hints.ai_family = C.AF_INET6
+	default:
+		hints.ai_family = C.AF_UNSPEC
+	}
+
+	var res *C.struct_addrinfo
+	gerrno, err := C.getaddrinfo(chost, nil, &hints, &res)
+	if gerrno != 0 {
+		return nil, gaiError(gerrno, err)
+	}
+	defer C.freeaddrinfo(res)
+
+	var addrs []netip.Addr
+	for ai := res; ai != nil; ai = ai.ai_next {
+		if ai.ai_addr == nil {
+			continue
+		}
+
+		var addr netip.Addr
+		switch ai.ai_family {
+		case C.AF_INET:
+			sa := (*C.struct_sockaddr_in)(unsafe.Pointer(ai.ai_addr))
+			addr = netip.AddrFrom4(*(*[4]byte)(unsafe.Pointer(&sa.sin_addr)))
+		case C.AF_INET6:
+			sa := (*C.struct_sockaddr_in6)(unsafe.Pointer(ai.ai_addr))
+			addr = netip.AddrFrom16(*(*[16]byte)(unsafe.Pointer(&sa.sin6_addr)))
+		default:
+			continue
+		}
+
+		if !slices.Contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{
+			Err:        resolver.ErrNoSuchHost.Error(),
+			IsNotFound: true,
+		}
+	}
+
+	return addrs, nil
+}
+
+func lookupAddr(ip netip.Addr) ([]string, *net.DNSError) {
+	var sa unsafe.Pointer
+	var salen C.socklen_t
+	if ip.Unmap().Is4() {
+		var sin C.struct_sockaddr_in
+		sin.sin_family = C.AF_INET
+		*(*[4]byte)(unsafe.Pointer(&sin.sin_addr)) = ip.Unmap().As4()
+		sa, salen = unsafe.Pointer(&sin), C.socklen_t(unsafe.Sizeof(sin))
+	} else {
+		var sin6 C.struct_sockaddr_in6
+		sin6.sin6_family = C.AF_INET6
+		*(*[16]byte)(unsafe.Pointer(&sin6.sin6_addr)) = ip.As16()
+		sa, salen = unsafe.Pointer(&sin6), C.socklen_t(unsafe.Sizeof(sin6))
+	}
+
+	buf := make([]byte, C.NI_MAXHOST)
+	gerrno, err := C.getnameinfo((*C.struct_sockaddr)(sa), salen,
+		(*C.char)(unsafe.Pointer(&buf[0])), C.socklen_t(len(buf)), nil, 0, C.NI_NAMEREQD)
+	if gerrno != 0 {
+		return nil, gaiError(gerrno, err)
+	}
+
+	name := C.GoString((*C.char)(unsafe.Pointer(&buf[0])))
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	return []string{name}, nil
+}
+
+// gaiError converts a getaddrinfo (or getnameinfo) error code into a DNS
+// error.
+func gaiError(gerrno C.int, err error) *net.DNSError {
+	switch gerrno {
+	case C.EAI_NONAME:
+		return &net.DNSError{
+			Err:        resolver.ErrNoSuchHost.Error(),
+			IsNotFound: true,
+		}
+	case C.EAI_AGAIN:
+		return &net.DNSError{
+			Err:         C.GoString(C.gai_strerror(gerrno)),
+			IsTemporary: true,
+		}
+	case C.EAI_SYSTEM:
+		if err == nil {
+			// Some C libraries don't set errno.
+			err = syscall.EMFILE
+		}
+
+		return &net.DNSError{
+			Err:         err.Error(),
+			IsTemporary: true,
+		}
+	default:
+		return &net.DNSError{
+			Err: C.GoString(C.gai_strerror(gerrno)),
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !cgo || !unix
+
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package getaddrinfo
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+)
+
+const supported = false
+
+func lookupHost(string, family) ([]netip.Addr, *net.DNSError) {
+	return nil, &net.DNSError{Err: errors.ErrUnsupported.Error()}
+}
+
+func lookupAddr(netip.Addr) ([]string, *net.DNSError) {
+	return nil, &net.DNSError{Err: errors.ErrUnsupported.Error()}
+}
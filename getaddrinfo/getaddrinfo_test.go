@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package getaddrinfo_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/getaddrinfo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver(t *testing.T) {
+	res, err := getaddrinfo.New()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("getaddrinfo is not supported on this platform")
+	}
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	t.Run("LookupNetIP", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip4", "localhost")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, addrs)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		_, err := res.LookupNetIP(ctx, "ip", "nonexistent.invalid")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("Unsupported Network", func(t *testing.T) {
+		_, err := res.LookupNetIP(ctx, "unix", "localhost")
+		require.Error(t, err)
+	})
+
+	t.Run("LookupAddr", func(t *testing.T) {
+		name, err := resolver.ReverseHostname(ctx, res, netip.MustParseAddr("127.0.0.1"))
+		require.NoError(t, err)
+
+		require.Equal(t, "localhost.", name)
+	})
+
+	t.Run("Composition", func(t *testing.T) {
+		cached, err := resolver.Cache(res, nil)
+		require.NoError(t, err)
+
+		addrs, err := cached.LookupNetIP(ctx, "ip4", "localhost")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, addrs)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := res.LookupNetIP(ctx, "ip", "localhost")
+		require.ErrorContains(t, err, context.Canceled.Error())
+	})
+}
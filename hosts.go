@@ -13,16 +13,21 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/netip"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
-	"github.com/noisysockets/resolver/internal/addrselect"
+	"github.com/noisysockets/resolver/internal/defaults"
 	"github.com/noisysockets/resolver/internal/hostsfile"
 	"github.com/noisysockets/util/address"
-	"github.com/noisysockets/util/defaults"
 	"github.com/noisysockets/util/ptr"
 )
 
@@ -32,29 +37,89 @@ type HostsResolverConfig struct {
 	// HostsFileReader is an optional reader that will be used as the source of the hosts file.
 	// If not provided, the OS's default hosts file will be used.
 	HostsFileReader io.Reader
-	// DialContext is an optional dialer used for ordering the returned addresses.
+	// DialContext is an optional dialer used to probe source addresses when
+	// ordering the returned addresses.
 	DialContext DialContextFunc
+	// SourceAddrProvider is an optional provider of the source addresses used
+	// when sorting addresses according to RFC 6724. By default, source
+	// addresses are probed using DialContext (if provided) or selected from
+	// the host's interface addresses.
+	SourceAddrProvider SourceAddrProvider
+	// AddressOrder is the policy used to order the returned addresses.
+	// By default, addresses are sorted according to RFC 6724.
+	AddressOrder *AddressOrder
+	// PolicyTable is an optional RFC 6724 address selection policy table used
+	// when sorting addresses. By default, the table from RFC 6724 is used.
+	PolicyTable []PolicyTableEntry
 	// NoHostsFile disables the use of the hosts file.
 	// This is useful when operating with only ephemeral hosts.
 	NoHostsFile *bool
+	// Hosts are additional static entries, mapping names to addresses. A name
+	// prefixed with "*." (eg. "*.dev.internal") is a wildcard that matches any
+	// subdomain of the suffix (but not the suffix itself).
+	Hosts map[string][]netip.Addr
+	// OnExpire is an optional callback invoked (from a background goroutine)
+	// with the host and addresses of each ephemeral host added with
+	// AddHostWithTTL when it expires.
+	OnExpire func(host string, addrs []netip.Addr)
+	// Events is an optional event distributor, that is notified of malformed
+	// lines of the hosts file, which are skipped (see
+	// EventHostsFileInvalidEntry).
+	Events *Events
 }
 
 type HostsResolver struct {
-	mu          sync.RWMutex
-	nameToAddr  map[string][]netip.Addr
-	dialContext DialContextFunc
+	// mu serializes modifications of the table, lookups don't take it.
+	mu     sync.Mutex
+	table  atomic.Pointer[hostsTable]
+	sorter addrSorter
+
+	onExpire    func(host string, addrs []netip.Addr)
+	janitorOnce sync.Once
+	wake        chan struct{}
+	stop        chan struct{}
+	stopOnce    sync.Once
+	wg          sync.WaitGroup
+}
+
+// hostsTable is an immutable snapshot of the hosts. Modifications are made to
+// a copy, which then replaces the table, so that lookups are lock-free. Slices
+// stored in the table may be shared with older snapshots, so are only ever
+// appended to (beyond the length visible to older snapshots), never modified
+// in place.
+type hostsTable struct {
+	// nameToAddr maps the keys (see hostKey) of names to their addresses.
+	nameToAddr map[string][]netip.Addr
+	addrToName map[netip.Addr][]string
+	// wildcards maps the keys of the suffixes of wildcard entries (eg.
+	// "dev.internal") to their addresses.
+	wildcards map[string][]netip.Addr
+	// expires maps the keys of expiring hosts (with the "*." prefix of
+	// wildcards) to their expiry.
+	expires map[string]hostExpiry
 }
 
 func Hosts(conf *HostsResolverConfig) (*HostsResolver, error) {
 	conf, err := defaults.WithDefaults(conf, &HostsResolverConfig{
-		DialContext: (&net.Dialer{}).DialContext,
-		NoHostsFile: ptr.To(false),
+		AddressOrder: ptr.To(AddressOrderRFC6724),
+		NoHostsFile:  ptr.To(false),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to apply defaults to hosts resolver config: %w", err)
 	}
 
-	addrsByName := make(map[string][]netip.Addr)
+	if err := conf.AddressOrder.validate(); err != nil {
+		return nil, err
+	}
+
+	// Entries are added in file order, so that reverse lookups return the
+	// canonical (first) name of an address first.
+	type hostsEntry struct {
+		name string
+		addr netip.Addr
+	}
+
+	var entries []hostsEntry
 	if !*conf.NoHostsFile {
 		// Don't incur the cost of opening the hosts file if a reader is already provided.
 		if conf.HostsFileReader == nil {
@@ -72,6 +137,13 @@ func Hosts(conf *HostsResolverConfig) (*HostsResolver, error) {
 			return nil, fmt.Errorf("failed to parse hosts file: %w", err)
 		}
 
+		for _, warning := range h.Warnings() {
+			conf.Events.Publish(Event{
+				Type: EventHostsFileInvalidEntry,
+				Err:  warning,
+			})
+		}
+
 		for _, record := range h.Records() {
 			for _, name := range record.Hostnames {
 				name = dns.Fqdn(name)
@@ -81,61 +153,258 @@ func Hosts(conf *HostsResolverConfig) (*HostsResolver, error) {
 					return nil, fmt.Errorf("failed to parse IP address: %w", err)
 				}
 
-				addrsByName[name] = append(addrsByName[name], addr)
+				entries = append(entries, hostsEntry{name: name, addr: addr})
 			}
 		}
 	}
 
-	return &HostsResolver{
-		nameToAddr:  addrsByName,
-		dialContext: conf.DialContext,
-	}, nil
-}
+	// The initial table isn't shared yet, so is built in place.
+	t := &hostsTable{
+		nameToAddr: make(map[string][]netip.Addr),
+		addrToName: make(map[netip.Addr][]string),
+		wildcards:  make(map[string][]netip.Addr),
+		expires:    make(map[string]hostExpiry),
+	}
 
-func (r *HostsResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
-	dnsErr := &net.DNSError{
-		Name: host,
+	for _, entry := range entries {
+		t.addHost(entry.name, entry.addr)
+	}
+
+	// Sorted for a deterministic reverse lookup order.
+	names := make([]string, 0, len(conf.Hosts))
+	for name := range conf.Hosts {
+		names = append(names, name)
 	}
+	slices.Sort(names)
 
-	r.mu.RLock()
-	addrs, ok := r.nameToAddr[dns.Fqdn(host)]
-	r.mu.RUnlock()
+	for _, name := range names {
+		t.setHost(name, conf.Hosts[name])
+	}
+
+	r := &HostsResolver{
+		sorter: newAddrSorter(*conf.AddressOrder,
+			sourceAddrProviderFor(conf.SourceAddrProvider, conf.DialContext), conf.PolicyTable),
+		onExpire: conf.OnExpire,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	r.table.Store(t)
+
+	return r, nil
+}
+
+func (r *HostsResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, ok := r.table.Load().lookup(host)
 	if !ok {
-		return nil, extendDNSError(dnsErr, net.DNSError{
+		return nil, extendDNSError(&net.DNSError{Name: host}, net.DNSError{
 			Err:        ErrNoSuchHost.Error(),
 			IsNotFound: true,
 		})
 	}
 
-	if network != "ip" && network != "ip4" && network != "ip6" {
-		return nil, extendDNSError(dnsErr, net.DNSError{
+	network, supported := ipNetwork(network)
+	if !supported {
+		return nil, extendDNSError(&net.DNSError{Name: host}, net.DNSError{
 			Err: ErrUnsupportedNetwork.Error(),
 		})
 	}
 
-	addrs = address.FilterByNetwork(addrs, network)
+	// Sorting happens in place, so avoid modifying the shared slice (filtering
+	// by address family already copies it).
+	if network == "ip" {
+		addrs = slices.Clone(addrs)
+	} else {
+		addrs = address.FilterByNetwork(addrs, network)
+	}
+
+	if network != "ip4" {
+		r.sorter.sort(ctx, addrs)
+	}
+
+	return addrs, nil
+}
 
-	if network != "ip4" && len(addrs) > 0 {
-		dial := func(network, address string) (net.Conn, error) {
-			return r.dialContext(ctx, network, address)
+// LookupAddr returns the names mapping to addr, in the order they were added.
+func (r *HostsResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:  "unrecognized address",
+			Name: addr,
 		}
+	}
+
+	t := r.table.Load()
 
-		addrselect.SortByRFC6724(dial, addrs)
+	names := slices.Clone(t.addrToName[ip.Unmap().WithZone("")])
+	if len(t.expires) > 0 {
+		now := time.Now()
+		names = slices.DeleteFunc(names, func(name string) bool {
+			return t.expired(hostKey(name), now)
+		})
 	}
 
-	return addrs, nil
+	if len(names) == 0 {
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       addr,
+			IsNotFound: true,
+		}
+	}
+
+	return names, nil
 }
 
 // AddHost adds an ephemeral host to the resolver with the given addresses.
+// A host prefixed with "*." is a wildcard that matches any subdomain.
 func (r *HostsResolver) AddHost(host string, addrs ...netip.Addr) {
-	r.mu.Lock()
-	r.nameToAddr[dns.Fqdn(host)] = addrs
-	r.mu.Unlock()
+	r.update(func(t *hostsTable) {
+		t.setHost(host, addrs)
+		delete(t.expires, hostKey(host))
+	})
 }
 
 // RemoveHost removes an ephemeral host from the resolver.
 func (r *HostsResolver) RemoveHost(host string) {
+	key := hostKey(host)
+
+	r.update(func(t *hostsTable) {
+		delete(t.expires, key)
+
+		if suffix, ok := wildcardSuffix(key); ok {
+			delete(t.wildcards, suffix)
+			return
+		}
+
+		t.removeHost(key)
+	})
+}
+
+// update applies fn to a copy of the table, which then replaces it.
+func (r *HostsResolver) update(fn func(t *hostsTable)) {
 	r.mu.Lock()
-	delete(r.nameToAddr, dns.Fqdn(host))
-	r.mu.Unlock()
+	defer r.mu.Unlock()
+
+	t := r.table.Load().clone()
+	fn(t)
+	r.table.Store(t)
+}
+
+// clone returns a copy of the table, that can be modified without affecting
+// the original.
+func (t *hostsTable) clone() *hostsTable {
+	return &hostsTable{
+		nameToAddr: maps.Clone(t.nameToAddr),
+		addrToName: maps.Clone(t.addrToName),
+		wildcards:  maps.Clone(t.wildcards),
+		expires:    maps.Clone(t.expires),
+	}
+}
+
+// setHost replaces the addresses of host.
+func (t *hostsTable) setHost(host string, addrs []netip.Addr) {
+	name := dns.Fqdn(host)
+	key := hostKey(name)
+
+	if suffix, ok := wildcardSuffix(key); ok {
+		t.wildcards[suffix] = slices.Clone(addrs)
+		return
+	}
+
+	t.removeHost(key)
+	t.nameToAddr[key] = make([]netip.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		t.addHost(name, addr)
+	}
+}
+
+// lookup returns the addresses of name, exact entries take precedence over
+// wildcards, and more specific wildcards over less specific ones. Names are
+// matched case-insensitively.
+func (t *hostsTable) lookup(name string) ([]netip.Addr, bool) {
+	// Expired hosts are ignored, even if they haven't been removed yet.
+	var now time.Time
+	if len(t.expires) > 0 {
+		now = time.Now()
+	}
+
+	key := hostKey(name)
+	if addrs, ok := t.nameToAddr[key]; ok && !t.expired(key, now) {
+		return addrs, true
+	}
+
+	if len(t.wildcards) == 0 {
+		return nil, false
+	}
+
+	for i := strings.IndexByte(key, '.'); i >= 0; i = strings.IndexByte(key, '.') {
+		key = key[i+1:]
+		if addrs, ok := t.wildcards[key]; ok && !t.expired("*."+key, now) {
+			return addrs, true
+		}
+	}
+
+	return nil, false
+}
+
+// addHost adds addr to name. Names are keyed by their normalized form (see
+// hostKey), but reverse lookups return them as they were added.
+func (t *hostsTable) addHost(name string, addr netip.Addr) {
+	key := hostKey(name)
+	addrKey := addr.Unmap().WithZone("")
+
+	// The reverse entry exists if the name already has the address (checking
+	// the addresses of the name, rather than the possibly many names of the
+	// address, eg. 0.0.0.0 in blocklists).
+	if !slices.ContainsFunc(t.nameToAddr[key], func(a netip.Addr) bool {
+		return a.Unmap().WithZone("") == addrKey
+	}) {
+		t.addrToName[addrKey] = append(t.addrToName[addrKey], name)
+	}
+
+	t.nameToAddr[key] = append(t.nameToAddr[key], addr)
+}
+
+// removeHost removes the name with the key.
+func (t *hostsTable) removeHost(key string) {
+	for _, addr := range t.nameToAddr[key] {
+		addrKey := addr.Unmap().WithZone("")
+
+		names := slices.DeleteFunc(slices.Clone(t.addrToName[addrKey]), func(n string) bool {
+			return hostKey(n) == key
+		})
+		if len(names) == 0 {
+			delete(t.addrToName, addrKey)
+		} else {
+			t.addrToName[addrKey] = names
+		}
+	}
+
+	delete(t.nameToAddr, key)
+}
+
+// hostKey returns the key of a host name, its lowercase form without the
+// trailing dot. Names are normalized when they are added, so that lookups of
+// (typically already lowercase) names don't allocate.
+func hostKey(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// wildcardSuffix returns the suffix matched by a wildcard name.
+func wildcardSuffix(name string) (string, bool) {
+	suffix, ok := strings.CutPrefix(name, "*.")
+	return suffix, ok && suffix != ""
+}
+
+func (r *HostsResolver) Describe() Description {
+	t := r.table.Load()
+	hosts := len(t.nameToAddr) + len(t.wildcards)
+
+	return Description{
+		Type: "hosts",
+		Attributes: map[string]string{
+			"hosts":         strconv.Itoa(hosts),
+			"address-order": string(r.sorter.order),
+		},
+	}
 }
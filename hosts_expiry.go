@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"math"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+type hostExpiry struct {
+	// host is the host as it was added.
+	host    string
+	expires time.Time
+}
+
+// AddHostWithTTL adds an ephemeral host to the resolver with the given
+// addresses, that is automatically removed after ttl (eg. so that the records
+// of a mesh peer disappear when it goes away). Adding the host again renews
+// (or with AddHost, removes) its expiry.
+func (r *HostsResolver) AddHostWithTTL(host string, ttl time.Duration, addrs ...netip.Addr) {
+	r.update(func(t *hostsTable) {
+		t.setHost(host, addrs)
+		t.expires[hostKey(host)] = hostExpiry{
+			host:    host,
+			expires: time.Now().Add(ttl),
+		}
+	})
+
+	r.janitorOnce.Do(func() {
+		r.wg.Add(1)
+		go r.janitor()
+	})
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops removing expired hosts in the background.
+func (r *HostsResolver) Close() error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	r.wg.Wait()
+
+	return nil
+}
+
+// janitor removes expired hosts, waking up when the next host expires (or a
+// host is added with a TTL).
+func (r *HostsResolver) janitor() {
+	defer r.wg.Done()
+
+	for {
+		wait := time.Duration(math.MaxInt64)
+		if next := r.removeExpired(); !next.IsZero() {
+			wait = time.Until(next)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.stop:
+			timer.Stop()
+			return
+		case <-r.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// removeExpired removes the expired hosts, and returns when the next host
+// expires (or the zero time if no hosts are expiring).
+func (r *HostsResolver) removeExpired() time.Time {
+	type expiredHost struct {
+		host  string
+		addrs []netip.Addr
+	}
+
+	now := time.Now()
+
+	var expired []expiredHost
+	var next time.Time
+
+	r.mu.Lock()
+	t := r.table.Load()
+	for key, expiry := range t.expires {
+		if now.Before(expiry.expires) {
+			if next.IsZero() || expiry.expires.Before(next) {
+				next = expiry.expires
+			}
+			continue
+		}
+
+		// Only copy the table if there is anything to remove.
+		if len(expired) == 0 {
+			t = t.clone()
+		}
+
+		var addrs []netip.Addr
+		if suffix, ok := wildcardSuffix(key); ok {
+			addrs = t.wildcards[suffix]
+			delete(t.wildcards, suffix)
+		} else {
+			addrs = t.nameToAddr[key]
+			t.removeHost(key)
+		}
+		delete(t.expires, key)
+
+		expired = append(expired, expiredHost{host: expiry.host, addrs: addrs})
+	}
+	if len(expired) > 0 {
+		r.table.Store(t)
+	}
+	r.mu.Unlock()
+
+	if r.onExpire != nil {
+		// Sorted for a deterministic callback order.
+		slices.SortFunc(expired, func(a, b expiredHost) int {
+			return strings.Compare(a.host, b.host)
+		})
+
+		for _, host := range expired {
+			r.onExpire(host.host, slices.Clone(host.addrs))
+		}
+	}
+
+	return next
+}
+
+// expired reports whether the host with the expiry key has expired.
+func (t *hostsTable) expired(key string, now time.Time) bool {
+	expiry, ok := t.expires[key]
+	return ok && !now.Before(expiry.expires)
+}
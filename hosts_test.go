@@ -11,11 +11,15 @@ package resolver_test
 
 import (
 	"context"
+	"fmt"
 	"net/netip"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/util/ptr"
 	"github.com/stretchr/testify/require"
 )
 
@@ -55,3 +59,277 @@ func TestHostsResolver(t *testing.T) {
 	_, err = res.LookupNetIP(context.Background(), "ip", "api2.testserver.local")
 	require.Error(t, err)
 }
+
+func TestHostsResolverMalformedHostsFile(t *testing.T) {
+	events := resolver.NewEvents()
+
+	var published []resolver.Event
+	events.Subscribe(func(event resolver.Event) {
+		published = append(published, event)
+	})
+
+	res, err := resolver.Hosts(&resolver.HostsResolverConfig{
+		HostsFileReader: strings.NewReader("10.0.0.0/8 corp\n10.0.0.1 api.corp # the api\n"),
+		Events:          events,
+	})
+	require.NoError(t, err)
+
+	// The malformed line doesn't break the rest of the hosts file.
+	addrs, err := res.LookupNetIP(context.Background(), "ip", "api.corp")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+	require.Len(t, published, 1)
+	require.Equal(t, resolver.EventHostsFileInvalidEntry, published[0].Type)
+	require.ErrorContains(t, published[0].Err, "line 1: ")
+	require.ErrorContains(t, published[0].Err, "CIDR prefixes are not supported")
+}
+
+func TestHostsResolverWildcards(t *testing.T) {
+	ctx := context.Background()
+
+	res, err := resolver.Hosts(&resolver.HostsResolverConfig{
+		NoHostsFile: ptr.To(true),
+		Hosts: map[string][]netip.Addr{
+			"*.dev.internal":     {netip.MustParseAddr("10.0.0.5")},
+			"*.api.dev.internal": {netip.MustParseAddr("10.0.0.6")},
+			"db.dev.internal":    {netip.MustParseAddr("10.0.0.7")},
+		},
+	})
+	require.NoError(t, err)
+
+	for host, expected := range map[string]string{
+		"app.dev.internal":        "10.0.0.5",
+		"a.b.dev.internal.":       "10.0.0.5",
+		"v1.api.dev.internal":     "10.0.0.6",
+		"api.dev.internal":        "10.0.0.5",
+		"db.dev.internal":         "10.0.0.7",
+		"replica.db.dev.internal": "10.0.0.5",
+	} {
+		addrs, err := res.LookupNetIP(ctx, "ip", host)
+		require.NoError(t, err, host)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr(expected)}, addrs, host)
+	}
+
+	// The suffix itself is not matched.
+	_, err = res.LookupNetIP(ctx, "ip", "dev.internal")
+	require.Error(t, err)
+
+	res.RemoveHost("*.dev.internal")
+
+	_, err = res.LookupNetIP(ctx, "ip", "app.dev.internal")
+	require.Error(t, err)
+
+	res.AddHost("*.test.internal", netip.MustParseAddr("10.0.1.1"))
+
+	addrs, err := res.LookupNetIP(ctx, "ip", "app.test.internal")
+	require.NoError(t, err)
+
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.1.1")}, addrs)
+}
+
+func TestHostsResolverExpiry(t *testing.T) {
+	type expiredHost struct {
+		host  string
+		addrs []netip.Addr
+	}
+	expired := make(chan expiredHost, 10)
+
+	res, err := resolver.Hosts(&resolver.HostsResolverConfig{
+		NoHostsFile: ptr.To(true),
+		OnExpire: func(host string, addrs []netip.Addr) {
+			expired <- expiredHost{host: host, addrs: addrs}
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, res.Close())
+	})
+
+	ctx := context.Background()
+
+	res.AddHostWithTTL("peer1.mesh.internal", 100*time.Millisecond, netip.MustParseAddr("100.64.0.1"))
+	res.AddHostWithTTL("*.peer2.mesh.internal", 100*time.Millisecond, netip.MustParseAddr("100.64.0.2"))
+	res.AddHostWithTTL("peer3.mesh.internal", time.Hour, netip.MustParseAddr("100.64.0.3"))
+
+	addrs, err := res.LookupNetIP(ctx, "ip", "peer1.mesh.internal")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("100.64.0.1")}, addrs)
+
+	_, err = res.LookupNetIP(ctx, "ip", "svc.peer2.mesh.internal")
+	require.NoError(t, err)
+
+	var hosts []expiredHost
+	for len(hosts) < 2 {
+		select {
+		case host := <-expired:
+			hosts = append(hosts, host)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for hosts to expire")
+		}
+	}
+
+	require.ElementsMatch(t, []expiredHost{
+		{host: "peer1.mesh.internal", addrs: []netip.Addr{netip.MustParseAddr("100.64.0.1")}},
+		{host: "*.peer2.mesh.internal", addrs: []netip.Addr{netip.MustParseAddr("100.64.0.2")}},
+	}, hosts)
+
+	_, err = res.LookupNetIP(ctx, "ip", "peer1.mesh.internal")
+	require.Error(t, err)
+
+	_, err = res.LookupNetIP(ctx, "ip", "svc.peer2.mesh.internal")
+	require.Error(t, err)
+
+	_, err = res.LookupAddr(ctx, "100.64.0.1")
+	require.Error(t, err)
+
+	// Hosts that haven't expired are unaffected.
+	addrs, err = res.LookupNetIP(ctx, "ip", "peer3.mesh.internal")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("100.64.0.3")}, addrs)
+
+	t.Run("Renewed", func(t *testing.T) {
+		res.AddHostWithTTL("peer4.mesh.internal", 50*time.Millisecond, netip.MustParseAddr("100.64.0.4"))
+
+		// Adding the host without a TTL removes its expiry.
+		res.AddHost("peer4.mesh.internal", netip.MustParseAddr("100.64.0.4"))
+
+		time.Sleep(100 * time.Millisecond)
+
+		addrs, err := res.LookupNetIP(ctx, "ip", "peer4.mesh.internal")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("100.64.0.4")}, addrs)
+	})
+}
+
+func BenchmarkHostsResolver(b *testing.B) {
+	hosts := make(map[string][]netip.Addr, 1024)
+	names := make([]string, 0, 1024)
+	for i := 0; i < 1024; i++ {
+		name := fmt.Sprintf("host%d.example.com", i)
+		hosts[name] = []netip.Addr{netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)})}
+		names = append(names, name)
+	}
+
+	res, err := resolver.Hosts(&resolver.HostsResolverConfig{
+		NoHostsFile:  ptr.To(true),
+		Hosts:        hosts,
+		AddressOrder: ptr.To(resolver.AddressOrderNone),
+	})
+	require.NoError(b, err)
+
+	lookup := func(b *testing.B) {
+		b.ReportAllocs()
+
+		b.RunParallel(func(pb *testing.PB) {
+			var i int
+			for pb.Next() {
+				if _, err := res.LookupNetIP(context.Background(), "ip4", names[i%len(names)]); err != nil {
+					b.Fatal(err)
+				}
+				i++
+			}
+		})
+	}
+
+	b.Run("Lookup", lookup)
+
+	b.Run("Lookup With Writer", func(b *testing.B) {
+		// A host is added and removed continuously during the lookups.
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				res.AddHost("ephemeral.example.com", netip.MustParseAddr("10.1.0.1"))
+				res.RemoveHost("ephemeral.example.com")
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		b.Cleanup(func() {
+			close(stop)
+			<-done
+		})
+
+		lookup(b)
+	})
+}
+
+// BenchmarkHostsResolverBlocklist uses a hosts file with 100k entries mapping
+// names to 0.0.0.0, typical of blocklists converted to hosts files.
+func BenchmarkHostsResolverBlocklist(b *testing.B) {
+	const entries = 100_000
+
+	var sb strings.Builder
+	names := make([]string, 0, entries)
+	for i := 0; i < entries; i++ {
+		name := fmt.Sprintf("ads%d.tracker.example", i)
+		fmt.Fprintf(&sb, "0.0.0.0 %s\n", name)
+		names = append(names, name)
+	}
+	hostsFile := sb.String()
+
+	newResolver := func(b *testing.B) *resolver.HostsResolver {
+		res, err := resolver.Hosts(&resolver.HostsResolverConfig{
+			HostsFileReader: strings.NewReader(hostsFile),
+			AddressOrder:    ptr.To(resolver.AddressOrderNone),
+		})
+		require.NoError(b, err)
+		return res
+	}
+
+	b.Run("Load", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			_ = newResolver(b)
+		}
+	})
+
+	res := newResolver(b)
+
+	lookup := func(b *testing.B, names []string, wantErr bool) {
+		b.ReportAllocs()
+
+		b.RunParallel(func(pb *testing.PB) {
+			var i int
+			for pb.Next() {
+				_, err := res.LookupNetIP(context.Background(), "ip4", names[i%len(names)])
+				if (err != nil) != wantErr {
+					b.Fatal(err)
+				}
+				i++
+			}
+		})
+	}
+
+	b.Run("Lookup", func(b *testing.B) {
+		lookup(b, names, false)
+	})
+
+	b.Run("Lookup Mixed Case", func(b *testing.B) {
+		mixedCase := make([]string, len(names))
+		for i, name := range names {
+			mixedCase[i] = strings.ToUpper(name[:1]) + name[1:]
+		}
+
+		lookup(b, mixedCase, false)
+	})
+
+	b.Run("Lookup Miss", func(b *testing.B) {
+		misses := make([]string, len(names))
+		for i, name := range names {
+			misses[i] = "www." + name + ".invalid"
+		}
+
+		lookup(b, misses, true)
+	})
+}
@@ -46,19 +46,40 @@ package addrselect
 import (
 	stdnet "net"
 	"net/netip"
+	"slices"
 	"sort"
 )
 
 type DialFunc func(network, address string) (stdnet.Conn, error)
 
 func SortByRFC6724(dial DialFunc, addrs []netip.Addr) {
+	SortByRFC6724WithPolicyTable(dial, addrs, rfc6724policyTable)
+}
+
+// SortByRFC6724WithPolicyTable sorts addrs using the provided policy table
+// rather than the default one from RFC 6724.
+func SortByRFC6724WithPolicyTable(dial DialFunc, addrs []netip.Addr, table PolicyTable) {
+	if len(addrs) < 2 {
+		return
+	}
+	sortByRFC6724withSrcs(addrs, srcAddrs(dial, addrs), table)
+}
+
+// SortByRFC6724WithSourceAddrs sorts addrs using the provided source
+// addresses (an invalid address marks a destination as unreachable) rather
+// than determining them by dialing.
+func SortByRFC6724WithSourceAddrs(addrs []netip.Addr, srcs []netip.Addr, table PolicyTable) {
 	if len(addrs) < 2 {
 		return
 	}
-	SortByRFC6724withSrcs(dial, addrs, srcAddrs(dial, addrs))
+	sortByRFC6724withSrcs(addrs, srcs, table)
 }
 
 func SortByRFC6724withSrcs(dial DialFunc, addrs []netip.Addr, srcs []netip.Addr) {
+	sortByRFC6724withSrcs(addrs, srcs, rfc6724policyTable)
+}
+
+func sortByRFC6724withSrcs(addrs []netip.Addr, srcs []netip.Addr, table PolicyTable) {
 	if len(addrs) != len(srcs) {
 		panic("internal error")
 	}
@@ -66,8 +87,8 @@ func SortByRFC6724withSrcs(dial DialFunc, addrs []netip.Addr, srcs []netip.Addr)
 	srcAttr := make([]ipAttr, len(srcs))
 	for i, v := range addrs {
 		addrAttrIP, _ := netip.AddrFromSlice(v.AsSlice())
-		addrAttr[i] = ipAttrOf(addrAttrIP)
-		srcAttr[i] = ipAttrOf(srcs[i])
+		addrAttr[i] = ipAttrOf(table, addrAttrIP)
+		srcAttr[i] = ipAttrOf(table, srcs[i])
 	}
 	sort.Stable(&byRFC6724{
 		addrs:    addrs,
@@ -94,17 +115,75 @@ func srcAddrs(dial DialFunc, addrs []netip.Addr) []netip.Addr {
 	return srcs
 }
 
+// SelectSourceAddr chooses the source address for dst from the candidate
+// local prefixes using the applicable rules from RFC 6724 section 5 (same
+// address, appropriate scope, matching label and longest matching prefix).
+// An invalid address is returned if no candidate is suitable.
+func SelectSourceAddr(table PolicyTable, dst netip.Addr, candidates []netip.Prefix) netip.Addr {
+	dst = dst.Unmap()
+	dstScope := classifyScope(dst)
+	dstLabel := table.Classify(dst).Label
+
+	var best netip.Addr
+	var bestScope scope
+	var bestLabelMatch bool
+	var bestPrefixLen int
+	for _, candidate := range candidates {
+		src := candidate.Addr().Unmap()
+		if src.Is4() != dst.Is4() {
+			continue
+		}
+
+		// Rule 1: Prefer same address.
+		if src == dst {
+			return src
+		}
+
+		// Without a routing table, assume that destinations with a wider scope
+		// than the source address are unreachable (eg. a global destination
+		// with only a link-local or loopback address).
+		srcScope := classifyScope(src)
+		if srcScope < dstScope {
+			continue
+		}
+
+		labelMatch := table.Classify(src).Label == dstLabel
+		prefixLen := commonPrefixLen(src, dst)
+
+		if best.IsValid() {
+			// Rule 2: Prefer appropriate scope.
+			if srcScope != bestScope {
+				if srcScope > bestScope {
+					continue
+				}
+			} else if labelMatch != bestLabelMatch {
+				// Rule 6: Prefer matching label.
+				if !labelMatch {
+					continue
+				}
+			} else if prefixLen <= bestPrefixLen {
+				// Rule 8: Use longest matching prefix.
+				continue
+			}
+		}
+
+		best, bestScope, bestLabelMatch, bestPrefixLen = src, srcScope, labelMatch, prefixLen
+	}
+
+	return best
+}
+
 type ipAttr struct {
 	Scope      scope
 	Precedence uint8
 	Label      uint8
 }
 
-func ipAttrOf(ip netip.Addr) ipAttr {
+func ipAttrOf(table PolicyTable, ip netip.Addr) ipAttr {
 	if !ip.IsValid() {
 		return ipAttr{}
 	}
-	match := rfc6724policyTable.Classify(ip)
+	match := table.Classify(ip)
 	return ipAttr{
 		Scope:      classifyScope(ip),
 		Precedence: match.Precedence,
@@ -252,17 +331,45 @@ func (s *byRFC6724) Less(i, j int) bool {
 	return false // "equal"
 }
 
-type policyTableEntry struct {
+// PolicyTableEntry is an entry in the address selection policy table.
+type PolicyTableEntry struct {
 	Prefix     netip.Prefix
 	Precedence uint8
 	Label      uint8
 }
 
-type policyTable []policyTableEntry
+// PolicyTable is an address selection policy table, as described in RFC 6724
+// section 2.1.
+type PolicyTable []PolicyTableEntry
+
+// NewPolicyTable returns a policy table containing the provided entries.
+// IPv4 prefixes are converted to their IPv4-mapped IPv6 equivalents and the
+// entries are sorted from the longest to the shortest prefix.
+func NewPolicyTable(entries []PolicyTableEntry) PolicyTable {
+	t := make(PolicyTable, len(entries))
+	for i, ent := range entries {
+		if ent.Prefix.Addr().Is4() {
+			ent.Prefix = netip.PrefixFrom(netip.AddrFrom16(ent.Prefix.Addr().As16()), ent.Prefix.Bits()+96)
+		}
+		t[i] = ent
+	}
+
+	slices.SortStableFunc(t, func(a, b PolicyTableEntry) int {
+		return b.Prefix.Bits() - a.Prefix.Bits()
+	})
+
+	return t
+}
+
+// DefaultPolicyTable returns a copy of the default policy table from RFC 6724
+// section 2.1.
+func DefaultPolicyTable() PolicyTable {
+	return slices.Clone(rfc6724policyTable)
+}
 
 // RFC 6724 section 2.1.
 // Items are sorted by the size of their Prefix.Mask.Size,
-var rfc6724policyTable = policyTable{
+var rfc6724policyTable = PolicyTable{
 	{
 		// "::1/128"
 		Prefix:     netip.PrefixFrom(netip.AddrFrom16([16]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01}), 128),
@@ -322,10 +429,10 @@ var rfc6724policyTable = policyTable{
 	},
 }
 
-// Classify returns the policyTableEntry of the entry with the longest
+// Classify returns the PolicyTableEntry of the entry with the longest
 // matching prefix that contains ip.
 // The table t must be sorted from largest mask size to smallest.
-func (t policyTable) Classify(ip netip.Addr) policyTableEntry {
+func (t PolicyTable) Classify(ip netip.Addr) PolicyTableEntry {
 	// Prefix.Contains() will not match an IPv6 prefix for an IPv4 address.
 	if ip.Is4() {
 		ip = netip.AddrFrom16(ip.As16())
@@ -335,7 +442,7 @@ func (t policyTable) Classify(ip netip.Addr) policyTableEntry {
 			return ent
 		}
 	}
-	return policyTableEntry{}
+	return PolicyTableEntry{}
 }
 
 // RFC 6724 section 3.1.
@@ -188,8 +188,101 @@ func TestRFC6724PolicyTableOrder(t *testing.T) {
 	}
 }
 
+func TestNewPolicyTable(t *testing.T) {
+	table := NewPolicyTable(append(DefaultPolicyTable(),
+		PolicyTableEntry{
+			Prefix:     netip.MustParsePrefix("10.0.0.0/8"),
+			Precedence: 45,
+			Label:      14,
+		},
+		PolicyTableEntry{
+			Prefix:     netip.MustParsePrefix("fd00::/8"),
+			Precedence: 45,
+			Label:      1,
+		},
+	))
+
+	for i := 0; i < len(table)-1; i++ {
+		if !(table[i].Prefix.Bits() >= table[i+1].Prefix.Bits()) {
+			t.Errorf("table item number %d sorted in wrong order = %d bits, next item = %d bits;", i, table[i].Prefix.Bits(), table[i+1].Prefix.Bits())
+		}
+	}
+
+	if got := table.Classify(netip.MustParseAddr("10.1.2.3")); got.Precedence != 45 || got.Label != 14 {
+		t.Errorf("Classify(10.1.2.3) = %v; want precedence 45, label 14", got)
+	}
+
+	// Prefer a ULA destination over a global one, when both have a matching
+	// source.
+	in := []netip.Addr{
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("fd00::1"),
+	}
+	srcs := []netip.Addr{
+		netip.MustParseAddr("2001:db8::100"),
+		netip.MustParseAddr("fd00::100"),
+	}
+
+	sortByRFC6724withSrcs(in, srcs, table)
+
+	want := []netip.Addr{
+		netip.MustParseAddr("fd00::1"),
+		netip.MustParseAddr("2001:db8::1"),
+	}
+	if !reflect.DeepEqual(in, want) {
+		t.Errorf("got: %s\nwant: %s", in, want)
+	}
+}
+
+func TestSelectSourceAddr(t *testing.T) {
+	candidates := []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.1/8"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("192.168.1.10/24"),
+		netip.MustParsePrefix("fe80::1/64"),
+		netip.MustParsePrefix("2001:db8:1::10/64"),
+		netip.MustParsePrefix("2001:db8:2::10/64"),
+	}
+
+	tests := []struct {
+		dst  string
+		want netip.Addr
+	}{
+		// Same address.
+		{"192.168.1.10", netip.MustParseAddr("192.168.1.10")},
+		// Loopback.
+		{"127.0.0.53", netip.MustParseAddr("127.0.0.1")},
+		{"::1", netip.MustParseAddr("::1")},
+		// IPv4 (including IPv4-mapped).
+		{"198.51.100.1", netip.MustParseAddr("192.168.1.10")},
+		{"::ffff:198.51.100.1", netip.MustParseAddr("192.168.1.10")},
+		// Appropriate scope.
+		{"fe80::2", netip.MustParseAddr("fe80::1")},
+		// Longest matching prefix.
+		{"2001:db8:2::1", netip.MustParseAddr("2001:db8:2::10")},
+		// Matching label (6to4 destination has no matching source).
+		{"2002:c000:0201::1", netip.MustParseAddr("2001:db8:1::10")},
+	}
+	for _, tt := range tests {
+		got := SelectSourceAddr(rfc6724policyTable, netip.MustParseAddr(tt.dst), candidates)
+		if got != tt.want {
+			t.Errorf("SelectSourceAddr(%s) = %v; want %v", tt.dst, got, tt.want)
+		}
+	}
+
+	// Global destinations are unreachable with only loopback/link-local
+	// addresses.
+	got := SelectSourceAddr(rfc6724policyTable, netip.MustParseAddr("2001:db8::1"), []netip.Prefix{
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("fe80::1/64"),
+	})
+	if got.IsValid() {
+		t.Errorf("SelectSourceAddr(2001:db8::1) = %v; want invalid", got)
+	}
+}
+
 func TestRFC6724PolicyTableContent(t *testing.T) {
-	expectedRfc6724policyTable := policyTable{
+	expectedRfc6724policyTable := PolicyTable{
 		{
 			Prefix:     netip.MustParsePrefix("::1/128"),
 			Precedence: 50,
@@ -244,11 +337,11 @@ func TestRFC6724PolicyTableContent(t *testing.T) {
 func TestRFC6724PolicyTableClassify(t *testing.T) {
 	tests := []struct {
 		ip   netip.Addr
-		want policyTableEntry
+		want PolicyTableEntry
 	}{
 		{
 			ip: netip.MustParseAddr("127.0.0.1"),
-			want: policyTableEntry{
+			want: PolicyTableEntry{
 				Prefix:     netip.MustParsePrefix("::ffff:0:0/96"),
 				Precedence: 35,
 				Label:      4,
@@ -256,7 +349,7 @@ func TestRFC6724PolicyTableClassify(t *testing.T) {
 		},
 		{
 			ip: netip.MustParseAddr("2601:645:8002:a500:986f:1db8:c836:bd65"),
-			want: policyTableEntry{
+			want: PolicyTableEntry{
 				Prefix:     netip.MustParsePrefix("::/0"),
 				Precedence: 40,
 				Label:      1,
@@ -264,7 +357,7 @@ func TestRFC6724PolicyTableClassify(t *testing.T) {
 		},
 		{
 			ip: netip.MustParseAddr("::1"),
-			want: policyTableEntry{
+			want: PolicyTableEntry{
 				Prefix:     netip.MustParsePrefix("::1/128"),
 				Precedence: 50,
 				Label:      0,
@@ -272,7 +365,7 @@ func TestRFC6724PolicyTableClassify(t *testing.T) {
 		},
 		{
 			ip: netip.MustParseAddr("2002::ab12"),
-			want: policyTableEntry{
+			want: PolicyTableEntry{
 				Prefix:     netip.MustParsePrefix("2002::/16"),
 				Precedence: 30,
 				Label:      2,
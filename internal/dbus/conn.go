@@ -0,0 +1,282 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dbus
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SystemBusSocket is the default path of the system bus socket.
+const SystemBusSocket = "/var/run/dbus/system_bus_socket"
+
+// Error is an error reply to a method call.
+type Error struct {
+	// Name is the name of the error, eg. "org.freedesktop.DBus.Error.Failed".
+	Name string
+	// Message is the optional human readable error message.
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// ErrClosed is returned by calls on a closed connection.
+var ErrClosed = errors.New("connection closed")
+
+// Conn is a connection to a message bus. It is safe for concurrent use,
+// replies are dispatched to the calls waiting for them.
+type Conn struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	serial  uint32
+	pending map[uint32]chan *Message
+	err     error
+	done    chan struct{}
+}
+
+// Dial connects (and authenticates) to the message bus listening on the unix
+// socket at path.
+func Dial(ctx context.Context, path string) (*Conn, error) {
+	nc, err := (&net.Dialer{}).DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		conn:    nc,
+		pending: make(map[uint32]chan *Message),
+		done:    make(chan struct{}),
+	}
+
+	if err := c.auth(ctx); err != nil {
+		_ = nc.Close()
+		return nil, contextErr(ctx, err)
+	}
+
+	go c.readLoop()
+
+	if _, err := c.Call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus",
+		"org.freedesktop.DBus", "Hello"); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Close closes the connection, failing pending calls.
+func (c *Conn) Close() error {
+	c.fail(ErrClosed)
+	return c.conn.Close()
+}
+
+// Err returns the error that broke the connection (eg. ErrClosed), or nil if
+// the connection is usable.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Call calls a method and returns the body of the reply. Error replies are
+// returned as an *Error.
+func (c *Conn) Call(ctx context.Context, destination string, path ObjectPath, iface, method string, args ...any) ([]any, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.serial++
+	serial := c.serial
+	replyCh := make(chan *Message, 1)
+	c.pending[serial] = replyCh
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, serial)
+		c.mu.Unlock()
+	}()
+
+	b, err := (&Message{
+		Type:        TypeMethodCall,
+		Serial:      serial,
+		Path:        path,
+		Interface:   iface,
+		Member:      method,
+		Destination: destination,
+		Body:        args,
+	}).Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.write(ctx, b); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replyCh:
+		if reply.Type == TypeError {
+			dbusErr := &Error{Name: reply.ErrorName}
+			if len(reply.Body) > 0 {
+				dbusErr.Message, _ = reply.Body[0].(string)
+			}
+			return nil, dbusErr
+		}
+		return reply.Body, nil
+	case <-c.done:
+		return nil, c.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// write writes a message, interrupting the write when ctx is done. A partially
+// written message corrupts the stream, so the connection is then closed.
+func (c *Conn) write(ctx context.Context, b []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	_ = c.conn.SetWriteDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.conn.SetWriteDeadline(time.Unix(1, 0))
+	})
+	_, err := c.conn.Write(b)
+	stop()
+	_ = c.conn.SetWriteDeadline(time.Time{})
+
+	if err != nil {
+		_ = c.conn.Close()
+		c.fail(err)
+		return contextErr(ctx, err)
+	}
+
+	return nil
+}
+
+// readLoop dispatches replies to the calls waiting for them, until the
+// connection breaks.
+func (c *Conn) readLoop() {
+	for {
+		msg, err := ReadMessage(c.conn)
+		if err != nil {
+			_ = c.conn.Close()
+			c.fail(err)
+			return
+		}
+
+		// Skip signals, and replies to abandoned calls.
+		if msg.Type != TypeMethodReturn && msg.Type != TypeError {
+			continue
+		}
+
+		c.mu.Lock()
+		replyCh, ok := c.pending[msg.ReplySerial]
+		delete(c.pending, msg.ReplySerial)
+		c.mu.Unlock()
+
+		if ok {
+			replyCh <- msg
+		}
+	}
+}
+
+// fail marks the connection as broken by err (unless it already is), waking
+// up pending calls.
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	close(c.done)
+}
+
+// auth authenticates using the EXTERNAL mechanism (ie. the credentials of the
+// unix socket).
+func (c *Conn) auth(ctx context.Context) error {
+	deadline, _ := ctx.Deadline()
+	_ = c.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer func() {
+		stop()
+		_ = c.conn.SetDeadline(time.Time{})
+	}()
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(c.conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		return err
+	}
+
+	line, err := ReadLine(c.conn)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("authentication failed: %s", line)
+	}
+
+	_, err = fmt.Fprint(c.conn, "BEGIN\r\n")
+	return err
+}
+
+// ReadLine reads a line of the authentication protocol, without reading past
+// the end of the line (into the messages that follow).
+func ReadLine(conn net.Conn) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for !strings.HasSuffix(string(line), "\r\n") {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+
+		line = append(line, b[0])
+		if len(line) > 1024 {
+			return "", fmt.Errorf("authentication line too long")
+		}
+	}
+
+	return strings.TrimSuffix(string(line), "\r\n"), nil
+}
+
+// contextErr returns the context's error if ctx is done (as the error is then
+// caused by the interrupted read or write), otherwise err.
+func contextErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
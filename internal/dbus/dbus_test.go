@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dbus_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver/internal/dbus"
+	"github.com/noisysockets/resolver/internal/dbus/dbustest"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	msg := &dbus.Message{
+		Type:        dbus.TypeMethodCall,
+		Serial:      7,
+		Path:        "/",
+		Interface:   "org.freedesktop.Avahi.Server",
+		Member:      "ResolveHostName",
+		Destination: "org.freedesktop.Avahi",
+		Body:        []any{int32(-1), uint32(4), "printer.local", byte(1), true, dbus.ObjectPath("/a")},
+	}
+
+	// Container types.
+	containers := &dbus.Message{
+		Type:   dbus.TypeMethodCall,
+		Serial: 8,
+		Path:   "/org/freedesktop/resolve1",
+		Member: "SetLinkDNS",
+		Body: []any{
+			int32(3),
+			dbus.Array{Elem: "(iay)", Elems: []any{
+				dbus.Struct{int32(2), []byte{10, 0, 0, 1}},
+				dbus.Struct{int32(10), []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+			}},
+			dbus.Array{Elem: "{sv}", Elems: []any{
+				dbus.DictEntry{Key: "ids", Value: dbus.Variant{Value: dbus.Array{Elem: "s"}}},
+				dbus.DictEntry{Key: "priority", Value: dbus.Variant{Value: int32(-50)}},
+				dbus.DictEntry{Key: "port", Value: dbus.Variant{Value: uint16(853)}},
+				dbus.DictEntry{Key: "timestamp", Value: dbus.Variant{Value: uint64(1 << 40)}},
+			}},
+			dbus.Signature("a(iay)"),
+		},
+	}
+
+	b, err := containers.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dbus.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, containers) {
+		t.Errorf("got: %+v\nwant: %+v", got, containers)
+	}
+
+	// Arrays must be homogeneous.
+	if _, err := (&dbus.Message{Body: []any{dbus.Array{Elem: "s", Elems: []any{int32(1)}}}}).Marshal(); err == nil {
+		t.Error("expected an error marshaling a heterogeneous array")
+	}
+
+	b, err = msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = dbus.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, msg) {
+		t.Errorf("got: %+v\nwant: %+v", got, msg)
+	}
+
+	// Truncated messages are rejected.
+	if _, err := dbus.ReadMessage(bytes.NewReader(b[:len(b)-1])); err == nil {
+		t.Error("expected an error reading a truncated message")
+	}
+}
+
+func TestConnCall(t *testing.T) {
+	srv := dbustest.NewServer(t, func(call *dbus.Message) ([]any, *dbus.Error) {
+		switch call.Member {
+		case "Echo":
+			return call.Body, nil
+		case "Hang":
+			time.Sleep(time.Second)
+			return nil, nil
+		default:
+			return nil, &dbus.Error{Name: "org.example.Error.Unknown", Message: "unknown method"}
+		}
+	})
+
+	ctx := context.Background()
+
+	conn, err := dbus.Dial(ctx, srv.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	body, err := conn.Call(ctx, "org.example", "/", "org.example", "Echo", "hello", int32(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []any{"hello", int32(42)}; !reflect.DeepEqual(body, want) {
+		t.Errorf("got: %v\nwant: %v", body, want)
+	}
+
+	_, err = conn.Call(ctx, "org.example", "/", "org.example", "Missing")
+	var dbusErr *dbus.Error
+	if !errors.As(err, &dbusErr) || dbusErr.Name != "org.example.Error.Unknown" {
+		t.Errorf("got error %v; want org.example.Error.Unknown", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := conn.Call(timeoutCtx, "org.example", "/", "org.example", "Hang"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	// The connection is shared by concurrent calls (and the late reply to the
+	// abandoned call is skipped).
+	var wg sync.WaitGroup
+	for i := int32(0); i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			body, err := conn.Call(ctx, "org.example", "/", "org.example", "Echo", i)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if want := []any{i}; !reflect.DeepEqual(body, want) {
+				t.Errorf("got: %v\nwant: %v", body, want)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Call(ctx, "org.example", "/", "org.example", "Echo"); !errors.Is(err, dbus.ErrClosed) {
+		t.Errorf("got error %v; want %v", err, dbus.ErrClosed)
+	}
+}
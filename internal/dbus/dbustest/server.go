@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package dbustest provides an in-process message bus for tests.
+package dbustest
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/noisysockets/resolver/internal/dbus"
+)
+
+// HandlerFunc handles a method call, returning the body of the reply or an
+// error reply.
+type HandlerFunc func(call *dbus.Message) ([]any, *dbus.Error)
+
+// Server is a message bus that dispatches method calls to a handler. It
+// listens on a unix socket and is shut down when the test completes.
+type Server struct {
+	path        string
+	handler     HandlerFunc
+	connections atomic.Int32
+}
+
+// NewServer starts a new message bus.
+func NewServer(t testing.TB, handler HandlerFunc) *Server {
+	t.Helper()
+
+	s := &Server{
+		path:    filepath.Join(t.TempDir(), "bus"),
+		handler: handler,
+	}
+
+	l, err := net.Listen("unix", s.path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			s.connections.Add(1)
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+// Path returns the path of the server's unix socket.
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Connections returns the number of connections accepted so far.
+func (s *Server) Connections() int {
+	return int(s.connections.Load())
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	line, err := dbus.ReadLine(conn)
+	if err != nil || !strings.HasPrefix(line, "\x00AUTH EXTERNAL ") {
+		return
+	}
+
+	if _, err := conn.Write([]byte("OK 0123456789abcdef0123456789abcdef\r\n")); err != nil {
+		return
+	}
+
+	if line, err := dbus.ReadLine(conn); err != nil || line != "BEGIN" {
+		return
+	}
+
+	var serial uint32
+	for {
+		call, err := dbus.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+
+		if call.Type != dbus.TypeMethodCall {
+			continue
+		}
+
+		var body []any
+		var callErr *dbus.Error
+		if call.Member == "Hello" && call.Interface == "org.freedesktop.DBus" {
+			body = []any{":1.1"}
+		} else {
+			body, callErr = s.handler(call)
+		}
+
+		serial++
+		reply := &dbus.Message{
+			Type:        dbus.TypeMethodReturn,
+			Serial:      serial,
+			ReplySerial: call.Serial,
+			Destination: ":1.1",
+			Body:        body,
+		}
+		if callErr != nil {
+			reply.Type = dbus.TypeError
+			reply.ErrorName = callErr.Name
+			reply.Body = []any{callErr.Message}
+		}
+
+		b, err := reply.Marshal()
+		if err != nil {
+			return
+		}
+
+		if _, err := conn.Write(b); err != nil {
+			return
+		}
+	}
+}
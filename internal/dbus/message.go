@@ -0,0 +1,662 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package dbus is a minimal D-Bus client, supporting method calls (and their
+// replies) only.
+package dbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Message types.
+const (
+	TypeMethodCall   byte = 1
+	TypeMethodReturn byte = 2
+	TypeError        byte = 3
+	TypeSignal       byte = 4
+)
+
+// Header fields.
+const (
+	fieldPath        byte = 1
+	fieldInterface   byte = 2
+	fieldMember      byte = 3
+	fieldErrorName   byte = 4
+	fieldReplySerial byte = 5
+	fieldDestination byte = 6
+	fieldSender      byte = 7
+	fieldSignature   byte = 8
+)
+
+// maxMessageSize is the maximum size of a message that is read (much smaller
+// than the protocol limit, as only small messages are expected).
+const maxMessageSize = 1 << 20
+
+// maxDepth is the maximum nesting depth of container types.
+const maxDepth = 32
+
+var errMalformedMessage = errors.New("malformed message")
+
+// ObjectPath is a D-Bus object path.
+type ObjectPath string
+
+// Signature is a D-Bus type signature.
+type Signature string
+
+// Array is a D-Bus array of elements of the type with signature Elem. Byte
+// arrays are represented as []byte instead.
+type Array struct {
+	Elem  Signature
+	Elems []any
+}
+
+// Struct is a D-Bus struct.
+type Struct []any
+
+// DictEntry is an entry of a D-Bus dictionary (an array of dictionary
+// entries, eg. "a{sv}").
+type DictEntry struct {
+	Key   any
+	Value any
+}
+
+// Variant is a D-Bus variant, a value of any type.
+type Variant struct {
+	Value any
+}
+
+// Message is a D-Bus message. The body may contain values of type byte, bool,
+// int16, uint16, int32, uint32, int64, uint64, float64, string, ObjectPath,
+// Signature, []byte, Array, Struct, DictEntry, and Variant.
+type Message struct {
+	Type        byte
+	Flags       byte
+	Serial      uint32
+	ReplySerial uint32
+	Path        ObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	Destination string
+	Sender      string
+	Body        []any
+}
+
+// Marshal returns the (little endian) wire format of the message.
+func (m *Message) Marshal() ([]byte, error) {
+	var signature string
+	var body encoder
+	for _, v := range m.Body {
+		sig, err := signatureOf(v, 0)
+		if err != nil {
+			return nil, err
+		}
+		signature += sig
+		body.value(v)
+	}
+
+	var hdr encoder
+	hdr.buf = append(hdr.buf, 'l', m.Type, m.Flags, 1)
+	hdr.uint32(uint32(len(body.buf)))
+	hdr.uint32(m.Serial)
+
+	// The length of the header field array is filled in below.
+	hdr.uint32(0)
+	start := len(hdr.buf)
+
+	// Header fields are (byte, variant) structs.
+	field := func(code byte, v any) {
+		hdr.align(8)
+		hdr.buf = append(hdr.buf, code)
+		hdr.value(Variant{Value: v})
+	}
+
+	if m.Path != "" {
+		field(fieldPath, m.Path)
+	}
+	if m.Interface != "" {
+		field(fieldInterface, m.Interface)
+	}
+	if m.Member != "" {
+		field(fieldMember, m.Member)
+	}
+	if m.ErrorName != "" {
+		field(fieldErrorName, m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		field(fieldReplySerial, m.ReplySerial)
+	}
+	if m.Destination != "" {
+		field(fieldDestination, m.Destination)
+	}
+	if m.Sender != "" {
+		field(fieldSender, m.Sender)
+	}
+	if len(signature) > 0 {
+		field(fieldSignature, Signature(signature))
+	}
+
+	binary.LittleEndian.PutUint32(hdr.buf[start-4:], uint32(len(hdr.buf)-start))
+	hdr.align(8)
+
+	return append(hdr.buf, body.buf...), nil
+}
+
+// ReadMessage reads a message from r.
+func ReadMessage(r io.Reader) (*Message, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	if buf[0] != 'l' {
+		return nil, fmt.Errorf("unsupported byte order %q", buf[0])
+	}
+
+	bodyLen := int(binary.LittleEndian.Uint32(buf[4:]))
+	fieldsLen := int(binary.LittleEndian.Uint32(buf[12:]))
+	if bodyLen > maxMessageSize || fieldsLen > maxMessageSize {
+		return nil, fmt.Errorf("message too large")
+	}
+
+	hdrLen := 16 + fieldsLen
+	bodyStart := (hdrLen + 7) &^ 7
+
+	buf = append(buf, make([]byte, bodyStart-16+bodyLen)...)
+	if _, err := io.ReadFull(r, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &Message{
+		Type:   buf[1],
+		Flags:  buf[2],
+		Serial: binary.LittleEndian.Uint32(buf[8:]),
+	}
+
+	var signature Signature
+	d := decoder{buf: buf[:hdrLen], pos: 16}
+	for d.err == nil && d.pos < hdrLen {
+		d.align(8)
+		code := d.byte()
+		variant, _ := d.value("v", 0).(Variant)
+		if d.err != nil {
+			break
+		}
+		v := variant.Value
+
+		var ok bool
+		switch code {
+		case fieldPath:
+			m.Path, ok = v.(ObjectPath)
+		case fieldInterface:
+			m.Interface, ok = v.(string)
+		case fieldMember:
+			m.Member, ok = v.(string)
+		case fieldErrorName:
+			m.ErrorName, ok = v.(string)
+		case fieldReplySerial:
+			m.ReplySerial, ok = v.(uint32)
+		case fieldDestination:
+			m.Destination, ok = v.(string)
+		case fieldSender:
+			m.Sender, ok = v.(string)
+		case fieldSignature:
+			signature, ok = v.(Signature)
+		default:
+			// Unknown header fields must be ignored.
+			ok = true
+		}
+		if !ok {
+			return nil, errMalformedMessage
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	d = decoder{buf: buf[bodyStart:]}
+	for rest := string(signature); rest != "" && d.err == nil; {
+		var sig string
+		sig, rest, d.err = nextType(rest, 0)
+		if d.err == nil {
+			m.Body = append(m.Body, d.value(sig, 0))
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return m, nil
+}
+
+// signatureOf returns the D-Bus type signature of a value.
+func signatureOf(v any, depth int) (string, error) {
+	if depth > maxDepth {
+		return "", fmt.Errorf("container types nested too deeply")
+	}
+
+	switch v := v.(type) {
+	case byte:
+		return "y", nil
+	case bool:
+		return "b", nil
+	case int16:
+		return "n", nil
+	case uint16:
+		return "q", nil
+	case int32:
+		return "i", nil
+	case uint32:
+		return "u", nil
+	case int64:
+		return "x", nil
+	case uint64:
+		return "t", nil
+	case float64:
+		return "d", nil
+	case string:
+		return "s", nil
+	case ObjectPath:
+		return "o", nil
+	case Signature:
+		return "g", nil
+	case []byte:
+		return "ay", nil
+	case Variant:
+		if _, err := signatureOf(v.Value, depth+1); err != nil {
+			return "", err
+		}
+		return "v", nil
+	case Array:
+		if sig, rest, err := nextType(string(v.Elem), depth+1); err != nil || rest != "" || sig == "" {
+			return "", fmt.Errorf("invalid array element signature %q", v.Elem)
+		}
+		for _, elem := range v.Elems {
+			sig, err := signatureOf(elem, depth+1)
+			if err != nil {
+				return "", err
+			}
+			if sig != string(v.Elem) {
+				return "", fmt.Errorf("array element of type %q, expected %q", sig, v.Elem)
+			}
+		}
+		return "a" + string(v.Elem), nil
+	case Struct:
+		if len(v) == 0 {
+			return "", fmt.Errorf("empty struct")
+		}
+		sig := "("
+		for _, field := range v {
+			fieldSig, err := signatureOf(field, depth+1)
+			if err != nil {
+				return "", err
+			}
+			sig += fieldSig
+		}
+		return sig + ")", nil
+	case DictEntry:
+		keySig, err := signatureOf(v.Key, depth+1)
+		if err != nil {
+			return "", err
+		}
+		if len(keySig) != 1 || !isBasicType(keySig[0]) {
+			return "", fmt.Errorf("dictionary key of non-basic type %q", keySig)
+		}
+		valueSig, err := signatureOf(v.Value, depth+1)
+		if err != nil {
+			return "", err
+		}
+		return "{" + keySig + valueSig + "}", nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+func isBasicType(code byte) bool {
+	return strings.IndexByte("ybnqiuxtdsog", code) >= 0
+}
+
+// nextType splits the first complete type off a signature.
+func nextType(sig string, depth int) (string, string, error) {
+	if sig == "" {
+		return "", "", errMalformedMessage
+	}
+
+	if depth > maxDepth {
+		return "", "", fmt.Errorf("container types nested too deeply")
+	}
+
+	switch code := sig[0]; {
+	case isBasicType(code) || code == 'v':
+		return sig[:1], sig[1:], nil
+	case code == 'a':
+		elem, rest, err := nextType(sig[1:], depth+1)
+		if err != nil {
+			return "", "", err
+		}
+		return "a" + elem, rest, nil
+	case code == '(' || code == '{':
+		closing := byte(')')
+		if code == '{' {
+			closing = '}'
+		}
+
+		n := 0
+		rest := sig[1:]
+		for rest != "" && rest[0] != closing {
+			var err error
+			_, rest, err = nextType(rest, depth+1)
+			if err != nil {
+				return "", "", err
+			}
+			n++
+		}
+
+		if rest == "" || n == 0 || (code == '{' && (n != 2 || !isBasicType(sig[1]))) {
+			return "", "", errMalformedMessage
+		}
+
+		end := len(sig) - len(rest) + 1
+		return sig[:end], sig[end:], nil
+	default:
+		return "", "", fmt.Errorf("unsupported type %q", code)
+	}
+}
+
+// alignment returns the alignment of the type with the given code.
+func alignment(code byte) int {
+	switch code {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	default:
+		return 4
+	}
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.align(2)
+	e.buf = binary.LittleEndian.AppendUint16(e.buf, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.align(8)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+func (e *encoder) signature(s string) {
+	e.buf = append(e.buf, byte(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+// value encodes a value, whose signature has been validated by signatureOf.
+func (e *encoder) value(v any) {
+	switch v := v.(type) {
+	case byte:
+		e.buf = append(e.buf, v)
+	case bool:
+		var b uint32
+		if v {
+			b = 1
+		}
+		e.uint32(b)
+	case int16:
+		e.uint16(uint16(v))
+	case uint16:
+		e.uint16(v)
+	case int32:
+		e.uint32(uint32(v))
+	case uint32:
+		e.uint32(v)
+	case int64:
+		e.uint64(uint64(v))
+	case uint64:
+		e.uint64(v)
+	case float64:
+		e.uint64(math.Float64bits(v))
+	case string:
+		e.string(v)
+	case ObjectPath:
+		e.string(string(v))
+	case Signature:
+		e.signature(string(v))
+	case []byte:
+		e.uint32(uint32(len(v)))
+		e.buf = append(e.buf, v...)
+	case Variant:
+		sig, _ := signatureOf(v.Value, 0)
+		e.signature(sig)
+		e.value(v.Value)
+	case Array:
+		e.uint32(0)
+		lenPos := len(e.buf) - 4
+
+		// The length excludes the padding before the first element.
+		e.align(alignment(v.Elem[0]))
+		start := len(e.buf)
+		for _, elem := range v.Elems {
+			e.value(elem)
+		}
+		binary.LittleEndian.PutUint32(e.buf[lenPos:], uint32(len(e.buf)-start))
+	case Struct:
+		e.align(8)
+		for _, field := range v {
+			e.value(field)
+		}
+	case DictEntry:
+		e.align(8)
+		e.value(v.Key)
+		e.value(v.Value)
+	}
+}
+
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (d *decoder) align(n int) {
+	d.pos = (d.pos + n - 1) &^ (n - 1)
+}
+
+// next returns the next n bytes.
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if n < 0 || d.pos+n > len(d.buf) {
+		d.err = errMalformedMessage
+		return nil
+	}
+
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+
+	return b
+}
+
+func (d *decoder) byte() byte {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	d.align(2)
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	d.align(4)
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	d.align(8)
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (d *decoder) string() string {
+	n := int(d.uint32())
+	b := d.next(n + 1)
+	if b == nil {
+		return ""
+	}
+	return string(b[:n])
+}
+
+func (d *decoder) signature() string {
+	n := int(d.byte())
+	b := d.next(n + 1)
+	if b == nil {
+		return ""
+	}
+	return string(b[:n])
+}
+
+// value decodes a value of the (single complete) type sig.
+func (d *decoder) value(sig string, depth int) any {
+	if d.err != nil {
+		return nil
+	}
+
+	if depth > maxDepth {
+		d.err = fmt.Errorf("container types nested too deeply")
+		return nil
+	}
+
+	switch sig[0] {
+	case 'y':
+		return d.byte()
+	case 'b':
+		return d.uint32() != 0
+	case 'n':
+		return int16(d.uint16())
+	case 'q':
+		return d.uint16()
+	case 'i':
+		return int32(d.uint32())
+	case 'u':
+		return d.uint32()
+	case 'x':
+		return int64(d.uint64())
+	case 't':
+		return d.uint64()
+	case 'd':
+		return math.Float64frombits(d.uint64())
+	case 's':
+		return d.string()
+	case 'o':
+		return ObjectPath(d.string())
+	case 'g':
+		return Signature(d.signature())
+	case 'v':
+		valueSig := d.signature()
+		if d.err != nil {
+			return nil
+		}
+		if _, rest, err := nextType(valueSig, depth+1); err != nil || rest != "" {
+			d.err = errMalformedMessage
+			return nil
+		}
+		return Variant{Value: d.value(valueSig, depth+1)}
+	case 'a':
+		n := int(d.uint32())
+		if n > len(d.buf) {
+			d.err = errMalformedMessage
+			return nil
+		}
+
+		elem := sig[1:]
+		d.align(alignment(elem[0]))
+
+		if elem == "y" {
+			return bytes.Clone(d.next(n))
+		}
+
+		end := d.pos + n
+		arr := Array{Elem: Signature(elem)}
+		for d.err == nil && d.pos < end {
+			arr.Elems = append(arr.Elems, d.value(elem, depth+1))
+		}
+		if d.err == nil && d.pos != end {
+			d.err = errMalformedMessage
+		}
+		return arr
+	case '(':
+		d.align(8)
+
+		var s Struct
+		for rest := sig[1 : len(sig)-1]; rest != "" && d.err == nil; {
+			var field string
+			field, rest, _ = nextType(rest, depth+1)
+			s = append(s, d.value(field, depth+1))
+		}
+		return s
+	case '{':
+		d.align(8)
+
+		key, rest, _ := nextType(sig[1:len(sig)-1], depth+1)
+		return DictEntry{
+			Key:   d.value(key, depth+1),
+			Value: d.value(rest, depth+1),
+		}
+	default:
+		d.err = fmt.Errorf("unsupported type %q", sig[0])
+		return nil
+	}
+}
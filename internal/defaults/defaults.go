@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package defaults applies default values to configurations.
+package defaults
+
+import (
+	"reflect"
+
+	utildefaults "github.com/noisysockets/util/defaults"
+)
+
+// WithDefaults returns a copy of conf with the defaults applied, like
+// github.com/noisysockets/util/defaults. But reference-typed fields of conf
+// (pointers to structs, interfaces, functions and channels, and slices,
+// arrays and maps of them) are carried over as is rather than deep copied,
+// as they usually refer to shared state (eg. limiters, worker pools, event
+// distributors, TLS configurations, or resolvers). Unset reference-typed
+// fields still get their defaults.
+func WithDefaults[T any](conf, defaultConf *T) (*T, error) {
+	if conf == nil {
+		return utildefaults.WithDefaults(conf, defaultConf)
+	}
+
+	// Leave the references out of the (deep) copy.
+	withoutRefs := *conf
+	v := reflect.ValueOf(&withoutRefs).Elem()
+	orig := reflect.ValueOf(conf).Elem()
+
+	type ref struct {
+		index int
+		value reflect.Value
+	}
+
+	var refs []ref
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanSet() || field.IsZero() || !isReference(field.Type()) {
+			continue
+		}
+
+		refs = append(refs, ref{index: i, value: orig.Field(i)})
+		field.SetZero()
+	}
+
+	merged, err := utildefaults.WithDefaults(&withoutRefs, defaultConf)
+	if err != nil {
+		return nil, err
+	}
+
+	mergedValue := reflect.ValueOf(merged).Elem()
+	for _, r := range refs {
+		mergedValue.Field(r.index).Set(r.value)
+	}
+
+	return merged, nil
+}
+
+// isReference reports whether values of t refer to state that must not be
+// copied.
+func isReference(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return true
+	case reflect.Pointer:
+		return t.Elem().Kind() == reflect.Struct
+	case reflect.Slice, reflect.Array:
+		return isReference(t.Elem())
+	case reflect.Map:
+		return isReference(t.Key()) || isReference(t.Elem())
+	default:
+		return false
+	}
+}
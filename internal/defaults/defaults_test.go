@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package defaults_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+type shared struct {
+	mu    sync.Mutex
+	count int
+}
+
+type config struct {
+	Name     string
+	Size     *int
+	Shared   *shared
+	Stringer fmt.Stringer
+	Hook     func() int
+	Shareds  []*shared
+}
+
+type name string
+
+func (n name) String() string { return string(n) }
+
+func TestWithDefaults(t *testing.T) {
+	defaultShared := &shared{}
+	defaultConf := &config{
+		Name:   "default",
+		Size:   ptr.To(16),
+		Shared: defaultShared,
+	}
+
+	t.Run("Nil", func(t *testing.T) {
+		conf, err := defaults.WithDefaults(nil, defaultConf)
+		require.NoError(t, err)
+
+		require.Equal(t, "default", conf.Name)
+		require.Equal(t, 16, *conf.Size)
+	})
+
+	t.Run("References", func(t *testing.T) {
+		s := &shared{count: 1}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		orig := &config{
+			Size:     ptr.To(8),
+			Shared:   s,
+			Stringer: name("example"),
+			Hook:     func() int { return 42 },
+			Shareds:  []*shared{s},
+		}
+
+		conf, err := defaults.WithDefaults(orig, defaultConf)
+		require.NoError(t, err)
+
+		require.Equal(t, "default", conf.Name)
+		require.Equal(t, 8, *conf.Size)
+		require.Same(t, s, conf.Shared)
+		require.Same(t, s, conf.Shareds[0])
+		require.Equal(t, name("example"), conf.Stringer)
+		require.Equal(t, 42, conf.Hook())
+
+		// The caller's configuration is left untouched.
+		require.Empty(t, orig.Name)
+	})
+
+	t.Run("Unset References", func(t *testing.T) {
+		conf, err := defaults.WithDefaults(&config{Name: "example"}, defaultConf)
+		require.NoError(t, err)
+
+		require.Equal(t, "example", conf.Name)
+		require.NotNil(t, conf.Shared)
+	})
+}
@@ -40,29 +40,185 @@
 package dnsconfig
 
 import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
 	"time"
 
-	"github.com/noisysockets/resolver/internal/fqdn"
+	"github.com/noisysockets/resolver/fqdn"
 )
 
 var (
 	defaultNS       = []string{"127.0.0.1:53", "[::1]:53"}
-	getFqdnHostname = fqdn.Hostname // variable for testing
+	getFqdnHostname = func() (string, error) { // variable for testing
+		return fqdn.Hostname(context.Background(), nil)
+	}
 )
 
 // Config is the system DNS configuration.
 type Config struct {
-	Servers       []string      // server addresses (in host:port form) to use
-	Search        []string      // rooted suffixes to append to local name
-	NDots         int           // number of dots in name to trigger absolute lookup
-	Timeout       time.Duration // wait before giving up on a query.
-	Attempts      int           // lost packets before giving up on server
-	Rotate        bool          // round robin among servers
-	UnknownOpt    bool          // anything unknown was encountered
-	Lookup        []string      // OpenBSD top-level database "lookup" order
-	MTime         time.Time     // time of resolv.conf modification
-	SingleRequest bool          // use sequential A and AAAA queries instead of parallel queries
-	UseTCP        bool          // force usage of TCP for DNS resolutions
-	TrustAD       bool          // add AD flag to queries
-	NoReload      bool          // do not check for config file updates
+	Servers       []string             // server addresses (in host:port form) to use
+	ExtraServers  []string             // server addresses beyond the standard limit of 3 (ignored by libc)
+	Search        []string             // rooted suffixes to append to local name
+	NDots         int                  // number of dots in name to trigger absolute lookup
+	Timeout       time.Duration        // wait before giving up on a query.
+	Attempts      int                  // lost packets before giving up on server
+	Rotate        bool                 // round robin among servers
+	UnknownOpt    bool                 // anything unknown was encountered
+	Lookup        []string             // OpenBSD top-level database "lookup" order
+	MTime         time.Time            // time of resolv.conf modification
+	SingleRequest bool                 // use sequential A and AAAA queries instead of parallel queries
+	UseTCP        bool                 // force usage of TCP for DNS resolutions
+	TrustAD       bool                 // add AD flag to queries
+	NoReload      bool                 // do not check for config file updates
+	SortList      []netip.Prefix       // IPv4 address sort order (sortlist)
+	DoH           map[string]DoHServer // DNS over HTTPS settings, keyed by server address (Windows)
+	Diagnostics   []Diagnostic         // problems found while parsing the configuration, in line order
+}
+
+// Diagnostic is a problem found while parsing a DNS config, eg. a line or
+// option that was ignored.
+type Diagnostic struct {
+	Line    int    // line number (1-based) of the problem
+	Message string // description of the problem
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("line %d: %s", d.Line, d.Message)
+}
+
+// DoHServer is the DNS over HTTPS configuration of a server.
+type DoHServer struct {
+	Template string // RFC 8484 URI template of the server
+	Fallback bool   // fall back to unencrypted DNS if DNS over HTTPS fails
+}
+
+// Flags of the per interface DNS over HTTPS settings of a server on Windows
+// (the DohFlags registry value).
+const (
+	dohFlagAutoTemplate   = 1 << 0 // use the template of the well-known server
+	dohFlagManualTemplate = 1 << 1 // use the template of the server's settings
+	dohFlagFallback       = 1 << 2 // allow fallback to unencrypted DNS
+)
+
+// dohServerFromSettings returns the DNS over HTTPS configuration of a server
+// from its per interface settings (flags and template), and the template of
+// the matching well-known server (if any). The boolean result is false if DNS
+// over HTTPS is not enabled for the server.
+func dohServerFromSettings(flags uint64, template, wellKnownTemplate string) (DoHServer, bool) {
+	switch {
+	case flags&dohFlagManualTemplate != 0 && template != "":
+	case flags&dohFlagAutoTemplate != 0 && wellKnownTemplate != "":
+		template = wellKnownTemplate
+	default:
+		return DoHServer{}, false
+	}
+
+	return DoHServer{
+		Template: template,
+		Fallback: flags&dohFlagFallback != 0,
+	}, true
+}
+
+// RetransmitTimeout returns how long to wait for a response from a server
+// during the given (zero based) attempt, following glibc's retransmission
+// schedule (see res_send.c). The timeout doubles with every attempt and, after
+// the first attempt, is divided between the servers. It is never less than a
+// second.
+func (c *Config) RetransmitTimeout(attempt int) time.Duration {
+	seconds := int(c.Timeout/time.Second) << attempt
+	if attempt > 0 && len(c.Servers) > 0 {
+		seconds /= len(c.Servers)
+	}
+
+	if seconds <= 0 {
+		seconds = 1
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// ApplySortList orders the IPv4 addresses in addrs according to the sortlist,
+// addresses matching earlier entries come first and addresses matching no
+// entry last. IPv6 addresses keep their positions.
+func (c *Config) ApplySortList(addrs []netip.Addr) {
+	if len(c.SortList) == 0 {
+		return
+	}
+
+	rank := func(addr netip.Addr) int {
+		for i, prefix := range c.SortList {
+			if prefix.Contains(addr.Unmap()) {
+				return i
+			}
+		}
+		return len(c.SortList)
+	}
+
+	var positions []int
+	var ipv4Addrs []netip.Addr
+	for i, addr := range addrs {
+		if addr.Unmap().Is4() {
+			positions = append(positions, i)
+			ipv4Addrs = append(ipv4Addrs, addr)
+		}
+	}
+
+	slices.SortStableFunc(ipv4Addrs, func(a, b netip.Addr) int {
+		return rank(a) - rank(b)
+	})
+
+	for i, pos := range positions {
+		addrs[pos] = ipv4Addrs[i]
+	}
+}
+
+// LookupSources returns the sources of host lookups ("file" for the hosts
+// file, and "bind" for DNS) in the order they are consulted, according to the
+// OpenBSD lookup option. Other sources (eg. "yp") are not supported and are
+// omitted. Without a lookup option, DNS is consulted before the hosts file
+// (the OpenBSD default).
+func (c *Config) LookupSources() []string {
+	if len(c.Lookup) == 0 {
+		return []string{"bind", "file"}
+	}
+
+	var sources []string
+	for _, source := range c.Lookup {
+		if (source == "bind" || source == "file") && !slices.Contains(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+
+	return sources
+}
+
+// adapterServers are the DNS servers of a network adapter.
+type adapterServers struct {
+	// metric is the interface metric of the adapter, lower is preferred.
+	metric  uint32
+	servers []string
+}
+
+// orderServersByMetric returns the DNS servers of the adapters, ordered by
+// the interface metric of their adapter (preserving the order of adapters with
+// equal metrics). Duplicate servers are removed.
+func orderServersByMetric(adapters []adapterServers) []string {
+	adapters = slices.Clone(adapters)
+	slices.SortStableFunc(adapters, func(a, b adapterServers) int {
+		return cmp.Compare(a.metric, b.metric)
+	})
+
+	var servers []string
+	for _, adapter := range adapters {
+		for _, server := range adapter.servers {
+			if !slices.Contains(servers, server) {
+				servers = append(servers, server)
+			}
+		}
+	}
+
+	return servers
 }
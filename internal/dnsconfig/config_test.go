@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dnsconfig
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+// The expected schedules are those of glibc's res_send(), where the timeout of
+// a try is (RES_TIMEOUT << try) / nscount (except for the first try), with a
+// minimum of one second.
+var retransmitTimeoutTests = []struct {
+	timeout time.Duration
+	servers int
+	want    []time.Duration
+}{
+	{
+		// The defaults (timeout:5 attempts:2) with a single server.
+		timeout: 5 * time.Second,
+		servers: 1,
+		want:    []time.Duration{5 * time.Second, 10 * time.Second},
+	},
+	{
+		timeout: 5 * time.Second,
+		servers: 2,
+		want:    []time.Duration{5 * time.Second, 5 * time.Second, 10 * time.Second},
+	},
+	{
+		timeout: 5 * time.Second,
+		servers: 3,
+		want:    []time.Duration{5 * time.Second, 3 * time.Second, 6 * time.Second, 13 * time.Second},
+	},
+	{
+		// Rounded up to the minimum of one second.
+		timeout: time.Second,
+		servers: 3,
+		want:    []time.Duration{time.Second, time.Second, time.Second, 2 * time.Second},
+	},
+}
+
+func TestRetransmitTimeout(t *testing.T) {
+	for _, tt := range retransmitTimeoutTests {
+		conf := &Config{
+			Servers: make([]string, tt.servers),
+			Timeout: tt.timeout,
+		}
+
+		for attempt, want := range tt.want {
+			if got := conf.RetransmitTimeout(attempt); got != want {
+				t.Errorf("timeout %v, %d servers, attempt %d: got %v; want %v",
+					tt.timeout, tt.servers, attempt, got, want)
+			}
+		}
+	}
+}
+
+func TestApplySortList(t *testing.T) {
+	conf := &Config{
+		SortList: []netip.Prefix{
+			netip.MustParsePrefix("130.155.160.0/20"),
+			netip.MustParsePrefix("130.155.0.0/16"),
+		},
+	}
+
+	addrs := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("130.155.1.1"),
+		netip.MustParseAddr("130.155.161.1"),
+		netip.MustParseAddr("198.51.100.1"),
+		netip.MustParseAddr("130.155.2.2"),
+	}
+
+	conf.ApplySortList(addrs)
+
+	want := []netip.Addr{
+		netip.MustParseAddr("130.155.161.1"),
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("130.155.1.1"),
+		netip.MustParseAddr("130.155.2.2"),
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("198.51.100.1"),
+	}
+	if !slices.Equal(addrs, want) {
+		t.Errorf("got: %v\nwant: %v", addrs, want)
+	}
+}
+
+func TestLookupSources(t *testing.T) {
+	tests := []struct {
+		lookup []string
+		want   []string
+	}{
+		{lookup: nil, want: []string{"bind", "file"}},
+		{lookup: []string{"file", "bind"}, want: []string{"file", "bind"}},
+		{lookup: []string{"file"}, want: []string{"file"}},
+		{lookup: []string{"yp", "bind", "bind"}, want: []string{"bind"}},
+	}
+
+	for _, tt := range tests {
+		conf := &Config{Lookup: tt.lookup}
+		if got := conf.LookupSources(); !slices.Equal(got, tt.want) {
+			t.Errorf("lookup %v: got %v; want %v", tt.lookup, got, tt.want)
+		}
+	}
+}
+
+func TestOrderServersByMetric(t *testing.T) {
+	servers := orderServersByMetric([]adapterServers{
+		{metric: 5000, servers: []string{"172.17.0.1:53"}}, // eg. a virtual switch
+		{metric: 25, servers: []string{"192.168.1.1:53", "[fd00::1]:53"}},
+		{metric: 35, servers: []string{"10.0.0.1:53"}},
+		{metric: 25, servers: []string{"192.168.1.1:53"}},
+	})
+
+	want := []string{"192.168.1.1:53", "[fd00::1]:53", "10.0.0.1:53", "172.17.0.1:53"}
+	if !slices.Equal(servers, want) {
+		t.Errorf("got: %v\nwant: %v", servers, want)
+	}
+}
+
+func TestDoHServerFromSettings(t *testing.T) {
+	const (
+		template          = "https://dns.example/dns-query{?dns}"
+		wellKnownTemplate = "https://dns.google/dns-query{?dns}"
+	)
+
+	tests := []struct {
+		flags     uint64
+		template  string
+		wellKnown string
+		want      DoHServer
+		wantOK    bool
+	}{
+		{flags: 0, template: template, wellKnown: wellKnownTemplate},
+		{
+			flags:     dohFlagAutoTemplate,
+			wellKnown: wellKnownTemplate,
+			want:      DoHServer{Template: wellKnownTemplate},
+			wantOK:    true,
+		},
+		// Not a well-known server.
+		{flags: dohFlagAutoTemplate | dohFlagFallback},
+		{
+			flags:     dohFlagManualTemplate | dohFlagFallback,
+			template:  template,
+			wellKnown: wellKnownTemplate,
+			want:      DoHServer{Template: template, Fallback: true},
+			wantOK:    true,
+		},
+		{flags: dohFlagManualTemplate, wellKnown: wellKnownTemplate},
+	}
+
+	for _, tt := range tests {
+		got, ok := dohServerFromSettings(tt.flags, tt.template, tt.wellKnown)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("flags %#x: got %+v, %v; want %+v, %v", tt.flags, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
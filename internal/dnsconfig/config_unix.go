@@ -43,6 +43,9 @@ package dnsconfig
 
 import (
 	"bufio"
+	"fmt"
+	"io"
+	"math"
 	"net"
 	"net/netip"
 	"os"
@@ -73,16 +76,37 @@ func Read(filename string) (*Config, error) {
 	}
 	defer file.Close()
 
-	if fi, err := file.Stat(); err == nil {
-		conf.MTime = fi.ModTime()
-	} else {
+	fi, err := file.Stat()
+	if err != nil {
 		conf.Servers = defaultNS
 		conf.Search = dnsDefaultSearch()
 		return conf, err
 	}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	conf, err = Decode(file)
+	conf.MTime = fi.ModTime()
+	return conf, err
+}
+
+// Decode parses a DNS config in resolv.conf format. Malformed lines and
+// options are ignored (as they are by the libc resolvers), and reported in
+// the config's diagnostics.
+func Decode(r io.Reader) (*Config, error) {
+	conf := &Config{
+		NDots:    1,
+		Timeout:  5 * time.Second,
+		Attempts: 2,
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		diagnose := func(format string, args ...any) {
+			conf.Diagnostics = append(conf.Diagnostics, Diagnostic{
+				Line:    lineNum,
+				Message: fmt.Sprintf(format, args...),
+			})
+		}
+
 		line := scanner.Text()
 		if len(line) > 0 && (line[0] == ';' || line[0] == '#') {
 			// comment.
@@ -95,13 +119,25 @@ func Read(filename string) (*Config, error) {
 		}
 		switch f[0] {
 		case "nameserver": // add one name server
-			if len(f) > 1 && len(conf.Servers) < 3 { // small, but the standard limit
-				// One more check: make sure server name is
-				// just an IP address. Otherwise we need DNS
-				// to look it up.
-				if _, err := netip.ParseAddr(f[1]); err == nil {
-					conf.Servers = append(conf.Servers, net.JoinHostPort(f[1], "53"))
-				}
+			if len(f) < 2 {
+				diagnose("nameserver without an address ignored")
+				continue
+			}
+
+			// One more check: make sure server name is
+			// just an IP address. Otherwise we need DNS
+			// to look it up.
+			if _, err := netip.ParseAddr(f[1]); err != nil {
+				diagnose("nameserver with invalid address %q ignored", f[1])
+				continue
+			}
+
+			server := net.JoinHostPort(f[1], "53")
+			if len(conf.Servers) < 3 { // small, but the standard limit
+				conf.Servers = append(conf.Servers, server)
+			} else {
+				diagnose("nameserver %s is beyond the limit of 3 name servers (ignored by libc)", f[1])
+				conf.ExtraServers = append(conf.ExtraServers, server)
 			}
 
 		case "domain": // set search path to just this domain
@@ -123,25 +159,11 @@ func Read(filename string) (*Config, error) {
 			for _, s := range f[1:] {
 				switch {
 				case strings.HasPrefix(s, "ndots:"):
-					n, _ := strconv.Atoi(s[6:])
-					if n < 0 {
-						n = 0
-					} else if n > 15 {
-						n = 15
-					}
-					conf.NDots = n
+					conf.NDots = parseIntOption(s, 0, 15, diagnose)
 				case strings.HasPrefix(s, "timeout:"):
-					n, _ := strconv.Atoi(s[8:])
-					if n < 1 {
-						n = 1
-					}
-					conf.Timeout = time.Duration(n) * time.Second
+					conf.Timeout = time.Duration(parseIntOption(s, 1, math.MaxInt, diagnose)) * time.Second
 				case strings.HasPrefix(s, "attempts:"):
-					n, _ := strconv.Atoi(s[9:])
-					if n < 1 {
-						n = 1
-					}
-					conf.Attempts = n
+					conf.Attempts = parseIntOption(s, 1, math.MaxInt, diagnose)
 				case s == "rotate":
 					conf.Rotate = true
 				case s == "single-request" || s == "single-request-reopen":
@@ -167,10 +189,29 @@ func Read(filename string) (*Config, error) {
 				case s == "no-reload":
 					conf.NoReload = true
 				default:
+					diagnose("unknown option %q ignored", s)
 					conf.UnknownOpt = true
 				}
 			}
 
+		case "sortlist":
+			// "Sortlist allows addresses returned by gethostbyname to be
+			//  sorted. A sortlist is specified by IP-address-netmask pairs.
+			//  The netmask is optional and defaults to the natural netmask
+			//  of the net. [...] Up to 10 pairs may be specified."
+			for _, entry := range f[1:] {
+				if len(conf.SortList) >= 10 {
+					diagnose("sortlist entries beyond the limit of 10 ignored")
+					break
+				}
+				prefix, ok := parseSortListEntry(entry)
+				if !ok {
+					diagnose("invalid sortlist entry %q ignored", entry)
+					continue
+				}
+				conf.SortList = append(conf.SortList, prefix)
+			}
+
 		case "lookup":
 			// OpenBSD option:
 			// https://www.openbsd.org/cgi-bin/man.cgi/OpenBSD-current/man5/resolv.conf.5
@@ -178,6 +219,7 @@ func Read(filename string) (*Config, error) {
 			conf.Lookup = f[1:]
 
 		default:
+			diagnose("unknown keyword %q ignored", f[0])
 			conf.UnknownOpt = true
 		}
 	}
@@ -210,3 +252,66 @@ func dnsDefaultSearch() []string {
 
 	return []string{dns.CanonicalName(strings.Join(labels[1:], "."))}
 }
+
+// parseIntOption parses the value of a "name:n" option, clamped to the range
+// [min, max] (as libc does). Values that aren't integers are parsed as zero.
+func parseIntOption(s string, min, max int, diagnose func(format string, args ...any)) int {
+	name, value, _ := strings.Cut(s, ":")
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		diagnose("invalid value %q of option %s, using %d", value, name, min)
+		return min
+	}
+
+	if n < min || n > max {
+		clamped := max
+		if n < min {
+			clamped = min
+		}
+		diagnose("value %d of option %s is out of range, using %d", n, name, clamped)
+		return clamped
+	}
+
+	return n
+}
+
+// parseSortListEntry parses an "address[/netmask]" sortlist entry, without a
+// netmask the natural (classful) netmask of the address is used.
+func parseSortListEntry(entry string) (netip.Prefix, bool) {
+	addrStr, maskStr, hasMask := strings.Cut(entry, "/")
+	if !hasMask {
+		addrStr, maskStr, hasMask = strings.Cut(entry, "&")
+	}
+
+	addr, err := netip.ParseAddr(addrStr)
+	if err != nil || !addr.Is4() {
+		return netip.Prefix{}, false
+	}
+
+	bits := -1
+	if hasMask {
+		if mask, err := netip.ParseAddr(maskStr); err == nil && mask.Is4() {
+			m := mask.As4()
+			ones, size := net.IPMask(m[:]).Size()
+			if size == 0 {
+				// Non-contiguous netmasks are not supported.
+				return netip.Prefix{}, false
+			}
+			bits = ones
+		}
+	}
+
+	if bits < 0 {
+		switch a := addr.As4(); {
+		case a[0]&0x80 == 0:
+			bits = 8
+		case a[0]&0xc0 == 0x80:
+			bits = 16
+		default:
+			bits = 24
+		}
+	}
+
+	return netip.PrefixFrom(addr, bits).Masked(), true
+}
@@ -43,9 +43,12 @@ package dnsconfig
 
 import (
 	"errors"
+	"io"
 	"io/fs"
+	"net/netip"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -64,6 +67,10 @@ var dnsReadConfigTests = []struct {
 			Attempts:   3,
 			Rotate:     true,
 			UnknownOpt: true, // the "options attempts 3" line
+			Diagnostics: []Diagnostic{
+				{Line: 8, Message: `unknown option "attempts" ignored`},
+				{Line: 8, Message: `unknown option "3" ignored`},
+			},
 		},
 	},
 	{
@@ -114,6 +121,9 @@ var dnsReadConfigTests = []struct {
 			Timeout:  5 * time.Second,
 			Attempts: 2,
 			Search:   []string{"domain.local."},
+			Diagnostics: []Diagnostic{
+				{Line: 1, Message: `invalid value "invalid" of option ndots, using 0`},
+			},
 		},
 	},
 	{
@@ -124,6 +134,9 @@ var dnsReadConfigTests = []struct {
 			Timeout:  5 * time.Second,
 			Attempts: 2,
 			Search:   []string{"domain.local."},
+			Diagnostics: []Diagnostic{
+				{Line: 1, Message: "value 16 of option ndots is out of range, using 15"},
+			},
 		},
 	},
 	{
@@ -134,6 +147,9 @@ var dnsReadConfigTests = []struct {
 			Timeout:  5 * time.Second,
 			Attempts: 2,
 			Search:   []string{"domain.local."},
+			Diagnostics: []Diagnostic{
+				{Line: 1, Message: "value -1 of option ndots is out of range, using 0"},
+			},
 		},
 	},
 	{
@@ -202,6 +218,38 @@ var dnsReadConfigTests = []struct {
 			Search:   []string{"domain.local."},
 		},
 	},
+	{
+		name: "testdata/sortlist-resolv.conf",
+		want: &Config{
+			Servers:  []string{"8.8.8.8:53"},
+			NDots:    1,
+			Timeout:  5 * time.Second,
+			Attempts: 2,
+			SortList: []netip.Prefix{
+				netip.MustParsePrefix("130.155.160.0/20"),
+				netip.MustParsePrefix("130.155.0.0/16"),
+				netip.MustParsePrefix("10.1.0.0/16"),
+			},
+			Diagnostics: []Diagnostic{
+				{Line: 4, Message: `invalid sortlist entry "192.168.1.0/255.0.255.0" ignored`},
+				{Line: 4, Message: `invalid sortlist entry "bogus" ignored`},
+			},
+		},
+	},
+	{
+		name: "testdata/kubernetes-resolv.conf",
+		want: &Config{
+			Servers:      []string{"10.96.0.10:53", "10.96.0.11:53", "10.96.0.12:53"},
+			ExtraServers: []string{"10.96.0.13:53"},
+			Search:       []string{"default.svc.cluster.local.", "svc.cluster.local.", "cluster.local."},
+			NDots:        5,
+			Timeout:      5 * time.Second,
+			Attempts:     2,
+			Diagnostics: []Diagnostic{
+				{Line: 5, Message: "nameserver 10.96.0.13 is beyond the limit of 3 name servers (ignored by libc)"},
+			},
+		},
+	},
 }
 
 func TestDNSReadConfig(t *testing.T) {
@@ -225,6 +273,76 @@ func TestDNSReadConfig(t *testing.T) {
 	}
 }
 
+func TestEncode(t *testing.T) {
+	origGetHostname := getFqdnHostname
+	defer func() { getFqdnHostname = origGetHostname }()
+	getFqdnHostname = func() (string, error) { return "host.domain.local", nil }
+
+	for _, tt := range dnsReadConfigTests {
+		conf, err := Read(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf.MTime = time.Time{}
+		conf.UnknownOpt = false
+
+		var b strings.Builder
+		if err := Encode(&b, conf); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		got, err := Decode(strings.NewReader(b.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Diagnostics describe the original file, not the encoded config.
+		conf.Diagnostics = nil
+		got.Diagnostics = nil
+		if !reflect.DeepEqual(got, conf) {
+			t.Errorf("%s:\ngot: %+v\nwant: %+v\nencoded:\n%s", tt.name, got, conf, b.String())
+		}
+	}
+
+	if err := Encode(io.Discard, &Config{Servers: []string{"127.0.0.1:5353"}}); err == nil {
+		t.Error("expected an error encoding a name server with a non-standard port")
+	}
+}
+
+func TestDecodeDiagnostics(t *testing.T) {
+	origGetHostname := getFqdnHostname
+	defer func() { getFqdnHostname = origGetHostname }()
+	getFqdnHostname = func() (string, error) { return "host.domain.local", nil }
+
+	data := strings.Join([]string{
+		"nameserver",
+		"nameserver dns.example.com",
+		"nameserver 10.0.0.1",
+		"options timeout:0 inet6",
+		"resolver 10.0.0.2",
+	}, "\n")
+
+	conf, err := Decode(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Diagnostic{
+		{Line: 1, Message: "nameserver without an address ignored"},
+		{Line: 2, Message: `nameserver with invalid address "dns.example.com" ignored`},
+		{Line: 4, Message: "value 0 of option timeout is out of range, using 1"},
+		{Line: 4, Message: `unknown option "inet6" ignored`},
+		{Line: 5, Message: `unknown keyword "resolver" ignored`},
+	}
+	if !reflect.DeepEqual(conf.Diagnostics, want) {
+		t.Errorf("diagnostics:\ngot: %v\nwant: %v", conf.Diagnostics, want)
+	}
+
+	// The valid parts of the config still take effect.
+	if !reflect.DeepEqual(conf.Servers, []string{"10.0.0.1:53"}) || conf.Timeout != time.Second {
+		t.Errorf("unexpected config: %+v", conf)
+	}
+}
+
 func TestDNSReadMissingFile(t *testing.T) {
 	origGetHostname := getFqdnHostname
 	defer func() { getFqdnHostname = origGetHostname }()
@@ -288,3 +406,34 @@ func TestDNSDefaultSearch(t *testing.T) {
 		}
 	}
 }
+
+func FuzzDecode(f *testing.F) {
+	origGetHostname := getFqdnHostname
+	defer func() { getFqdnHostname = origGetHostname }()
+	getFqdnHostname = func() (string, error) { return "host.domain.local", nil }
+
+	for _, tt := range dnsReadConfigTests {
+		data, err := os.ReadFile(tt.name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(data))
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		conf, err := Decode(strings.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		if len(conf.Servers) == 0 {
+			t.Errorf("no servers in decoded config")
+		}
+		if conf.NDots < 0 || conf.NDots > 15 {
+			t.Errorf("ndots out of range: %d", conf.NDots)
+		}
+		if conf.Timeout < time.Second || conf.Attempts < 1 {
+			t.Errorf("invalid timeout or attempts: %v, %d", conf.Timeout, conf.Attempts)
+		}
+	})
+}
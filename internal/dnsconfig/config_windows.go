@@ -43,9 +43,11 @@ package dnsconfig
 
 import (
 	"net"
+	"net/netip"
 	"time"
 
 	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
 
 	"github.com/noisysockets/resolver/internal/winipcfg"
 )
@@ -54,6 +56,15 @@ import (
 // This is ignored on Windows.
 const Location = ""
 
+const (
+	dnscacheParametersKey = `SYSTEM\CurrentControlSet\Services\Dnscache\Parameters`
+	dnscacheInterfacesKey = `SYSTEM\CurrentControlSet\Services\Dnscache\InterfaceSpecificParameters`
+)
+
+// enableAutoDoHUpgrade is the EnableAutoDoh registry value that upgrades
+// queries to well-known servers to DNS over HTTPS.
+const enableAutoDoHUpgrade = 2
+
 // Read reads the system DNS config from the Windows registry.
 func Read(ignoredFilename string) (*Config, error) {
 	conf := &Config{
@@ -67,11 +78,16 @@ func Read(ignoredFilename string) (*Config, error) {
 	if aasV6, err := winipcfg.GetAdaptersAddresses(windows.AF_INET6, winipcfg.GAAFlagIncludeAll); err == nil {
 		aas = append(aas, aasV6...)
 	}
+	numV6 := len(aas)
 	if aasV4, err := winipcfg.GetAdaptersAddresses(windows.AF_INET, winipcfg.GAAFlagIncludeAll); err == nil {
 		aas = append(aas, aasV4...)
 	}
 
-	for _, aa := range aas {
+	wellKnownDoH := readDoHWellKnownServers()
+	autoDoH := readAutoDoH()
+
+	var adapters []adapterServers
+	for i, aa := range aas {
 		// Only take interfaces whose OperStatus is IfOperStatusUp(0x01) into DNS configs.
 		if aa.OperStatus != winipcfg.IfOperStatusUp {
 			continue
@@ -82,11 +98,21 @@ func Read(ignoredFilename string) (*Config, error) {
 			continue
 		}
 
+		// Skip loopback and tunnel (eg. Teredo and ISATAP) pseudo-interfaces.
+		if aa.IfType == winipcfg.IfTypeSoftwareLoopback || aa.IfType == winipcfg.IfTypeTunnel {
+			continue
+		}
+
 		dnsAddrs, err := aa.LUID.DNS()
 		if err != nil {
 			continue
 		}
 
+		adapter := adapterServers{metric: aa.Ipv6Metric}
+		if i >= numV6 {
+			adapter.metric = aa.Ipv4Metric
+		}
+
 		for _, addr := range dnsAddrs {
 			addr := addr.Unmap()
 			if addr.Is6() && addr.AsSlice()[0] == 0xfe && addr.AsSlice()[1] == 0xc0 {
@@ -98,13 +124,108 @@ func Read(ignoredFilename string) (*Config, error) {
 				continue
 			}
 
-			conf.Servers = append(conf.Servers, net.JoinHostPort(addr.String(), "53"))
+			server := net.JoinHostPort(addr.String(), "53")
+			adapter.servers = append(adapter.servers, server)
+
+			doh, ok := readDoHInterfaceSettings(aa.AdapterName(), addr, wellKnownDoH)
+			if !ok && autoDoH && wellKnownDoH[addr] != "" {
+				// Automatic upgrades fall back to unencrypted DNS.
+				doh, ok = DoHServer{Template: wellKnownDoH[addr], Fallback: true}, true
+			}
+
+			if ok {
+				if conf.DoH == nil {
+					conf.DoH = make(map[string]DoHServer)
+				}
+				conf.DoH[server] = doh
+			}
 		}
+
+		adapters = append(adapters, adapter)
 	}
 
+	// Query the DNS servers of the primary interface first, as Windows does.
+	conf.Servers = orderServersByMetric(adapters)
+
 	if len(conf.Servers) == 0 {
 		conf.Servers = defaultNS
 	}
 
 	return conf, nil
 }
+
+// readDoHWellKnownServers returns the DNS over HTTPS templates of the
+// well-known servers (eg. as added by "netsh dns add encryption").
+func readDoHWellKnownServers() map[netip.Addr]string {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, dnscacheParametersKey+`\DohWellKnownServers`, registry.READ)
+	if err != nil {
+		return nil
+	}
+	defer k.Close()
+
+	names, err := k.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil
+	}
+
+	servers := make(map[netip.Addr]string)
+	for _, name := range names {
+		addr, err := netip.ParseAddr(name)
+		if err != nil {
+			continue
+		}
+
+		serverKey, err := registry.OpenKey(k, name, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+
+		template, _, err := serverKey.GetStringValue("Template")
+		serverKey.Close()
+		if err != nil {
+			continue
+		}
+
+		servers[addr.Unmap()] = template
+	}
+
+	return servers
+}
+
+// readAutoDoH reports whether queries to well-known servers are automatically
+// upgraded to DNS over HTTPS.
+func readAutoDoH() bool {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, dnscacheParametersKey, registry.QUERY_VALUE)
+	if err != nil {
+		return false
+	}
+	defer k.Close()
+
+	value, _, err := k.GetIntegerValue("EnableAutoDoh")
+	return err == nil && value == enableAutoDoHUpgrade
+}
+
+// readDoHInterfaceSettings returns the DNS over HTTPS configuration of a
+// server of an adapter (as set in the Settings app of Windows 11).
+func readDoHInterfaceSettings(adapterName string, addr netip.Addr, wellKnown map[netip.Addr]string) (DoHServer, bool) {
+	family := "Doh"
+	if addr.Is6() {
+		family = "Doh6"
+	}
+
+	path := dnscacheInterfacesKey + `\` + adapterName + `\DohInterfaceSettings\` + family + `\` + addr.String()
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		return DoHServer{}, false
+	}
+	defer k.Close()
+
+	flags, _, err := k.GetIntegerValue("DohFlags")
+	if err != nil {
+		return DoHServer{}, false
+	}
+
+	template, _, _ := k.GetStringValue("DohTemplate")
+
+	return dohServerFromSettings(flags, template, wellKnown[addr])
+}
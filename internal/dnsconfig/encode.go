@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dnsconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encode writes the DNS config in resolv.conf format. Options with their
+// default values (ndots:1, timeout:5, attempts:2) are omitted, as are the
+// fields that can't be expressed in resolv.conf (eg. DoH). Name servers must
+// use port 53, as resolv.conf has no way to specify the port.
+func Encode(w io.Writer, conf *Config) error {
+	bw := bufio.NewWriter(w)
+
+	for _, server := range append(conf.Servers[:len(conf.Servers):len(conf.Servers)], conf.ExtraServers...) {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			return fmt.Errorf("invalid name server %q: %w", server, err)
+		}
+
+		if port != "53" {
+			return fmt.Errorf("name server %q must use port 53", server)
+		}
+
+		if _, err := netip.ParseAddr(host); err != nil {
+			return fmt.Errorf("invalid name server %q: %w", server, err)
+		}
+
+		fmt.Fprintf(bw, "nameserver %s\n", host)
+	}
+
+	if len(conf.Search) > 0 {
+		search := make([]string, len(conf.Search))
+		for i, domain := range conf.Search {
+			if strings.ContainsAny(domain, " \t\n") {
+				return fmt.Errorf("invalid search domain %q", domain)
+			}
+			search[i] = strings.TrimSuffix(domain, ".")
+		}
+
+		fmt.Fprintf(bw, "search %s\n", strings.Join(search, " "))
+	}
+
+	if len(conf.SortList) > 0 {
+		entries := make([]string, len(conf.SortList))
+		for i, prefix := range conf.SortList {
+			if !prefix.Addr().Is4() {
+				return fmt.Errorf("invalid sortlist entry %q", prefix)
+			}
+
+			mask := net.CIDRMask(prefix.Bits(), 32)
+			entries[i] = prefix.Masked().Addr().String() + "/" + net.IP(mask).String()
+		}
+
+		fmt.Fprintf(bw, "sortlist %s\n", strings.Join(entries, " "))
+	}
+
+	if len(conf.Lookup) > 0 {
+		fmt.Fprintf(bw, "lookup %s\n", strings.Join(conf.Lookup, " "))
+	}
+
+	var options []string
+	if conf.NDots != 1 {
+		options = append(options, "ndots:"+strconv.Itoa(min(max(conf.NDots, 0), 15)))
+	}
+	if conf.Timeout > 0 && conf.Timeout != 5*time.Second {
+		options = append(options, "timeout:"+strconv.Itoa(max(int(conf.Timeout/time.Second), 1)))
+	}
+	if conf.Attempts > 0 && conf.Attempts != 2 {
+		options = append(options, "attempts:"+strconv.Itoa(conf.Attempts))
+	}
+	if conf.Rotate {
+		options = append(options, "rotate")
+	}
+	if conf.SingleRequest {
+		options = append(options, "single-request")
+	}
+	if conf.UseTCP {
+		options = append(options, "use-vc")
+	}
+	if conf.TrustAD {
+		options = append(options, "trust-ad")
+	}
+	if conf.NoReload {
+		options = append(options, "no-reload")
+	}
+
+	if len(options) > 0 {
+		fmt.Fprintf(bw, "options %s\n", strings.Join(options, " "))
+	}
+
+	return bw.Flush()
+}
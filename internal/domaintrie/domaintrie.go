@@ -0,0 +1,273 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package domaintrie implements a compact set of domains, matching names
+// that are equal to (or subdomains of) any domain in the set.
+package domaintrie
+
+import (
+	"slices"
+	"strings"
+)
+
+// nodeSize is the size of a node in bytes.
+const nodeSize = 12
+
+// terminalBit is set in the count of nodes of domains in the trie.
+const terminalBit = 1 << 31
+
+// Stats describes the contents of a trie.
+type Stats struct {
+	// Domains is the number of domains in the trie.
+	Domains int
+	// Duplicates is the number of domains that were added more than once.
+	Duplicates int
+	// Redundant is the number of domains that were dropped as they are
+	// subdomains of another domain in the trie (and so already matched).
+	Redundant int
+	// Nodes is the number of nodes (one per distinct label path).
+	Nodes int
+	// Bytes is the approximate memory used by the trie.
+	Bytes int
+}
+
+// Builder builds a trie. Domains are deduplicated as they are added.
+type Builder struct {
+	root  buildNode
+	stats Stats
+}
+
+type buildNode struct {
+	children map[string]*buildNode
+	terminal bool
+}
+
+// NewBuilder returns a new, empty builder.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Add adds a domain (eg. "example.com"), matched case-insensitively, with or
+// without the trailing dot. The root domain matches every name.
+func (b *Builder) Add(domain string) {
+	n := &b.root
+	for name := normalize(domain); name != ""; {
+		if n.terminal {
+			// A parent domain is already in the trie.
+			b.stats.Redundant++
+			return
+		}
+
+		var label string
+		label, name = lastLabel(name)
+
+		child, ok := n.children[label]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[string]*buildNode)
+			}
+			child = &buildNode{}
+			n.children[label] = child
+		}
+		n = child
+	}
+
+	if n.terminal {
+		b.stats.Duplicates++
+		return
+	}
+
+	// Subdomains of the domain are now redundant.
+	if removed := n.countTerminals(); removed > 0 {
+		b.stats.Domains -= removed
+		b.stats.Redundant += removed
+		n.children = nil
+	}
+
+	n.terminal = true
+	b.stats.Domains++
+}
+
+// countTerminals returns the number of domains below the node.
+func (n *buildNode) countTerminals() int {
+	var count int
+	for _, child := range n.children {
+		if child.terminal {
+			count++
+		}
+		count += child.countTerminals()
+	}
+	return count
+}
+
+// Build returns the trie of the domains added so far.
+func (b *Builder) Build() *Trie {
+	t := &Trie{
+		stats: b.stats,
+	}
+
+	// Labels are interned, as the same labels (eg. "www" or "ads") appear
+	// under many parents.
+	labelIndexes := make(map[string]uint32)
+	var labels strings.Builder
+	intern := func(label string) uint32 {
+		if i, ok := labelIndexes[label]; ok {
+			return i
+		}
+
+		i := uint32(len(t.labelOffs))
+		t.labelOffs = append(t.labelOffs, uint32(labels.Len()))
+		labels.WriteString(label)
+		labelIndexes[label] = i
+		return i
+	}
+
+	// Nodes are laid out breadth first, so that the children of each node are
+	// contiguous (and sorted, for binary search).
+	t.nodes = append(t.nodes, node{})
+	queue := []*buildNode{&b.root}
+	for i := 0; i < len(queue); i++ {
+		bn := queue[i]
+
+		childLabels := make([]string, 0, len(bn.children))
+		for label := range bn.children {
+			childLabels = append(childLabels, label)
+		}
+		slices.Sort(childLabels)
+
+		t.nodes[i].first = uint32(len(t.nodes))
+		t.nodes[i].count = uint32(len(childLabels))
+		if bn.terminal {
+			t.nodes[i].count |= terminalBit
+		}
+
+		for _, label := range childLabels {
+			t.nodes = append(t.nodes, node{label: intern(label)})
+			queue = append(queue, bn.children[label])
+		}
+	}
+
+	t.labelOffs = append(t.labelOffs, uint32(labels.Len()))
+	t.labels = labels.String()
+
+	t.nodes = slices.Clip(t.nodes)
+	t.labelOffs = slices.Clip(t.labelOffs)
+
+	t.stats.Nodes = len(t.nodes)
+	t.stats.Bytes = len(t.labels) + len(t.labelOffs)*4 + len(t.nodes)*nodeSize
+
+	return t
+}
+
+// Trie is an immutable set of domains. The zero value is an empty trie. It is
+// safe for concurrent use.
+type Trie struct {
+	// labels holds the interned labels, label i is
+	// labels[labelOffs[i]:labelOffs[i+1]].
+	labels    string
+	labelOffs []uint32
+	// nodes[0] is the root.
+	nodes []node
+	stats Stats
+}
+
+type node struct {
+	// label is the index of the label of the node.
+	label uint32
+	// first is the index of the first child.
+	first uint32
+	// count is the number of children, with terminalBit set if the node is
+	// a domain in the trie.
+	count uint32
+}
+
+func (n *node) terminal() bool {
+	return n.count&terminalBit != 0
+}
+
+func (n *node) children() uint32 {
+	return n.count &^ terminalBit
+}
+
+// Match reports whether name is equal to, or a subdomain of, any domain in
+// the trie. Names are matched case-insensitively, in O(labels).
+func (t *Trie) Match(name string) bool {
+	if t == nil || len(t.nodes) == 0 {
+		return false
+	}
+
+	n := &t.nodes[0]
+	for name = strings.TrimSuffix(name, "."); ; {
+		if n.terminal() {
+			return true
+		}
+
+		if name == "" || n.children() == 0 {
+			return false
+		}
+
+		var label string
+		label, name = lastLabel(name)
+
+		children := t.nodes[n.first : n.first+n.children()]
+		i, found := slices.BinarySearchFunc(children, label, func(child node, label string) int {
+			return compareFold(t.label(child.label), label)
+		})
+		if !found {
+			return false
+		}
+		n = &children[i]
+	}
+}
+
+// Stats returns the statistics of the trie.
+func (t *Trie) Stats() Stats {
+	if t == nil {
+		return Stats{}
+	}
+	return t.stats
+}
+
+func (t *Trie) label(i uint32) string {
+	return t.labels[t.labelOffs[i]:t.labelOffs[i+1]]
+}
+
+// normalize returns the lowercase form of domain, without the trailing dot.
+func normalize(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// lastLabel splits the last label off name.
+func lastLabel(name string) (label, rest string) {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return name, ""
+	}
+	return name[i+1:], name[:i]
+}
+
+// compareFold compares the (lowercase) label a with b, ignoring the case of
+// b, without allocating.
+func compareFold(a, b string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		c := b[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+
+		if a[i] != c {
+			if a[i] < c {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return len(a) - len(b)
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package domaintrie_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/noisysockets/resolver/internal/domaintrie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrie(t *testing.T) {
+	b := domaintrie.NewBuilder()
+	b.Add("ads.example.com")
+	b.Add("Tracker.Example.NET.")
+	b.Add("a.b.c.test")
+	b.Add("ads.example.com.")
+
+	trie := b.Build()
+
+	for _, name := range []string{
+		"ads.example.com",
+		"ads.example.com.",
+		"ADS.Example.com",
+		"www.ads.example.com",
+		"tracker.example.net",
+		"x.y.tracker.example.net.",
+		"a.b.c.test",
+	} {
+		require.True(t, trie.Match(name), name)
+	}
+
+	for _, name := range []string{
+		"example.com",
+		"xads.example.com",
+		"ads.example.org",
+		"b.c.test",
+		"com",
+		"",
+		".",
+	} {
+		require.False(t, trie.Match(name), name)
+	}
+
+	stats := trie.Stats()
+	require.Equal(t, 3, stats.Domains)
+	require.Equal(t, 1, stats.Duplicates)
+	require.Zero(t, stats.Redundant)
+	require.NotZero(t, stats.Bytes)
+
+	t.Run("Redundant", func(t *testing.T) {
+		b := domaintrie.NewBuilder()
+		b.Add("a.example.com")
+		b.Add("b.a.example.com")
+		// Blocking the parent drops both subdomains.
+		b.Add("example.com")
+		b.Add("c.example.com")
+
+		trie := b.Build()
+
+		require.True(t, trie.Match("example.com"))
+		require.True(t, trie.Match("z.example.com"))
+
+		stats := trie.Stats()
+		require.Equal(t, 1, stats.Domains)
+		require.Equal(t, 3, stats.Redundant)
+		// The root, "com" and "example".
+		require.Equal(t, 3, stats.Nodes)
+	})
+
+	t.Run("Root", func(t *testing.T) {
+		b := domaintrie.NewBuilder()
+		b.Add(".")
+
+		require.True(t, b.Build().Match("example.com"))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		require.False(t, domaintrie.NewBuilder().Build().Match("example.com"))
+
+		var trie *domaintrie.Trie
+		require.False(t, trie.Match("example.com"))
+		require.Zero(t, trie.Stats())
+	})
+}
+
+func BenchmarkTrie(b *testing.B) {
+	const domains = 1_000_000
+
+	builder := domaintrie.NewBuilder()
+	names := make([]string, 0, domains)
+	for i := 0; i < domains; i++ {
+		name := fmt.Sprintf("ads%d.tracker%d.example", i, i%1000)
+		builder.Add(name)
+		names = append(names, "www."+name)
+	}
+
+	trie := builder.Build()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if !trie.Match(names[i%len(names)]) {
+			b.Fatal("not matched")
+		}
+	}
+
+	b.ReportMetric(float64(trie.Stats().Bytes)/(1<<20), "MiB")
+}
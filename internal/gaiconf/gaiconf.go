@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package gaiconf parses the glibc getaddrinfo(3) configuration file,
+// gai.conf(5), into an RFC 6724 address selection policy table.
+package gaiconf
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/noisysockets/resolver/internal/addrselect"
+)
+
+// Read reads and parses the gai.conf file at filename. If the file does not
+// exist, or doesn't customize the label or precedence tables, a nil policy
+// table is returned.
+func Read(filename string) (addrselect.PolicyTable, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return Decode(f)
+}
+
+type entry struct {
+	prefix netip.Prefix
+	value  uint8
+}
+
+// Decode parses a gai.conf file into an address selection policy table.
+// As with glibc, any label (or precedence) directives replace the default
+// label (or precedence) table entirely. Invalid lines are ignored.
+func Decode(r io.Reader) (addrselect.PolicyTable, error) {
+	var labels, precedences []entry
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			continue
+		}
+
+		switch f[0] {
+		case "label", "precedence":
+			prefix, err := netip.ParsePrefix(f[1])
+			if err != nil {
+				continue
+			}
+
+			value, err := strconv.ParseUint(f[2], 10, 8)
+			if err != nil {
+				continue
+			}
+
+			ent := entry{prefix: normalizePrefix(prefix), value: uint8(value)}
+			if f[0] == "label" {
+				labels = append(labels, ent)
+			} else {
+				precedences = append(precedences, ent)
+			}
+		default:
+			// scopev4 and reload are not supported.
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(labels) == 0 && len(precedences) == 0 {
+		return nil, nil
+	}
+
+	defaultTable := addrselect.DefaultPolicyTable()
+	if len(labels) == 0 {
+		for _, ent := range defaultTable {
+			labels = append(labels, entry{prefix: ent.Prefix, value: ent.Label})
+		}
+	}
+	if len(precedences) == 0 {
+		for _, ent := range defaultTable {
+			precedences = append(precedences, entry{prefix: ent.Prefix, value: ent.Precedence})
+		}
+	}
+
+	// Combine the label and precedence tables into a single table containing
+	// every prefix from both. The longest matching prefix in the combined table
+	// always inherits the values of the longest matching prefix in each of the
+	// original tables.
+	seen := make(map[netip.Prefix]bool)
+	var entries []addrselect.PolicyTableEntry
+	for _, ent := range append(labels, precedences...) {
+		if seen[ent.prefix] {
+			continue
+		}
+		seen[ent.prefix] = true
+
+		entries = append(entries, addrselect.PolicyTableEntry{
+			Prefix:     ent.prefix,
+			Precedence: lookup(precedences, ent.prefix),
+			Label:      lookup(labels, ent.prefix),
+		})
+	}
+
+	return addrselect.NewPolicyTable(entries), nil
+}
+
+// lookup returns the value of the longest prefix in entries that contains
+// prefix.
+func lookup(entries []entry, prefix netip.Prefix) uint8 {
+	var value uint8
+	bestBits := -1
+	for _, ent := range entries {
+		if ent.prefix.Bits() <= prefix.Bits() && ent.prefix.Bits() > bestBits && ent.prefix.Contains(prefix.Addr()) {
+			value = ent.value
+			bestBits = ent.prefix.Bits()
+		}
+	}
+	return value
+}
+
+// normalizePrefix converts IPv4 prefixes to IPv4-mapped IPv6 prefixes and
+// masks off any host bits.
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	if prefix.Addr().Is4() {
+		prefix = netip.PrefixFrom(netip.AddrFrom16(prefix.Addr().As16()), prefix.Bits()+96)
+	}
+	return prefix.Masked()
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package gaiconf
+
+// Location is the location of the gai.conf file.
+// gai.conf is specific to glibc, so this is empty on other platforms.
+const Location = ""
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package gaiconf_test
+
+import (
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/noisysockets/resolver/internal/addrselect"
+	"github.com/noisysockets/resolver/internal/gaiconf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	t.Run("Missing", func(t *testing.T) {
+		table, err := gaiconf.Read("testdata/missing-gai.conf")
+		require.NoError(t, err)
+
+		require.Nil(t, table)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		table, err := gaiconf.Decode(strings.NewReader("# nothing to see here\nreload no\n"))
+		require.NoError(t, err)
+
+		require.Nil(t, table)
+	})
+
+	t.Run("Precedence", func(t *testing.T) {
+		table, err := gaiconf.Read("testdata/prefer-ipv4-gai.conf")
+		require.NoError(t, err)
+
+		// The precedence table is replaced entirely.
+		require.Equal(t, addrselect.PolicyTableEntry{
+			Prefix:     netip.MustParsePrefix("::ffff:0:0/96"),
+			Precedence: 100,
+			Label:      4,
+		}, table.Classify(netip.MustParseAddr("192.0.2.1")))
+
+		// But the default labels are retained.
+		require.Equal(t, uint8(13), table.Classify(netip.MustParseAddr("fd00::1")).Label)
+		require.Equal(t, uint8(0), table.Classify(netip.MustParseAddr("fd00::1")).Precedence)
+	})
+
+	t.Run("Label", func(t *testing.T) {
+		table, err := gaiconf.Read("testdata/label-gai.conf")
+		require.NoError(t, err)
+
+		ent := table.Classify(netip.MustParseAddr("fd00::1"))
+		require.Equal(t, uint8(8), ent.Label)
+		// The default precedence of fc00::/7.
+		require.Equal(t, uint8(3), ent.Precedence)
+
+		ent = table.Classify(netip.MustParseAddr("2001:0:4136:e378::1"))
+		require.Equal(t, uint8(7), ent.Label)
+		// The default precedence of 2001::/32 (Teredo).
+		require.Equal(t, uint8(5), ent.Precedence)
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, name := range []string{"testdata/label-gai.conf", "testdata/prefer-ipv4-gai.conf"} {
+		data, err := os.ReadFile(name)
+		require.NoError(f, err)
+
+		f.Add(string(data))
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		table, err := gaiconf.Decode(strings.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		for i := 0; i < len(table)-1; i++ {
+			require.GreaterOrEqual(t, table[i].Prefix.Bits(), table[i+1].Prefix.Bits())
+		}
+	})
+}
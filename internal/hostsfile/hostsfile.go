@@ -35,6 +35,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"strings"
 
 	"github.com/miekg/dns"
@@ -42,7 +43,8 @@ import (
 
 // Represents a hosts file. Records match a single line in the file.
 type Hostsfile struct {
-	records []*Record
+	records  []*Record
+	warnings []error
 }
 
 // Records returns an array of all entries in the hostsfile.
@@ -50,6 +52,29 @@ func (h *Hostsfile) Records() []*Record {
 	return h.records
 }
 
+// Warnings returns the problems found while decoding the hostsfile (as
+// *LineError's), in line order. Malformed lines are skipped, and invalid
+// hostnames are omitted from their records.
+func (h *Hostsfile) Warnings() []error {
+	return h.warnings
+}
+
+// LineError is a problem with a line of a hostsfile.
+type LineError struct {
+	// Line is the (1-based) line number.
+	Line int
+	// Err describes the problem.
+	Err error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
 // A single line in the hosts file
 type Record struct {
 	IpAddress net.IPAddr
@@ -70,42 +95,66 @@ func (r *Record) Matches(hostname string) bool {
 // Decodes the raw text of a hostsfile into a Hostsfile struct. If a line
 // contains both an IP address and a comment, the comment will be lost.
 //
+// A single malformed line doesn't fail the whole hostsfile, instead the line
+// is skipped and a warning is recorded (see Hostsfile.Warnings). An error is
+// only returned if the hostsfile can't be read.
+//
 // Interface example from the image package.
 func Decode(rdr io.Reader) (Hostsfile, error) {
 	var h Hostsfile
 	scanner := bufio.NewScanner(rdr)
-	for scanner.Scan() {
-		rawLine := scanner.Text()
-		line := strings.TrimSpace(rawLine)
-		r := new(Record)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
 		if len(line) == 0 {
-			r.isBlank = true
+			h.records = append(h.records, &Record{isBlank: true})
+			continue
 		} else if line[0] == '#' {
-			// comment line or blank line: skip it.
-			r.comment = line
-		} else {
-			vals := strings.Fields(line)
-			if len(vals) <= 1 {
-				return Hostsfile{}, fmt.Errorf("invalid hostsfile entry: %s", line)
-			}
-			ip, err := net.ResolveIPAddr("ip", vals[0])
-			if err != nil {
-				return Hostsfile{}, err
-			}
-			r = &Record{
-				IpAddress: *ip,
+			h.records = append(h.records, &Record{comment: line})
+			continue
+		}
+
+		warn := func(err error) {
+			h.warnings = append(h.warnings, &LineError{Line: lineNum, Err: err})
+		}
+
+		// Comments may start anywhere in a line (eg. "127.0.0.1 foo#bar").
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		vals := strings.Fields(line)
+		if len(vals) <= 1 {
+			warn(fmt.Errorf("invalid hostsfile entry: %s", line))
+			continue
+		}
+
+		// Only accept address literals, we don't want to trigger any DNS
+		// lookups while parsing the hosts file.
+		addr, err := netip.ParseAddr(vals[0])
+		if err != nil {
+			if _, prefixErr := netip.ParsePrefix(vals[0]); prefixErr == nil {
+				warn(fmt.Errorf("invalid hostsfile entry address %q: CIDR prefixes are not supported", vals[0]))
+			} else {
+				warn(fmt.Errorf("invalid hostsfile entry address: %w", err))
 			}
-			for i := 1; i < len(vals); i++ {
-				name := vals[i]
-				if len(name) > 0 && name[0] == '#' {
-					// beginning of a comment. rest of the line is bunk
-					break
-				}
-				if _, ok := dns.IsDomainName(name); ok {
-					r.Hostnames = append(r.Hostnames, dns.CanonicalName(name))
-				}
+			continue
+		}
+
+		r := &Record{
+			IpAddress: net.IPAddr{IP: addr.AsSlice(), Zone: addr.Zone()},
+		}
+		for _, name := range vals[1:] {
+			if _, ok := dns.IsDomainName(name); !ok {
+				warn(fmt.Errorf("invalid hostsfile entry hostname %q", name))
+				continue
 			}
+			r.Hostnames = append(r.Hostnames, dns.CanonicalName(name))
 		}
+
+		if len(r.Hostnames) == 0 {
+			continue
+		}
+
 		h.records = append(h.records, r)
 	}
 	if err := scanner.Err(); err != nil {
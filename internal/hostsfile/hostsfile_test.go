@@ -31,6 +31,7 @@
 package hostsfile
 
 import (
+	"net/netip"
 	"strings"
 	"testing"
 
@@ -62,12 +63,10 @@ func TestDecode(t *testing.T) {
 
 	badline := strings.NewReader("blah")
 	h, err = Decode(badline)
-	if err == nil {
-		t.Error("expected Decode(\"blah\") to return invalid, got no error")
-	}
-	if err.Error() != "invalid hostsfile entry: blah" {
-		t.Errorf("expected Decode(\"blah\") to return invalid, got %s", err.Error())
-	}
+	require.NoError(t, err)
+	require.Empty(t, h.Records())
+	require.Len(t, h.Warnings(), 1)
+	require.EqualError(t, h.Warnings()[0], "line 1: invalid hostsfile entry: blah")
 
 	h, err = Decode(strings.NewReader("##\n127.0.0.1\tlocalhost    2nd-alias"))
 	require.NoError(t, err)
@@ -77,4 +76,74 @@ func TestDecode(t *testing.T) {
 	require.NoError(t, err)
 	require.NotContains(t, h.records[0].Hostnames, "#.")
 	require.NotContains(t, h.records[0].Hostnames, "a.")
+
+	h, err = Decode(strings.NewReader("127.0.0.1 foo#a comment"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo."}, h.records[0].Hostnames)
+	require.Empty(t, h.Warnings())
+}
+
+func TestDecodeWarnings(t *testing.T) {
+	t.Parallel()
+
+	sampledata := strings.Join([]string{
+		"127.0.0.1 localhost",
+		"10.0.0.0/8 corp",
+		"not-an-address foo",
+		"10.0.0.1",
+		"10.0.0.2 bar ..invalid",
+		"10.0.0.3 baz",
+	}, "\n")
+
+	h, err := Decode(strings.NewReader(sampledata))
+	require.NoError(t, err)
+
+	// Lines after malformed ones are still decoded.
+	var hostnames []string
+	for _, r := range h.Records() {
+		hostnames = append(hostnames, r.Hostnames...)
+	}
+	require.Equal(t, []string{"localhost.", "bar.", "baz."}, hostnames)
+
+	warnings := h.Warnings()
+	require.Len(t, warnings, 4)
+
+	var lines []int
+	for _, warning := range warnings {
+		var lineErr *LineError
+		require.ErrorAs(t, warning, &lineErr)
+		lines = append(lines, lineErr.Line)
+	}
+	require.Equal(t, []int{2, 3, 4, 5}, lines)
+
+	require.ErrorContains(t, warnings[0], "CIDR prefixes are not supported")
+	require.ErrorContains(t, warnings[3], `invalid hostsfile entry hostname "..invalid"`)
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add("127.0.0.1 foobar\n# this is a comment\n\n10.0.0.1 anotheralias")
+	f.Add("127.0.0.1 name alias1 alias2 alias3")
+	f.Add("fe80::1%lo0 localhost # a comment")
+	f.Add("blah")
+
+	f.Fuzz(func(t *testing.T, data string) {
+		h, err := Decode(strings.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		for _, r := range h.Records() {
+			if r.isBlank || r.comment != "" {
+				continue
+			}
+
+			if _, err := netip.ParseAddr(r.IpAddress.String()); err != nil {
+				t.Errorf("record has invalid address %q: %v", r.IpAddress.String(), err)
+			}
+
+			for _, hn := range r.Hostnames {
+				_ = r.Matches(hn)
+			}
+		}
+	})
 }
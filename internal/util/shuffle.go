@@ -14,17 +14,27 @@ import (
 	"math/big"
 )
 
-// Shuffle shuffles the elements of a slice.
+// Shuffle shuffles s in place using a cryptographically secure random source,
+// returning s.
 func Shuffle[T any](s []T) []T {
-	n := len(s)
-	for i := n - 1; i > 0; i-- {
-		jBig, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
-		if err != nil {
-			panic(err)
-		}
+	return ShuffleFunc(s, cryptoIntN)
+}
 
-		j := int(jBig.Int64())
+// ShuffleFunc shuffles s in place (using the Fisher-Yates algorithm), returning
+// s. intN must return a random integer in [0, n).
+func ShuffleFunc[T any](s []T, intN func(n int) int) []T {
+	for i := len(s) - 1; i > 0; i-- {
+		j := intN(i + 1)
 		s[i], s[j] = s[j], s[i]
 	}
 	return s
 }
+
+func cryptoIntN(n int) int {
+	nBig, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err)
+	}
+
+	return int(nBig.Int64())
+}
@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"net"
+)
+
+// IPAddrResolver is implemented by resolvers that can look up net.IPAddr
+// addresses directly, this interface is also implemented by net.Resolver from
+// the Go standard library.
+type IPAddrResolver interface {
+	// LookupIPAddr looks up host, returning its IPv4 and IPv6 addresses.
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// LookupIPAddr looks up host using the resolver, returning its addresses as
+// net.IPAddr values (as returned by net.Resolver.LookupIPAddr). This eases
+// the migration of code written against net.DefaultResolver. IPv6 zones (eg.
+// from hosts file entries, or link-local answers from a link-local server)
+// are preserved.
+func LookupIPAddr(ctx context.Context, resolver Resolver, host string) ([]net.IPAddr, error) {
+	if ir, ok := resolver.(IPAddrResolver); ok {
+		return ir.LookupIPAddr(ctx, host)
+	}
+
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	ipAddrs := make([]net.IPAddr, 0, len(addrs))
+	for _, addr := range addrs {
+		ipAddrs = append(ipAddrs, net.IPAddr{
+			IP:   addr.Unmap().AsSlice(),
+			Zone: addr.Zone(),
+		})
+	}
+
+	return ipAddrs, nil
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupIPAddr(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Hosts", func(t *testing.T) {
+		res, err := resolver.Hosts(&resolver.HostsResolverConfig{
+			NoHostsFile:  ptr.To(true),
+			AddressOrder: ptr.To(resolver.AddressOrderNone),
+		})
+		require.NoError(t, err)
+
+		res.AddHost("printer.local", netip.MustParseAddr("192.168.1.20"), netip.MustParseAddr("fe80::20%eth0"))
+
+		ipAddrs, err := resolver.LookupIPAddr(ctx, res, "printer.local")
+		require.NoError(t, err)
+
+		require.Equal(t, []net.IPAddr{
+			{IP: net.ParseIP("192.168.1.20").To4()},
+			{IP: net.ParseIP("fe80::20"), Zone: "eth0"},
+		}, ipAddrs)
+	})
+
+	t.Run("Link-Local Server", func(t *testing.T) {
+		srv := resolvertest.NewServer(t, nil)
+		srv.AddRecords(&dns.AAAA{
+			Hdr:  dns.RR_Header{Name: "router.lan.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+			AAAA: net.ParseIP("fe80::1"),
+		}, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: "router.lan.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+			AAAA: net.ParseIP("2001:db8::1"),
+		})
+
+		// Pretend the server is reachable via a link-local address.
+		res, err := resolver.DNS(resolver.DNSResolverConfig{
+			Server:       netip.AddrPortFrom(netip.MustParseAddr("fe80::53%eth0"), 53),
+			AddressOrder: ptr.To(resolver.AddressOrderNone),
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, srv.Addr().String())
+			},
+		})
+		require.NoError(t, err)
+
+		ipAddrs, err := resolver.LookupIPAddr(ctx, res, "router.lan")
+		require.NoError(t, err)
+
+		require.Equal(t, []net.IPAddr{
+			{IP: net.ParseIP("fe80::1"), Zone: "eth0"},
+			{IP: net.ParseIP("2001:db8::1")},
+		}, ipAddrs)
+	})
+}
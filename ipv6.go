@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*ipv6GateResolver)(nil)
+
+// IPv6Mode controls whether IPv6 addresses are looked up.
+type IPv6Mode string
+
+const (
+	// IPv6ModeAuto looks up IPv6 addresses only if the host has IPv6
+	// connectivity, ie. a route (with a non link-local source address) to
+	// global IPv6 destinations.
+	IPv6ModeAuto IPv6Mode = "auto"
+	// IPv6ModeEnabled always looks up IPv6 addresses.
+	IPv6ModeEnabled IPv6Mode = "enabled"
+	// IPv6ModeDisabled never looks up IPv6 addresses.
+	IPv6ModeDisabled IPv6Mode = "disabled"
+)
+
+// IPv6GateResolverConfig is the configuration for an IPv6 gate resolver.
+type IPv6GateResolverConfig struct {
+	// Mode controls whether IPv6 addresses are looked up.
+	// By default, IPv6ModeAuto.
+	Mode *IPv6Mode
+	// DialContext is an optional dialer used to probe for an IPv6 route in
+	// auto mode. By default, DefaultReachabilityProbe is used.
+	DialContext DialContextFunc
+	// SourceAddrProvider is an optional provider of the source addresses used
+	// to detect IPv6 connectivity in auto mode, instead of probing for a
+	// route.
+	SourceAddrProvider SourceAddrProvider
+}
+
+// ipv6GateResolver skips IPv6 lookups when IPv6 is disabled (or unavailable).
+type ipv6GateResolver struct {
+	resolver Resolver
+	mode     IPv6Mode
+	probe    *ReachabilityProbe
+	srcAddrs SourceAddrProvider
+}
+
+// IPv6Gate returns a resolver that, when IPv6 is disabled (or in auto mode,
+// when the host has no IPv6 connectivity), looks up only IPv4 addresses. AAAA
+// queries are skipped entirely (halving the query volume on IPv4 only
+// networks, like the Go runtime does) and any IPv6 addresses returned by the
+// wrapped resolver are dropped.
+func IPv6Gate(resolver Resolver, conf *IPv6GateResolverConfig) (*ipv6GateResolver, error) {
+	conf, err := defaults.WithDefaults(conf, &IPv6GateResolverConfig{
+		Mode: ptr.To(IPv6ModeAuto),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to ipv6 gate resolver config: %w", err)
+	}
+
+	switch *conf.Mode {
+	case IPv6ModeAuto, IPv6ModeEnabled, IPv6ModeDisabled:
+	default:
+		return nil, fmt.Errorf("invalid ipv6 mode %q", *conf.Mode)
+	}
+
+	var probe *ReachabilityProbe
+	if *conf.Mode == IPv6ModeAuto && conf.SourceAddrProvider == nil {
+		probe = DefaultReachabilityProbe()
+		if conf.DialContext != nil {
+			probe, err = NewReachabilityProbe(&ReachabilityProbeConfig{
+				DialContext: conf.DialContext,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &ipv6GateResolver{
+		resolver: resolver,
+		mode:     *conf.Mode,
+		probe:    probe,
+		srcAddrs: conf.SourceAddrProvider,
+	}, nil
+}
+
+func (r *ipv6GateResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	network, _ = ipNetwork(network)
+
+	// IP literals are returned as is.
+	if _, err := netip.ParseAddr(host); err == nil || r.available(ctx) {
+		return r.resolver.LookupNetIP(ctx, network, host)
+	}
+
+	switch network {
+	case "ip6":
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       host,
+			IsNotFound: true,
+		}
+	case "ip":
+		network = "ip4"
+	}
+
+	addrs, err := r.resolver.LookupNetIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ipv4Addrs := make([]netip.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr.Unmap().Is4() {
+			ipv4Addrs = append(ipv4Addrs, addr)
+		}
+	}
+
+	if len(ipv4Addrs) == 0 {
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       host,
+			IsNotFound: true,
+		}
+	}
+
+	return ipv4Addrs, nil
+}
+
+func (r *ipv6GateResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return lookupAddr(ctx, r.resolver, addr)
+}
+
+func (r *ipv6GateResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	if q.Type == dns.TypeAAAA && !r.available(ctx) {
+		return Answer{}, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       q.Name,
+			IsNotFound: true,
+		}
+	}
+
+	return Lookup(ctx, r.resolver, q)
+}
+
+func (r *ipv6GateResolver) Describe() Description {
+	return Description{
+		Type: "ipv6-gate",
+		Attributes: map[string]string{
+			"mode":      string(r.mode),
+			"available": strconv.FormatBool(r.available(context.Background())),
+		},
+		Children: []Description{Describe(r.resolver)},
+	}
+}
+
+// available reports whether IPv6 addresses should be looked up.
+func (r *ipv6GateResolver) available(ctx context.Context) bool {
+	switch r.mode {
+	case IPv6ModeEnabled:
+		return true
+	case IPv6ModeDisabled:
+		return false
+	}
+
+	if r.srcAddrs == nil {
+		_, ipv6 := r.probe.Reachable(ctx)
+		return ipv6
+	}
+
+	src := r.srcAddrs.SourceAddrs(ctx, []netip.Addr{ipv6ProbeAddr})[0]
+	return src.IsValid() && src.Is6() && !src.Is4In6() &&
+		!src.IsLinkLocalUnicast() && !src.IsLoopback()
+}
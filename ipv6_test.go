@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPv6Gate(t *testing.T) {
+	fake := resolvertest.NewFake()
+
+	ctx := context.Background()
+	probe := netip.MustParseAddr("2000::1")
+
+	t.Run("Disabled", func(t *testing.T) {
+		fake.Reset()
+		fake.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("2001:db8::1"))
+
+		res, err := resolver.IPv6Gate(fake, &resolver.IPv6GateResolverConfig{
+			Mode: ptr.To(resolver.IPv6ModeDisabled),
+		})
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(ctx, "tcp", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+		// Only the A query is made.
+		require.Equal(t, []resolvertest.Call{{Network: "ip4", Host: "example.com"}}, fake.Calls())
+
+		_, err = res.LookupNetIP(ctx, "ip6", "example.com")
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+		require.Len(t, fake.Calls(), 1)
+
+		_, err = resolver.Lookup(ctx, res, resolver.Question{Name: "example.com.", Type: dns.TypeAAAA})
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+
+		// IP literals are returned as is.
+		res, err = resolver.IPv6Gate(resolver.Literal(), &resolver.IPv6GateResolverConfig{
+			Mode: ptr.To(resolver.IPv6ModeDisabled),
+		})
+		require.NoError(t, err)
+
+		addrs, err = res.LookupNetIP(ctx, "ip", "2001:db8::3")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("2001:db8::3")}, addrs)
+	})
+
+	t.Run("Auto", func(t *testing.T) {
+		fake.Reset()
+		fake.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("2001:db8::1"))
+		fake.SetAddrs("ipv6-only.example.com", netip.MustParseAddr("2001:db8::2"))
+
+		// Only a link-local IPv6 address.
+		res, err := resolver.IPv6Gate(fake, &resolver.IPv6GateResolverConfig{
+			SourceAddrProvider: staticSourceAddrs{probe: netip.MustParseAddr("fe80::1")},
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, "false", resolver.Describe(res).Attributes["available"])
+
+		_, err = res.LookupNetIP(ctx, "ip", "ipv6-only.example.com")
+		require.Error(t, err)
+
+		// A global IPv6 address.
+		res, err = resolver.IPv6Gate(fake, &resolver.IPv6GateResolverConfig{
+			SourceAddrProvider: staticSourceAddrs{probe: netip.MustParseAddr("2001:db8::100")},
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, "true", resolver.Describe(res).Attributes["available"])
+
+		addrs, err := res.LookupNetIP(ctx, "ip", "example.com")
+		require.NoError(t, err)
+		require.Len(t, addrs, 2)
+	})
+
+	t.Run("Invalid Mode", func(t *testing.T) {
+		_, err := resolver.IPv6Gate(fake, &resolver.IPv6GateResolverConfig{
+			Mode: ptr.To(resolver.IPv6Mode("sometimes")),
+		})
+		require.Error(t, err)
+	})
+}
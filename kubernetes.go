@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
+)
+
+var _ Resolver = (*KubernetesResolver)(nil)
+
+// KubernetesResolverConfig is the configuration for a Kubernetes resolver.
+type KubernetesResolverConfig struct {
+	// ClusterDomain is the DNS domain of the cluster.
+	// By default, "cluster.local.".
+	ClusterDomain string
+	// Namespace is the namespace of services named without one (eg. "api").
+	// By default, "default".
+	Namespace string
+}
+
+// KubernetesResolver is a resolver that understands the DNS conventions of
+// Kubernetes services, expanding short names into names within the cluster
+// domain:
+//
+//   - "<service>" to "<service>.<namespace>.svc.<cluster-domain>".
+//   - "<service>.<namespace>" to "<service>.<namespace>.svc.<cluster-domain>".
+//   - Names ending in ".svc" or ".pod" (eg. "<hostname>.<service>.<namespace>.svc"
+//     for the pods of a headless service) are suffixed with the cluster domain.
+//
+// Leading underscore labels (eg. "_http._tcp.<service>") are preserved, so that
+// SRV names can be expanded too. Names outside of the cluster domain are not
+// found, so that they can be passed on to other resolvers.
+//
+// Queries are sent to the upstream resolver, which need not be in the cluster
+// (eg. a DNS resolver dialing the cluster's DNS service over a tunnel).
+type KubernetesResolver struct {
+	resolver      Resolver
+	clusterDomain string
+	namespace     string
+}
+
+// Kubernetes returns a resolver that resolves Kubernetes service names using
+// the upstream resolver.
+func Kubernetes(resolver Resolver, conf *KubernetesResolverConfig) (*KubernetesResolver, error) {
+	conf, err := defaults.WithDefaults(conf, &KubernetesResolverConfig{
+		ClusterDomain: "cluster.local.",
+		Namespace:     "default",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to kubernetes resolver config: %w", err)
+	}
+
+	if _, ok := dns.IsDomainName(conf.ClusterDomain); !ok || conf.ClusterDomain == "." {
+		return nil, fmt.Errorf("invalid cluster domain %q", conf.ClusterDomain)
+	}
+
+	if strings.Contains(conf.Namespace, ".") {
+		return nil, fmt.Errorf("invalid namespace %q", conf.Namespace)
+	}
+
+	return &KubernetesResolver{
+		resolver:      resolver,
+		clusterDomain: dns.CanonicalName(conf.ClusterDomain),
+		namespace:     strings.ToLower(conf.Namespace),
+	}, nil
+}
+
+func (r *KubernetesResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	name, ok := r.expand(host)
+	if !ok {
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       host,
+			IsNotFound: true,
+		}
+	}
+
+	return r.resolver.LookupNetIP(ctx, network, name)
+}
+
+func (r *KubernetesResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return lookupAddr(ctx, r.resolver, addr)
+}
+
+// Lookup answers the question, after expanding the name of the question.
+func (r *KubernetesResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	if q.Type == dns.TypePTR {
+		return Lookup(ctx, r.resolver, q)
+	}
+
+	name, ok := r.expand(q.Name)
+	if !ok {
+		return Answer{}, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       q.Name,
+			IsNotFound: true,
+		}
+	}
+
+	return Lookup(ctx, r.resolver, Question{Name: name, Type: q.Type})
+}
+
+// LookupSRV looks up the SRV records of a service, like net.Resolver's
+// LookupSRV, eg. LookupSRV(ctx, "grpc", "tcp", "api.prod") queries
+// "_grpc._tcp.api.prod.svc.<cluster-domain>". For headless services, the
+// targets are the names of the service's pods. If service and proto are
+// empty, name is looked up directly.
+//
+// The returned records are sorted by priority, and then weight (highest
+// first).
+func (r *KubernetesResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if service != "" || proto != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+
+	answer, err := r.Lookup(ctx, Question{Name: name, Type: dns.TypeSRV})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var cname string
+	var srvs []*net.SRV
+	for _, rr := range answer.Records {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+
+		cname = srv.Hdr.Name
+		srvs = append(srvs, &net.SRV{
+			Target:   srv.Target,
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+		})
+	}
+
+	if len(srvs) == 0 {
+		return "", nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       name,
+			IsNotFound: true,
+		}
+	}
+
+	slices.SortStableFunc(srvs, func(a, b *net.SRV) int {
+		if a.Priority != b.Priority {
+			return int(a.Priority) - int(b.Priority)
+		}
+		return int(b.Weight) - int(a.Weight)
+	})
+
+	return cname, srvs, nil
+}
+
+// expand returns the fully qualified name within the cluster domain of host.
+// The boolean result is false if host is not a cluster name.
+func (r *KubernetesResolver) expand(host string) (string, bool) {
+	if _, ok := dns.IsDomainName(host); !ok || host == "" || host == "." {
+		return "", false
+	}
+
+	name := dns.CanonicalName(host)
+	if dns.IsSubDomain(r.clusterDomain, name) {
+		return name, true
+	}
+
+	// Absolute names outside of the cluster domain are left alone.
+	if strings.HasSuffix(host, ".") {
+		return "", false
+	}
+
+	labels := dns.SplitDomainName(name)
+
+	// Split off the service and protocol labels of SRV names.
+	var prefix []string
+	for len(labels) > 1 && strings.HasPrefix(labels[0], "_") {
+		prefix = append(prefix, labels[0])
+		labels = labels[1:]
+	}
+
+	switch {
+	case len(labels) == 1:
+		labels = append(labels, r.namespace, "svc")
+	case len(labels) == 2:
+		labels = append(labels, "svc")
+	case labels[len(labels)-1] == "svc" || labels[len(labels)-1] == "pod":
+	default:
+		return "", false
+	}
+
+	return strings.Join(append(prefix, labels...), ".") + "." + r.clusterDomain, true
+}
+
+func (r *KubernetesResolver) Describe() Description {
+	return Description{
+		Type: "kubernetes",
+		Attributes: map[string]string{
+			"cluster-domain": r.clusterDomain,
+			"namespace":      r.namespace,
+		},
+		Children: []Description{Describe(r.resolver)},
+	}
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKubernetesResolver(t *testing.T) {
+	ctx := context.Background()
+
+	srv := resolvertest.NewServer(t, nil)
+	srv.AddAddrs("api.default.svc.k8s.internal.", time.Minute, netip.MustParseAddr("10.96.0.20"))
+	srv.AddAddrs("db.prod.svc.k8s.internal.", time.Minute, netip.MustParseAddr("10.96.0.30"))
+	srv.AddAddrs("db-0.db.prod.svc.k8s.internal.", time.Minute, netip.MustParseAddr("10.0.1.10"))
+	srv.AddRecords(&dns.SRV{
+		Hdr:      dns.RR_Header{Name: "_postgres._tcp.db.prod.svc.k8s.internal.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 30},
+		Priority: 10,
+		Weight:   50,
+		Port:     5432,
+		Target:   "db-1.db.prod.svc.k8s.internal.",
+	}, &dns.SRV{
+		Hdr:      dns.RR_Header{Name: "_postgres._tcp.db.prod.svc.k8s.internal.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 30},
+		Priority: 0,
+		Weight:   50,
+		Port:     5432,
+		Target:   "db-0.db.prod.svc.k8s.internal.",
+	})
+
+	dnsRes, err := resolver.DNS(resolver.DNSResolverConfig{
+		Server: srv.Addr(),
+	})
+	require.NoError(t, err)
+
+	res, err := resolver.Kubernetes(dnsRes, &resolver.KubernetesResolverConfig{
+		ClusterDomain: "k8s.internal",
+	})
+	require.NoError(t, err)
+
+	t.Run("Expansion", func(t *testing.T) {
+		for host, want := range map[string]string{
+			"api":                           "10.96.0.20",
+			"db.prod":                       "10.96.0.30",
+			"db.prod.svc":                   "10.96.0.30",
+			"db.prod.svc.k8s.internal.":     "10.96.0.30",
+			"db-0.db.prod.svc":              "10.0.1.10",
+			"DB-0.db.prod.svc.k8s.internal": "10.0.1.10",
+		} {
+			addrs, err := res.LookupNetIP(ctx, "ip4", host)
+			require.NoError(t, err, host)
+
+			require.Equal(t, []netip.Addr{netip.MustParseAddr(want)}, addrs, host)
+		}
+	})
+
+	t.Run("Outside Cluster", func(t *testing.T) {
+		for _, host := range []string{"example.com.", "www.example.com"} {
+			_, err := res.LookupNetIP(ctx, "ip4", host)
+			require.Error(t, err)
+
+			var dnsErr *net.DNSError
+			require.ErrorAs(t, err, &dnsErr)
+			require.True(t, dnsErr.IsNotFound)
+		}
+	})
+
+	t.Run("SRV", func(t *testing.T) {
+		cname, srvs, err := res.LookupSRV(ctx, "postgres", "tcp", "db.prod")
+		require.NoError(t, err)
+
+		require.Equal(t, "_postgres._tcp.db.prod.svc.k8s.internal.", cname)
+		require.Len(t, srvs, 2)
+		require.Equal(t, "db-0.db.prod.svc.k8s.internal.", srvs[0].Target)
+		require.Equal(t, uint16(5432), srvs[0].Port)
+	})
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// QueryLimiter limits the number of concurrent queries sent to upstream DNS
+// servers, across all of the resolvers it is shared with. Excess queries are
+// queued (in FIFO order) until a slot becomes available, rather than opening
+// an unbounded number of sockets. This protects conntrack tables and file
+// descriptor limits under burst load.
+type QueryLimiter struct {
+	max      int
+	sem      *semaphore.Weighted
+	inFlight atomic.Int64
+	queued   atomic.Int64
+}
+
+// NewQueryLimiter returns a limiter that allows at most max concurrent
+// queries. A max less than one is treated as one.
+func NewQueryLimiter(max int) *QueryLimiter {
+	if max < 1 {
+		max = 1
+	}
+
+	return &QueryLimiter{
+		max: max,
+		sem: semaphore.NewWeighted(int64(max)),
+	}
+}
+
+// Max returns the maximum number of concurrent queries.
+func (l *QueryLimiter) Max() int {
+	return l.max
+}
+
+// InFlight returns the number of queries currently holding a slot.
+func (l *QueryLimiter) InFlight() int {
+	return int(l.inFlight.Load())
+}
+
+// Queued returns the number of queries waiting for a slot.
+func (l *QueryLimiter) Queued() int {
+	return int(l.queued.Load())
+}
+
+// acquire waits for a slot to become available.
+func (l *QueryLimiter) acquire(ctx context.Context) error {
+	l.queued.Add(1)
+	err := l.sem.Acquire(ctx, 1)
+	l.queued.Add(-1)
+	if err != nil {
+		return err
+	}
+
+	l.inFlight.Add(1)
+	return nil
+}
+
+// release returns a slot acquired with acquire.
+func (l *QueryLimiter) release() {
+	l.inFlight.Add(-1)
+	l.sem.Release(1)
+}
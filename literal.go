@@ -45,7 +45,8 @@ func (r *literalResolver) LookupNetIP(ctx context.Context, network, host string)
 		addrs = []netip.Addr{addr}
 	}
 
-	if network != "ip" && network != "ip4" && network != "ip6" {
+	network, supported := ipNetwork(network)
+	if !supported {
 		return nil, &net.DNSError{
 			Err:  ErrUnsupportedNetwork.Error(),
 			Name: host,
@@ -63,3 +64,7 @@ func (r *literalResolver) LookupNetIP(ctx context.Context, network, host string)
 
 	return addrs, nil
 }
+
+func (r *literalResolver) Describe() Description {
+	return Description{Type: "literal"}
+}
@@ -47,6 +47,26 @@ func TestLiteralResolver(t *testing.T) {
 		require.Error(t, err)
 	})
 
+	t.Run("Transport Networks", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(context.Background(), "tcp4", "localhost")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, addrs)
+
+		addrs, err = res.LookupNetIP(context.Background(), "udp6", "localhost")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.IPv6Loopback()}, addrs)
+
+		addrs, err = res.LookupNetIP(context.Background(), "ip4:icmp", "10.0.0.1")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+		_, err = res.LookupNetIP(context.Background(), "unix", "10.0.0.1")
+		require.Error(t, err)
+	})
+
 	t.Run("Localhost", func(t *testing.T) {
 		addrs, err := res.LookupNetIP(context.Background(), "ip", "localhost")
 		require.NoError(t, err)
@@ -0,0 +1,304 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*mergeResolver)(nil)
+
+// MergePolicy decides how the addresses (of one address family) returned by
+// the sources of a merge resolver are combined. Whatever the policy,
+// duplicate addresses are dropped (keeping the first occurrence) and the order
+// of the addresses returned by each source is preserved.
+type MergePolicy string
+
+const (
+	// MergePolicyShadow uses only the addresses of the highest priority source
+	// that returned any, eg. so that local entries shadow upstream ones.
+	MergePolicyShadow MergePolicy = "shadow"
+	// MergePolicyAppend uses the addresses of every source, in priority order.
+	MergePolicyAppend MergePolicy = "append"
+	// MergePolicyInterleave uses the addresses of every source, taking one
+	// address from each source in turn (in priority order).
+	MergePolicyInterleave MergePolicy = "interleave"
+)
+
+func (p MergePolicy) validate() error {
+	switch p {
+	case MergePolicyShadow, MergePolicyAppend, MergePolicyInterleave:
+		return nil
+	default:
+		return fmt.Errorf("invalid merge policy %q", p)
+	}
+}
+
+// MergeSource is a source of addresses for a merge resolver.
+type MergeSource struct {
+	// Resolver is the resolver queried for addresses.
+	Resolver Resolver
+	// Priority orders the sources, higher priority sources come first. Sources
+	// of equal priority keep the order they were given in.
+	Priority int
+}
+
+// MergeResolverConfig is the configuration of a merge resolver.
+type MergeResolverConfig struct {
+	// IPv4 is the policy used to combine IPv4 addresses.
+	// By default, MergePolicyAppend.
+	IPv4 *MergePolicy
+	// IPv6 is the policy used to combine IPv6 addresses.
+	// By default, MergePolicyAppend.
+	IPv6 *MergePolicy
+}
+
+// mergeResolver is a resolver that combines the addresses returned by
+// multiple resolvers.
+type mergeResolver struct {
+	sources []MergeSource
+	ipv4    MergePolicy
+	ipv6    MergePolicy
+}
+
+// Merge returns a resolver that queries every source concurrently and combines
+// the addresses they return, per address family, according to the configured
+// policies (eg. so that local sources shadow, or are appended to, upstream
+// responses). The results are deterministic: they only depend on the priority
+// (and order) of the sources, never on which source answered first.
+//
+// A lookup only fails if no source returns any addresses, the failures of
+// individual sources are otherwise ignored.
+func Merge(conf *MergeResolverConfig, sources ...MergeSource) (*mergeResolver, error) {
+	conf, err := defaults.WithDefaults(conf, &MergeResolverConfig{
+		IPv4: ptr.To(MergePolicyAppend),
+		IPv6: ptr.To(MergePolicyAppend),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to merge resolver config: %w", err)
+	}
+
+	if err := conf.IPv4.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := conf.IPv6.validate(); err != nil {
+		return nil, err
+	}
+
+	sources = slices.Clone(sources)
+	slices.SortStableFunc(sources, func(a, b MergeSource) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+
+	return &mergeResolver{
+		sources: sources,
+		ipv4:    *conf.IPv4,
+		ipv6:    *conf.IPv6,
+	}, nil
+}
+
+func (r *mergeResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	results := make([][]netip.Addr, len(r.sources))
+	errs := make([]error, len(r.sources))
+
+	var wg sync.WaitGroup
+	wg.Add(len(r.sources))
+
+	for i, source := range r.sources {
+		go func(i int, resolver Resolver) {
+			defer wg.Done()
+
+			results[i], errs[i] = resolver.LookupNetIP(ctx, network, host)
+		}(i, source.Resolver)
+	}
+
+	wg.Wait()
+
+	ipv4 := make([][]netip.Addr, len(results))
+	ipv6 := make([][]netip.Addr, len(results))
+
+	// The address families are returned in the order used by the highest
+	// priority source that returned any addresses.
+	var ipv6First, ordered bool
+	for i, addrs := range results {
+		if errs[i] != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if addr.Unmap().Is4() {
+				ipv4[i] = append(ipv4[i], addr)
+			} else {
+				ipv6[i] = append(ipv6[i], addr)
+			}
+		}
+
+		if !ordered && len(addrs) > 0 {
+			ipv6First, ordered = !addrs[0].Unmap().Is4(), true
+		}
+	}
+
+	ipv4Addrs := mergeAddrs(r.ipv4, ipv4)
+	ipv6Addrs := mergeAddrs(r.ipv6, ipv6)
+
+	if len(ipv4Addrs) == 0 && len(ipv6Addrs) == 0 {
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       host,
+			IsNotFound: true,
+		}
+	}
+
+	if ipv6First {
+		return append(ipv6Addrs, ipv4Addrs...), nil
+	}
+
+	return append(ipv4Addrs, ipv6Addrs...), nil
+}
+
+// LookupAddr returns the names returned by every source, in priority order.
+// Unlike forward lookups the sources are queried in turn as reverse lookups
+// are rarely latency sensitive.
+func (r *mergeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	var names []string
+	var errs []error
+	for _, source := range r.sources {
+		sourceNames, err := lookupAddr(ctx, source.Resolver, addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, name := range sourceNames {
+			if !slices.ContainsFunc(names, func(n string) bool {
+				return strings.EqualFold(n, name)
+			}) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	if len(names) == 0 {
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       addr,
+			IsNotFound: true,
+		}
+	}
+
+	return names, nil
+}
+
+// Lookup answers the question, address questions are answered with the merged
+// addresses, other questions are sent to each source in turn (in priority
+// order).
+func (r *mergeResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	switch q.Type {
+	case dns.TypeA, dns.TypeAAAA:
+		network := "ip4"
+		if q.Type == dns.TypeAAAA {
+			network = "ip6"
+		}
+
+		addrs, err := r.LookupNetIP(ctx, network, q.Name)
+		if err != nil {
+			return Answer{}, err
+		}
+
+		return addrAnswer(q, addrs), nil
+	default:
+		return Sequential(r.resolvers()...).Lookup(ctx, q)
+	}
+}
+
+func (r *mergeResolver) Describe() Description {
+	return Description{
+		Type: "merge",
+		Attributes: map[string]string{
+			"ipv4": string(r.ipv4),
+			"ipv6": string(r.ipv6),
+		},
+		Children: describeAll(r.resolvers()),
+	}
+}
+
+// resolvers returns the resolvers of the sources, in priority order.
+func (r *mergeResolver) resolvers() []Resolver {
+	resolvers := make([]Resolver, 0, len(r.sources))
+	for _, source := range r.sources {
+		resolvers = append(resolvers, source.Resolver)
+	}
+	return resolvers
+}
+
+// mergeAddrs combines the addresses returned by each source (in priority
+// order) according to the policy.
+func mergeAddrs(policy MergePolicy, results [][]netip.Addr) []netip.Addr {
+	var merged []netip.Addr
+	seen := make(map[netip.Addr]struct{})
+	add := func(addr netip.Addr) {
+		key := addr.Unmap()
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			merged = append(merged, addr)
+		}
+	}
+
+	switch policy {
+	case MergePolicyShadow:
+		for _, addrs := range results {
+			if len(addrs) > 0 {
+				for _, addr := range addrs {
+					add(addr)
+				}
+				break
+			}
+		}
+	case MergePolicyAppend:
+		for _, addrs := range results {
+			for _, addr := range addrs {
+				add(addr)
+			}
+		}
+	case MergePolicyInterleave:
+		for i, more := 0, true; more; i++ {
+			more = false
+			for _, addrs := range results {
+				if i < len(addrs) {
+					add(addrs[i])
+					more = true
+				}
+			}
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeResolver(t *testing.T) {
+	ctx := context.Background()
+
+	local, err := resolver.Static(map[string][]netip.Addr{
+		"app.example.com": {
+			netip.MustParseAddr("10.0.0.1"),
+			netip.MustParseAddr("10.0.0.2"),
+			netip.MustParseAddr("192.0.2.1"),
+		},
+	})
+	require.NoError(t, err)
+
+	upstream := resolvertest.NewFake()
+	upstream.SetAddrs("app.example.com",
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("192.0.2.2"),
+		netip.MustParseAddr("2001:db8::1"),
+	)
+
+	// Sources are given in reverse priority order, to check that they're
+	// sorted by priority.
+	sources := []resolver.MergeSource{
+		{Resolver: upstream},
+		{Resolver: local, Priority: 10},
+	}
+
+	newMerge := func(t *testing.T, ipv4, ipv6 resolver.MergePolicy) resolver.Resolver {
+		res, err := resolver.Merge(&resolver.MergeResolverConfig{
+			IPv4: ptr.To(ipv4),
+			IPv6: ptr.To(ipv6),
+		}, sources...)
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("Append", func(t *testing.T) {
+		res := newMerge(t, resolver.MergePolicyAppend, resolver.MergePolicyAppend)
+
+		addrs, err := res.LookupNetIP(ctx, "ip", "app.example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("10.0.0.1"),
+			netip.MustParseAddr("10.0.0.2"),
+			netip.MustParseAddr("192.0.2.1"),
+			netip.MustParseAddr("192.0.2.2"),
+			netip.MustParseAddr("2001:db8::1"),
+		}, addrs)
+	})
+
+	t.Run("Shadow", func(t *testing.T) {
+		res := newMerge(t, resolver.MergePolicyShadow, resolver.MergePolicyShadow)
+
+		addrs, err := res.LookupNetIP(ctx, "ip", "app.example.com")
+		require.NoError(t, err)
+
+		// The local source has no IPv6 addresses, so it only shadows the
+		// upstream IPv4 addresses.
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("10.0.0.1"),
+			netip.MustParseAddr("10.0.0.2"),
+			netip.MustParseAddr("192.0.2.1"),
+			netip.MustParseAddr("2001:db8::1"),
+		}, addrs)
+
+		addrs, err = res.LookupNetIP(ctx, "ip6", "app.example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("2001:db8::1")}, addrs)
+	})
+
+	t.Run("Interleave", func(t *testing.T) {
+		res := newMerge(t, resolver.MergePolicyInterleave, resolver.MergePolicyAppend)
+
+		addrs, err := res.LookupNetIP(ctx, "ip4", "app.example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("10.0.0.1"),
+			netip.MustParseAddr("192.0.2.1"),
+			netip.MustParseAddr("10.0.0.2"),
+			netip.MustParseAddr("192.0.2.2"),
+		}, addrs)
+	})
+
+	t.Run("Stable", func(t *testing.T) {
+		res := newMerge(t, resolver.MergePolicyInterleave, resolver.MergePolicyInterleave)
+
+		want, err := res.LookupNetIP(ctx, "ip", "app.example.com")
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			addrs, err := res.LookupNetIP(ctx, "ip", "app.example.com")
+			require.NoError(t, err)
+			require.Equal(t, want, addrs)
+		}
+	})
+
+	t.Run("Partial Failure", func(t *testing.T) {
+		failing := resolvertest.NewFake()
+		failing.Script("app.example.com", dns.TypeA, resolvertest.Response{Err: resolvertest.ServerFailure("app.example.com")})
+
+		res, err := resolver.Merge(nil,
+			resolver.MergeSource{Resolver: failing, Priority: 1},
+			resolver.MergeSource{Resolver: local},
+		)
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(ctx, "ip4", "app.example.com")
+		require.NoError(t, err)
+		require.Len(t, addrs, 3)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		res := newMerge(t, resolver.MergePolicyAppend, resolver.MergePolicyAppend)
+
+		_, err := res.LookupNetIP(ctx, "ip", "missing.example.com")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("Reverse", func(t *testing.T) {
+		res := newMerge(t, resolver.MergePolicyAppend, resolver.MergePolicyAppend)
+
+		name, err := resolver.ReverseHostname(ctx, res, netip.MustParseAddr("10.0.0.2"))
+		require.NoError(t, err)
+		require.Equal(t, "app.example.com.", name)
+	})
+
+	t.Run("Invalid Policy", func(t *testing.T) {
+		_, err := resolver.Merge(&resolver.MergeResolverConfig{
+			IPv4: ptr.To(resolver.MergePolicy("bogus")),
+		}, sources...)
+		require.Error(t, err)
+	})
+}
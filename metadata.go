@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"sync"
+)
+
+type metadataKey struct{}
+
+// Metadata collects information about a lookup, that can't be expressed by
+// the Resolver interface, from the resolvers in the chain. It is safe for
+// concurrent use.
+type Metadata struct {
+	mu                sync.Mutex
+	responses         int
+	authenticatedData bool
+	queriedName       string
+}
+
+// WithMetadata returns a context that collects metadata about lookups
+// performed with it, eg.
+//
+//	ctx, md := resolver.WithMetadata(ctx)
+//	addrs, err := res.LookupNetIP(ctx, "ip", host)
+//	if err == nil && md.AuthenticatedData() {
+//		// The addresses were validated using DNSSEC by a trusted server.
+//	}
+func WithMetadata(ctx context.Context) (context.Context, *Metadata) {
+	md := &Metadata{}
+	return context.WithValue(ctx, metadataKey{}, md), md
+}
+
+// metadataFromContext returns the metadata collector of ctx (if any).
+func metadataFromContext(ctx context.Context) *Metadata {
+	md, _ := ctx.Value(metadataKey{}).(*Metadata)
+	return md
+}
+
+// AuthenticatedData returns true if every DNS response of the lookup had the
+// AD (authenticated data) bit set, ie. the answers were validated using DNSSEC
+// by the server. The AD bit is only trusted from servers configured with
+// TrustAD (as with the trust-ad option of glibc), otherwise it is cleared.
+// Lookups answered without querying a server (eg. from a cache or the hosts
+// file) report false.
+func (md *Metadata) AuthenticatedData() bool {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	return md.responses > 0 && md.authenticatedData
+}
+
+// QueriedName returns the name that answered the lookup after search domain
+// expansion by a relative resolver (eg. "www.corp.example.com." for "www"),
+// or an empty string if no relative resolver answered the lookup.
+func (md *Metadata) QueriedName() string {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	return md.queriedName
+}
+
+// recordQueriedName records the name that answered the lookup.
+func (md *Metadata) recordQueriedName(name string) {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	md.queriedName = name
+}
+
+// recordResponse records a successful DNS response.
+func (md *Metadata) recordResponse(authenticatedData bool) {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	if md.responses == 0 {
+		md.authenticatedData = authenticatedData
+	} else {
+		md.authenticatedData = md.authenticatedData && authenticatedData
+	}
+	md.responses++
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"net/netip"
+	"slices"
+)
+
+var _ Resolver = (*nativeResolver)(nil)
+
+// nativeResolver is a resolver that uses the name resolution API of the
+// operating system.
+type nativeResolver struct {
+	// api is the name of the operating system API used.
+	api string
+}
+
+// Native returns a resolver that uses the name resolution API of the
+// operating system (GetAddrInfoExW on Windows), rather than this package's
+// own DNS implementation. This gives access to the OS cache, the Name
+// Resolution Policy Table, and mDNS (at the cost of less control over how
+// names are resolved), for parity with other applications on the host.
+// Addresses are returned in the order chosen by the operating system.
+//
+// An error wrapping errors.ErrUnsupported is returned on platforms without a
+// supported API. On Unix, see the getaddrinfo package (which requires cgo).
+func Native() (*nativeResolver, error) {
+	return newNativeResolver()
+}
+
+// uniqueAddrs removes duplicate addresses (eg. returned once per socket
+// type), preserving the order of the first occurrences.
+func uniqueAddrs(addrs []netip.Addr) []netip.Addr {
+	var unique []netip.Addr
+	for _, addr := range addrs {
+		if !slices.Contains(unique, addr) {
+			unique = append(unique, addr)
+		}
+	}
+
+	return unique
+}
+
+func (r *nativeResolver) Describe() Description {
+	return Description{
+		Type: "native",
+		Attributes: map[string]string{
+			"api": r.api,
+		},
+	}
+}
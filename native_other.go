@@ -0,0 +1,31 @@
+//go:build !windows
+
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+func newNativeResolver() (*nativeResolver, error) {
+	return nil, fmt.Errorf("native resolver: %w", errors.ErrUnsupported)
+}
+
+func (r *nativeResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return nil, &net.DNSError{
+		Err:  errors.ErrUnsupported.Error(),
+		Name: host,
+	}
+}
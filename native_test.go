@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNativeResolver(t *testing.T) {
+	res, err := resolver.Native()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("native resolver is not supported on this platform")
+	}
+	require.NoError(t, err)
+
+	require.Equal(t, "native", resolver.Describe(res).Type)
+
+	t.Run("Localhost", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(context.Background(), "ip4", "localhost")
+		require.NoError(t, err)
+
+		require.Contains(t, addrs, netip.MustParseAddr("127.0.0.1"))
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		_, err := res.LookupNetIP(context.Background(), "ip", "nonexistent.invalid")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+}
@@ -0,0 +1,212 @@
+//go:build windows
+
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modws2_32 = windows.NewLazySystemDLL("ws2_32.dll")
+
+	procGetAddrInfoExW                = modws2_32.NewProc("GetAddrInfoExW")
+	procGetAddrInfoExCancel           = modws2_32.NewProc("GetAddrInfoExCancel")
+	procGetAddrInfoExOverlappedResult = modws2_32.NewProc("GetAddrInfoExOverlappedResult")
+	procFreeAddrInfoExW               = modws2_32.NewProc("FreeAddrInfoExW")
+)
+
+const (
+	nsAll = 0 // NS_ALL
+
+	wsaIOPending    syscall.Errno = 997   // WSA_IO_PENDING
+	wsaECancelled   syscall.Errno = 10111 // WSA_E_CANCELLED
+	wsaHostNotFound syscall.Errno = 11001 // WSAHOST_NOT_FOUND
+	wsaTryAgain     syscall.Errno = 11002 // WSATRY_AGAIN
+	wsaNoData       syscall.Errno = 11004 // WSANO_DATA
+)
+
+// addrinfoExW is the ADDRINFOEXW structure.
+type addrinfoExW struct {
+	Flags     int32
+	Family    int32
+	Socktype  int32
+	Protocol  int32
+	Addrlen   uintptr
+	Canonname *uint16
+	Addr      *windows.RawSockaddrAny
+	Blob      unsafe.Pointer
+	Bloblen   uintptr
+	Provider  *windows.GUID
+	Next      *addrinfoExW
+}
+
+func newNativeResolver() (*nativeResolver, error) {
+	// Asynchronous (cancellable) queries require Windows 8 or later.
+	if err := procGetAddrInfoExCancel.Find(); err != nil {
+		return nil, fmt.Errorf("native resolver: %w", errors.ErrUnsupported)
+	}
+
+	return &nativeResolver{api: "GetAddrInfoExW"}, nil
+}
+
+func (r *nativeResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	dnsErr := &net.DNSError{
+		Name: host,
+	}
+
+	network, supported := ipNetwork(network)
+	if !supported {
+		return nil, extendDNSError(dnsErr, net.DNSError{
+			Err: ErrUnsupportedNetwork.Error(),
+		})
+	}
+
+	family := int32(windows.AF_UNSPEC)
+	switch network {
+	case "ip4":
+		family = windows.AF_INET
+	case "ip6":
+		family = windows.AF_INET6
+	}
+
+	name, err := windows.UTF16PtrFromString(host)
+	if err != nil {
+		return nil, extendDNSError(dnsErr, net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			IsNotFound: true,
+		})
+	}
+
+	// Without a socket type, every address is returned once per socket type.
+	hints := &addrinfoExW{
+		Family:   family,
+		Socktype: windows.SOCK_STREAM,
+		Protocol: windows.IPPROTO_TCP,
+	}
+
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return nil, extendDNSError(dnsErr, net.DNSError{
+			Err:         err.Error(),
+			IsTemporary: true,
+		})
+	}
+	defer windows.CloseHandle(event)
+
+	overlapped := &windows.Overlapped{HEvent: event}
+	var result *addrinfoExW
+	var cancelHandle windows.Handle
+
+	ret, _, _ := procGetAddrInfoExW.Call(uintptr(unsafe.Pointer(name)), 0, nsAll, 0,
+		uintptr(unsafe.Pointer(hints)), uintptr(unsafe.Pointer(&result)), 0,
+		uintptr(unsafe.Pointer(overlapped)), 0, uintptr(unsafe.Pointer(&cancelHandle)))
+	code := syscall.Errno(ret)
+	if code == wsaIOPending {
+		code = waitAddrInfoEx(ctx, event, overlapped, &cancelHandle)
+	}
+
+	// The query may have written to these after the call returned.
+	runtime.KeepAlive(name)
+	runtime.KeepAlive(hints)
+	runtime.KeepAlive(overlapped)
+
+	if result != nil {
+		defer procFreeAddrInfoExW.Call(uintptr(unsafe.Pointer(result)))
+	}
+
+	if code != 0 {
+		return nil, extendDNSError(dnsErr, nativeError(ctx, code))
+	}
+
+	var addrs []netip.Addr
+	for ai := result; ai != nil; ai = ai.Next {
+		if ai.Addr == nil {
+			continue
+		}
+
+		switch ai.Addr.Addr.Family {
+		case windows.AF_INET:
+			sa := (*windows.RawSockaddrInet4)(unsafe.Pointer(ai.Addr))
+			addrs = append(addrs, netip.AddrFrom4(sa.Addr))
+		case windows.AF_INET6:
+			sa := (*windows.RawSockaddrInet6)(unsafe.Pointer(ai.Addr))
+			addrs = append(addrs, netip.AddrFrom16(sa.Addr))
+		}
+	}
+
+	addrs = uniqueAddrs(addrs)
+	if len(addrs) == 0 {
+		return nil, extendDNSError(dnsErr, net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			IsNotFound: true,
+		})
+	}
+
+	return addrs, nil
+}
+
+// waitAddrInfoEx waits for an asynchronous query to complete, cancelling it if
+// ctx is done first.
+func waitAddrInfoEx(ctx context.Context, event windows.Handle, overlapped *windows.Overlapped, cancelHandle *windows.Handle) syscall.Errno {
+	done := make(chan struct{})
+	go func() {
+		_, _ = windows.WaitForSingleObject(event, windows.INFINITE)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		_, _, _ = procGetAddrInfoExCancel.Call(uintptr(unsafe.Pointer(cancelHandle)))
+		// A cancelled query still completes (with WSA_E_CANCELLED), its
+		// result must not be freed before then.
+		<-done
+	}
+
+	ret, _, _ := procGetAddrInfoExOverlappedResult.Call(uintptr(unsafe.Pointer(overlapped)))
+	return syscall.Errno(ret)
+}
+
+// nativeError converts a GetAddrInfoExW error code into a DNS error.
+func nativeError(ctx context.Context, code syscall.Errno) net.DNSError {
+	switch {
+	case code == wsaHostNotFound || code == wsaNoData:
+		return net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			IsNotFound: true,
+		}
+	case code == wsaECancelled || ctx.Err() != nil:
+		err := ctx.Err()
+		if err == nil {
+			err = code
+		}
+
+		return net.DNSError{
+			Err:       err.Error(),
+			IsTimeout: isTimeout(err),
+		}
+	default:
+		return net.DNSError{
+			Err:         code.Error(),
+			IsTemporary: code == wsaTryAgain,
+		}
+	}
+}
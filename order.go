@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/noisysockets/resolver/internal/addrselect"
+)
+
+// AddressOrder is the policy used to order the addresses returned by a lookup.
+//
+// Only resolvers that produce addresses (DNS, DNS64, and Hosts) apply an
+// ordering policy. Combinators (eg. Sequential, RoundRobin, Parallel, Retry,
+// Relative, Cache, and Filter) never reorder the addresses returned by the
+// resolver that answered the lookup. RoundRobin only shuffles the order in
+// which its resolvers are tried.
+type AddressOrder string
+
+const (
+	// AddressOrderRFC6724 sorts addresses using the destination address
+	// selection algorithm from RFC 6724. The source address for each
+	// destination is determined by a SourceAddrProvider.
+	AddressOrderRFC6724 AddressOrder = "rfc6724"
+	// AddressOrderPreferIPv4 returns IPv4 addresses before IPv6 addresses.
+	AddressOrderPreferIPv4 AddressOrder = "prefer-ipv4"
+	// AddressOrderPreferIPv6 returns IPv6 addresses before IPv4 addresses.
+	AddressOrderPreferIPv6 AddressOrder = "prefer-ipv6"
+	// AddressOrderNone disables sorting, addresses are returned in the order
+	// the server sent them. When both A and AAAA records are queried, the
+	// answers are concatenated in query order (regardless of which response
+	// arrived first). Hosts file entries are returned in file order. This is
+	// useful when implementing custom load balancing.
+	AddressOrderNone AddressOrder = "none"
+)
+
+// PolicyTableEntry is an entry in the RFC 6724 address selection policy
+// table. IPv4 prefixes are matched against IPv4 addresses (they are converted
+// to IPv4-mapped IPv6 prefixes internally).
+type PolicyTableEntry = addrselect.PolicyTableEntry
+
+// DefaultPolicyTable returns the default address selection policy table from
+// RFC 6724 section 2.1. This is a useful starting point for adding site
+// specific entries (similar to /etc/gai.conf).
+func DefaultPolicyTable() []PolicyTableEntry {
+	return addrselect.DefaultPolicyTable()
+}
+
+// validate returns an error if the address ordering policy is unknown.
+func (o AddressOrder) validate() error {
+	switch o {
+	case AddressOrderRFC6724, AddressOrderPreferIPv4, AddressOrderPreferIPv6,
+		AddressOrderNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid address order %q", o)
+	}
+}
+
+// addrSorter orders looked up addresses according to an address ordering
+// policy.
+type addrSorter struct {
+	order       AddressOrder
+	srcAddrs    SourceAddrProvider
+	policyTable addrselect.PolicyTable
+}
+
+func newAddrSorter(order AddressOrder, srcAddrs SourceAddrProvider, policyTable []PolicyTableEntry) addrSorter {
+	s := addrSorter{
+		order:    order,
+		srcAddrs: srcAddrs,
+	}
+
+	// An empty table is treated as unset.
+	if len(policyTable) > 0 {
+		s.policyTable = addrselect.NewPolicyTable(policyTable)
+	} else {
+		s.policyTable = addrselect.DefaultPolicyTable()
+	}
+
+	return s
+}
+
+// sort sorts addrs in place according to the address ordering policy.
+func (s *addrSorter) sort(ctx context.Context, addrs []netip.Addr) {
+	if len(addrs) < 2 {
+		return
+	}
+
+	switch s.order {
+	case AddressOrderNone:
+	case AddressOrderPreferIPv4:
+		slices.SortStableFunc(addrs, func(a, b netip.Addr) int {
+			return familyRank(b) - familyRank(a)
+		})
+	case AddressOrderPreferIPv6:
+		slices.SortStableFunc(addrs, func(a, b netip.Addr) int {
+			return familyRank(a) - familyRank(b)
+		})
+	default:
+		srcs := s.srcAddrs.SourceAddrs(ctx, addrs)
+		addrselect.SortByRFC6724WithSourceAddrs(addrs, srcs, s.policyTable)
+	}
+}
+
+// familyRank returns 0 for IPv6 addresses and 1 for IPv4 addresses.
+func familyRank(addr netip.Addr) int {
+	if addr.Unmap().Is4() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressOrder(t *testing.T) {
+	addrs := []netip.Addr{
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("2001:db8::2"),
+		netip.MustParseAddr("192.0.2.2"),
+	}
+
+	newResolver := func(t *testing.T, order resolver.AddressOrder) resolver.Resolver {
+		res, err := resolver.Hosts(&resolver.HostsResolverConfig{
+			NoHostsFile:  ptr.To(true),
+			AddressOrder: ptr.To(order),
+		})
+		require.NoError(t, err)
+
+		res.AddHost("example.com", addrs...)
+
+		return res
+	}
+
+	t.Run("None", func(t *testing.T) {
+		res := newResolver(t, resolver.AddressOrderNone)
+
+		resolved, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, addrs, resolved)
+	})
+
+	t.Run("Prefer IPv4", func(t *testing.T) {
+		res := newResolver(t, resolver.AddressOrderPreferIPv4)
+
+		resolved, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("192.0.2.1"),
+			netip.MustParseAddr("192.0.2.2"),
+			netip.MustParseAddr("2001:db8::1"),
+			netip.MustParseAddr("2001:db8::2"),
+		}, resolved)
+	})
+
+	t.Run("Prefer IPv6", func(t *testing.T) {
+		res := newResolver(t, resolver.AddressOrderPreferIPv6)
+
+		resolved, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("2001:db8::1"),
+			netip.MustParseAddr("2001:db8::2"),
+			netip.MustParseAddr("192.0.2.1"),
+			netip.MustParseAddr("192.0.2.2"),
+		}, resolved)
+	})
+
+	t.Run("RFC 6724", func(t *testing.T) {
+		res := newResolver(t, resolver.AddressOrderRFC6724)
+
+		resolved, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+
+		require.ElementsMatch(t, addrs, resolved)
+	})
+
+	t.Run("RFC 6724 With Source Addresses", func(t *testing.T) {
+		newResolver := func(t *testing.T, srcs map[netip.Addr]netip.Addr) resolver.Resolver {
+			res, err := resolver.Hosts(&resolver.HostsResolverConfig{
+				NoHostsFile:        ptr.To(true),
+				SourceAddrProvider: staticSourceAddrs(srcs),
+			})
+			require.NoError(t, err)
+
+			res.AddHost("example.com", addrs...)
+
+			return res
+		}
+
+		t.Run("IPv4 Only", func(t *testing.T) {
+			res := newResolver(t, map[netip.Addr]netip.Addr{
+				netip.MustParseAddr("192.0.2.1"): netip.MustParseAddr("192.0.2.100"),
+				netip.MustParseAddr("192.0.2.2"): netip.MustParseAddr("192.0.2.100"),
+			})
+
+			resolved, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+			require.NoError(t, err)
+
+			require.Equal(t, []netip.Addr{
+				netip.MustParseAddr("192.0.2.1"),
+				netip.MustParseAddr("192.0.2.2"),
+				netip.MustParseAddr("2001:db8::1"),
+				netip.MustParseAddr("2001:db8::2"),
+			}, resolved)
+		})
+
+		t.Run("Dual Stack", func(t *testing.T) {
+			res := newResolver(t, map[netip.Addr]netip.Addr{
+				netip.MustParseAddr("192.0.2.1"):   netip.MustParseAddr("192.0.2.100"),
+				netip.MustParseAddr("192.0.2.2"):   netip.MustParseAddr("192.0.2.100"),
+				netip.MustParseAddr("2001:db8::1"): netip.MustParseAddr("2001:db8::100"),
+				netip.MustParseAddr("2001:db8::2"): netip.MustParseAddr("2001:db8::100"),
+			})
+
+			resolved, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+			require.NoError(t, err)
+
+			require.Equal(t, []netip.Addr{
+				netip.MustParseAddr("2001:db8::1"),
+				netip.MustParseAddr("2001:db8::2"),
+				netip.MustParseAddr("192.0.2.1"),
+				netip.MustParseAddr("192.0.2.2"),
+			}, resolved)
+		})
+
+		t.Run("Empty Policy Table", func(t *testing.T) {
+			// An empty policy table falls back to the default table.
+			res, err := resolver.Hosts(&resolver.HostsResolverConfig{
+				NoHostsFile: ptr.To(true),
+				SourceAddrProvider: staticSourceAddrs{
+					netip.MustParseAddr("192.0.2.1"):   netip.MustParseAddr("192.0.2.100"),
+					netip.MustParseAddr("2001:db8::1"): netip.MustParseAddr("2001:db8::100"),
+				},
+				PolicyTable: []resolver.PolicyTableEntry{},
+			})
+			require.NoError(t, err)
+
+			res.AddHost("example.com", netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1"))
+
+			resolved, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+			require.NoError(t, err)
+
+			require.Equal(t, []netip.Addr{
+				netip.MustParseAddr("2001:db8::1"),
+				netip.MustParseAddr("192.0.2.1"),
+			}, resolved)
+		})
+	})
+}
+
+type staticSourceAddrs map[netip.Addr]netip.Addr
+
+func (srcs staticSourceAddrs) SourceAddrs(_ context.Context, dsts []netip.Addr) []netip.Addr {
+	result := make([]netip.Addr, len(dsts))
+	for i, dst := range dsts {
+		result[i] = srcs[dst]
+	}
+	return result
+}
@@ -76,3 +76,21 @@ func (r *parallelResolver) LookupNetIP(ctx context.Context, network, host string
 		return nil, ctx.Err()
 	}
 }
+
+// LookupAddr performs a reverse lookup, unlike forward lookups the resolvers are
+// tried in order as reverse lookups are rarely latency sensitive.
+func (r *parallelResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return Sequential(r.resolvers...).LookupAddr(ctx, addr)
+}
+
+// Lookup answers the question, questions are sent to each resolver in turn.
+func (r *parallelResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	return Sequential(r.resolvers...).Lookup(ctx, q)
+}
+
+func (r *parallelResolver) Describe() Description {
+	return Description{
+		Type:     "parallel",
+		Children: describeAll(r.resolvers),
+	}
+}
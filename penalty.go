@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*penaltyBoxResolver)(nil)
+
+// PenaltyBoxResolverConfig is the configuration for a penalty box resolver.
+type PenaltyBoxResolverConfig struct {
+	// InitialPenalty is the cooling-off period applied after the first timeout.
+	// Each further consecutive timeout doubles the penalty.
+	InitialPenalty *time.Duration
+	// MaxPenalty is the upper bound on the cooling-off period.
+	MaxPenalty *time.Duration
+	// Events is an optional event distributor, that is notified when the
+	// upstream becomes unhealthy (is penalized) and healthy again.
+	Events *Events
+}
+
+// penaltyBoxResolver is a resolver that tracks consecutive timeouts of an
+// upstream resolver and marks it as penalized for a cooling-off period.
+type penaltyBoxResolver struct {
+	resolver       Resolver
+	initialPenalty time.Duration
+	maxPenalty     time.Duration
+	events         *Events
+
+	mu       sync.Mutex
+	timeouts int
+	until    time.Time
+}
+
+// PenaltyBox returns a resolver that tracks consecutive timeouts of the
+// provided resolver (typically a single upstream server). After a timeout the
+// resolver is penalized for an exponentially increasing cooling-off period
+// (similar to BIND's server selection). While penalized, Sequential and
+// RoundRobin will only try it after all other resolvers have failed.
+func PenaltyBox(resolver Resolver, conf *PenaltyBoxResolverConfig) (*penaltyBoxResolver, error) {
+	conf, err := defaults.WithDefaults(conf, &PenaltyBoxResolverConfig{
+		InitialPenalty: ptr.To(time.Second),
+		MaxPenalty:     ptr.To(time.Minute),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to penalty box resolver config: %w", err)
+	}
+
+	if *conf.InitialPenalty <= 0 || *conf.MaxPenalty < *conf.InitialPenalty {
+		return nil, fmt.Errorf("invalid penalty: initial penalty must be positive and not exceed the max penalty")
+	}
+
+	return &penaltyBoxResolver{
+		resolver:       resolver,
+		initialPenalty: *conf.InitialPenalty,
+		maxPenalty:     *conf.MaxPenalty,
+		events:         conf.Events,
+	}, nil
+}
+
+func (r *penaltyBoxResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, err := r.resolver.LookupNetIP(ctx, network, host)
+	r.observe(ctx, host, err)
+	return addrs, err
+}
+
+func (r *penaltyBoxResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	names, err := lookupAddr(ctx, r.resolver, addr)
+	r.observe(ctx, addr, err)
+	return names, err
+}
+
+func (r *penaltyBoxResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	answer, err := Lookup(ctx, r.resolver, q)
+	r.observe(ctx, q.Name, err)
+	return answer, err
+}
+
+// observe updates the penalty of the resolver based on the outcome of a lookup
+// of name.
+func (r *penaltyBoxResolver) observe(ctx context.Context, name string, err error) {
+	// Don't blame the upstream if the caller gave up on the lookup.
+	if ctx.Err() != nil {
+		return
+	}
+
+	r.mu.Lock()
+	wasHealthy := r.timeouts == 0
+	r.updatePenalty(err)
+	isHealthy := r.timeouts == 0
+	r.mu.Unlock()
+
+	if r.events == nil || wasHealthy == isHealthy {
+		return
+	}
+
+	ev := Event{
+		Type:     EventUpstreamHealthy,
+		Resolver: ptr.To(Describe(r.resolver)),
+		Name:     name,
+	}
+	if !isHealthy {
+		ev.Type = EventUpstreamUnhealthy
+		ev.Err = err
+	}
+
+	r.events.Publish(ev)
+}
+
+// updatePenalty updates the penalty of the resolver based on the error of a
+// lookup. The caller must hold the lock.
+func (r *penaltyBoxResolver) updatePenalty(err error) {
+	if err != nil && isTimeout(err) {
+		r.timeouts++
+
+		penalty := r.maxPenalty
+		// Guard against overflowing the shift on long outages.
+		if r.timeouts < 32 {
+			if p := r.initialPenalty << (r.timeouts - 1); p > 0 && p < r.maxPenalty {
+				penalty = p
+			}
+		}
+
+		r.until = time.Now().Add(penalty)
+	} else {
+		r.timeouts = 0
+		r.until = time.Time{}
+	}
+}
+
+// penalized returns true if the resolver is currently serving a penalty.
+func (r *penaltyBoxResolver) penalized() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return time.Now().Before(r.until)
+}
+
+// deprioritizePenalized returns the resolvers with any currently penalized
+// resolvers moved to the end, otherwise preserving their relative order.
+func deprioritizePenalized(resolvers []Resolver) []Resolver {
+	var healthy, penalized []Resolver
+	for i, resolver := range resolvers {
+		if pr, ok := resolver.(*penaltyBoxResolver); ok && pr.penalized() {
+			if penalized == nil {
+				healthy = append(healthy, resolvers[:i]...)
+			}
+			penalized = append(penalized, resolver)
+		} else if penalized != nil {
+			healthy = append(healthy, resolver)
+		}
+	}
+
+	// Avoid allocating in the common case of no penalized resolvers.
+	if penalized == nil {
+		return resolvers
+	}
+
+	return append(healthy, penalized...)
+}
+
+func (r *penaltyBoxResolver) Describe() Description {
+	return Description{
+		Type: "penalty-box",
+		Attributes: map[string]string{
+			"initial-penalty": r.initialPenalty.String(),
+			"max-penalty":     r.maxPenalty.String(),
+			"penalized":       strconv.FormatBool(r.penalized()),
+		},
+		Children: []Description{Describe(r.resolver)},
+	}
+}
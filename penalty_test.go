@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/internal/testutil"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPenaltyBoxResolver(t *testing.T) {
+	res1 := new(testutil.MockResolver)
+	res1.On("LookupNetIP", mock.Anything, mock.Anything, mock.Anything).Return([]netip.Addr{}, &net.DNSError{
+		Err:         "i/o timeout",
+		IsTimeout:   true,
+		IsTemporary: true,
+	})
+
+	res2 := new(testutil.MockResolver)
+	res2.On("LookupNetIP", mock.Anything, "ip", "example.com").Return([]netip.Addr{netip.MustParseAddr("10.0.0.1")}, nil)
+
+	penaltyBoxRes, err := resolver.PenaltyBox(res1, &resolver.PenaltyBoxResolverConfig{
+		InitialPenalty: ptr.To(100 * time.Millisecond),
+	})
+	require.NoError(t, err)
+
+	res := resolver.Sequential(penaltyBoxRes, res2)
+
+	t.Run("Timeout", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+		res1.AssertNumberOfCalls(t, "LookupNetIP", 1)
+		res2.AssertNumberOfCalls(t, "LookupNetIP", 1)
+	})
+
+	t.Run("Penalized", func(t *testing.T) {
+		_, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+
+		// The timed out resolver should have been skipped.
+		res1.AssertNumberOfCalls(t, "LookupNetIP", 1)
+		res2.AssertNumberOfCalls(t, "LookupNetIP", 2)
+	})
+
+	t.Run("Penalty Expired", func(t *testing.T) {
+		time.Sleep(150 * time.Millisecond)
+
+		_, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+
+		res1.AssertNumberOfCalls(t, "LookupNetIP", 2)
+		res2.AssertNumberOfCalls(t, "LookupNetIP", 3)
+	})
+
+	t.Run("Exponential Backoff", func(t *testing.T) {
+		// Second consecutive timeout, so the penalty should have doubled.
+		time.Sleep(150 * time.Millisecond)
+
+		_, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+
+		res1.AssertNumberOfCalls(t, "LookupNetIP", 2)
+		res2.AssertNumberOfCalls(t, "LookupNetIP", 4)
+	})
+}
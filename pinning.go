@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// SPKIHash returns the base64 encoded SHA-256 hash of a certificate's
+// SubjectPublicKeyInfo, the format used for SPKI pins (as in RFC 7469 and
+// RFC 7858).
+func SPKIHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// parseSPKIPins decodes a set of base64 encoded SPKI pins.
+func parseSPKIPins(pins []string) ([][sha256.Size]byte, error) {
+	decoded := make([][sha256.Size]byte, 0, len(pins))
+	for _, pin := range pins {
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid spki pin %q: %w", pin, err)
+		}
+
+		if len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid spki pin %q: not a sha-256 hash", pin)
+		}
+
+		decoded = append(decoded, [sha256.Size]byte(hash))
+	}
+
+	return decoded, nil
+}
+
+// withConnectionVerification returns a copy of tlsConfig that checks the
+// server's certificates against pins and then calls verifyConnection (if
+// provided). If skipChainVerification is true, the pins replace the standard
+// certificate chain and hostname verification.
+func withConnectionVerification(tlsConfig *tls.Config, pins [][sha256.Size]byte,
+	verifyConnection func(cs tls.ConnectionState) error, skipChainVerification bool) *tls.Config {
+	tlsConfig = tlsConfig.Clone()
+
+	if skipChainVerification {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	previousVerifyConnection := tlsConfig.VerifyConnection
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if previousVerifyConnection != nil {
+			if err := previousVerifyConnection(cs); err != nil {
+				return err
+			}
+		}
+
+		if len(pins) > 0 && !matchesSPKIPins(cs.PeerCertificates, pins) {
+			return errors.New("server certificate does not match any spki pin")
+		}
+
+		if verifyConnection != nil {
+			return verifyConnection(cs)
+		}
+
+		return nil
+	}
+
+	return tlsConfig
+}
+
+// matchesSPKIPins returns true if any of the certificates matches any of the
+// pins.
+func matchesSPKIPins(certs []*x509.Certificate, pins [][sha256.Size]byte) bool {
+	for _, cert := range certs {
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if subtle.ConstantTimeCompare(hash[:], pin[:]) == 1 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/address"
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*ProviderResolver)(nil)
+
+// Provider is a programmatic source of synthetic address records, eg. the
+// in-memory routing table of an overlay network serving peer names (similar
+// to Tailscale's MagicDNS).
+type Provider interface {
+	// Resolve returns the addresses of the canonical (lowercase, fully
+	// qualified) name. The boolean result is false if the name is unknown to
+	// the provider.
+	Resolve(name string) ([]netip.Addr, bool)
+}
+
+// ProviderFunc adapts an ordinary function to a Provider.
+type ProviderFunc func(name string) ([]netip.Addr, bool)
+
+// Resolve calls f(name).
+func (f ProviderFunc) Resolve(name string) ([]netip.Addr, bool) {
+	return f(name)
+}
+
+type ProviderResolverConfig struct {
+	// DialContext is an optional dialer used to probe source addresses when
+	// ordering the returned addresses.
+	DialContext DialContextFunc
+	// SourceAddrProvider is an optional provider of the source addresses used
+	// when sorting addresses according to RFC 6724. By default, source
+	// addresses are probed using DialContext (if provided) or selected from
+	// the host's interface addresses.
+	SourceAddrProvider SourceAddrProvider
+	// AddressOrder is the policy used to order the returned addresses.
+	// By default, addresses are sorted according to RFC 6724.
+	AddressOrder *AddressOrder
+	// PolicyTable is an optional RFC 6724 address selection policy table used
+	// when sorting addresses. By default, the table from RFC 6724 is used.
+	PolicyTable []PolicyTableEntry
+}
+
+// ProviderResolver is a resolver that serves the records of a Provider. It is
+// like the hosts resolver, but callback driven rather than map driven.
+type ProviderResolver struct {
+	provider Provider
+	sorter   addrSorter
+
+	mu       sync.Mutex
+	nextID   int
+	watchers map[int]func(names ...string)
+}
+
+// FromProvider returns a resolver that serves the records of the provided
+// provider. When the records of the provider change, Notify should be called
+// so that watchers (eg. caches) can discard stale results, eg.
+//
+//	res, _ := resolver.FromProvider(peers, nil)
+//	cached, _ := resolver.Cache(res, nil)
+//	res.Watch(cached.Invalidate)
+func FromProvider(provider Provider, conf *ProviderResolverConfig) (*ProviderResolver, error) {
+	conf, err := defaults.WithDefaults(conf, &ProviderResolverConfig{
+		AddressOrder: ptr.To(AddressOrderRFC6724),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to provider resolver config: %w", err)
+	}
+
+	if err := conf.AddressOrder.validate(); err != nil {
+		return nil, err
+	}
+
+	return &ProviderResolver{
+		provider: provider,
+		sorter: newAddrSorter(*conf.AddressOrder,
+			sourceAddrProviderFor(conf.SourceAddrProvider, conf.DialContext), conf.PolicyTable),
+		watchers: make(map[int]func(names ...string)),
+	}, nil
+}
+
+func (r *ProviderResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	dnsErr := &net.DNSError{
+		Name: host,
+	}
+
+	network, supported := ipNetwork(network)
+	if !supported {
+		return nil, extendDNSError(dnsErr, net.DNSError{
+			Err: ErrUnsupportedNetwork.Error(),
+		})
+	}
+
+	addrs, ok := r.provider.Resolve(dns.CanonicalName(host))
+	if !ok {
+		return nil, extendDNSError(dnsErr, net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			IsNotFound: true,
+		})
+	}
+
+	// The provider may return a slice it shares with its routing table.
+	addrs = address.FilterByNetwork(slices.Clone(addrs), network)
+	if len(addrs) == 0 {
+		return nil, extendDNSError(dnsErr, net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			IsNotFound: true,
+		})
+	}
+
+	if network != "ip4" {
+		r.sorter.sort(ctx, addrs)
+	}
+
+	return addrs, nil
+}
+
+// Watch registers fn to be called with the names passed to Notify. The
+// returned function unregisters fn.
+func (r *ProviderResolver) Watch(fn func(names ...string)) (cancel func()) {
+	r.mu.Lock()
+	id := r.nextID
+	r.nextID++
+	r.watchers[id] = fn
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		delete(r.watchers, id)
+		r.mu.Unlock()
+	}
+}
+
+// Notify informs watchers that the records of the given names have changed.
+func (r *ProviderResolver) Notify(names ...string) {
+	r.mu.Lock()
+	watchers := make([]func(names ...string), 0, len(r.watchers))
+	for _, fn := range r.watchers {
+		watchers = append(watchers, fn)
+	}
+	r.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn(names...)
+	}
+}
+
+func (r *ProviderResolver) Describe() Description {
+	return Description{
+		Type: "provider",
+		Attributes: map[string]string{
+			"provider":      fmt.Sprintf("%T", r.provider),
+			"address-order": string(r.sorter.order),
+		},
+	}
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+type routingTable struct {
+	mu    sync.Mutex
+	peers map[string][]netip.Addr
+	calls atomic.Int32
+}
+
+func (t *routingTable) Resolve(name string) ([]netip.Addr, bool) {
+	t.calls.Add(1)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	addrs, ok := t.peers[name]
+	return addrs, ok
+}
+
+func TestProviderResolver(t *testing.T) {
+	ctx := context.Background()
+
+	peers := &routingTable{
+		peers: map[string][]netip.Addr{
+			"node1.overlay.internal.": {
+				netip.MustParseAddr("100.64.0.1"),
+				netip.MustParseAddr("fd7a:115c:a1e0::1"),
+			},
+		},
+	}
+
+	res, err := resolver.FromProvider(peers, &resolver.ProviderResolverConfig{
+		AddressOrder: ptr.To(resolver.AddressOrderNone),
+	})
+	require.NoError(t, err)
+
+	t.Run("Resolve", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip", "Node1.Overlay.Internal")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("100.64.0.1"),
+			netip.MustParseAddr("fd7a:115c:a1e0::1"),
+		}, addrs)
+
+		addrs, err = res.LookupNetIP(ctx, "ip6", "node1.overlay.internal.")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("fd7a:115c:a1e0::1")}, addrs)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		_, err := res.LookupNetIP(ctx, "ip", "node2.overlay.internal")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.True(t, errors.As(err, &dnsErr))
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("Notify", func(t *testing.T) {
+		cached, err := resolver.Cache(res, nil)
+		require.NoError(t, err)
+
+		var notified []string
+		cancel := res.Watch(func(names ...string) {
+			notified = append(notified, names...)
+		})
+		t.Cleanup(cancel)
+
+		cancelInvalidate := res.Watch(cached.Invalidate)
+		t.Cleanup(cancelInvalidate)
+
+		addrs, err := cached.LookupNetIP(ctx, "ip4", "node1.overlay.internal")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("100.64.0.1")}, addrs)
+
+		peers.mu.Lock()
+		peers.peers["node1.overlay.internal."] = []netip.Addr{netip.MustParseAddr("100.64.0.10")}
+		peers.mu.Unlock()
+
+		// Still served from the cache.
+		calls := peers.calls.Load()
+		addrs, err = cached.LookupNetIP(ctx, "ip4", "node1.overlay.internal")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("100.64.0.1")}, addrs)
+		require.Equal(t, calls, peers.calls.Load())
+
+		res.Notify("node1.overlay.internal.")
+
+		require.Equal(t, []string{"node1.overlay.internal."}, notified)
+
+		addrs, err = cached.LookupNetIP(ctx, "ip4", "node1.overlay.internal")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("100.64.0.10")}, addrs)
+	})
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+// QueryLogEntry describes a single query sent to an upstream DNS server.
+type QueryLogEntry struct {
+	// Time is when the query was sent.
+	Time time.Time `json:"time"`
+	// Name is the fully qualified name that was queried.
+	Name string `json:"name"`
+	// Type is the query type (eg. "A" or "AAAA").
+	Type string `json:"type"`
+	// Server is the address of the upstream server.
+	Server string `json:"server"`
+	// Transport is the transport protocol used to send the query.
+	Transport DNSTransport `json:"transport"`
+	// RCode is the response code (eg. "NOERROR" or "NXDOMAIN"), it is empty if
+	// no response was received.
+	RCode string `json:"rcode,omitempty"`
+	// Latency is how long the query took to complete.
+	Latency time.Duration `json:"latency"`
+	// Err is the error that caused the query to fail (if any).
+	Err string `json:"error,omitempty"`
+}
+
+// QueryLog is a fixed size, in-memory, ring buffer of the most recent queries
+// sent to upstream DNS servers. It is safe for concurrent use and can be shared
+// between multiple resolvers.
+type QueryLog struct {
+	mu      sync.Mutex
+	entries []QueryLogEntry
+	// next is the index of the slot that will be written next.
+	next int
+	full bool
+	// redactor, if not nil, anonymizes entries before they are recorded.
+	redactor Redactor
+}
+
+// NewQueryLog returns a query log that retains the last size queries.
+// A size less than one is treated as one.
+func NewQueryLog(size int) *QueryLog {
+	if size < 1 {
+		size = 1
+	}
+
+	return &QueryLog{
+		entries: make([]QueryLogEntry, size),
+	}
+}
+
+// Entries returns a snapshot of the logged queries, in the order they
+// completed (oldest first).
+func (l *QueryLog) Entries() []QueryLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]QueryLogEntry(nil), l.entries[:l.next]...)
+	}
+
+	entries := make([]QueryLogEntry, 0, len(l.entries))
+	entries = append(entries, l.entries[l.next:]...)
+	return append(entries, l.entries[:l.next]...)
+}
+
+// Since returns a snapshot of the logged queries sent at or after t.
+func (l *QueryLog) Since(t time.Time) []QueryLogEntry {
+	var entries []QueryLogEntry
+	for _, entry := range l.Entries() {
+		if !entry.Time.Before(t) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// SetRedactor sets the redactor used to anonymize queries before they are
+// recorded (eg. HashNames), a nil redactor records queries as is. Queries that
+// were already recorded are not affected.
+func (l *QueryLog) SetRedactor(redactor Redactor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.redactor = redactor
+}
+
+// Reset discards all logged queries.
+func (l *QueryLog) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	clear(l.entries)
+	l.next = 0
+	l.full = false
+}
+
+func (l *QueryLog) record(entry QueryLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.redactor != nil {
+		l.redactor.Redact(&entry)
+	}
+
+	l.entries[l.next] = entry
+	l.next++
+	if l.next == len(l.entries) {
+		l.next = 0
+		l.full = true
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryLog(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+	})
+
+	queryLog := resolver.NewQueryLog(2)
+
+	for _, lowAllocation := range []bool{false, true} {
+		queryLog.Reset()
+
+		opts := []resolver.DNSOption{resolver.WithQueryLog(queryLog)}
+		if lowAllocation {
+			opts = append(opts, resolver.WithLowAllocation())
+		}
+
+		res, err := resolver.NewDNS(srv.Addr(), opts...)
+		require.NoError(t, err)
+
+		start := time.Now()
+
+		_, err = res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip4", "missing.example.com")
+		require.Error(t, err)
+
+		entries := queryLog.Entries()
+		require.Len(t, entries, 2)
+
+		require.Equal(t, "example.com.", entries[0].Name)
+		require.Equal(t, "A", entries[0].Type)
+		require.Equal(t, srv.Addr().String(), entries[0].Server)
+		require.Equal(t, resolver.DNSTransportUDP, entries[0].Transport)
+		require.Equal(t, "NOERROR", entries[0].RCode)
+		require.Empty(t, entries[0].Err)
+
+		require.Equal(t, "missing.example.com.", entries[1].Name)
+		require.Equal(t, "NXDOMAIN", entries[1].RCode)
+		require.NotEmpty(t, entries[1].Err)
+
+		// The oldest entry is overwritten once the log is full.
+		_, err = res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+
+		entries = queryLog.Entries()
+		require.Len(t, entries, 2)
+		require.Equal(t, "missing.example.com.", entries[0].Name)
+		require.Equal(t, "example.com.", entries[1].Name)
+
+		require.Len(t, queryLog.Since(start), 2)
+		require.Empty(t, queryLog.Since(time.Now().Add(time.Minute)))
+	}
+}
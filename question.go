@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ErrUnsupportedQueryType is returned when a resolver in the chain is unable
+// to answer questions of the requested type.
+var ErrUnsupportedQueryType = errors.New("unsupported query type")
+
+// Question is a query for the records of a given type (eg. dns.TypeMX).
+type Question struct {
+	// Name is the domain name to query.
+	Name string
+	// Type is the type of the records to query.
+	Type uint16
+}
+
+// Answer is the response to a Question.
+type Answer struct {
+	// Records are the records answering the question, including any CNAME
+	// records leading to them.
+	Records []dns.RR
+}
+
+// QuestionResolver is implemented by resolvers that can answer questions of
+// arbitrary types (rather than just address lookups).
+type QuestionResolver interface {
+	// Lookup answers the question. Questions the resolver is unable to answer
+	// fail with an error, so that they can be passed on to other resolvers.
+	Lookup(ctx context.Context, q Question) (Answer, error)
+}
+
+// Lookup answers the question using the resolver. Resolvers that don't
+// implement QuestionResolver are able to answer A, AAAA and (if they support
+// reverse lookups) PTR questions.
+func Lookup(ctx context.Context, resolver Resolver, q Question) (Answer, error) {
+	if qr, ok := resolver.(QuestionResolver); ok {
+		return qr.Lookup(ctx, q)
+	}
+
+	return lookupBasic(ctx, resolver, q)
+}
+
+// lookupBasic answers A, AAAA and PTR questions using the address and reverse
+// lookup methods of the resolver.
+func lookupBasic(ctx context.Context, resolver Resolver, q Question) (Answer, error) {
+	switch q.Type {
+	case dns.TypeA, dns.TypeAAAA:
+		network := "ip4"
+		if q.Type == dns.TypeAAAA {
+			network = "ip6"
+		}
+
+		addrs, err := resolver.LookupNetIP(ctx, network, q.Name)
+		if err != nil {
+			return Answer{}, err
+		}
+
+		return addrAnswer(q, addrs), nil
+	case dns.TypePTR:
+		addr, ok := addrFromReverseName(q.Name)
+		if !ok {
+			return Answer{}, &net.DNSError{
+				Err:        ErrNoSuchHost.Error(),
+				Name:       q.Name,
+				IsNotFound: true,
+			}
+		}
+
+		names, err := lookupAddr(ctx, resolver, addr.String())
+		if err != nil {
+			return Answer{}, err
+		}
+
+		return ptrAnswer(q, names), nil
+	default:
+		return Answer{}, unsupportedQueryTypeError(q)
+	}
+}
+
+func unsupportedQueryTypeError(q Question) *net.DNSError {
+	return &net.DNSError{
+		Err:  ErrUnsupportedQueryType.Error() + " " + dns.Type(q.Type).String(),
+		Name: q.Name,
+	}
+}
+
+// addrAnswer returns an answer containing synthetic A or AAAA records (with
+// a zero TTL) for the addresses.
+func addrAnswer(q Question, addrs []netip.Addr) Answer {
+	hdr := dns.RR_Header{Name: dns.Fqdn(q.Name), Rrtype: q.Type, Class: dns.ClassINET}
+
+	var answer Answer
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		switch {
+		case q.Type == dns.TypeA && addr.Is4():
+			answer.Records = append(answer.Records, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+		case q.Type == dns.TypeAAAA && addr.Is6():
+			answer.Records = append(answer.Records, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+		}
+	}
+
+	return answer
+}
+
+// ptrAnswer returns an answer containing synthetic PTR records (with a zero
+// TTL) for the names.
+func ptrAnswer(q Question, names []string) Answer {
+	hdr := dns.RR_Header{Name: dns.Fqdn(q.Name), Rrtype: dns.TypePTR, Class: dns.ClassINET}
+
+	answer := Answer{Records: make([]dns.RR, 0, len(names))}
+	for _, name := range names {
+		answer.Records = append(answer.Records, &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(name)})
+	}
+
+	return answer
+}
+
+// addrFromReverseName parses the address of a reverse lookup name (eg.
+// "4.3.2.1.in-addr.arpa.").
+func addrFromReverseName(name string) (netip.Addr, bool) {
+	name = dns.CanonicalName(name)
+
+	if labels, ok := strings.CutSuffix(name, ".in-addr.arpa."); ok {
+		octets := strings.Split(labels, ".")
+		if len(octets) != 4 {
+			return netip.Addr{}, false
+		}
+
+		var ip [4]byte
+		for i, octet := range octets {
+			n, err := strconv.ParseUint(octet, 10, 8)
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			ip[3-i] = byte(n)
+		}
+
+		return netip.AddrFrom4(ip), true
+	}
+
+	if labels, ok := strings.CutSuffix(name, ".ip6.arpa."); ok {
+		nibbles := strings.Split(labels, ".")
+		if len(nibbles) != 32 {
+			return netip.Addr{}, false
+		}
+
+		var ip [16]byte
+		for i, nibble := range nibbles {
+			n, err := strconv.ParseUint(nibble, 16, 4)
+			if err != nil || len(nibble) != 1 {
+				return netip.Addr{}, false
+			}
+
+			pos := 31 - i
+			ip[pos/2] |= byte(n) << (4 * (1 - pos%2))
+		}
+
+		return netip.AddrFrom16(ip), true
+	}
+
+	return netip.Addr{}, false
+}
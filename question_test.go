@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	ctx := context.Background()
+
+	srv := resolvertest.NewServer(t, nil)
+	srv.AddRecords(&dns.MX{
+		Hdr:        dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: 60},
+		Preference: 10,
+		Mx:         "mail.example.com.",
+	}, &dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("93.184.216.34"),
+	})
+
+	dnsRes, err := resolver.DNS(resolver.DNSResolverConfig{
+		Server: srv.Addr(),
+	})
+	require.NoError(t, err)
+
+	hostsRes, err := resolver.Hosts(&resolver.HostsResolverConfig{
+		NoHostsFile: ptr.To(true),
+	})
+	require.NoError(t, err)
+
+	hostsRes.AddHost("node1.overlay.internal", netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("fd7a:115c:a1e0::1"))
+
+	cached, err := resolver.Cache(dnsRes, nil)
+	require.NoError(t, err)
+
+	res := resolver.Sequential(resolver.Literal(), hostsRes, cached)
+
+	t.Run("Hosts", func(t *testing.T) {
+		answer, err := resolver.Lookup(ctx, res, resolver.Question{Name: "node1.overlay.internal", Type: dns.TypeAAAA})
+		require.NoError(t, err)
+
+		require.Len(t, answer.Records, 1)
+		require.Equal(t, "fd7a:115c:a1e0::1", answer.Records[0].(*dns.AAAA).AAAA.String())
+	})
+
+	t.Run("Reverse", func(t *testing.T) {
+		for _, addr := range []string{"100.64.0.1", "fd7a:115c:a1e0::1"} {
+			name, err := dns.ReverseAddr(addr)
+			require.NoError(t, err)
+
+			answer, err := resolver.Lookup(ctx, res, resolver.Question{Name: name, Type: dns.TypePTR})
+			require.NoError(t, err)
+
+			require.Len(t, answer.Records, 1)
+			require.Equal(t, "node1.overlay.internal.", answer.Records[0].(*dns.PTR).Ptr)
+		}
+	})
+
+	t.Run("Funneled", func(t *testing.T) {
+		answer, err := resolver.Lookup(ctx, res, resolver.Question{Name: "example.com", Type: dns.TypeMX})
+		require.NoError(t, err)
+
+		require.Len(t, answer.Records, 1)
+		require.Equal(t, "mail.example.com.", answer.Records[0].(*dns.MX).Mx)
+
+		answer, err = resolver.Lookup(ctx, res, resolver.Question{Name: "example.com", Type: dns.TypeA})
+		require.NoError(t, err)
+
+		require.Len(t, answer.Records, 1)
+		require.Equal(t, "93.184.216.34", answer.Records[0].(*dns.A).A.String())
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := resolver.Lookup(ctx, hostsRes, resolver.Question{Name: "node1.overlay.internal", Type: dns.TypeTXT})
+		require.ErrorContains(t, err, resolver.ErrUnsupportedQueryType.Error())
+	})
+}
@@ -0,0 +1,438 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+var _ Resolver = (*RAResolver)(nil)
+
+// RAInfiniteLifetime is the lifetime of router advertised options that never
+// expire (a lifetime of 0xffffffff seconds).
+const RAInfiniteLifetime = time.Duration(math.MaxInt64)
+
+var errTruncatedRAOption = errors.New("truncated ra option")
+
+// RDNSSOption is an IPv6 Router Advertisement Recursive DNS Server option, as
+// defined in RFC 8106.
+type RDNSSOption struct {
+	// Lifetime is how long the servers may be used for, zero means the
+	// servers must no longer be used.
+	Lifetime time.Duration
+	// Servers are the addresses of the recursive DNS servers.
+	Servers []netip.Addr
+}
+
+// DNSSLOption is an IPv6 Router Advertisement DNS Search List option, as
+// defined in RFC 8106.
+type DNSSLOption struct {
+	// Lifetime is how long the domains may be used for, zero means the
+	// domains must no longer be used.
+	Lifetime time.Duration
+	// Domains are the (rooted) search domains.
+	Domains []string
+}
+
+// ParseRDNSS parses an IPv6 Router Advertisement Recursive DNS Server option
+// (type 25), including the option type and length.
+func ParseRDNSS(data []byte) (RDNSSOption, error) {
+	var opt RDNSSOption
+
+	data, lifetime, err := parseRAOptionHeader(data, 3)
+	if err != nil {
+		return opt, err
+	}
+	opt.Lifetime = lifetime
+
+	if len(data)%net.IPv6len != 0 {
+		return opt, errTruncatedRAOption
+	}
+
+	for i := 0; i < len(data); i += net.IPv6len {
+		opt.Servers = append(opt.Servers, netip.AddrFrom16([16]byte(data[i:i+net.IPv6len])))
+	}
+
+	return opt, nil
+}
+
+// ParseDNSSL parses an IPv6 Router Advertisement DNS Search List option (type
+// 31), including the option type and length.
+func ParseDNSSL(data []byte) (DNSSLOption, error) {
+	var opt DNSSLOption
+
+	data, lifetime, err := parseRAOptionHeader(data, 2)
+	if err != nil {
+		return opt, err
+	}
+	opt.Lifetime = lifetime
+
+	// The domains are followed by zero padding.
+	for off := 0; off < len(data) && !isPadding(data[off:]); {
+		name, n, err := dns.UnpackDomainName(data, off)
+		if err != nil {
+			return opt, fmt.Errorf("invalid dnssl domain name: %w", err)
+		}
+		off = n
+
+		if name != "." {
+			opt.Domains = append(opt.Domains, dns.CanonicalName(name))
+		}
+	}
+
+	return opt, nil
+}
+
+// parseRAOptionHeader parses the header of an RDNSS or DNSSL option (which
+// must be at least minLength units of 8 octets long), returning the lifetime
+// and the remaining data.
+func parseRAOptionHeader(data []byte, minLength int) ([]byte, time.Duration, error) {
+	if len(data) < 8 {
+		return nil, 0, errTruncatedRAOption
+	}
+
+	// The length is in units of 8 octets.
+	n := int(data[1]) * 8
+	if n < minLength*8 || len(data) < n {
+		return nil, 0, errTruncatedRAOption
+	}
+	data = data[:n]
+
+	lifetime := RAInfiniteLifetime
+	if seconds := binary.BigEndian.Uint32(data[4:]); seconds != math.MaxUint32 {
+		lifetime = time.Duration(seconds) * time.Second
+	}
+
+	return data[8:], lifetime, nil
+}
+
+// RAResolverConfig is the configuration for a router advertisement resolver.
+type RAResolverConfig struct {
+	// Zone is the optional zone (ie. the name or index of the network
+	// interface) the router advertisements were received on. It's applied to
+	// link-local server addresses, which are otherwise unreachable.
+	Zone string
+	// Timeout is the maximum duration to wait for a query to complete.
+	Timeout *time.Duration
+	// DialContext is used to establish a connection to a DNS server.
+	DialContext DialContextFunc
+	// AddressOrder is the policy used to order the returned addresses.
+	// By default, addresses are sorted according to RFC 6724.
+	AddressOrder *AddressOrder
+	// NDots is the number of dots in a name to trigger an absolute lookup,
+	// before the search domains are applied. By default, 1.
+	NDots *int
+}
+
+// raEntry is a router advertised server or search domain.
+type raEntry[T comparable] struct {
+	value T
+	// expires is when the entry expires, the zero time means never.
+	expires time.Time
+}
+
+// RAResolver is a resolver that queries the recursive DNS servers advertised
+// by IPv6 routers (RFC 8106), applying the advertised search domains. As this
+// package can't observe router advertisements itself, the embedder is
+// responsible for feeding in the advertised options (eg. using ParseRDNSS and
+// ParseDNSSL). Servers and search domains are dropped once their lifetime
+// expires, unless refreshed by a later advertisement.
+type RAResolver struct {
+	conf RAResolverConfig
+
+	mu       sync.Mutex
+	servers  []raEntry[netip.Addr]
+	domains  []raEntry[string]
+	resolver Resolver
+	// stale is true if the resolver must be rebuilt.
+	stale bool
+}
+
+// RA returns a resolver that queries the router advertised DNS servers.
+// Until servers are advertised (see UpdateRDNSS), all lookups fail.
+func RA(conf *RAResolverConfig) (*RAResolver, error) {
+	conf, err := defaults.WithDefaults(conf, &RAResolverConfig{
+		AddressOrder: ptr.To(AddressOrderRFC6724),
+		NDots:        ptr.To(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to ra resolver config: %w", err)
+	}
+
+	if err := conf.AddressOrder.validate(); err != nil {
+		return nil, err
+	}
+
+	if *conf.NDots < 0 {
+		return nil, fmt.Errorf("ndots must not be negative")
+	}
+
+	return &RAResolver{
+		conf: *conf,
+	}, nil
+}
+
+// UpdateRDNSS processes an advertised RDNSS option. Advertised servers are
+// added (or have their lifetime refreshed), and servers advertised with a
+// lifetime of zero are removed.
+func (r *RAResolver) UpdateRDNSS(opt RDNSSOption) {
+	servers := make([]netip.Addr, 0, len(opt.Servers))
+	for _, server := range opt.Servers {
+		if server.Is6() && server.IsLinkLocalUnicast() && server.Zone() == "" {
+			server = server.WithZone(r.conf.Zone)
+		}
+		servers = append(servers, server)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var changed bool
+	r.servers, changed = updateRAEntries(r.servers, servers, opt.Lifetime)
+	r.stale = r.stale || changed
+}
+
+// UpdateDNSSL processes an advertised DNSSL option. Advertised domains are
+// added (or have their lifetime refreshed), and domains advertised with a
+// lifetime of zero are removed.
+func (r *RAResolver) UpdateDNSSL(opt DNSSLOption) {
+	domains := make([]string, 0, len(opt.Domains))
+	for _, domain := range opt.Domains {
+		domains = append(domains, dns.CanonicalName(domain))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var changed bool
+	r.domains, changed = updateRAEntries(r.domains, domains, opt.Lifetime)
+	r.stale = r.stale || changed
+}
+
+// Servers returns the advertised servers that haven't expired, in the order
+// they were first advertised.
+func (r *RAResolver) Servers() []netip.Addr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+	return raValues(r.servers)
+}
+
+// Search returns the advertised search domains that haven't expired, in the
+// order they were first advertised.
+func (r *RAResolver) Search() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+	return raValues(r.domains)
+}
+
+func (r *RAResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	resolver, err := r.current(host)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolver.LookupNetIP(ctx, network, host)
+}
+
+func (r *RAResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	resolver, err := r.current(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return lookupAddr(ctx, resolver, addr)
+}
+
+func (r *RAResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	resolver, err := r.current(q.Name)
+	if err != nil {
+		return Answer{}, err
+	}
+
+	return Lookup(ctx, resolver, q)
+}
+
+func (r *RAResolver) Describe() Description {
+	r.mu.Lock()
+	resolver := r.resolver
+	r.mu.Unlock()
+
+	d := Description{Type: "ra"}
+	if resolver != nil {
+		d.Children = []Description{Describe(resolver)}
+	}
+
+	return d
+}
+
+// current returns the resolver for the advertised servers that haven't
+// expired, rebuilding it if the servers or search domains changed.
+func (r *RAResolver) current(name string) (Resolver, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+
+	if r.stale {
+		resolver, err := r.build()
+		if err != nil {
+			return nil, &net.DNSError{
+				Err:  err.Error(),
+				Name: name,
+			}
+		}
+
+		r.resolver = resolver
+		r.stale = false
+	}
+
+	if r.resolver == nil {
+		return nil, &net.DNSError{
+			Err:         "no router advertised resolvers available",
+			Name:        name,
+			IsTemporary: true,
+		}
+	}
+
+	return r.resolver, nil
+}
+
+// expire removes the expired servers and search domains.
+func (r *RAResolver) expire() {
+	now := time.Now()
+
+	servers := removeExpiredRAEntries(r.servers, now)
+	domains := removeExpiredRAEntries(r.domains, now)
+	if len(servers) != len(r.servers) || len(domains) != len(r.domains) {
+		r.stale = true
+	}
+
+	r.servers = servers
+	r.domains = domains
+}
+
+// build creates the resolver for the current servers and search domains.
+func (r *RAResolver) build() (Resolver, error) {
+	return newAdvertisedResolver(raValues(r.servers), raValues(r.domains),
+		r.conf.Timeout, r.conf.DialContext, r.conf.AddressOrder, r.conf.NDots)
+}
+
+// newAdvertisedResolver returns a resolver that queries the DNS servers
+// advertised by the network (eg. using router advertisements or DHCP) in
+// order, applying the advertised search domains. It returns nil if there are
+// no servers.
+func newAdvertisedResolver(servers []netip.Addr, search []string, timeout *time.Duration,
+	dialContext DialContextFunc, addressOrder *AddressOrder, nDots *int) (Resolver, error) {
+	if len(servers) == 0 {
+		return nil, nil
+	}
+
+	resolvers := make([]Resolver, 0, len(servers))
+	for _, server := range servers {
+		dnsResolver, err := DNS(DNSResolverConfig{
+			Server:       netip.AddrPortFrom(server, 53),
+			Timeout:      timeout,
+			DialContext:  dialContext,
+			AddressOrder: addressOrder,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dns resolver for %s: %w", server, err)
+		}
+
+		penaltyBoxResolver, err := PenaltyBox(dnsResolver, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create penalty box resolver: %w", err)
+		}
+
+		resolvers = append(resolvers, penaltyBoxResolver)
+	}
+
+	resolver := Resolver(SequentialWithConfig(&SequentialResolverConfig{
+		Failover: FailoverOnServerError,
+	}, resolvers...))
+
+	if len(search) > 0 {
+		var err error
+		resolver, err = Relative(resolver, &RelativeResolverConfig{
+			Search: search,
+			NDots:  nDots,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create relative resolver: %w", err)
+		}
+	}
+
+	return resolver, nil
+}
+
+// updateRAEntries adds (or refreshes) the advertised values, or removes them
+// if the lifetime is zero (RFC 8106, section 5.3.1). It returns true if values
+// were added or removed (rather than only refreshed).
+func updateRAEntries[T comparable](entries []raEntry[T], values []T, lifetime time.Duration) ([]raEntry[T], bool) {
+	var changed bool
+	var expires time.Time
+	if lifetime != RAInfiniteLifetime {
+		expires = time.Now().Add(lifetime)
+	}
+
+	for _, value := range values {
+		i := slices.IndexFunc(entries, func(entry raEntry[T]) bool {
+			return entry.value == value
+		})
+
+		switch {
+		case lifetime == 0:
+			if i >= 0 {
+				entries = slices.Delete(entries, i, i+1)
+				changed = true
+			}
+		case i >= 0:
+			entries[i].expires = expires
+		default:
+			entries = append(entries, raEntry[T]{value: value, expires: expires})
+			changed = true
+		}
+	}
+
+	return entries, changed
+}
+
+// removeExpiredRAEntries returns the entries that haven't expired by now.
+func removeExpiredRAEntries[T comparable](entries []raEntry[T], now time.Time) []raEntry[T] {
+	return slices.DeleteFunc(entries, func(entry raEntry[T]) bool {
+		return !entry.expires.IsZero() && !now.Before(entry.expires)
+	})
+}
+
+// raValues returns the values of the entries.
+func raValues[T comparable](entries []raEntry[T]) []T {
+	values := make([]T, 0, len(entries))
+	for _, entry := range entries {
+		values = append(values, entry.value)
+	}
+	return values
+}
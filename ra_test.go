@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRDNSS(t *testing.T) {
+	server := netip.MustParseAddr("2001:db8::53").As16()
+
+	data := append([]byte{25, 3, 0, 0, 0, 0, 0x0e, 0x10}, server[:]...)
+
+	opt, err := resolver.ParseRDNSS(data)
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, opt.Lifetime)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("2001:db8::53")}, opt.Servers)
+
+	// A lifetime of all one bits is infinite.
+	data = append([]byte{25, 3, 0, 0, 0xff, 0xff, 0xff, 0xff}, server[:]...)
+
+	opt, err = resolver.ParseRDNSS(data)
+	require.NoError(t, err)
+	require.Equal(t, resolver.RAInfiniteLifetime, opt.Lifetime)
+
+	_, err = resolver.ParseRDNSS(data[:16])
+	require.Error(t, err)
+}
+
+func TestParseDNSSL(t *testing.T) {
+	domains := []byte("\x07example\x03com\x00\x04corp\x08internal\x00")
+
+	data := append([]byte{31, 5, 0, 0, 0, 0, 0x0e, 0x10}, domains...)
+	// Padded to a multiple of 8 octets.
+	data = append(data, 0, 0, 0, 0)
+
+	opt, err := resolver.ParseDNSSL(data)
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, opt.Lifetime)
+	require.Equal(t, []string{"example.com.", "corp.internal."}, opt.Domains)
+
+	_, err = resolver.ParseDNSSL(data[:24])
+	require.Error(t, err)
+}
+
+func TestRAResolver(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"www.example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+	})
+
+	var mu sync.Mutex
+	var dialed []string
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, address)
+		mu.Unlock()
+
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Addr().String())
+	}
+
+	newRA := func(t *testing.T) *resolver.RAResolver {
+		res, err := resolver.RA(&resolver.RAResolverConfig{
+			Zone:         "eth0",
+			DialContext:  dialContext,
+			AddressOrder: ptr.To(resolver.AddressOrderNone),
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("No Servers", func(t *testing.T) {
+		res := newRA(t)
+
+		_, err := res.LookupNetIP(context.Background(), "ip4", "www.example.com")
+		require.Error(t, err)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		res := newRA(t)
+
+		res.UpdateRDNSS(resolver.RDNSSOption{
+			Lifetime: time.Hour,
+			Servers:  []netip.Addr{netip.MustParseAddr("fe80::53")},
+		})
+		res.UpdateDNSSL(resolver.DNSSLOption{
+			Lifetime: time.Hour,
+			Domains:  []string{"example.com"},
+		})
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("fe80::53%eth0")}, res.Servers())
+		require.Equal(t, []string{"example.com."}, res.Search())
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip4", "www")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+		mu.Lock()
+		require.Contains(t, dialed, "[fe80::53%eth0]:53")
+		mu.Unlock()
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		res := newRA(t)
+
+		res.UpdateRDNSS(resolver.RDNSSOption{
+			Lifetime: 50 * time.Millisecond,
+			Servers:  []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+		})
+
+		_, err := res.LookupNetIP(context.Background(), "ip4", "www.example.com")
+		require.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+
+		require.Empty(t, res.Servers())
+
+		_, err = res.LookupNetIP(context.Background(), "ip4", "www.example.com")
+		require.Error(t, err)
+	})
+
+	t.Run("Zero Lifetime", func(t *testing.T) {
+		res := newRA(t)
+
+		res.UpdateRDNSS(resolver.RDNSSOption{
+			Lifetime: resolver.RAInfiniteLifetime,
+			Servers:  []netip.Addr{netip.MustParseAddr("2001:db8::53"), netip.MustParseAddr("2001:db8::54")},
+		})
+
+		res.UpdateRDNSS(resolver.RDNSSOption{
+			Servers: []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+		})
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("2001:db8::54")}, res.Servers())
+	})
+}
+
+func TestSystemResolverRA(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+	})
+
+	// Only the router advertised server is reachable.
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if !strings.HasPrefix(address, "[2001:db8::53]:") {
+			return nil, errors.New("unreachable")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Addr().String())
+	}
+
+	ra, err := resolver.RA(&resolver.RAResolverConfig{
+		DialContext: dialContext,
+	})
+	require.NoError(t, err)
+
+	ra.UpdateRDNSS(resolver.RDNSSOption{
+		Lifetime: time.Hour,
+		Servers:  []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+	})
+
+	res, err := resolver.System(&resolver.SystemResolverConfig{
+		ResolvConfPath: "testdata/kubernetes-resolv.conf",
+		DNSOnly:        ptr.To(true),
+		AddressOrder:   ptr.To(resolver.AddressOrderNone),
+		DialContext:    dialContext,
+		RA:             ra,
+	})
+	require.NoError(t, err)
+
+	addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com.")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/noisysockets/resolver/internal/defaults"
+	"github.com/noisysockets/util/ptr"
+)
+
+var (
+	// ipv4ProbeAddr and ipv6ProbeAddr are (non local) addresses used to check
+	// whether the host has routes to the IPv4 and IPv6 internet.
+	ipv4ProbeAddr = netip.MustParseAddr("192.0.2.1")
+	ipv6ProbeAddr = netip.MustParseAddr("2000::1")
+)
+
+// ReachabilityProbeConfig is the configuration of a reachability probe.
+type ReachabilityProbeConfig struct {
+	// DialContext is used to probe for routes, by connecting (but not
+	// sending) a UDP socket.
+	// By default, a net.Dialer is used.
+	DialContext DialContextFunc
+	// RefreshInterval is how long probe results are cached for.
+	// By default, 1 minute.
+	RefreshInterval *time.Duration
+}
+
+// ReachabilityProbe checks whether the host has routes to IPv4 and IPv6
+// destinations (like the Go runtime's IPv4/IPv6 probe, but taking the routing
+// table into account rather than just kernel support). Results are cached
+// until they are invalidated. It is safe for concurrent use.
+type ReachabilityProbe struct {
+	dialContext DialContextFunc
+
+	mu      sync.Mutex
+	refresh time.Duration
+	ipv4    bool
+	ipv6    bool
+	valid   bool
+	expires time.Time
+}
+
+var (
+	defaultReachabilityProbeOnce sync.Once
+	defaultReachabilityProbeInst *ReachabilityProbe
+)
+
+// DefaultReachabilityProbe returns a (shared) reachability probe using the
+// host's network stack. Results are invalidated when the interface addresses
+// or routes change (on Linux) or periodically (elsewhere).
+func DefaultReachabilityProbe() *ReachabilityProbe {
+	defaultReachabilityProbeOnce.Do(func() {
+		p, _ := NewReachabilityProbe(nil)
+
+		// If we're notified of changes, there's no need to re-probe.
+		refresh := p.refresh
+		p.refresh = 0
+
+		if !watchRoutes(p.Invalidate, func() { p.poll(refresh) }) {
+			p.refresh = refresh
+		}
+
+		defaultReachabilityProbeInst = p
+	})
+
+	return defaultReachabilityProbeInst
+}
+
+// NewReachabilityProbe returns a new reachability probe.
+func NewReachabilityProbe(conf *ReachabilityProbeConfig) (*ReachabilityProbe, error) {
+	conf, err := defaults.WithDefaults(conf, &ReachabilityProbeConfig{
+		DialContext:     (&net.Dialer{}).DialContext,
+		RefreshInterval: ptr.To(time.Minute),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply defaults to reachability probe config: %w", err)
+	}
+
+	return &ReachabilityProbe{
+		dialContext: conf.DialContext,
+		refresh:     *conf.RefreshInterval,
+	}, nil
+}
+
+// Reachable reports whether the host has routes to IPv4 and IPv6 destinations.
+func (p *ReachabilityProbe) Reachable(ctx context.Context) (ipv4, ipv6 bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.valid && (p.refresh == 0 || time.Now().Before(p.expires)) {
+		return p.ipv4, p.ipv6
+	}
+
+	p.ipv4 = p.probe(ctx, ipv4ProbeAddr)
+	p.ipv6 = p.probe(ctx, ipv6ProbeAddr)
+	p.valid = true
+	p.expires = time.Now().Add(p.refresh)
+
+	return p.ipv4, p.ipv6
+}
+
+// Invalidate discards the cached results, eg. after a network change.
+func (p *ReachabilityProbe) Invalidate() {
+	p.mu.Lock()
+	p.valid = false
+	p.mu.Unlock()
+}
+
+// poll re-probes periodically, once changes are no longer notified.
+func (p *ReachabilityProbe) poll(refresh time.Duration) {
+	p.mu.Lock()
+	p.refresh = refresh
+	p.valid = false
+	p.mu.Unlock()
+}
+
+// Filter returns a SourceAddrProvider that reports destinations of address
+// families without a route as unreachable (an invalid source address), and
+// otherwise uses srcAddrs.
+func (p *ReachabilityProbe) Filter(srcAddrs SourceAddrProvider) SourceAddrProvider {
+	return &reachableSourceAddrProvider{probe: p, srcAddrs: srcAddrs}
+}
+
+// probe reports whether the host has a route to dst, with a usable source
+// address.
+func (p *ReachabilityProbe) probe(ctx context.Context, dst netip.Addr) bool {
+	conn, err := p.dialContext(ctx, "udp", netip.AddrPortFrom(dst, 9).String())
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	src, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+
+	addr := src.AddrPort().Addr().Unmap()
+	return addr.IsValid() && !addr.IsUnspecified() && !addr.IsLoopback() &&
+		!addr.IsLinkLocalUnicast()
+}
+
+type reachableSourceAddrProvider struct {
+	probe    *ReachabilityProbe
+	srcAddrs SourceAddrProvider
+}
+
+func (p *reachableSourceAddrProvider) SourceAddrs(ctx context.Context, dsts []netip.Addr) []netip.Addr {
+	srcs := p.srcAddrs.SourceAddrs(ctx, dsts)
+
+	ipv4, ipv6 := p.probe.Reachable(ctx)
+	for i, dst := range dsts {
+		// Local and private destinations may be reachable without a default
+		// route.
+		if dst.IsLoopback() || dst.IsLinkLocalUnicast() || dst.IsPrivate() {
+			continue
+		}
+
+		if is4 := dst.Unmap().Is4(); (is4 && !ipv4) || (!is4 && !ipv6) {
+			srcs[i] = netip.Addr{}
+		}
+	}
+
+	return srcs
+}
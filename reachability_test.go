@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReachabilityProbe(t *testing.T) {
+	// An IPv4 only network.
+	var dials atomic.Int64
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dials.Add(1)
+
+		addrPort := netip.MustParseAddrPort(address)
+		if addrPort.Addr().Is6() {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ENETUNREACH}
+		}
+
+		return &probeConn{local: &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 12345}}, nil
+	}
+
+	probe, err := resolver.NewReachabilityProbe(&resolver.ReachabilityProbeConfig{
+		DialContext:     dialContext,
+		RefreshInterval: ptr.To(time.Hour),
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	ipv4, ipv6 := probe.Reachable(ctx)
+	require.True(t, ipv4)
+	require.False(t, ipv6)
+
+	// Results are cached.
+	_, _ = probe.Reachable(ctx)
+	require.Equal(t, int64(2), dials.Load())
+
+	probe.Invalidate()
+	_, _ = probe.Reachable(ctx)
+	require.Equal(t, int64(4), dials.Load())
+
+	t.Run("Filter", func(t *testing.T) {
+		global := netip.MustParseAddr("2001:db8::1")
+		ula := netip.MustParseAddr("fd00::1")
+		ipv4Dst := netip.MustParseAddr("198.51.100.1")
+
+		srcAddrs := probe.Filter(staticSourceAddrs{
+			global:  netip.MustParseAddr("2001:db8::100"),
+			ula:     netip.MustParseAddr("fd00::100"),
+			ipv4Dst: netip.MustParseAddr("192.168.1.2"),
+		})
+
+		srcs := srcAddrs.SourceAddrs(ctx, []netip.Addr{global, ula, ipv4Dst})
+		require.False(t, srcs[0].IsValid())
+		require.Equal(t, netip.MustParseAddr("fd00::100"), srcs[1])
+		require.Equal(t, netip.MustParseAddr("192.168.1.2"), srcs[2])
+	})
+
+	t.Run("IPv6 Gate", func(t *testing.T) {
+		fake := resolvertest.NewFake()
+		fake.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("2001:db8::1"))
+
+		res, err := resolver.IPv6Gate(fake, &resolver.IPv6GateResolverConfig{
+			DialContext: dialContext,
+		})
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(ctx, "ip", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+	})
+}
+
+// probeConn is a connected UDP socket used to probe for routes.
+type probeConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c *probeConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *probeConn) Close() error {
+	return nil
+}
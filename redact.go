@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Redactor anonymizes query log entries before they are recorded, so that
+// operators can enable query logging (and the debug handler) without storing
+// a browsing history.
+type Redactor interface {
+	// Redact modifies the entry in place.
+	Redact(entry *QueryLogEntry)
+}
+
+// RedactorFunc is an adapter to allow the use of ordinary functions as
+// redactors.
+type RedactorFunc func(entry *QueryLogEntry)
+
+// Redact calls f(entry).
+func (f RedactorFunc) Redact(entry *QueryLogEntry) {
+	f(entry)
+}
+
+// ChainRedactors returns a redactor that applies each of the redactors in
+// turn.
+func ChainRedactors(redactors ...Redactor) Redactor {
+	return RedactorFunc(func(entry *QueryLogEntry) {
+		for _, redactor := range redactors {
+			redactor.Redact(entry)
+		}
+	})
+}
+
+// HashNames returns a redactor that replaces queried names with a keyed hash
+// (a truncated HMAC-SHA256) of the name. Queries of the same name can still be
+// correlated, but without the key the names can't be recovered by hashing a
+// dictionary of likely names. Names are compared case insensitively.
+func HashNames(key []byte) Redactor {
+	return RedactorFunc(func(entry *QueryLogEntry) {
+		redactName(entry, func(name string) string {
+			mac := hmac.New(sha256.New, key)
+			_, _ = mac.Write([]byte(strings.ToLower(dns.Fqdn(name))))
+			return hex.EncodeToString(mac.Sum(nil)[:8])
+		})
+	})
+}
+
+// TruncateNames returns a redactor that replaces all but the last labels
+// labels of queried names with a wildcard, eg. with two labels
+// "www.example.com." is recorded as "*.example.com.". A labels value less than
+// one is treated as one.
+func TruncateNames(labels int) Redactor {
+	if labels < 1 {
+		labels = 1
+	}
+
+	return RedactorFunc(func(entry *QueryLogEntry) {
+		redactName(entry, func(name string) string {
+			idx := dns.Split(name)
+			if len(idx) <= labels {
+				return name
+			}
+			return "*." + name[idx[len(idx)-labels]:]
+		})
+	})
+}
+
+// DropClientData returns a redactor that drops the data that could identify
+// the client, the upstream server (eg. the address of a home router or of a
+// corporate resolver), and the precise time of the query, which is rounded
+// down to a multiple of precision (a precision less than or equal to zero
+// drops the time entirely).
+func DropClientData(precision time.Duration) Redactor {
+	return RedactorFunc(func(entry *QueryLogEntry) {
+		if entry.Err != "" && entry.Server != "" {
+			entry.Err = strings.ReplaceAll(entry.Err, entry.Server, "[redacted]")
+		}
+		entry.Server = ""
+
+		if precision > 0 {
+			entry.Time = entry.Time.Truncate(precision)
+		} else {
+			entry.Time = time.Time{}
+		}
+	})
+}
+
+// redactName replaces the name of the entry, and any mention of it in the
+// entry's error.
+func redactName(entry *QueryLogEntry, redact func(name string) string) {
+	if entry.Name == "" {
+		return
+	}
+
+	redacted := redact(entry.Name)
+	if entry.Err != "" {
+		entry.Err = strings.ReplaceAll(entry.Err, entry.Name, redacted)
+		if name := strings.TrimSuffix(entry.Name, "."); name != "" {
+			entry.Err = strings.ReplaceAll(entry.Err, name, strings.TrimSuffix(redacted, "."))
+		}
+	}
+	entry.Name = redacted
+}
@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactors(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 34, 56, 0, time.UTC)
+
+	newEntry := func() resolver.QueryLogEntry {
+		return resolver.QueryLogEntry{
+			Time:   now,
+			Name:   "www.example.com.",
+			Type:   "A",
+			Server: "192.168.1.1:53",
+			Err:    "lookup www.example.com on 192.168.1.1:53: server misbehaving",
+		}
+	}
+
+	t.Run("HashNames", func(t *testing.T) {
+		entry := newEntry()
+		resolver.HashNames([]byte("secret")).Redact(&entry)
+
+		require.Len(t, entry.Name, 16)
+		require.NotContains(t, entry.Err, "example")
+
+		// The same name (in any case) hashes to the same value.
+		other := newEntry()
+		other.Name = "WWW.Example.com."
+		resolver.HashNames([]byte("secret")).Redact(&other)
+		require.Equal(t, entry.Name, other.Name)
+
+		// But not with a different key.
+		other = newEntry()
+		resolver.HashNames([]byte("other")).Redact(&other)
+		require.NotEqual(t, entry.Name, other.Name)
+	})
+
+	t.Run("TruncateNames", func(t *testing.T) {
+		entry := newEntry()
+		resolver.TruncateNames(2).Redact(&entry)
+
+		require.Equal(t, "*.example.com.", entry.Name)
+		require.Equal(t, "lookup *.example.com on 192.168.1.1:53: server misbehaving", entry.Err)
+
+		entry = newEntry()
+		entry.Name = "example.com."
+		resolver.TruncateNames(2).Redact(&entry)
+		require.Equal(t, "example.com.", entry.Name)
+	})
+
+	t.Run("DropClientData", func(t *testing.T) {
+		entry := newEntry()
+		resolver.DropClientData(time.Hour).Redact(&entry)
+
+		require.Empty(t, entry.Server)
+		require.Equal(t, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), entry.Time)
+		require.Equal(t, "lookup www.example.com on [redacted]: server misbehaving", entry.Err)
+
+		entry = newEntry()
+		resolver.DropClientData(0).Redact(&entry)
+		require.True(t, entry.Time.IsZero())
+	})
+}
+
+func TestQueryLogRedactor(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"www.example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+	})
+
+	queryLog := resolver.NewQueryLog(10)
+	queryLog.SetRedactor(resolver.ChainRedactors(
+		resolver.TruncateNames(2),
+		resolver.DropClientData(time.Minute),
+	))
+
+	res, err := resolver.NewDNS(srv.Addr(), resolver.WithQueryLog(queryLog))
+	require.NoError(t, err)
+
+	_, err = res.LookupNetIP(context.Background(), "ip4", "www.example.com")
+	require.NoError(t, err)
+
+	entries := queryLog.Entries()
+	require.Len(t, entries, 1)
+
+	require.Equal(t, "*.example.com.", entries[0].Name)
+	require.Empty(t, entries[0].Server)
+	require.Zero(t, entries[0].Time.Second())
+	require.Equal(t, "NOERROR", entries[0].RCode)
+}
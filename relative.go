@@ -12,12 +12,17 @@ package resolver
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/netip"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver/internal/defaults"
 	"github.com/noisysockets/resolver/util"
-	"github.com/noisysockets/util/defaults"
 	"github.com/noisysockets/util/ptr"
 )
 
@@ -29,40 +34,207 @@ type RelativeResolverConfig struct {
 	Search []string
 	// NDots is the number of dots in a name to trigger an absolute lookup.
 	NDots *int
+	// Rules override the search list and ndots for relative names within
+	// specific domains (like the routing domains of systemd-resolved), so that
+	// short names in one realm aren't tried against unrelated search domains.
+	// When multiple rules match a name, the rule with the longest domain is
+	// used.
+	Rules []RelativeDomainRule
+	// SearchConcurrency is the maximum number of search domain expansions of a
+	// name looked up concurrently, cutting the latency of long search lists
+	// (eg. ndots:5 with the search domains of a Kubernetes pod). Whatever the
+	// concurrency, the first expansion (in search order) that succeeds is
+	// returned, and lookups of later expansions are then canceled.
+	// By default, 1 (expansions are looked up one at a time).
+	SearchConcurrency *int
+	// SearchMissTTL is how long not found (NXDOMAIN) results of search domain
+	// expansions (eg. "example.com.default.svc.cluster.local.") are cached,
+	// so that repeated lookups of external names don't walk the whole search
+	// list every time. Absolute names are never cached.
+	// By default, 0 (search misses are not cached).
+	SearchMissTTL *time.Duration
+	// QueryHook is an optional hook that can rewrite or veto each name tried
+	// for a host before it's looked up, eg. NoSearchForTLDs.
+	QueryHook QueryHook
+}
+
+// QueryHook is called with each name tried for host (host itself as a rooted
+// name, or one of its search domain expansions) before it's looked up. It
+// returns the (possibly rewritten) name to look up, or false to skip the name.
+type QueryHook func(host, name string) (string, bool)
+
+// NoSearchForTLDs returns a query hook that skips the search domain expansions
+// of names containing a dot and ending in one of the top level domains (eg.
+// "com"), so that "example.com" is only looked up as an absolute name.
+func NoSearchForTLDs(tlds ...string) QueryHook {
+	known := make(map[string]struct{}, len(tlds))
+	for _, tld := range tlds {
+		known[dns.CanonicalName(tld)] = struct{}{}
+	}
+
+	return func(host, name string) (string, bool) {
+		host = dns.CanonicalName(host)
+		if dns.CanonicalName(name) == host {
+			return name, true
+		}
+
+		labels := dns.SplitDomainName(host)
+		if len(labels) < 2 {
+			return name, true
+		}
+
+		_, ok := known[dns.Fqdn(labels[len(labels)-1])]
+		return name, !ok
+	}
+}
+
+// RelativeDomainRule overrides the search behavior for relative names within
+// a domain.
+type RelativeDomainRule struct {
+	// Domain is the domain the rule applies to, relative names ending in the
+	// domain (eg. "db.prod" for the domain "prod") or equal to it match.
+	Domain string
+	// Search is the list of rooted suffixes to append to matching names.
+	// By default (or if empty), the search list of the resolver.
+	Search []string
+	// NDots is the number of dots in a matching name to trigger an absolute
+	// lookup. By default, the ndots of the resolver.
+	NDots *int
+}
+
+// SearchError is returned by a relative resolver when none of the names tried
+// for a host (the host itself as a rooted name, or its search domain
+// expansions) could be looked up. It wraps the error of each name.
+type SearchError struct {
+	// Host is the name that was looked up.
+	Host string
+	// Names are the names that were tried, in order.
+	Names []string
+	// Errs are the errors of the lookups of each name.
+	Errs []error
+}
+
+func (e *SearchError) Error() string {
+	return errors.Join(e.Errs...).Error()
+}
+
+func (e *SearchError) Unwrap() []error {
+	return e.Errs
 }
 
 type relativeResolver struct {
-	resolver Resolver
-	search   []string
-	nDots    int
+	resolver    Resolver
+	search      []string
+	nDots       int
+	rules       []relativeRule
+	concurrency int
+	misses      *searchMisses
+	queryHook   QueryHook
+}
+
+// relativeRule is a validated RelativeDomainRule.
+type relativeRule struct {
+	// domain is the canonical (rooted) domain of the rule.
+	domain string
+	search []string
+	nDots  int
 }
 
 // Relative returns a resolver that resolves relative hostnames.
-func Relative(resolver Resolver, conf *RelativeResolverConfig) *relativeResolver {
+func Relative(resolver Resolver, conf *RelativeResolverConfig) (*relativeResolver, error) {
 	conf, err := defaults.WithDefaults(conf, &RelativeResolverConfig{
-		Search: []string{"."},
-		NDots:  ptr.To(1),
+		Search:            []string{"."},
+		NDots:             ptr.To(1),
+		SearchConcurrency: ptr.To(1),
+		SearchMissTTL:     ptr.To(time.Duration(0)),
 	})
 	if err != nil {
-		// Should never happen.
-		panic(err)
+		return nil, fmt.Errorf("failed to apply defaults to relative resolver config: %w", err)
+	}
+
+	if *conf.NDots < 0 {
+		return nil, fmt.Errorf("ndots must not be negative")
+	}
+
+	if *conf.SearchConcurrency < 1 {
+		return nil, fmt.Errorf("search concurrency must be at least 1")
+	}
+
+	if *conf.SearchMissTTL < 0 {
+		return nil, fmt.Errorf("search miss ttl must not be negative")
+	}
+
+	var misses *searchMisses
+	if *conf.SearchMissTTL > 0 {
+		misses = &searchMisses{
+			ttl:     *conf.SearchMissTTL,
+			expires: make(map[searchMissKey]time.Time),
+		}
+	}
+
+	if err := validateSearch(conf.Search); err != nil {
+		return nil, err
+	}
+
+	rules := make([]relativeRule, 0, len(conf.Rules))
+	for _, rule := range conf.Rules {
+		if _, ok := dns.IsDomainName(rule.Domain); !ok || rule.Domain == "" {
+			return nil, fmt.Errorf("invalid rule domain %q", rule.Domain)
+		}
+
+		search := conf.Search
+		if len(rule.Search) > 0 {
+			if err := validateSearch(rule.Search); err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Domain, err)
+			}
+			search = rule.Search
+		}
+
+		nDots := *conf.NDots
+		if rule.NDots != nil {
+			if *rule.NDots < 0 {
+				return nil, fmt.Errorf("rule %q: ndots must not be negative", rule.Domain)
+			}
+			nDots = *rule.NDots
+		}
+
+		rules = append(rules, relativeRule{
+			domain: dns.CanonicalName(rule.Domain),
+			search: search,
+			nDots:  nDots,
+		})
 	}
 
 	return &relativeResolver{
-		resolver: resolver,
-		search:   conf.Search,
-		nDots:    *conf.NDots,
+		resolver:    resolver,
+		search:      conf.Search,
+		nDots:       *conf.NDots,
+		rules:       rules,
+		concurrency: *conf.SearchConcurrency,
+		misses:      misses,
+		queryHook:   conf.QueryHook,
+	}, nil
+}
+
+func validateSearch(search []string) error {
+	for _, domain := range search {
+		if _, ok := dns.IsDomainName(domain); !ok {
+			return fmt.Errorf("invalid search domain %q", domain)
+		}
 	}
+
+	return nil
 }
 
 func (r *relativeResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
 	names := []string{dns.Fqdn(host)}
 
-	if nDots := strings.Count(host, "."); !strings.HasSuffix(host, ".") && nDots < r.nDots {
+	search, minDots := r.searchFor(host)
+	if nDots := strings.Count(host, "."); !strings.HasSuffix(host, ".") && nDots < minDots {
 		// If the name has fewer dots than the threshold, append the search
 		// domains to the name.
 		names = nil
-		for _, domain := range r.search {
+		for _, domain := range search {
 			name := util.Join(host, domain)
 			if _, ok := dns.IsDomainName(name); ok {
 				names = append(names, name)
@@ -70,14 +242,235 @@ func (r *relativeResolver) LookupNetIP(ctx context.Context, network, host string
 		}
 	}
 
+	if r.queryHook != nil {
+		names = r.rewriteNames(host, names)
+	}
+
+	if len(names) == 0 {
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       host,
+			IsNotFound: true,
+		}
+	}
+
+	if r.concurrency > 1 && len(names) > 1 {
+		return r.lookupConcurrently(ctx, network, host, names)
+	}
+
 	var errs []error
 	for _, name := range names {
-		addrs, err := r.resolver.LookupNetIP(ctx, network, name)
+		addrs, err := r.lookupName(ctx, network, host, name)
 		if err == nil {
+			recordQueriedName(ctx, name)
 			return addrs, nil
 		}
 		errs = append(errs, err)
 	}
 
-	return nil, errors.Join(errs...)
+	return nil, &SearchError{Host: host, Names: names, Errs: errs}
+}
+
+// recordQueriedName records the name that answered the lookup in the
+// metadata of the context (if any).
+func recordQueriedName(ctx context.Context, name string) {
+	if md := metadataFromContext(ctx); md != nil {
+		md.recordQueriedName(name)
+	}
+}
+
+type searchResult struct {
+	addrs []netip.Addr
+	err   error
+}
+
+// lookupConcurrently looks up (up to the configured concurrency) names
+// concurrently, returning the first name (in order) that succeeds. Once a
+// name succeeds, the lookups of later names are canceled.
+func (r *relativeResolver) lookupConcurrently(ctx context.Context, network, host string, names []string) ([]netip.Addr, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Buffered so that lookups never block on results that won't be read.
+	results := make([]chan searchResult, len(names))
+	for i := range results {
+		results[i] = make(chan searchResult, 1)
+	}
+
+	go func() {
+		sem := make(chan struct{}, r.concurrency)
+		for i, name := range names {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i] <- searchResult{err: ctx.Err()}
+				continue
+			}
+
+			go func(i int, name string) {
+				defer func() { <-sem }()
+
+				addrs, err := r.lookupName(ctx, network, host, name)
+				results[i] <- searchResult{addrs: addrs, err: err}
+			}(i, name)
+		}
+	}()
+
+	var errs []error
+	for i, result := range results {
+		res := <-result
+		if res.err == nil {
+			recordQueriedName(ctx, names[i])
+			return res.addrs, nil
+		}
+		errs = append(errs, res.err)
+	}
+
+	return nil, &SearchError{Host: host, Names: names, Errs: errs}
+}
+
+// rewriteNames applies the query hook to the names tried for host.
+func (r *relativeResolver) rewriteNames(host string, names []string) []string {
+	rewritten := make([]string, 0, len(names))
+	for _, name := range names {
+		name, ok := r.queryHook(host, name)
+		if !ok {
+			continue
+		}
+
+		name = dns.Fqdn(name)
+		if _, ok := dns.IsDomainName(name); ok {
+			rewritten = append(rewritten, name)
+		}
+	}
+
+	return rewritten
+}
+
+// lookupName looks up name, one of the names tried for host. Not found
+// results of search domain expansions are cached (if enabled).
+func (r *relativeResolver) lookupName(ctx context.Context, network, host, name string) ([]netip.Addr, error) {
+	expansion := r.misses != nil && name != dns.CanonicalName(host)
+	if expansion && r.misses.missed(network, name) {
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       name,
+			IsNotFound: true,
+		}
+	}
+
+	addrs, err := r.resolver.LookupNetIP(ctx, network, name)
+	if err != nil && expansion && isNotFound(err) {
+		r.misses.add(network, name)
+	}
+
+	return addrs, err
+}
+
+// searchFor returns the search list and ndots used for the relative name host,
+// from the most specific matching rule (if any).
+func (r *relativeResolver) searchFor(host string) ([]string, int) {
+	search, nDots := r.search, r.nDots
+	if strings.HasSuffix(host, ".") {
+		return search, nDots
+	}
+
+	name := dns.CanonicalName(host)
+	var matched string
+	for _, rule := range r.rules {
+		if len(rule.domain) > len(matched) && dns.IsSubDomain(rule.domain, name) {
+			search, nDots, matched = rule.search, rule.nDots, rule.domain
+		}
+	}
+
+	return search, nDots
+}
+
+func (r *relativeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return lookupAddr(ctx, r.resolver, addr)
+}
+
+// Lookup answers the question, the search list is only applied to address (A
+// and AAAA) questions.
+func (r *relativeResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	if q.Type == dns.TypeA || q.Type == dns.TypeAAAA {
+		return lookupBasic(ctx, r, q)
+	}
+
+	return Lookup(ctx, r.resolver, q)
+}
+
+func (r *relativeResolver) Describe() Description {
+	attributes := map[string]string{
+		"search": strings.Join(r.search, " "),
+		"ndots":  strconv.Itoa(r.nDots),
+	}
+	if r.concurrency > 1 {
+		attributes["search-concurrency"] = strconv.Itoa(r.concurrency)
+	}
+	if r.misses != nil {
+		attributes["search-miss-ttl"] = r.misses.ttl.String()
+	}
+	for _, rule := range r.rules {
+		attributes["rule "+rule.domain] = fmt.Sprintf("search=%s ndots=%d",
+			strings.Join(rule.search, ","), rule.nDots)
+	}
+
+	return Description{
+		Type:       "relative",
+		Attributes: attributes,
+		Children:   []Description{Describe(r.resolver)},
+	}
+}
+
+// maxSearchMisses is the maximum number of cached search misses.
+const maxSearchMisses = 4096
+
+type searchMissKey struct {
+	network string
+	name    string
+}
+
+// searchMisses caches the not found results of search domain expansions.
+type searchMisses struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	expires map[searchMissKey]time.Time
+}
+
+// missed reports whether a lookup of name recently wasn't found.
+func (m *searchMisses) missed(network, name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := searchMissKey{network: network, name: name}
+	expires, ok := m.expires[key]
+	if ok && time.Now().After(expires) {
+		delete(m.expires, key)
+		return false
+	}
+
+	return ok
+}
+
+// add records that a lookup of name wasn't found.
+func (m *searchMisses) add(network, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if len(m.expires) >= maxSearchMisses {
+		for key, expires := range m.expires {
+			if now.After(expires) {
+				delete(m.expires, key)
+			}
+		}
+
+		// Still full, the entry is dropped rather than evicting live ones.
+		if len(m.expires) >= maxSearchMisses {
+			return
+		}
+	}
+
+	m.expires[searchMissKey{network: network, name: name}] = now.Add(m.ttl)
 }
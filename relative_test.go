@@ -13,10 +13,16 @@ import (
 	"context"
 	"net"
 	"net/netip"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/noisysockets/resolver"
 	"github.com/noisysockets/resolver/internal/testutil"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -30,9 +36,10 @@ func TestRelativeResolver(t *testing.T) {
 		IsNotFound: true,
 	})
 
-	res := resolver.Relative(inner, &resolver.RelativeResolverConfig{
+	res, err := resolver.Relative(inner, &resolver.RelativeResolverConfig{
 		Search: []string{"example.com."},
 	})
+	require.NoError(t, err)
 
 	t.Run("Relative", func(t *testing.T) {
 		addrs, err := res.LookupNetIP(context.Background(), "ip", "www")
@@ -41,6 +48,29 @@ func TestRelativeResolver(t *testing.T) {
 		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
 	})
 
+	t.Run("Queried Name", func(t *testing.T) {
+		ctx, md := resolver.WithMetadata(context.Background())
+
+		_, err := res.LookupNetIP(ctx, "ip", "www")
+		require.NoError(t, err)
+
+		require.Equal(t, "www.example.com.", md.QueriedName())
+	})
+
+	t.Run("Search Error", func(t *testing.T) {
+		_, err := res.LookupNetIP(context.Background(), "ip", "missing")
+
+		var searchErr *resolver.SearchError
+		require.ErrorAs(t, err, &searchErr)
+		require.Equal(t, "missing", searchErr.Host)
+		require.Contains(t, searchErr.Names, "missing.example.com.")
+		require.Len(t, searchErr.Errs, len(searchErr.Names))
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
 	t.Run("Absolute", func(t *testing.T) {
 		addrs, err := res.LookupNetIP(context.Background(), "ip", "www.foobar.com")
 		require.NoError(t, err)
@@ -56,3 +86,286 @@ func TestRelativeResolver(t *testing.T) {
 		require.Equal(t, resolver.ErrNoSuchHost.Error(), dnsErr.Err)
 	})
 }
+
+func TestRelativeResolverRules(t *testing.T) {
+	inner := resolvertest.NewFake()
+	inner.SetAddrs("db.prod.example.com.", netip.MustParseAddr("10.0.0.1"))
+	inner.SetAddrs("www.corp.example.", netip.MustParseAddr("10.0.0.2"))
+
+	res, err := resolver.Relative(inner, &resolver.RelativeResolverConfig{
+		Search: []string{"corp.example.", "."},
+		Rules: []resolver.RelativeDomainRule{
+			{Domain: "prod", Search: []string{"example.com."}, NDots: ptr.To(2)},
+			{Domain: "staging.prod", Search: []string{"staging.example.com."}},
+			{Domain: "dev", NDots: ptr.To(3)},
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("Rule", func(t *testing.T) {
+		inner.Reset()
+		inner.SetAddrs("db.prod.example.com.", netip.MustParseAddr("10.0.0.1"))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "DB.Prod")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+
+		// Only the search domains of the rule are tried.
+		require.Equal(t, []resolvertest.Call{{Network: "ip", Host: "db.prod.example.com."}}, inner.Calls())
+	})
+
+	t.Run("NDots Only", func(t *testing.T) {
+		inner.Reset()
+		inner.SetAddrs("db.dev.corp.example.", netip.MustParseAddr("10.0.0.3"))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "db.dev")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.3")}, addrs)
+
+		// The search list of the resolver is inherited.
+		require.Equal(t, []resolvertest.Call{{Network: "ip", Host: "db.dev.corp.example."}}, inner.Calls())
+	})
+
+	t.Run("Longest Match", func(t *testing.T) {
+		inner.Reset()
+
+		_, err := res.LookupNetIP(context.Background(), "ip", "db.staging.prod")
+		require.Error(t, err)
+
+		// The ndots of the resolver (1) applies, so the name is absolute.
+		require.Equal(t, []resolvertest.Call{{Network: "ip", Host: "db.staging.prod."}}, inner.Calls())
+	})
+
+	t.Run("No Match", func(t *testing.T) {
+		inner.Reset()
+		inner.SetAddrs("www.corp.example.", netip.MustParseAddr("10.0.0.2"))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "www")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.2")}, addrs)
+	})
+
+	t.Run("Absolute", func(t *testing.T) {
+		inner.Reset()
+
+		_, err := res.LookupNetIP(context.Background(), "ip", "db.prod.")
+		require.Error(t, err)
+
+		require.Equal(t, []resolvertest.Call{{Network: "ip", Host: "db.prod."}}, inner.Calls())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := resolver.Relative(inner, &resolver.RelativeResolverConfig{
+			Rules: []resolver.RelativeDomainRule{{Domain: "prod", NDots: ptr.To(-1)}},
+		})
+		require.Error(t, err)
+
+		_, err = resolver.Relative(inner, &resolver.RelativeResolverConfig{
+			Rules: []resolver.RelativeDomainRule{{Domain: ""}},
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestRelativeResolverSearchConcurrency(t *testing.T) {
+	fake := resolvertest.NewFake()
+	fake.Script("www.a.example.", dns.TypeA, resolvertest.Response{
+		Err:     resolvertest.NotFound("www.a.example."),
+		Latency: 50 * time.Millisecond,
+	})
+	fake.Script("www.b.example.", dns.TypeA, resolvertest.Response{
+		Addrs:   []netip.Addr{netip.MustParseAddr("10.0.0.2")},
+		Latency: 50 * time.Millisecond,
+	})
+	fake.Script("www.c.example.", dns.TypeA, resolvertest.Response{
+		Addrs: []netip.Addr{netip.MustParseAddr("10.0.0.3")},
+	})
+
+	var inFlight, maxInFlight atomic.Int32
+	inner := resolver.Func(func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		return fake.LookupNetIP(ctx, network, host)
+	})
+
+	res, err := resolver.Relative(inner, &resolver.RelativeResolverConfig{
+		Search:            []string{"a.example.", "b.example.", "c.example.", "d.example.", "e.example."},
+		SearchConcurrency: ptr.To(2),
+	})
+	require.NoError(t, err)
+
+	t.Run("First Success In Order", func(t *testing.T) {
+		start := time.Now()
+
+		ctx, md := resolver.WithMetadata(context.Background())
+
+		addrs, err := res.LookupNetIP(ctx, "ip4", "www")
+		require.NoError(t, err)
+		require.Equal(t, "www.b.example.", md.QueriedName())
+
+		// The expansion with the second search domain wins, even though the
+		// third one answers first.
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.2")}, addrs)
+
+		// The first two expansions were looked up concurrently.
+		require.Less(t, time.Since(start), 100*time.Millisecond)
+		require.LessOrEqual(t, maxInFlight.Load(), int32(2))
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		_, err := res.LookupNetIP(context.Background(), "ip4", "missing")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+
+		require.LessOrEqual(t, maxInFlight.Load(), int32(2))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := resolver.Relative(inner, &resolver.RelativeResolverConfig{
+			SearchConcurrency: ptr.To(0),
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestRelativeResolverSearchMisses(t *testing.T) {
+	inner := resolvertest.NewFake()
+	inner.SetAddrs("example.com.", netip.MustParseAddr("93.184.216.34"))
+
+	res, err := resolver.Relative(inner, &resolver.RelativeResolverConfig{
+		Search:        []string{"default.svc.cluster.local.", "svc.cluster.local.", "."},
+		NDots:         ptr.To(5),
+		SearchMissTTL: ptr.To(100 * time.Millisecond),
+	})
+	require.NoError(t, err)
+
+	addrs, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("93.184.216.34")}, addrs)
+
+	require.Equal(t, []resolvertest.Call{
+		{Network: "ip", Host: "example.com.default.svc.cluster.local."},
+		{Network: "ip", Host: "example.com.svc.cluster.local."},
+		{Network: "ip", Host: "example.com."},
+	}, inner.Calls())
+
+	t.Run("Cached", func(t *testing.T) {
+		inner.Reset()
+		inner.SetAddrs("example.com.", netip.MustParseAddr("93.184.216.34"))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("93.184.216.34")}, addrs)
+
+		// Only the absolute name is looked up again.
+		require.Equal(t, []resolvertest.Call{{Network: "ip", Host: "example.com."}}, inner.Calls())
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		time.Sleep(150 * time.Millisecond)
+
+		inner.Reset()
+		inner.SetAddrs("example.com.", netip.MustParseAddr("93.184.216.34"))
+
+		_, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+
+		require.Len(t, inner.Calls(), 3)
+	})
+
+	t.Run("Absolute Not Cached", func(t *testing.T) {
+		inner.Reset()
+
+		_, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.Error(t, err)
+
+		inner.Reset()
+		inner.SetAddrs("example.com.", netip.MustParseAddr("93.184.216.34"))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("93.184.216.34")}, addrs)
+	})
+}
+
+func TestRelativeResolverQueryHook(t *testing.T) {
+	inner := resolvertest.NewFake()
+
+	res, err := resolver.Relative(inner, &resolver.RelativeResolverConfig{
+		Search:    []string{"corp.example.", "."},
+		NDots:     ptr.To(5),
+		QueryHook: resolver.NoSearchForTLDs("com", "net"),
+	})
+	require.NoError(t, err)
+
+	t.Run("Known TLD", func(t *testing.T) {
+		inner.Reset()
+		inner.SetAddrs("example.com.", netip.MustParseAddr("93.184.216.34"))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "Example.COM")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("93.184.216.34")}, addrs)
+
+		require.Equal(t, []resolvertest.Call{{Network: "ip", Host: "example.com."}}, inner.Calls())
+	})
+
+	t.Run("Unknown TLD", func(t *testing.T) {
+		inner.Reset()
+
+		_, err := res.LookupNetIP(context.Background(), "ip", "db.prod")
+		require.Error(t, err)
+
+		require.Equal(t, []resolvertest.Call{
+			{Network: "ip", Host: "db.prod.corp.example."},
+			{Network: "ip", Host: "db.prod."},
+		}, inner.Calls())
+	})
+
+	t.Run("Rewrite", func(t *testing.T) {
+		inner.Reset()
+		inner.SetAddrs("www.corp.example.", netip.MustParseAddr("10.0.0.1"))
+
+		res, err := resolver.Relative(inner, &resolver.RelativeResolverConfig{
+			Search: []string{"corp.example."},
+			QueryHook: func(host, name string) (string, bool) {
+				return strings.TrimPrefix(name, "legacy-"), true
+			},
+		})
+		require.NoError(t, err)
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "legacy-www")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+	})
+
+	t.Run("Vetoed", func(t *testing.T) {
+		inner.Reset()
+
+		res, err := resolver.Relative(inner, &resolver.RelativeResolverConfig{
+			QueryHook: func(host, name string) (string, bool) {
+				return name, false
+			},
+		})
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(context.Background(), "ip", "www")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+
+		require.Empty(t, inner.Calls())
+	})
+}
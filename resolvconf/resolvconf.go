@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package resolvconf reads and writes the system's DNS configuration in
+// resolv.conf format, eg. to program the DNS servers of a tunnel.
+package resolvconf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/noisysockets/resolver/internal/dnsconfig"
+)
+
+// Location is the location of the system's resolv.conf file.
+const Location = "/etc/resolv.conf"
+
+// Config is a DNS configuration. Name servers are in host:port form (with port
+// 53), and the zero value of NDots is ndots:0 (rather than the default of 1).
+type Config = dnsconfig.Config
+
+// Diagnostic is a problem found while parsing a DNS configuration (see
+// Config.Diagnostics), eg. an unknown option that was ignored.
+type Diagnostic = dnsconfig.Diagnostic
+
+// command is the resolvconf(8) command, looked up in the PATH.
+const command = "resolvconf"
+
+// Read reads the DNS configuration from the resolv.conf file at path. If the
+// file does not exist, the defaults are returned along with the error. On
+// Windows, path is ignored and the system's DNS configuration is read from
+// the registry.
+func Read(path string) (*Config, error) {
+	return dnsconfig.Read(path)
+}
+
+// Marshal renders the DNS configuration in resolv.conf format.
+func Marshal(conf *Config) ([]byte, error) {
+	var b bytes.Buffer
+	if err := dnsconfig.Encode(&b, conf); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// WriteFile atomically replaces the resolv.conf file at path with the DNS
+// configuration, readers see either the old or the new file. If path is a
+// symbolic link (eg. to a file managed by systemd-resolved), the link itself
+// is replaced.
+func WriteFile(path string, conf *Config) error {
+	b, err := Marshal(conf)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	if err := f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to set permissions of temporary file: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// Register adds the DNS configuration of an interface (eg. "wg0") using
+// resolvconf(8), which merges the configurations of all interfaces into the
+// system's resolv.conf file. Both openresolv and Debian's resolvconf (and the
+// resolvconf compatibility mode of systemd-resolved) are supported.
+func Register(ctx context.Context, iface string, conf *Config) error {
+	b, err := Marshal(conf)
+	if err != nil {
+		return err
+	}
+
+	return run(ctx, b, "-a", iface)
+}
+
+// Unregister removes the DNS configuration of an interface previously added
+// using Register.
+func Unregister(ctx context.Context, iface string) error {
+	return run(ctx, nil, "-d", iface)
+}
+
+func run(ctx context.Context, stdin []byte, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s %s failed: %w: %s", command, strings.Join(args, " "), err, msg)
+		}
+		return fmt.Errorf("%s %s failed: %w", command, strings.Join(args, " "), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolvconf_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver/resolvconf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(path, []byte("nameserver 192.0.2.1\n"), 0o644))
+
+	conf := &resolvconf.Config{
+		Servers:  []string{"100.100.100.100:53", "[fd7a:115c:a1e0::53]:53"},
+		Search:   []string{"tailnet.internal."},
+		NDots:    1,
+		Timeout:  2 * time.Second,
+		Attempts: 2,
+		Rotate:   true,
+	}
+
+	require.NoError(t, resolvconf.WriteFile(path, conf))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	require.Equal(t, `nameserver 100.100.100.100
+nameserver fd7a:115c:a1e0::53
+search tailnet.internal
+options timeout:2 rotate
+`, string(b))
+
+	got, err := resolvconf.Read(path)
+	if runtime.GOOS != "windows" {
+		require.NoError(t, err)
+		require.Equal(t, conf.Servers, got.Servers)
+		require.Equal(t, conf.Search, got.Search)
+	}
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	err = resolvconf.WriteFile(path, &resolvconf.Config{Servers: []string{"127.0.0.1:5353"}})
+	require.Error(t, err)
+}
+
+func TestRegister(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("resolvconf(8) is not available on Windows")
+	}
+
+	// A fake resolvconf(8) that records its arguments and input.
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" > \"$(dirname \"$0\")/args\"\ncat > \"$(dirname \"$0\")/stdin\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "resolvconf"), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ctx := context.Background()
+
+	err := resolvconf.Register(ctx, "wg0", &resolvconf.Config{
+		Servers: []string{"10.0.0.1:53"},
+		NDots:   1,
+	})
+	require.NoError(t, err)
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	require.Equal(t, "-a wg0\n", string(args))
+
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	require.NoError(t, err)
+	require.Equal(t, "nameserver 10.0.0.1\n", string(stdin))
+
+	require.NoError(t, resolvconf.Unregister(ctx, "wg0"))
+
+	args, err = os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	require.Equal(t, "-d wg0\n", string(args))
+}
@@ -43,16 +43,95 @@ import (
 	"context"
 	"net"
 	"net/netip"
+	"strings"
 )
 
 // DialContextFunc is a network dialer that can be used to dial a network.
 type DialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
 
+// TransportDialers are optional dialers for specific transports, each
+// overriding the general purpose DialContext of a resolver. This is useful
+// for userspace network stacks (eg. gVisor's netstack) which often have
+// different code paths and capabilities per protocol.
+type TransportDialers struct {
+	// UDP is used to establish connections for DNS over UDP.
+	UDP DialContextFunc
+	// TCP is used to establish connections for DNS over TCP.
+	TCP DialContextFunc
+	// TLS is used to establish the underlying TCP connections for DNS over
+	// TLS and DNS over HTTPS, the TLS handshake is performed by the resolver.
+	TLS DialContextFunc
+	// Probe is used to probe source addresses when sorting addresses
+	// according to RFC 6724 (by connecting, but not sending anything on, UDP
+	// sockets).
+	Probe DialContextFunc
+}
+
+// forTransport returns the dialer for the transport, or fallback if none is
+// set.
+func (d *TransportDialers) forTransport(transport DNSTransport, fallback DialContextFunc) DialContextFunc {
+	if d == nil {
+		return fallback
+	}
+
+	var dialContext DialContextFunc
+	switch transport {
+	case DNSTransportUDP:
+		dialContext = d.UDP
+	case DNSTransportTCP:
+		dialContext = d.TCP
+	case DNSTransportTLS, DNSTransportHTTPS:
+		dialContext = d.TLS
+	}
+
+	if dialContext == nil {
+		return fallback
+	}
+
+	return dialContext
+}
+
+// probe returns the dialer used to probe source addresses, or fallback if
+// none is set.
+func (d *TransportDialers) probe(fallback DialContextFunc) DialContextFunc {
+	if d == nil || d.Probe == nil {
+		return fallback
+	}
+
+	return d.Probe
+}
+
+// empty returns true if no dialers are set.
+func (d *TransportDialers) empty() bool {
+	return d == nil || (d.UDP == nil && d.TCP == nil && d.TLS == nil && d.Probe == nil)
+}
+
 // Resolver looks up names and numbers, this interface is also implemented by
 // net.Resolver from the Go standard library.
 type Resolver interface {
 	// LookupNetIP looks up host using the resolver. It returns a slice of that
 	// host's IP addresses of the type specified by network. The network must be
-	// one of "ip", "ip4" or "ip6".
+	// one of "ip", "ip4" or "ip6". Like the net package, transport networks
+	// (eg. "tcp4" or "udp6") are also accepted and mapped to the address
+	// family they imply.
 	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
 }
+
+// ipNetwork maps network to the address family network ("ip", "ip4" or "ip6")
+// it implies, eg. "tcp4" to "ip4" (as the net package does). The boolean
+// result is false if the network is not supported.
+func ipNetwork(network string) (string, bool) {
+	// Strip the protocol of raw IP networks (eg. "ip4:icmp").
+	network, _, _ = strings.Cut(network, ":")
+
+	switch network {
+	case "ip", "tcp", "udp":
+		return "ip", true
+	case "ip4", "tcp4", "udp4":
+		return "ip4", true
+	case "ip6", "tcp6", "udp6":
+		return "ip6", true
+	default:
+		return network, false
+	}
+}
@@ -0,0 +1,398 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolverconfig
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+)
+
+// BuildOptions are optional settings used when building a resolver that
+// can't be expressed in a configuration document.
+type BuildOptions struct {
+	// DialContext is used to establish connections to the upstream servers.
+	DialContext resolver.DialContextFunc
+	// QueryLimiter is an optional limiter of concurrent queries to the
+	// upstream servers, eg. to share a limit with other resolvers. It takes
+	// precedence over the configured MaxInFlightQueries.
+	QueryLimiter *resolver.QueryLimiter
+	// WorkerPool is an optional pool of workers used to send the queries of
+	// lookups concurrently, eg. to share a goroutine ceiling with other
+	// resolvers. It takes precedence over the configured MaxLookupWorkers.
+	WorkerPool *resolver.WorkerPool
+	// RoundRobinStrategy optionally chooses the order in which the upstream
+	// servers are tried by the round-robin strategy, eg. a seeded
+	// resolver.RandomOrder to reproduce the selection in tests.
+	RoundRobinStrategy resolver.RoundRobinStrategy
+	// Events is an optional event distributor, that is notified when an
+	// upstream server becomes unhealthy (or healthy again) and when the cache
+	// is flushed.
+	Events *resolver.Events
+}
+
+// Build constructs the resolver chain described by the configuration. The
+// resulting chain resolves IP literals, then hosts file entries and finally
+// queries the upstream servers (or the servers of the matching route).
+func Build(conf *Config, opts *BuildOptions) (resolver.Resolver, error) {
+	if opts == nil {
+		opts = &BuildOptions{}
+	}
+
+	var addressOrder *resolver.AddressOrder
+	if conf.AddressOrder != "" {
+		order := resolver.AddressOrder(conf.AddressOrder)
+		switch order {
+		case resolver.AddressOrderRFC6724, resolver.AddressOrderPreferIPv4,
+			resolver.AddressOrderPreferIPv6, resolver.AddressOrderNone:
+		default:
+			return nil, fmt.Errorf("invalid address order %q", conf.AddressOrder)
+		}
+		addressOrder = &order
+	}
+
+	if len(conf.Upstreams) == 0 {
+		return nil, fmt.Errorf("at least one upstream is required")
+	}
+
+	var failover resolver.FailoverPolicy
+	switch conf.Failover {
+	case "", FailoverAnyError:
+		failover = resolver.FailoverOnAnyError
+	case FailoverServerError:
+		failover = resolver.FailoverOnServerError
+	default:
+		return nil, fmt.Errorf("invalid failover %q", conf.Failover)
+	}
+
+	if conf.MaxInFlightQueries != nil && opts.QueryLimiter == nil {
+		if *conf.MaxInFlightQueries < 1 {
+			return nil, fmt.Errorf("max in-flight queries must be positive")
+		}
+
+		// Don't modify the caller's options.
+		optsWithLimiter := *opts
+		optsWithLimiter.QueryLimiter = resolver.NewQueryLimiter(*conf.MaxInFlightQueries)
+		opts = &optsWithLimiter
+	}
+
+	if conf.MaxLookupWorkers != nil && opts.WorkerPool == nil {
+		if *conf.MaxLookupWorkers < 1 {
+			return nil, fmt.Errorf("max lookup workers must be positive")
+		}
+
+		// Don't modify the caller's options.
+		optsWithWorkers := *opts
+		optsWithWorkers.WorkerPool = resolver.NewWorkerPool(*conf.MaxLookupWorkers)
+		opts = &optsWithWorkers
+	}
+
+	upstream, err := buildUpstreams(conf.Upstreams, conf.Strategy, failover, addressOrder, conf.TCPFallback, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conf.Routes) > 0 {
+		for i, route := range conf.Routes {
+			if route.Domain == "" {
+				return nil, fmt.Errorf("route %d: domain is required", i)
+			}
+		}
+
+		routes := slices.Clone(conf.Routes)
+		// Try the most specific domain first, so that the longest matching
+		// route wins.
+		slices.SortStableFunc(routes, func(a, b Route) int {
+			return dns.CountLabel(b.Domain) - dns.CountLabel(a.Domain)
+		})
+
+		forwards := make([]resolver.Resolver, 0, len(routes)+1)
+		for _, route := range routes {
+			if len(route.Upstreams) == 0 {
+				return nil, fmt.Errorf("route %q: at least one upstream is required", route.Domain)
+			}
+
+			routeUpstream, err := buildUpstreams(route.Upstreams, route.Strategy, failover, addressOrder, conf.TCPFallback, opts)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: %w", route.Domain, err)
+			}
+
+			forwards = append(forwards, resolver.Forward(route.Domain, routeUpstream))
+		}
+
+		// Lookups that aren't forwarded (or fail) move on to the next route,
+		// so this must fail over on any error.
+		upstream = resolver.Sequential(append(forwards, upstream)...)
+	}
+
+	upstream, err = resolver.Retry(upstream, &resolver.RetryResolverConfig{
+		Attempts: conf.Attempts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Cache != nil {
+		upstream, err = resolver.Cache(upstream, &resolver.CacheResolverConfig{
+			Size:        conf.Cache.Size,
+			TTL:         (*time.Duration)(conf.Cache.TTL),
+			NegativeTTL: (*time.Duration)(conf.Cache.NegativeTTL),
+			Events:      opts.Events,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cache: %w", err)
+		}
+	}
+
+	if conf.Blocklist != nil {
+		blocked, err := readBlocklist(conf.Blocklist)
+		if err != nil {
+			return nil, err
+		}
+
+		upstream, err = resolver.Filter(upstream, &resolver.FilterResolverConfig{
+			Block: blocked,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("blocklist: %w", err)
+		}
+	}
+
+	if len(conf.Search) > 0 || conf.NDots != nil || len(conf.SearchRules) > 0 {
+		rules := make([]resolver.RelativeDomainRule, 0, len(conf.SearchRules))
+		for _, rule := range conf.SearchRules {
+			rules = append(rules, resolver.RelativeDomainRule{
+				Domain: rule.Domain,
+				Search: rule.Search,
+				NDots:  rule.NDots,
+			})
+		}
+
+		upstream, err = resolver.Relative(upstream, &resolver.RelativeResolverConfig{
+			Search:            conf.Search,
+			NDots:             conf.NDots,
+			Rules:             rules,
+			SearchConcurrency: conf.SearchConcurrency,
+			SearchMissTTL:     (*time.Duration)(conf.SearchMissTTL),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resolvers := []resolver.Resolver{resolver.Literal()}
+
+	if conf.Hosts == nil || !conf.Hosts.Disabled {
+		hostsResolver, err := buildHosts(conf.Hosts, addressOrder)
+		if err != nil {
+			return nil, err
+		}
+
+		resolvers = append(resolvers, hostsResolver)
+	}
+
+	return resolver.Sequential(append(resolvers, upstream)...), nil
+}
+
+func buildUpstreams(upstreams []Upstream, strategy Strategy, failover resolver.FailoverPolicy, addressOrder *resolver.AddressOrder, tcpFallback bool, opts *BuildOptions) (resolver.Resolver, error) {
+	resolvers := make([]resolver.Resolver, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		res, err := buildUpstream(upstream, addressOrder, tcpFallback, opts)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q: %w", upstream.Address, err)
+		}
+
+		res, err = resolver.PenaltyBox(res, &resolver.PenaltyBoxResolverConfig{
+			Events: opts.Events,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resolvers = append(resolvers, res)
+	}
+
+	switch strategy {
+	case "", StrategySequential:
+		return resolver.SequentialWithConfig(&resolver.SequentialResolverConfig{
+			Failover: failover,
+		}, resolvers...), nil
+	case StrategyRoundRobin:
+		return resolver.RoundRobinWithConfig(&resolver.RoundRobinResolverConfig{
+			Strategy: opts.RoundRobinStrategy,
+			Failover: failover,
+		}, resolvers...), nil
+	case StrategyParallel:
+		return resolver.Parallel(resolvers...), nil
+	default:
+		return nil, fmt.Errorf("invalid strategy %q", strategy)
+	}
+}
+
+func buildUpstream(upstream Upstream, addressOrder *resolver.AddressOrder, tcpFallback bool, opts *BuildOptions) (resolver.Resolver, error) {
+	var transport resolver.DNSTransport
+	switch upstream.Transport {
+	case "", "udp":
+		transport = resolver.DNSTransportUDP
+	case "tcp":
+		transport = resolver.DNSTransportTCP
+	case "tls", string(resolver.DNSTransportTLS):
+		transport = resolver.DNSTransportTLS
+	default:
+		return nil, fmt.Errorf("invalid transport %q", upstream.Transport)
+	}
+
+	server, err := parseServer(upstream.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if transport == resolver.DNSTransportTLS {
+		if upstream.ServerName == "" && len(upstream.SPKIPins) == 0 {
+			return nil, fmt.Errorf("server name or spki pins are required for DNS over TLS")
+		}
+
+		if upstream.ServerName != "" {
+			tlsConfig = &tls.Config{
+				ServerName: upstream.ServerName,
+			}
+		}
+	}
+
+	var localAddr netip.Addr
+	if upstream.LocalAddress != "" {
+		localAddr, err = netip.ParseAddr(upstream.LocalAddress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local address: %w", err)
+		}
+	}
+
+	res, err := resolver.DNS(resolver.DNSResolverConfig{
+		Server:             server,
+		Transport:          &transport,
+		Timeout:            (*time.Duration)(upstream.Timeout),
+		DialContext:        opts.DialContext,
+		Interface:          upstream.Interface,
+		LocalAddr:          localAddr,
+		AddressOrder:       addressOrder,
+		TLSConfig:          tlsConfig,
+		SPKIPins:           upstream.SPKIPins,
+		MaxInFlightQueries: upstream.MaxInFlightQueries,
+		QueryLimiter:       opts.QueryLimiter,
+		WorkerPool:         opts.WorkerPool,
+		TCPFallback:        &tcpFallback,
+		TrustAD:            &upstream.TrustAD,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// parseServer parses a server address with an optional port, a zero port
+// selects the default port for the transport.
+func parseServer(address string) (netip.AddrPort, error) {
+	if addr, err := netip.ParseAddr(address); err == nil {
+		return netip.AddrPortFrom(addr, 0), nil
+	}
+
+	server, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid server address: %w", err)
+	}
+
+	return server, nil
+}
+
+func buildHosts(conf *Hosts, addressOrder *resolver.AddressOrder) (*resolver.HostsResolver, error) {
+	if conf == nil {
+		conf = &Hosts{}
+	}
+
+	hostsConf := &resolver.HostsResolverConfig{
+		AddressOrder: addressOrder,
+		NoHostsFile:  &conf.NoHostsFile,
+	}
+
+	if conf.File != "" && !conf.NoHostsFile {
+		f, err := os.Open(conf.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open hosts file %q: %w", conf.File, err)
+		}
+		defer f.Close()
+
+		hostsConf.HostsFileReader = f
+	}
+
+	hostsResolver, err := resolver.Hosts(hostsConf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hosts file resolver: %w", err)
+	}
+
+	for name, addrStrs := range conf.Entries {
+		addrs := make([]netip.Addr, 0, len(addrStrs))
+		for _, addrStr := range addrStrs {
+			addr, err := netip.ParseAddr(addrStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address for host %q: %w", name, err)
+			}
+			addrs = append(addrs, addr)
+		}
+
+		hostsResolver.AddHost(name, addrs...)
+	}
+
+	return hostsResolver, nil
+}
+
+func readBlocklist(conf *Blocklist) ([]string, error) {
+	blocked := append([]string(nil), conf.Domains...)
+
+	for _, path := range conf.Files {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open blocklist %q: %w", path, err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			// Hosts file style entries (eg. "0.0.0.0 example.com").
+			if _, err := netip.ParseAddr(fields[0]); err == nil {
+				fields = fields[1:]
+			}
+
+			blocked = append(blocked, fields...)
+		}
+		err = scanner.Err()
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blocklist %q: %w", path, err)
+		}
+	}
+
+	return blocked, nil
+}
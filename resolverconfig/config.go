@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package resolverconfig builds resolver chains from declarative (YAML or
+// JSON) configuration documents.
+package resolverconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Strategy is the strategy used to query multiple upstream servers.
+type Strategy string
+
+const (
+	// StrategySequential queries the upstream servers in order.
+	StrategySequential Strategy = "sequential"
+	// StrategyRoundRobin queries the upstream servers in a random order.
+	StrategyRoundRobin Strategy = "round-robin"
+	// StrategyParallel queries all of the upstream servers concurrently, the
+	// first successful response is used.
+	StrategyParallel Strategy = "parallel"
+)
+
+// Failover decides when the next upstream server is tried.
+type Failover string
+
+const (
+	// FailoverAnyError tries the next upstream server after any error.
+	FailoverAnyError Failover = "any-error"
+	// FailoverServerError tries the next upstream server only when a server
+	// can't answer (eg. a timeout, or a SERVFAIL, REFUSED, NOTIMP or FORMERR
+	// response), a NXDOMAIN response ends the lookup.
+	FailoverServerError Failover = "server-error"
+)
+
+// Config is a declarative resolver configuration.
+type Config struct {
+	// Upstreams are the DNS servers used to resolve names that don't match any
+	// of the routes.
+	Upstreams []Upstream `yaml:"upstreams" json:"upstreams"`
+	// Strategy is the strategy used to query the upstream servers.
+	// By default, servers are queried sequentially.
+	Strategy Strategy `yaml:"strategy,omitempty" json:"strategy,omitempty"`
+	// Attempts is the number of attempts made before giving up.
+	// By default, 2 attempts are made.
+	Attempts *int `yaml:"attempts,omitempty" json:"attempts,omitempty"`
+	// Failover decides when the next upstream server is tried by the
+	// sequential and round-robin strategies (applies to the upstreams of
+	// routes too), one of "any-error" (the default) or "server-error".
+	Failover Failover `yaml:"failover,omitempty" json:"failover,omitempty"`
+	// TCPFallback retries UDP queries that fail without a response from an
+	// upstream server (eg. a timeout) over TCP, before moving on to the next
+	// upstream server (applies to the upstreams of routes too).
+	TCPFallback bool `yaml:"tcpFallback,omitempty" json:"tcpFallback,omitempty"`
+	// MaxInFlightQueries is the maximum number of concurrent queries sent to
+	// all of the upstream servers (including those of routes), excess queries
+	// are queued. By default, there is no limit.
+	MaxInFlightQueries *int `yaml:"maxInFlightQueries,omitempty" json:"maxInFlightQueries,omitempty"`
+	// MaxLookupWorkers is the maximum number of goroutines spawned to send
+	// the A and AAAA queries of lookups concurrently, across all of the
+	// upstream servers. Once reached, queries are sent sequentially instead.
+	// By default, there is no limit.
+	MaxLookupWorkers *int `yaml:"maxLookupWorkers,omitempty" json:"maxLookupWorkers,omitempty"`
+	// Search is a list of domains to append to relative names.
+	Search []string `yaml:"search,omitempty" json:"search,omitempty"`
+	// NDots is the number of dots in a name to trigger an absolute lookup
+	// before trying the search domains. By default, 1.
+	NDots *int `yaml:"ndots,omitempty" json:"ndots,omitempty"`
+	// SearchRules override the search domains and ndots for relative names
+	// within specific domains.
+	SearchRules []SearchRule `yaml:"searchRules,omitempty" json:"searchRules,omitempty"`
+	// SearchConcurrency is the maximum number of search domain expansions of a
+	// name looked up concurrently. By default, 1.
+	SearchConcurrency *int `yaml:"searchConcurrency,omitempty" json:"searchConcurrency,omitempty"`
+	// SearchMissTTL is how long not found results of search domain expansions
+	// are cached. By default, search misses are not cached.
+	SearchMissTTL *Duration `yaml:"searchMissTTL,omitempty" json:"searchMissTTL,omitempty"`
+	// Routes send lookups of names under a domain to dedicated upstream servers
+	// (eg. split horizon DNS).
+	Routes []Route `yaml:"routes,omitempty" json:"routes,omitempty"`
+	// Hosts configures the hosts file resolver.
+	Hosts *Hosts `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+	// Cache configures caching of lookup results. By default, results are not
+	// cached.
+	Cache *Cache `yaml:"cache,omitempty" json:"cache,omitempty"`
+	// Blocklist is a list of domains (including subdomains) that will not be
+	// resolved.
+	Blocklist *Blocklist `yaml:"blocklist,omitempty" json:"blocklist,omitempty"`
+	// AddressOrder is the policy used to order the returned addresses, one of
+	// "rfc6724" (the default), "prefer-ipv4", "prefer-ipv6" or "none".
+	AddressOrder string `yaml:"addressOrder,omitempty" json:"addressOrder,omitempty"`
+}
+
+// Upstream is an upstream DNS server.
+type Upstream struct {
+	// Address is the address of the server, with an optional port
+	// (eg. "8.8.8.8" or "[2001:4860:4860::8888]:853").
+	Address string `yaml:"address" json:"address"`
+	// Transport is the transport protocol, one of "udp" (the default), "tcp",
+	// or "tls".
+	Transport string `yaml:"transport,omitempty" json:"transport,omitempty"`
+	// ServerName is the name used to verify the server's certificate when
+	// using DNS over TLS.
+	ServerName string `yaml:"serverName,omitempty" json:"serverName,omitempty"`
+	// SPKIPins are base64 encoded SHA-256 hashes of the server's trusted
+	// public keys when using DNS over TLS. If no server name is set, the pins
+	// replace certificate chain and hostname verification.
+	SPKIPins []string `yaml:"spkiPins,omitempty" json:"spkiPins,omitempty"`
+	// Timeout is the maximum duration to wait for a query to complete.
+	Timeout *Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// Interface is the name of a network interface that queries to the server
+	// are bound to (eg. to send them through a specific VPN tunnel).
+	Interface string `yaml:"interface,omitempty" json:"interface,omitempty"`
+	// LocalAddress is the local (source) address queries to the server are
+	// sent from.
+	LocalAddress string `yaml:"localAddress,omitempty" json:"localAddress,omitempty"`
+	// TrustAD trusts the server to validate responses using DNSSEC, the AD
+	// bit of its responses is exposed using resolver.WithMetadata.
+	TrustAD bool `yaml:"trustAD,omitempty" json:"trustAD,omitempty"`
+	// MaxInFlightQueries is the maximum number of concurrent queries sent to
+	// the server, excess queries are queued. By default, there is no limit.
+	MaxInFlightQueries *int `yaml:"maxInFlightQueries,omitempty" json:"maxInFlightQueries,omitempty"`
+}
+
+// Route sends lookups of names under a domain to dedicated upstream servers
+// (see resolver.Forward). The most specific matching route is tried first,
+// names its servers fail to resolve are passed on to less specific routes,
+// and finally to the default upstream servers.
+type Route struct {
+	// Domain is the domain to route, subdomains are also routed.
+	Domain string `yaml:"domain" json:"domain"`
+	// Upstreams are the DNS servers used to resolve the routed names.
+	Upstreams []Upstream `yaml:"upstreams" json:"upstreams"`
+	// Strategy is the strategy used to query the upstream servers.
+	// By default, servers are queried sequentially.
+	Strategy Strategy `yaml:"strategy,omitempty" json:"strategy,omitempty"`
+}
+
+// SearchRule overrides the search domains and ndots for relative names within
+// a domain (eg. "db.prod" for the domain "prod").
+type SearchRule struct {
+	// Domain is the domain the rule applies to.
+	Domain string `yaml:"domain" json:"domain"`
+	// Search is a list of domains to append to matching names.
+	// By default, the top level search domains are used.
+	Search []string `yaml:"search,omitempty" json:"search,omitempty"`
+	// NDots is the number of dots in a matching name to trigger an absolute
+	// lookup. By default, the top level ndots is used.
+	NDots *int `yaml:"ndots,omitempty" json:"ndots,omitempty"`
+}
+
+// Hosts configures the hosts file resolver.
+type Hosts struct {
+	// Disabled disables the hosts file resolver.
+	Disabled bool `yaml:"disabled,omitempty" json:"disabled,omitempty"`
+	// File is the path to the hosts file. By default, the system's hosts file
+	// is used.
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	// NoHostsFile disables reading a hosts file, only the static entries are
+	// used.
+	NoHostsFile bool `yaml:"noHostsFile,omitempty" json:"noHostsFile,omitempty"`
+	// Entries are static host entries, mapping names to addresses. Names
+	// prefixed with "*." are wildcards matching any subdomain.
+	Entries map[string][]string `yaml:"entries,omitempty" json:"entries,omitempty"`
+}
+
+// Cache configures caching of lookup results.
+type Cache struct {
+	// Size is the maximum number of cached entries.
+	Size *int `yaml:"size,omitempty" json:"size,omitempty"`
+	// TTL is how long successful lookups are cached.
+	TTL *Duration `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+	// NegativeTTL is how long not found results are cached.
+	NegativeTTL *Duration `yaml:"negativeTTL,omitempty" json:"negativeTTL,omitempty"`
+}
+
+// Blocklist is a list of blocked domains.
+type Blocklist struct {
+	// Domains are the blocked domains.
+	Domains []string `yaml:"domains,omitempty" json:"domains,omitempty"`
+	// Files are paths to files containing blocked domains, one per line.
+	// Lines starting with '#' are ignored, as are hosts file style address
+	// prefixes (eg. "0.0.0.0 example.com").
+	Files []string `yaml:"files,omitempty" json:"files,omitempty"`
+}
+
+// Duration is a time.Duration that is encoded as a string (eg. "5s").
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// Decode reads a YAML or JSON (which is a subset of YAML) configuration
+// document. Unknown fields are rejected.
+func Decode(r io.Reader) (*Config, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	var conf Config
+	if err := dec.Decode(&conf); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &conf, nil
+		}
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	return &conf, nil
+}
+
+// Load reads a YAML or JSON configuration document from a file.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	return Decode(f)
+}
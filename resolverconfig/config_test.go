@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolverconfig_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver/resolverconfig"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	expected := &resolverconfig.Config{
+		Upstreams: []resolverconfig.Upstream{
+			{Address: "8.8.8.8"},
+			{
+				Address:    "[2001:4860:4860::8888]:853",
+				Transport:  "tls",
+				ServerName: "dns.google",
+				Timeout:    ptr.To(resolverconfig.Duration(2 * time.Second)),
+			},
+		},
+		Strategy: resolverconfig.StrategyRoundRobin,
+		Attempts: ptr.To(3),
+		Search:   []string{"example.com"},
+		NDots:    ptr.To(2),
+		SearchRules: []resolverconfig.SearchRule{
+			{Domain: "prod", Search: []string{"example.net"}, NDots: ptr.To(3)},
+		},
+		Routes: []resolverconfig.Route{
+			{
+				Domain:    "consul",
+				Upstreams: []resolverconfig.Upstream{{Address: "127.0.0.1:8600"}},
+			},
+		},
+		Hosts: &resolverconfig.Hosts{
+			NoHostsFile: true,
+			Entries: map[string][]string{
+				"router.lan": {"192.168.1.1"},
+			},
+		},
+		Cache: &resolverconfig.Cache{
+			Size: ptr.To(512),
+			TTL:  ptr.To(resolverconfig.Duration(30 * time.Second)),
+		},
+		Blocklist: &resolverconfig.Blocklist{
+			Domains: []string{"ads.example.net"},
+		},
+	}
+
+	for _, path := range []string{"testdata/config.yaml", "testdata/config.json"} {
+		t.Run(path, func(t *testing.T) {
+			conf, err := resolverconfig.Load(path)
+			require.NoError(t, err)
+
+			require.Equal(t, expected, conf)
+
+			_, err = resolverconfig.Build(conf, nil)
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("Unknown Field", func(t *testing.T) {
+		_, err := resolverconfig.Decode(strings.NewReader("upstream:\n  - address: 8.8.8.8\n"))
+		require.Error(t, err)
+	})
+}
+
+func TestBuild(t *testing.T) {
+	defaultSrv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"www.example.com":    {netip.MustParseAddr("192.0.2.1")},
+			"ads.example.net":    {netip.MustParseAddr("192.0.2.2")},
+			"web.service.consul": {netip.MustParseAddr("192.0.2.3")},
+		},
+	})
+
+	consulSrv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"web.service.consul": {netip.MustParseAddr("10.0.0.1")},
+			"db.service.consul":  {netip.MustParseAddr("10.0.0.2")},
+		},
+	})
+
+	dbSrv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"db.service.consul": {netip.MustParseAddr("10.0.1.1")},
+		},
+	})
+
+	res, err := resolverconfig.Build(&resolverconfig.Config{
+		Upstreams: []resolverconfig.Upstream{
+			{Address: defaultSrv.Addr().String(), Transport: "tcp"},
+		},
+		Search: []string{"example.com"},
+		Routes: []resolverconfig.Route{
+			{
+				Domain:    "consul",
+				Upstreams: []resolverconfig.Upstream{{Address: consulSrv.Addr().String()}},
+			},
+			{
+				Domain:    "db.service.consul",
+				Upstreams: []resolverconfig.Upstream{{Address: dbSrv.Addr().String()}},
+			},
+		},
+		Hosts: &resolverconfig.Hosts{
+			NoHostsFile: true,
+			Entries: map[string][]string{
+				"router.lan": {"192.168.1.1"},
+			},
+		},
+		Cache: &resolverconfig.Cache{},
+		Blocklist: &resolverconfig.Blocklist{
+			Domains: []string{"example.net"},
+		},
+		AddressOrder: "none",
+	}, nil)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	t.Run("Upstream", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip", "www")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, addrs)
+	})
+
+	t.Run("Route", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip", "web.service.consul")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+	})
+
+	t.Run("Longest Route", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip", "db.service.consul")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.1.1")}, addrs)
+	})
+
+	t.Run("Hosts", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip", "router.lan")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("192.168.1.1")}, addrs)
+	})
+
+	t.Run("Literal", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip", "192.0.2.100")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.100")}, addrs)
+	})
+
+	t.Run("Blocked", func(t *testing.T) {
+		_, err := res.LookupNetIP(ctx, "ip", "ads.example.net")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := resolverconfig.Build(&resolverconfig.Config{
+			Upstreams: []resolverconfig.Upstream{{Address: "8.8.8.8", Transport: "carrier-pigeon"}},
+		}, nil)
+		require.ErrorContains(t, err, "invalid transport")
+
+		_, err = resolverconfig.Build(&resolverconfig.Config{
+			Upstreams: []resolverconfig.Upstream{{Address: "8.8.8.8"}},
+			Failover:  "sometimes",
+		}, nil)
+		require.ErrorContains(t, err, "invalid failover")
+	})
+}
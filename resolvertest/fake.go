@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package resolvertest provides deterministic resolvers for testing code that
+// depends on the resolver package.
+package resolvertest
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+)
+
+var _ resolver.Resolver = (*Fake)(nil)
+
+// Response is a scripted response to a query.
+type Response struct {
+	// Addrs are the addresses returned in the answer.
+	Addrs []netip.Addr
+	// Err is an optional error to return instead of the addresses.
+	Err error
+	// Latency is an optional delay before the response is returned.
+	Latency time.Duration
+}
+
+// Call is a recorded call to a Fake resolver.
+type Call struct {
+	// Network is the network that was requested.
+	Network string
+	// Host is the host that was looked up.
+	Host string
+}
+
+type queryKey struct {
+	name  string
+	qType uint16
+}
+
+// Fake is a scriptable resolver. Responses are scripted per name and query
+// type (dns.TypeA or dns.TypeAAAA) and are returned in order, with the last
+// response being repeated once the script is exhausted. Unscripted names
+// return a not found error.
+type Fake struct {
+	mu      sync.Mutex
+	scripts map[queryKey][]Response
+	calls   []Call
+}
+
+// NewFake returns a new Fake resolver with no scripted responses.
+func NewFake() *Fake {
+	return &Fake{
+		scripts: make(map[queryKey][]Response),
+	}
+}
+
+// SetAddrs sets fixed answers for host, the addresses are split into A and
+// AAAA answers by address family.
+func (f *Fake) SetAddrs(host string, addrs ...netip.Addr) {
+	var ipv4Addrs, ipv6Addrs []netip.Addr
+	for _, addr := range addrs {
+		if addr.Unmap().Is4() {
+			ipv4Addrs = append(ipv4Addrs, addr.Unmap())
+		} else {
+			ipv6Addrs = append(ipv6Addrs, addr)
+		}
+	}
+
+	f.Script(host, dns.TypeA, Response{Addrs: ipv4Addrs})
+	f.Script(host, dns.TypeAAAA, Response{Addrs: ipv6Addrs})
+}
+
+// Script sets the sequence of responses returned for queries of qType
+// (dns.TypeA or dns.TypeAAAA) for host, replacing any existing script.
+func (f *Fake) Script(host string, qType uint16, responses ...Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := queryKey{name: dns.CanonicalName(host), qType: qType}
+	if len(responses) == 0 {
+		delete(f.scripts, key)
+		return
+	}
+
+	f.scripts[key] = append([]Response(nil), responses...)
+}
+
+// Calls returns the calls made to the resolver, in order.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Call(nil), f.calls...)
+}
+
+// Reset clears all scripted responses and recorded calls.
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scripts = make(map[queryKey][]Response)
+	f.calls = nil
+}
+
+func (f *Fake) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	var qTypes []uint16
+	switch network {
+	case "ip":
+		qTypes = []uint16{dns.TypeA, dns.TypeAAAA}
+	case "ip4":
+		qTypes = []uint16{dns.TypeA}
+	case "ip6":
+		qTypes = []uint16{dns.TypeAAAA}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, Call{Network: network, Host: host})
+
+	var responses []Response
+	var scripted bool
+	for _, qType := range qTypes {
+		key := queryKey{name: dns.CanonicalName(host), qType: qType}
+		script, ok := f.scripts[key]
+		if !ok {
+			continue
+		}
+		scripted = true
+
+		responses = append(responses, script[0])
+		if len(script) > 1 {
+			f.scripts[key] = script[1:]
+		}
+	}
+	f.mu.Unlock()
+
+	if qTypes == nil {
+		return nil, &net.DNSError{
+			Err:  resolver.ErrUnsupportedNetwork.Error(),
+			Name: host,
+		}
+	}
+
+	if !scripted {
+		return nil, NotFound(host)
+	}
+
+	// Queries are assumed to be sent concurrently.
+	var latency time.Duration
+	for _, resp := range responses {
+		latency = max(latency, resp.Latency)
+	}
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return nil, &net.DNSError{
+				Err:         ctx.Err().Error(),
+				Name:        host,
+				IsTimeout:   true,
+				IsTemporary: true,
+			}
+		case <-timer.C:
+		}
+	}
+
+	var addrs []netip.Addr
+	for _, resp := range responses {
+		if resp.Err != nil {
+			return nil, resp.Err
+		}
+
+		addrs = append(addrs, resp.Addrs...)
+	}
+
+	if len(addrs) == 0 {
+		return nil, NotFound(host)
+	}
+
+	return addrs, nil
+}
+
+// NotFound returns the error returned by resolvers when host does not exist
+// (NXDOMAIN).
+func NotFound(host string) error {
+	return &net.DNSError{
+		Err:        resolver.ErrNoSuchHost.Error(),
+		Name:       host,
+		IsNotFound: true,
+	}
+}
+
+// ServerFailure returns the error returned by resolvers when the server
+// failed to answer a query for host (SERVFAIL).
+func ServerFailure(host string) error {
+	return &net.DNSError{
+		Err:         resolver.ErrServerMisbehaving.Error(),
+		Name:        host,
+		IsTemporary: true,
+	}
+}
+
+// Timeout returns the error returned by resolvers when a query for host timed
+// out.
+func Timeout(host string) error {
+	return &net.DNSError{
+		Err:         "i/o timeout",
+		Name:        host,
+		IsTimeout:   true,
+		IsTemporary: true,
+	}
+}
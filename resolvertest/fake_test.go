@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolvertest_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake(t *testing.T) {
+	t.Run("Fixed Answers", func(t *testing.T) {
+		res := resolvertest.NewFake()
+		res.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("fd00::1"))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "Example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("fd00::1")}, addrs)
+
+		addrs, err = res.LookupNetIP(context.Background(), "ip6", "example.com.")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("fd00::1")}, addrs)
+
+		require.Equal(t, []resolvertest.Call{
+			{Network: "ip", Host: "Example.com"},
+			{Network: "ip6", Host: "example.com."},
+		}, res.Calls())
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		res := resolvertest.NewFake()
+		res.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"))
+
+		_, err := res.LookupNetIP(context.Background(), "ip", "example.net")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+
+		// No AAAA records.
+		_, err = res.LookupNetIP(context.Background(), "ip6", "example.com")
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("Error Sequence", func(t *testing.T) {
+		res := resolvertest.NewFake()
+		res.Script("example.com", dns.TypeA,
+			resolvertest.Response{Err: resolvertest.ServerFailure("example.com")},
+			resolvertest.Response{Addrs: []netip.Addr{netip.MustParseAddr("10.0.0.1")}},
+		)
+
+		retryRes, err := resolver.Retry(res, &resolver.RetryResolverConfig{
+			Attempts: ptr.To(2),
+		})
+		require.NoError(t, err)
+
+		addrs, err := retryRes.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+		require.Len(t, res.Calls(), 2)
+
+		// The last response is repeated.
+		addrs, err = res.LookupNetIP(context.Background(), "ip4", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		res := resolvertest.NewFake()
+		res.Script("example.com", dns.TypeA, resolvertest.Response{
+			Addrs:   []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+			Latency: time.Second,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		t.Cleanup(cancel)
+
+		_, err := res.LookupNetIP(ctx, "ip", "example.com")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsTimeout)
+	})
+}
@@ -0,0 +1,443 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolvertest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dohPath        = "/dns-query"
+	dohContentType = "application/dns-message"
+)
+
+// ServerConfig is the configuration for a test DNS server.
+type ServerConfig struct {
+	// Addrs maps names to the addresses served as A and AAAA records.
+	Addrs map[string][]netip.Addr
+	// Records are additional resource records served (eg. CNAME records).
+	Records []dns.RR
+	// TTL is the TTL of the records generated from Addrs.
+	// By default, a TTL of 60 seconds is used.
+	TTL *time.Duration
+	// TLS enables a DNS over TLS listener using a self-signed certificate.
+	TLS *bool
+	// HTTPS enables a DNS over HTTPS listener (serving URL) using the same
+	// self-signed certificate.
+	HTTPS *bool
+	// AltSvc is an optional Alt-Svc header value sent with DNS over HTTPS
+	// responses, eg. `h3=":443"` to advertise HTTP/3 support.
+	AltSvc string
+	// RequiredHTTPHeader are HTTP headers that DNS over HTTPS requests must
+	// contain (eg. an Authorization header), requests without them are
+	// rejected as unauthorized.
+	RequiredHTTPHeader http.Header
+	// OnQuery is an optional callback invoked with every query received
+	// (over any transport), eg. to inspect EDNS(0) options.
+	OnQuery func(req *dns.Msg)
+	// Handler optionally answers queries instead of the served records, eg.
+	// to simulate misbehaving servers.
+	Handler dns.HandlerFunc
+}
+
+// Server is an in-process DNS server serving from a zone map over UDP, TCP
+// and optionally DNS over TLS and DNS over HTTPS. The server listens on the loopback interface
+// and is shut down when the test completes.
+type Server struct {
+	addr      netip.AddrPort
+	tlsAddr   netip.AddrPort
+	httpsAddr netip.AddrPort
+	tlsConfig *tls.Config
+	tlsCert   *x509.Certificate
+	altSvc    string
+	reqHeader http.Header
+	onQuery   func(req *dns.Msg)
+	handler   dns.HandlerFunc
+
+	mu      sync.RWMutex
+	records map[string][]dns.RR
+}
+
+// NewServer starts a new test DNS server.
+func NewServer(t testing.TB, conf *ServerConfig) *Server {
+	t.Helper()
+
+	if conf == nil {
+		conf = &ServerConfig{}
+	}
+
+	ttl := 60 * time.Second
+	if conf.TTL != nil {
+		ttl = *conf.TTL
+	}
+
+	s := &Server{
+		altSvc:    conf.AltSvc,
+		reqHeader: conf.RequiredHTTPHeader,
+		onQuery:   conf.OnQuery,
+		handler:   conf.Handler,
+		records:   make(map[string][]dns.RR),
+	}
+
+	for name, addrs := range conf.Addrs {
+		s.AddAddrs(name, ttl, addrs...)
+	}
+
+	for _, rr := range conf.Records {
+		s.AddRecords(rr)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on udp: %v", err)
+	}
+
+	s.addr = pc.LocalAddr().(*net.UDPAddr).AddrPort()
+	s.addr = netip.AddrPortFrom(s.addr.Addr().Unmap(), s.addr.Port())
+
+	l, err := net.Listen("tcp", s.addr.String())
+	if err != nil {
+		_ = pc.Close()
+		t.Fatalf("failed to listen on tcp: %v", err)
+	}
+
+	servers := []*dns.Server{
+		{PacketConn: pc, Handler: s},
+		{Listener: l, Handler: s},
+	}
+
+	enableTLS := conf.TLS != nil && *conf.TLS
+	enableHTTPS := conf.HTTPS != nil && *conf.HTTPS
+
+	var cert tls.Certificate
+	if enableTLS || enableHTTPS {
+		cert, err = selfSignedCertificate()
+		if err != nil {
+			t.Fatalf("failed to generate certificate: %v", err)
+		}
+
+		s.tlsCert = cert.Leaf
+
+		roots := x509.NewCertPool()
+		roots.AddCert(cert.Leaf)
+
+		s.tlsConfig = &tls.Config{
+			ServerName: cert.Leaf.DNSNames[0],
+			RootCAs:    roots,
+		}
+	}
+
+	if enableTLS {
+		tlsListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		})
+		if err != nil {
+			t.Fatalf("failed to listen on tls: %v", err)
+		}
+
+		s.tlsAddr = tlsListener.Addr().(*net.TCPAddr).AddrPort()
+		s.tlsAddr = netip.AddrPortFrom(s.tlsAddr.Addr().Unmap(), s.tlsAddr.Port())
+
+		servers = append(servers, &dns.Server{Listener: tlsListener, Net: "tcp-tls", Handler: s})
+	}
+
+	if enableHTTPS {
+		httpsListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		})
+		if err != nil {
+			t.Fatalf("failed to listen on https: %v", err)
+		}
+
+		s.httpsAddr = httpsListener.Addr().(*net.TCPAddr).AddrPort()
+		s.httpsAddr = netip.AddrPortFrom(s.httpsAddr.Addr().Unmap(), s.httpsAddr.Port())
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST "+dohPath, s.serveDoH)
+
+		httpServer := &http.Server{Handler: mux}
+		go func() {
+			_ = httpServer.Serve(httpsListener)
+		}()
+
+		t.Cleanup(func() {
+			_ = httpServer.Close()
+		})
+	}
+
+	for _, srv := range servers {
+		go func() {
+			_ = srv.ActivateAndServe()
+		}()
+	}
+
+	t.Cleanup(func() {
+		for _, srv := range servers {
+			_ = srv.Shutdown()
+		}
+	})
+
+	return s
+}
+
+// Addr returns the address of the UDP and TCP listeners.
+func (s *Server) Addr() netip.AddrPort {
+	return s.addr
+}
+
+// TLSAddr returns the address of the DNS over TLS listener (if enabled).
+func (s *Server) TLSAddr() netip.AddrPort {
+	return s.tlsAddr
+}
+
+// HTTPSAddr returns the address of the DNS over HTTPS listener (if enabled).
+func (s *Server) HTTPSAddr() netip.AddrPort {
+	return s.httpsAddr
+}
+
+// URL returns the URL of the DNS over HTTPS endpoint (if enabled). The host
+// of the URL matches the server's certificate, queries should be sent to
+// HTTPSAddr.
+func (s *Server) URL() string {
+	if s.tlsCert == nil {
+		return ""
+	}
+	return "https://" + s.tlsCert.DNSNames[0] + dohPath
+}
+
+// ClientTLSConfig returns a TLS client configuration that trusts the
+// server's self-signed certificate (if DNS over TLS or HTTPS is enabled).
+func (s *Server) ClientTLSConfig() *tls.Config {
+	if s.tlsConfig == nil {
+		return nil
+	}
+	return s.tlsConfig.Clone()
+}
+
+// Certificate returns the server's self-signed certificate (if DNS over TLS
+// or HTTPS is enabled).
+func (s *Server) Certificate() *x509.Certificate {
+	return s.tlsCert
+}
+
+// AddAddrs adds A and AAAA records for name.
+func (s *Server) AddAddrs(name string, ttl time.Duration, addrs ...netip.Addr) {
+	hdr := func(rrType uint16) dns.RR_Header {
+		return dns.RR_Header{
+			Name:   dns.CanonicalName(name),
+			Rrtype: rrType,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(ttl.Seconds()),
+		}
+	}
+
+	for _, addr := range addrs {
+		if addr.Unmap().Is4() {
+			s.AddRecords(&dns.A{Hdr: hdr(dns.TypeA), A: addr.Unmap().AsSlice()})
+		} else {
+			s.AddRecords(&dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: addr.AsSlice()})
+		}
+	}
+}
+
+// AddRecords adds resource records to the zone.
+func (s *Server) AddRecords(rrs ...dns.RR) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rr := range rrs {
+		name := dns.CanonicalName(rr.Header().Name)
+		s.records[name] = append(s.records[name], rr)
+	}
+}
+
+// RemoveRecords removes all resource records for name.
+func (s *Server) RemoveRecords(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, dns.CanonicalName(name))
+}
+
+func (s *Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if s.onQuery != nil {
+		s.onQuery(req)
+	}
+
+	if s.handler != nil {
+		s.handler(w, req)
+		return
+	}
+
+	reply := new(dns.Msg)
+	reply.SetReply(req)
+	reply.Authoritative = true
+	reply.Compress = true
+
+	if len(req.Question) != 1 {
+		reply.Rcode = dns.RcodeFormatError
+		_ = w.WriteMsg(reply)
+		return
+	}
+
+	q := req.Question[0]
+
+	s.mu.RLock()
+	name := dns.CanonicalName(q.Name)
+	_, exists := s.records[name]
+	// Follow CNAME chains (within the zone), guarding against loops.
+	for i := 0; exists && i < 16; i++ {
+		var target string
+		for _, rr := range s.records[name] {
+			if rr.Header().Rrtype == q.Qtype {
+				reply.Answer = append(reply.Answer, dns.Copy(rr))
+			} else if cname, ok := rr.(*dns.CNAME); ok {
+				reply.Answer = append(reply.Answer, dns.Copy(rr))
+				target = dns.CanonicalName(cname.Target)
+			}
+		}
+
+		if target == "" || q.Qtype == dns.TypeCNAME {
+			break
+		}
+		name = target
+	}
+	s.mu.RUnlock()
+
+	if !exists {
+		reply.Rcode = dns.RcodeNameError
+	}
+
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		reply.Truncate(size)
+	}
+
+	_ = w.WriteMsg(reply)
+}
+
+// serveDoH serves RFC 8484 DNS over HTTPS POST requests.
+func (s *Server) serveDoH(w http.ResponseWriter, r *http.Request) {
+	if s.altSvc != "" {
+		w.Header().Set("Alt-Svc", s.altSvc)
+	}
+
+	for name := range s.reqHeader {
+		if r.Header.Get(name) != s.reqHeader.Get(name) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if r.Header.Get("Content-Type") != dohContentType {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := new(dns.Msg)
+	if err := req.Unpack(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw := &dohResponseWriter{remoteAddr: &net.TCPAddr{}}
+	s.ServeDNS(rw, req)
+
+	w.Header().Set("Content-Type", dohContentType)
+	_, _ = w.Write(rw.reply)
+}
+
+// dohResponseWriter captures the reply of ServeDNS to a DNS over HTTPS query.
+type dohResponseWriter struct {
+	remoteAddr net.Addr
+	reply      []byte
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr  { return &net.TCPAddr{} }
+func (w *dohResponseWriter) RemoteAddr() net.Addr { return w.remoteAddr }
+func (w *dohResponseWriter) Close() error         { return nil }
+func (w *dohResponseWriter) TsigStatus() error    { return nil }
+func (w *dohResponseWriter) TsigTimersOnly(bool)  {}
+func (w *dohResponseWriter) Hijack()              {}
+func (w *dohResponseWriter) Write(b []byte) (int, error) {
+	w.reply = append(w.reply, b...)
+	return len(b), nil
+}
+
+func (w *dohResponseWriter) WriteMsg(msg *dns.Msg) error {
+	b, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "resolvertest"},
+		DNSNames:     []string{"dns.resolvertest"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
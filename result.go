@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/util/ptr"
+)
+
+// LookupResult is the JSON representation of the result of a lookup, for
+// consumption by tooling (eg. the resolve command). Fields are only ever
+// added to the representation, never renamed or removed.
+type LookupResult struct {
+	// Question is the question that was asked.
+	Question ResultQuestion `json:"question"`
+	// Answers are the records answering the question (if the lookup
+	// succeeded), including any CNAME records leading to them.
+	Answers []ResultRecord `json:"answers,omitempty"`
+	// AuthenticatedData is true if the answers were validated using DNSSEC by
+	// a trusted server (see Metadata.AuthenticatedData).
+	AuthenticatedData bool `json:"authenticatedData"`
+	// QueriedName is the name that answered the lookup after search domain
+	// expansion (see Metadata.QueriedName), if it was expanded.
+	QueriedName string `json:"queriedName,omitempty"`
+	// Error describes why the lookup failed (if it did).
+	Error *ResultError `json:"error,omitempty"`
+	// Resolver describes the resolver chain that performed the lookup.
+	Resolver *Description `json:"resolver,omitempty"`
+	// Time is when the lookup started (if measured).
+	Time *time.Time `json:"time,omitempty"`
+	// Duration is how long the lookup took, in nanoseconds (if measured).
+	Duration time.Duration `json:"durationNs,omitempty"`
+}
+
+// ResultQuestion is the JSON representation of a Question.
+type ResultQuestion struct {
+	// Name is the domain name that was queried.
+	Name string `json:"name"`
+	// Type is the record type that was queried (eg. "A" or "MX").
+	Type string `json:"type"`
+}
+
+// ResultRecord is the JSON representation of a resource record.
+type ResultRecord struct {
+	// Name is the owner name of the record.
+	Name string `json:"name"`
+	// Type is the type of the record (eg. "A" or "CNAME").
+	Type string `json:"type"`
+	// TTL is the time to live of the record, in seconds. Records synthesized
+	// by resolvers that don't query a server (eg. the hosts file) have a TTL
+	// of zero.
+	TTL uint32 `json:"ttl"`
+	// Data is the presentation format of the record's data (eg. "10.0.0.1",
+	// or "10 mail.example.com." for MX records).
+	Data string `json:"data"`
+}
+
+// ResultError is the JSON representation of a lookup error.
+type ResultError struct {
+	// Message is the error message.
+	Message string `json:"message"`
+	// Server is the server that failed the lookup (if known).
+	Server string `json:"server,omitempty"`
+	// NotFound is true if the name (or records of the requested type) don't
+	// exist.
+	NotFound bool `json:"notFound,omitempty"`
+	// Tried are the names that were tried after search domain expansion (see
+	// SearchError), if the name was expanded.
+	Tried []string `json:"tried,omitempty"`
+	// Timeout is true if the lookup timed out.
+	Timeout bool `json:"timeout,omitempty"`
+	// Temporary is true if the error is temporary, and the lookup may succeed
+	// if retried.
+	Temporary bool `json:"temporary,omitempty"`
+}
+
+// NewLookupResult returns the JSON representation of the answer to (or error
+// of) a lookup of q.
+func NewLookupResult(q Question, answer Answer, err error) LookupResult {
+	result := LookupResult{
+		Question: ResultQuestion{
+			Name: q.Name,
+			Type: dns.Type(q.Type).String(),
+		},
+	}
+
+	if err != nil {
+		result.Error = &ResultError{
+			Message:  err.Error(),
+			NotFound: isNotFound(err),
+			Timeout:  isTimeout(err),
+		}
+
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			result.Error.Server = dnsErr.Server
+			result.Error.Timeout = result.Error.Timeout || dnsErr.IsTimeout
+			result.Error.Temporary = dnsErr.IsTemporary
+		}
+
+		var searchErr *SearchError
+		if errors.As(err, &searchErr) && !slices.Equal(searchErr.Names, []string{dns.Fqdn(searchErr.Host)}) {
+			result.Error.Tried = searchErr.Names
+		}
+
+		return result
+	}
+
+	for _, rr := range answer.Records {
+		hdr := rr.Header()
+		result.Answers = append(result.Answers, ResultRecord{
+			Name: hdr.Name,
+			Type: dns.Type(hdr.Rrtype).String(),
+			TTL:  hdr.Ttl,
+			Data: strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+
+	return result
+}
+
+// LookupResultFor answers the question using the resolver (see Lookup), and
+// returns the JSON representation of the result, including its timing and
+// the resolver chain that answered it.
+func LookupResultFor(ctx context.Context, resolver Resolver, q Question) LookupResult {
+	ctx, md := WithMetadata(ctx)
+
+	start := time.Now()
+	answer, err := Lookup(ctx, resolver, q)
+	duration := time.Since(start)
+
+	result := NewLookupResult(q, answer, err)
+	result.AuthenticatedData = err == nil && md.AuthenticatedData()
+	if err == nil && md.QueriedName() != dns.Fqdn(q.Name) {
+		result.QueriedName = md.QueriedName()
+	}
+	result.Resolver = ptr.To(Describe(resolver))
+	result.Time = &start
+	result.Duration = duration
+
+	return result
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupResult(t *testing.T) {
+	t.Run("Answer", func(t *testing.T) {
+		q := resolver.Question{Name: "example.com", Type: dns.TypeMX}
+		answer := resolver.Answer{Records: []dns.RR{
+			&dns.MX{
+				Hdr:        dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: 300},
+				Preference: 10,
+				Mx:         "mail.example.com.",
+			},
+		}}
+
+		result := resolver.NewLookupResult(q, answer, nil)
+
+		data, err := json.Marshal(result)
+		require.NoError(t, err)
+		require.JSONEq(t, `{
+			"question": {"name": "example.com", "type": "MX"},
+			"answers": [
+				{"name": "example.com.", "type": "MX", "ttl": 300, "data": "10 mail.example.com."}
+			],
+			"authenticatedData": false
+		}`, string(data))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		q := resolver.Question{Name: "example.com", Type: dns.TypeA}
+
+		result := resolver.NewLookupResult(q, resolver.Answer{}, resolvertest.Timeout("example.com"))
+
+		require.Empty(t, result.Answers)
+		require.NotNil(t, result.Error)
+		require.True(t, result.Error.Timeout)
+		require.True(t, result.Error.Temporary)
+		require.False(t, result.Error.NotFound)
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		fake := resolvertest.NewFake()
+		fake.SetAddrs("example.com", netip.MustParseAddr("10.0.0.1"))
+
+		result := resolver.LookupResultFor(context.Background(), fake, resolver.Question{Name: "example.com", Type: dns.TypeA})
+
+		require.Nil(t, result.Error)
+		require.Equal(t, []resolver.ResultRecord{
+			{Name: "example.com.", Type: "A", Data: "10.0.0.1"},
+		}, result.Answers)
+		require.NotNil(t, result.Resolver)
+		require.NotNil(t, result.Time)
+		require.Positive(t, result.Duration)
+
+		result = resolver.LookupResultFor(context.Background(), fake, resolver.Question{Name: "missing.example.com", Type: dns.TypeA})
+
+		require.NotNil(t, result.Error)
+		require.True(t, result.Error.NotFound)
+	})
+
+	t.Run("Search Expansion", func(t *testing.T) {
+		fake := resolvertest.NewFake()
+		fake.SetAddrs("www.corp.example.com", netip.MustParseAddr("10.0.0.1"))
+
+		res, err := resolver.Relative(fake, &resolver.RelativeResolverConfig{
+			Search: []string{"corp.example.com."},
+		})
+		require.NoError(t, err)
+
+		result := resolver.LookupResultFor(context.Background(), res, resolver.Question{Name: "www", Type: dns.TypeA})
+
+		require.Nil(t, result.Error)
+		require.Equal(t, "www.corp.example.com.", result.QueriedName)
+
+		result = resolver.LookupResultFor(context.Background(), res, resolver.Question{Name: "missing", Type: dns.TypeA})
+
+		require.NotNil(t, result.Error)
+		require.True(t, result.Error.NotFound)
+		require.Contains(t, result.Error.Tried, "missing.corp.example.com.")
+	})
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/noisysockets/resolver/internal/util"
+)
+
+var _ Resolver = (*retransmitResolver)(nil)
+
+// retransmitResolver queries a set of name servers the way glibc's stub
+// resolver does. Each attempt tries every server in turn (each with its own
+// timeout), and only once every server has failed is the next attempt made.
+type retransmitResolver struct {
+	resolvers []Resolver
+	attempts  int
+	rotate    bool
+	// timeout returns the per server timeout of the given (zero based) attempt.
+	timeout func(attempt int) time.Duration
+}
+
+func newRetransmitResolver(resolvers []Resolver, attempts int, rotate bool, timeout func(attempt int) time.Duration) *retransmitResolver {
+	return &retransmitResolver{
+		resolvers: resolvers,
+		attempts:  max(attempts, 1),
+		rotate:    rotate,
+		timeout:   timeout,
+	}
+}
+
+func (r *retransmitResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return retransmit(ctx, r, func(ctx context.Context, resolver Resolver) ([]netip.Addr, error) {
+		return resolver.LookupNetIP(ctx, network, host)
+	})
+}
+
+func (r *retransmitResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return retransmit(ctx, r, func(ctx context.Context, resolver Resolver) ([]string, error) {
+		return lookupAddr(ctx, resolver, addr)
+	})
+}
+
+func (r *retransmitResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	return retransmit(ctx, r, func(ctx context.Context, resolver Resolver) (Answer, error) {
+		return Lookup(ctx, resolver, q)
+	})
+}
+
+// retransmit performs a query against the servers of r. A not found response
+// is authoritative and ends the query, any other failure moves on to the next
+// server.
+func retransmit[T any](ctx context.Context, r *retransmitResolver, query func(ctx context.Context, resolver Resolver) (T, error)) (T, error) {
+	resolvers := r.resolvers
+	if r.rotate {
+		resolvers = util.Shuffle(append([]Resolver(nil), resolvers...))
+	}
+	resolvers = deprioritizePenalized(resolvers)
+
+	var result T
+	var err error
+	for attempt := 0; attempt < r.attempts; attempt++ {
+		timeout := r.timeout(attempt)
+
+		for _, resolver := range resolvers {
+			attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+			result, err = query(attemptCtx, resolver)
+			cancel()
+			if err == nil || isNotFound(err) || ctx.Err() != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, err
+}
+
+func (r *retransmitResolver) Describe() Description {
+	return Description{
+		Type: "retransmit",
+		Attributes: map[string]string{
+			"attempts": strconv.Itoa(r.attempts),
+			"timeout":  r.timeout(0).String(),
+			"rotate":   strconv.FormatBool(r.rotate),
+		},
+		Children: describeAll(r.resolvers),
+	}
+}
@@ -11,10 +11,12 @@ package resolver
 
 import (
 	"context"
+	"fmt"
 	"net/netip"
+	"strconv"
 
 	"github.com/avast/retry-go/v4"
-	"github.com/noisysockets/util/defaults"
+	"github.com/noisysockets/resolver/internal/defaults"
 	"github.com/noisysockets/util/ptr"
 )
 
@@ -34,19 +36,22 @@ type retryResolver struct {
 }
 
 // Retry returns a resolver that retries a resolver a number of times.
-func Retry(resolver Resolver, conf *RetryResolverConfig) *retryResolver {
+func Retry(resolver Resolver, conf *RetryResolverConfig) (*retryResolver, error) {
 	conf, err := defaults.WithDefaults(conf, &RetryResolverConfig{
 		Attempts: ptr.To(2), // glibc defaults to 2 attempts.
 	})
 	if err != nil {
-		// Should never happen.
-		panic(err)
+		return nil, fmt.Errorf("failed to apply defaults to retry resolver config: %w", err)
+	}
+
+	if *conf.Attempts < 0 {
+		return nil, fmt.Errorf("attempts must not be negative")
 	}
 
 	return &retryResolver{
 		resolver: resolver,
 		attempts: *conf.Attempts,
-	}
+	}, nil
 }
 
 func (r *retryResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
@@ -59,3 +64,35 @@ func (r *retryResolver) LookupNetIP(ctx context.Context, network, host string) (
 		retry.LastErrorOnly(true),
 	)
 }
+
+func (r *retryResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return retry.DoWithData(func() ([]string, error) {
+		return lookupAddr(ctx, r.resolver, addr)
+	},
+		retry.Context(ctx),
+		retry.Attempts(uint(r.attempts)),
+		retry.RetryIf(isTemporary),
+		retry.LastErrorOnly(true),
+	)
+}
+
+func (r *retryResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	return retry.DoWithData(func() (Answer, error) {
+		return Lookup(ctx, r.resolver, q)
+	},
+		retry.Context(ctx),
+		retry.Attempts(uint(r.attempts)),
+		retry.RetryIf(isTemporary),
+		retry.LastErrorOnly(true),
+	)
+}
+
+func (r *retryResolver) Describe() Description {
+	return Description{
+		Type: "retry",
+		Attributes: map[string]string{
+			"attempts": strconv.Itoa(r.attempts),
+		},
+		Children: []Description{Describe(r.resolver)},
+	}
+}
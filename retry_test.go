@@ -32,7 +32,8 @@ func TestRetryResolver(t *testing.T) {
 		IsTemporary: true,
 	})
 
-	res := resolver.Retry(inner, nil)
+	res, err := resolver.Retry(inner, nil)
+	require.NoError(t, err)
 
 	t.Run("Retryable", func(t *testing.T) {
 		_, err := res.LookupNetIP(context.Background(), "ip", "example.com")
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// ErrReverseLookupUnsupported is returned when a resolver in the chain is
+// unable to perform reverse (PTR) lookups.
+var ErrReverseLookupUnsupported = errors.New("reverse lookups not supported")
+
+// AddrResolver is implemented by resolvers that can perform reverse (PTR)
+// lookups, this interface is also implemented by net.Resolver from the Go
+// standard library.
+type AddrResolver interface {
+	// LookupAddr performs a reverse lookup for the given address, returning a
+	// list of names mapping to that address.
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// lookupAddr performs a reverse lookup using the resolver, if it supports
+// reverse lookups.
+func lookupAddr(ctx context.Context, resolver Resolver, addr string) ([]string, error) {
+	if ar, ok := resolver.(AddrResolver); ok {
+		return ar.LookupAddr(ctx, addr)
+	}
+
+	return nil, &net.DNSError{
+		Err:  ErrReverseLookupUnsupported.Error(),
+		Name: addr,
+	}
+}
+
+// ReverseHostname returns the (fully qualified) hostname that addr maps to,
+// using a reverse (PTR) lookup via the provided resolver chain. If multiple
+// names map to addr, the first is returned.
+func ReverseHostname(ctx context.Context, resolver Resolver, addr netip.Addr) (string, error) {
+	names, err := lookupAddr(ctx, resolver, addr.Unmap().String())
+	if err != nil {
+		return "", err
+	}
+
+	if len(names) == 0 {
+		return "", &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       addr.String(),
+			IsNotFound: true,
+		}
+	}
+
+	return names[0], nil
+}
+
+// LocalHostname determines the fully qualified hostname of the machine by
+// reverse (PTR) lookups of its primary addresses via the provided resolver
+// chain. This is useful for logging and Kerberos style hostname
+// canonicalization inside overlay networks (where the machine's name is only
+// known to the overlay's resolvers).
+//
+// The addresses are tried in order and the first name found is returned. If
+// no addresses are provided, the global unicast addresses assigned to the
+// host's interfaces are used.
+func LocalHostname(ctx context.Context, resolver Resolver, addrs ...netip.Addr) (string, error) {
+	if len(addrs) == 0 {
+		prefixes, err := interfacePrefixes()
+		if err != nil {
+			return "", err
+		}
+
+		for _, prefix := range prefixes {
+			if prefix.Addr().IsGlobalUnicast() {
+				addrs = append(addrs, prefix.Addr())
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return "", &net.DNSError{
+			Err:        "no primary addresses",
+			IsNotFound: true,
+		}
+	}
+
+	var errs []error
+	for _, addr := range addrs {
+		name, err := ReverseHostname(ctx, resolver, addr)
+		if err == nil {
+			return name, nil
+		}
+		errs = append(errs, err)
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return "", errors.Join(errs...)
+}
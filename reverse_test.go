@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseHostname(t *testing.T) {
+	ctx := context.Background()
+
+	srv := resolvertest.NewServer(t, nil)
+
+	reverseName, err := dns.ReverseAddr("100.64.0.7")
+	require.NoError(t, err)
+
+	srv.AddRecords(&dns.PTR{
+		Hdr: dns.RR_Header{Name: reverseName, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 60},
+		Ptr: "node7.overlay.internal.",
+	})
+
+	dnsRes, err := resolver.DNS(resolver.DNSResolverConfig{
+		Server: srv.Addr(),
+	})
+	require.NoError(t, err)
+
+	t.Run("DNS", func(t *testing.T) {
+		name, err := resolver.ReverseHostname(ctx, dnsRes, netip.MustParseAddr("100.64.0.7"))
+		require.NoError(t, err)
+
+		require.Equal(t, "node7.overlay.internal.", name)
+
+		_, err = resolver.ReverseHostname(ctx, dnsRes, netip.MustParseAddr("100.64.0.8"))
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.True(t, errors.As(err, &dnsErr))
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("Hosts", func(t *testing.T) {
+		f, err := os.Open("testdata/hosts")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, f.Close())
+		})
+
+		hostsRes, err := resolver.Hosts(&resolver.HostsResolverConfig{
+			HostsFileReader: f,
+		})
+		require.NoError(t, err)
+
+		names, err := hostsRes.LookupAddr(ctx, "127.0.1.1")
+		require.NoError(t, err)
+
+		require.Equal(t, []string{"mymachine.local.", "mymachine."}, names)
+
+		hostsRes.AddHost("peer.overlay.internal", netip.MustParseAddr("100.64.0.9"))
+
+		name, err := resolver.ReverseHostname(ctx, hostsRes, netip.MustParseAddr("100.64.0.9"))
+		require.NoError(t, err)
+
+		require.Equal(t, "peer.overlay.internal.", name)
+
+		hostsRes.RemoveHost("peer.overlay.internal")
+
+		_, err = resolver.ReverseHostname(ctx, hostsRes, netip.MustParseAddr("100.64.0.9"))
+		require.Error(t, err)
+	})
+
+	t.Run("Chain", func(t *testing.T) {
+		hostsRes, err := resolver.Hosts(&resolver.HostsResolverConfig{
+			NoHostsFile: ptr.To(true),
+		})
+		require.NoError(t, err)
+
+		res := resolver.Sequential(resolver.Literal(), hostsRes, dnsRes)
+
+		name, err := resolver.ReverseHostname(ctx, res, netip.MustParseAddr("100.64.0.7"))
+		require.NoError(t, err)
+
+		require.Equal(t, "node7.overlay.internal.", name)
+	})
+}
+
+func TestLocalHostname(t *testing.T) {
+	ctx := context.Background()
+
+	res, err := resolver.Hosts(&resolver.HostsResolverConfig{
+		NoHostsFile: ptr.To(true),
+	})
+	require.NoError(t, err)
+
+	res.AddHost("node2.overlay.internal", netip.MustParseAddr("100.64.0.2"))
+
+	name, err := resolver.LocalHostname(ctx, res,
+		netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("100.64.0.2"))
+	require.NoError(t, err)
+
+	require.Equal(t, "node2.overlay.internal.", name)
+
+	_, err = resolver.LocalHostname(ctx, res, netip.MustParseAddr("100.64.0.1"))
+	require.Error(t, err)
+}
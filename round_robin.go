@@ -11,31 +11,158 @@ package resolver
 
 import (
 	"context"
+	"math/rand/v2"
 	"net/netip"
+	"sync"
+	"sync/atomic"
 
 	"github.com/noisysockets/resolver/internal/util"
 )
 
 var _ Resolver = (*roundRobinResolver)(nil)
 
+// RoundRobinStrategy chooses the order in which a round-robin resolver tries
+// its resolvers. Implementations must be safe for concurrent use.
+type RoundRobinStrategy interface {
+	// Order returns the order in which to try n resolvers for a query, as a
+	// permutation of the indices [0, n).
+	Order(n int) []int
+}
+
+// RandomOrder returns a strategy that tries the resolvers in a random order
+// drawn from src, eg. a seeded rand.NewPCG source so that the selection can be
+// reproduced in bug reports and tests. If src is nil, a cryptographically
+// secure source is used (the default).
+func RandomOrder(src rand.Source) RoundRobinStrategy {
+	if src == nil {
+		return &randomOrder{}
+	}
+
+	return &randomOrder{rand: rand.New(src)}
+}
+
+type randomOrder struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func (s *randomOrder) Order(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+
+	if s.rand == nil {
+		return util.Shuffle(order)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return util.ShuffleFunc(order, s.rand.IntN)
+}
+
+// RotateOrder returns a strategy that starts each query with the resolver
+// after the one the previous query started with, cycling through the resolvers
+// in a fixed, deterministic order.
+func RotateOrder() RoundRobinStrategy {
+	return &rotateOrder{}
+}
+
+type rotateOrder struct {
+	next atomic.Uint64
+}
+
+func (s *rotateOrder) Order(n int) []int {
+	if n == 0 {
+		return nil
+	}
+
+	start := int((s.next.Add(1) - 1) % uint64(n))
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = (start + i) % n
+	}
+	return order
+}
+
+// RoundRobinResolverConfig is the configuration of a round-robin resolver.
+type RoundRobinResolverConfig struct {
+	// Strategy chooses the order in which the resolvers are tried.
+	// By default, RandomOrder(nil).
+	Strategy RoundRobinStrategy
+	// Failover decides whether to move on to the next resolver after a
+	// resolver fails.
+	// By default, FailoverOnAnyError.
+	Failover FailoverPolicy
+}
+
 // roundRobinResolver is a Resolver that load balances between multiple resolvers
 // using a round-robin strategy.
 type roundRobinResolver struct {
 	resolvers []Resolver
+	strategy  RoundRobinStrategy
+	failover  FailoverPolicy
 }
 
 // RoundRobin returns a Resolver that load balances between multiple resolvers
 // using a round-robin strategy.
 func RoundRobin(resolvers ...Resolver) *roundRobinResolver {
+	return RoundRobinWithConfig(nil, resolvers...)
+}
+
+// RoundRobinWithConfig returns a Resolver that load balances between multiple
+// resolvers, trying them in the order chosen by the configured strategy.
+func RoundRobinWithConfig(conf *RoundRobinResolverConfig, resolvers ...Resolver) *roundRobinResolver {
+	if conf == nil {
+		conf = &RoundRobinResolverConfig{}
+	}
+
+	strategy := conf.Strategy
+	if strategy == nil {
+		strategy = RandomOrder(nil)
+	}
+
 	return &roundRobinResolver{
 		resolvers: resolvers,
+		strategy:  strategy,
+		failover:  conf.Failover,
 	}
 }
 
 func (r *roundRobinResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
-	rotatedResolvers := make([]Resolver, len(r.resolvers))
-	copy(rotatedResolvers, r.resolvers)
-	rotatedResolvers = util.Shuffle(rotatedResolvers)
+	return r.sequential().LookupNetIP(ctx, network, host)
+}
+
+func (r *roundRobinResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.sequential().LookupAddr(ctx, addr)
+}
 
-	return Sequential(rotatedResolvers...).LookupNetIP(ctx, network, host)
+func (r *roundRobinResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	return r.sequential().Lookup(ctx, q)
+}
+
+func (r *roundRobinResolver) Describe() Description {
+	return Description{
+		Type:     "round-robin",
+		Children: describeAll(r.resolvers),
+	}
+}
+
+// sequential returns a sequential resolver that tries the resolvers in the
+// order chosen by the strategy.
+func (r *roundRobinResolver) sequential() *sequentialResolver {
+	return SequentialWithConfig(&SequentialResolverConfig{
+		Failover: r.failover,
+	}, r.rotatedResolvers()...)
+}
+
+// rotatedResolvers returns the resolvers in the order chosen by the strategy.
+func (r *roundRobinResolver) rotatedResolvers() []Resolver {
+	rotatedResolvers := make([]Resolver, 0, len(r.resolvers))
+	for _, i := range r.strategy.Order(len(r.resolvers)) {
+		rotatedResolvers = append(rotatedResolvers, r.resolvers[i])
+	}
+	return rotatedResolvers
 }
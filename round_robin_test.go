@@ -12,8 +12,10 @@ package resolver_test
 import (
 	"context"
 	"errors"
+	"math/rand/v2"
 	"net"
 	"net/netip"
+	"slices"
 	"testing"
 
 	"github.com/noisysockets/resolver"
@@ -65,3 +67,72 @@ func TestRoundRobinResolver(t *testing.T) {
 		require.GreaterOrEqual(t, len(res2.Calls), 10)
 	})
 }
+
+func TestRoundRobinStrategy(t *testing.T) {
+	var resolvers []resolver.Resolver
+	for i := 0; i < 4; i++ {
+		res := new(testutil.MockResolver)
+		res.On("LookupNetIP", mock.Anything, "ip", "example.com").Return([]netip.Addr{netip.AddrFrom4([4]byte{10, 0, 0, byte(i)})}, nil)
+		resolvers = append(resolvers, res)
+	}
+
+	// lookups returns the last octet of the address returned by each lookup,
+	// ie. the index of the first resolver tried.
+	lookups := func(res resolver.Resolver) []int {
+		var indices []int
+		for i := 0; i < 20; i++ {
+			addrs, err := res.LookupNetIP(context.Background(), "ip", "example.com")
+			require.NoError(t, err)
+			require.Len(t, addrs, 1)
+
+			indices = append(indices, int(addrs[0].As4()[3]))
+		}
+		return indices
+	}
+
+	t.Run("Seeded", func(t *testing.T) {
+		res1 := resolver.RoundRobinWithConfig(&resolver.RoundRobinResolverConfig{
+			Strategy: resolver.RandomOrder(rand.NewPCG(1, 2)),
+		}, resolvers...)
+		res2 := resolver.RoundRobinWithConfig(&resolver.RoundRobinResolverConfig{
+			Strategy: resolver.RandomOrder(rand.NewPCG(1, 2)),
+		}, resolvers...)
+
+		indices := lookups(res1)
+		require.Equal(t, indices, lookups(res2))
+
+		// Every resolver should have been selected at least once.
+		for i := range resolvers {
+			require.Contains(t, indices, i)
+		}
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		res := resolver.RoundRobinWithConfig(&resolver.RoundRobinResolverConfig{
+			Strategy: resolver.RotateOrder(),
+		}, resolvers...)
+
+		indices := lookups(res)
+		for i, index := range indices {
+			require.Equal(t, i%len(resolvers), index)
+		}
+	})
+
+	t.Run("Permutation", func(t *testing.T) {
+		for _, strategy := range []resolver.RoundRobinStrategy{
+			resolver.RandomOrder(nil),
+			resolver.RandomOrder(rand.NewPCG(3, 4)),
+			resolver.RotateOrder(),
+		} {
+			for n := 0; n < 8; n++ {
+				order := strategy.Order(n)
+				require.Len(t, order, n)
+
+				slices.Sort(order)
+				for i := range order {
+					require.Equal(t, i, order[i])
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// minAdaptiveTimeout is the lower bound of adaptive query timeouts, it
+	// keeps a server with a very stable round trip time from timing out on
+	// the slightest delay.
+	minAdaptiveTimeout = 200 * time.Millisecond
+	// maxTimeoutBackoff is the maximum number of times the adaptive timeout
+	// is doubled after consecutive timeouts.
+	maxTimeoutBackoff = 8
+)
+
+// DNSServerStats are the runtime statistics of a DNS resolver's server.
+type DNSServerStats struct {
+	// Server is the address of the server.
+	Server string `json:"server"`
+	// Transport is the transport protocol used to query the server.
+	Transport DNSTransport `json:"transport"`
+	// Queries is the number of queries sent to the server.
+	Queries int64 `json:"queries"`
+	// Failures is the number of queries that failed.
+	Failures int64 `json:"failures"`
+	// InFlight is the number of queries currently in progress.
+	InFlight int64 `json:"inFlight"`
+	// Samples is the number of round trip times measured, ie. the number of
+	// responses received.
+	Samples int64 `json:"samples"`
+	// SRTT is the smoothed round trip time of the server (zero if no
+	// responses have been received).
+	SRTT time.Duration `json:"srtt"`
+	// RTTVar is the smoothed mean deviation of the round trip time.
+	RTTVar time.Duration `json:"rttvar"`
+	// Timeout is the timeout the next query will use.
+	Timeout time.Duration `json:"timeout"`
+}
+
+// rttEstimator tracks the smoothed round trip time (and its variation) of a
+// server, and derives retransmission timeouts from it, as described by RFC
+// 6298. It is safe for concurrent use.
+type rttEstimator struct {
+	mu      sync.Mutex
+	srtt    time.Duration
+	rttvar  time.Duration
+	samples int64
+	// backoff is the number of consecutive timeouts since the last response.
+	backoff int
+}
+
+// observe records the round trip time of a response.
+func (e *rttEstimator) observe(rtt time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.samples == 0 {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+	} else {
+		delta := e.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		e.rttvar = (3*e.rttvar + delta) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+
+	e.samples++
+	e.backoff = 0
+}
+
+// timedOut records a query that timed out without a response.
+func (e *rttEstimator) timedOut() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.backoff < maxTimeoutBackoff {
+		e.backoff++
+	}
+}
+
+// timeout returns the timeout of the next query, bounded by limit. Until the
+// first response is received the limit is used.
+func (e *rttEstimator) timeout(limit time.Duration) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.samples == 0 {
+		return limit
+	}
+
+	timeout := max(e.srtt+4*e.rttvar, minAdaptiveTimeout) << e.backoff
+	if limit > 0 && (timeout > limit || timeout <= 0) {
+		return limit
+	}
+
+	return timeout
+}
+
+// stats returns the smoothed round trip time, its variation, and the number
+// of samples.
+func (e *rttEstimator) stats() (srtt, rttvar time.Duration, samples int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.srtt, e.rttvar, e.samples
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSResolverStats(t *testing.T) {
+	srv := resolvertest.NewServer(t, &resolvertest.ServerConfig{
+		Addrs: map[string][]netip.Addr{
+			"example.com": {netip.MustParseAddr("10.0.0.1")},
+		},
+	})
+
+	ctx := context.Background()
+
+	t.Run("Fixed", func(t *testing.T) {
+		res, err := resolver.NewDNS(srv.Addr())
+		require.NoError(t, err)
+
+		stats := res.Stats()
+		require.Zero(t, stats.Samples)
+		require.Equal(t, 5*time.Second, stats.Timeout)
+
+		for i := 0; i < 5; i++ {
+			_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+			require.NoError(t, err)
+		}
+
+		// Responses with an error rcode are also round trip time samples.
+		_, err = res.LookupNetIP(ctx, "ip4", "missing.example.com")
+		require.Error(t, err)
+
+		stats = res.Stats()
+		require.Equal(t, srv.Addr().String(), stats.Server)
+		require.Equal(t, int64(6), stats.Queries)
+		require.Equal(t, int64(1), stats.Failures)
+		require.Equal(t, int64(6), stats.Samples)
+		require.Positive(t, stats.SRTT)
+		require.Less(t, stats.SRTT, time.Second)
+
+		// Without adaptive timeouts, the configured timeout is always used.
+		require.Equal(t, 5*time.Second, stats.Timeout)
+		require.NotEmpty(t, resolver.Describe(res).Attributes["srtt"])
+	})
+
+	t.Run("Adaptive", func(t *testing.T) {
+		res, err := resolver.NewDNS(srv.Addr(), resolver.WithAdaptiveTimeout())
+		require.NoError(t, err)
+
+		// Until a response is received the configured timeout is used.
+		require.Equal(t, 5*time.Second, res.Stats().Timeout)
+
+		for i := 0; i < 5; i++ {
+			_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+			require.NoError(t, err)
+		}
+
+		timeout := res.Stats().Timeout
+		require.GreaterOrEqual(t, timeout, 200*time.Millisecond)
+		require.Less(t, timeout, 5*time.Second)
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		// A server that stops responding after the first query.
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = pc.Close()
+		})
+
+		var responded bool
+		res, err := resolver.NewDNS(netip.MustParseAddrPort(pc.LocalAddr().String()),
+			resolver.WithAdaptiveTimeout(),
+			resolver.WithDialContext(func(ctx context.Context, network, address string) (net.Conn, error) {
+				if responded {
+					return (&net.Dialer{}).DialContext(ctx, network, address)
+				}
+				responded = true
+				return (&net.Dialer{}).DialContext(ctx, network, srv.Addr().String())
+			}))
+		require.NoError(t, err)
+
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		timeout := res.Stats().Timeout
+		require.Less(t, timeout, 5*time.Second)
+
+		start := time.Now()
+		_, err = res.LookupNetIP(ctx, "ip4", "example.com")
+		require.Error(t, err)
+		require.Less(t, time.Since(start), 5*time.Second)
+
+		// The timeout doubles after each timeout.
+		require.Equal(t, min(2*timeout, 5*time.Second), res.Stats().Timeout)
+	})
+}
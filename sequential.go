@@ -17,27 +17,90 @@ import (
 
 var _ Resolver = (*sequentialResolver)(nil)
 
+// SequentialResolverConfig is the configuration of a sequential resolver.
+type SequentialResolverConfig struct {
+	// Failover decides whether to move on to the next resolver after a
+	// resolver fails.
+	// By default, FailoverOnAnyError.
+	Failover FailoverPolicy
+}
+
 // sequentialResolver is a resolver that tries each resolver in order until one succeeds.
 type sequentialResolver struct {
 	resolvers []Resolver
+	failover  FailoverPolicy
 }
 
 // Sequential returns a resolver that tries each resolver in order until one succeeds.
 func Sequential(resolvers ...Resolver) *sequentialResolver {
+	return SequentialWithConfig(nil, resolvers...)
+}
+
+// SequentialWithConfig returns a resolver that tries each resolver in order
+// until one succeeds, or until the failover policy ends the lookup.
+func SequentialWithConfig(conf *SequentialResolverConfig, resolvers ...Resolver) *sequentialResolver {
+	failover := FailoverPolicy(FailoverOnAnyError)
+	if conf != nil && conf.Failover != nil {
+		failover = conf.Failover
+	}
+
 	return &sequentialResolver{
 		resolvers: resolvers,
+		failover:  failover,
 	}
 }
 
 func (r *sequentialResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
 	var errs []error
-	for _, resolver := range r.resolvers {
+	for _, resolver := range deprioritizePenalized(r.resolvers) {
 		addrs, err := resolver.LookupNetIP(ctx, network, host)
 		if err == nil {
 			return addrs, nil
 		}
 		errs = append(errs, err)
+		if !r.failover(err) {
+			break
+		}
 	}
 
 	return nil, errors.Join(errs...)
 }
+
+func (r *sequentialResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	var errs []error
+	for _, resolver := range deprioritizePenalized(r.resolvers) {
+		names, err := lookupAddr(ctx, resolver, addr)
+		if err == nil {
+			return names, nil
+		}
+		errs = append(errs, err)
+		if !r.failover(err) {
+			break
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (r *sequentialResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	var errs []error
+	for _, resolver := range deprioritizePenalized(r.resolvers) {
+		answer, err := Lookup(ctx, resolver, q)
+		if err == nil {
+			return answer, nil
+		}
+		errs = append(errs, err)
+		if !r.failover(err) {
+			break
+		}
+	}
+
+	return Answer{}, errors.Join(errs...)
+}
+
+func (r *sequentialResolver) Describe() Description {
+	return Description{
+		Type:     "sequential",
+		Children: describeAll(r.resolvers),
+	}
+}
@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/noisysockets/resolver/internal/addrselect"
+)
+
+// SourceAddrProvider determines the source addresses used for RFC 6724
+// address sorting.
+type SourceAddrProvider interface {
+	// SourceAddrs returns the source address that would be used to reach each
+	// of the destination addresses. An invalid address indicates that the
+	// destination is unreachable.
+	SourceAddrs(ctx context.Context, dsts []netip.Addr) []netip.Addr
+}
+
+// DialSourceAddrProvider returns a SourceAddrProvider that determines source
+// addresses by connecting (but not sending) a UDP socket to each destination.
+// This is accurate but requires a socket per destination on every lookup.
+func DialSourceAddrProvider(dialContext DialContextFunc) SourceAddrProvider {
+	return dialSourceAddrProvider(dialContext)
+}
+
+type dialSourceAddrProvider DialContextFunc
+
+func (dialContext dialSourceAddrProvider) SourceAddrs(ctx context.Context, dsts []netip.Addr) []netip.Addr {
+	srcs := make([]netip.Addr, len(dsts))
+	for i, dst := range dsts {
+		conn, err := dialContext(ctx, "udp", netip.AddrPortFrom(dst, 9).String())
+		if err != nil {
+			continue
+		}
+
+		if src, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			srcs[i] = src.AddrPort().Addr()
+		}
+		_ = conn.Close()
+	}
+
+	return srcs
+}
+
+var (
+	interfaceSourceAddrProviderOnce sync.Once
+	interfaceSourceAddrProviderInst *interfaceSourceAddrProvider
+)
+
+// InterfaceSourceAddrProvider returns a (shared) SourceAddrProvider that
+// selects source addresses from the addresses assigned to the host's network
+// interfaces. The interface addresses are cached and refreshed when they
+// change (on Linux) or periodically (elsewhere). As no routing table is
+// consulted, the result is an approximation of what the kernel would choose.
+func InterfaceSourceAddrProvider() SourceAddrProvider {
+	interfaceSourceAddrProviderOnce.Do(func() {
+		// If we're notified of changes, there's no need to poll.
+		p := &interfaceSourceAddrProvider{
+			table: addrselect.DefaultPolicyTable(),
+		}
+
+		if !watchInterfaceAddrs(p.invalidate, func() { p.poll(time.Minute) }) {
+			p.refresh = time.Minute
+		}
+
+		interfaceSourceAddrProviderInst = p
+	})
+
+	return interfaceSourceAddrProviderInst
+}
+
+type interfaceSourceAddrProvider struct {
+	table addrselect.PolicyTable
+
+	mu       sync.Mutex
+	refresh  time.Duration
+	prefixes []netip.Prefix
+	valid    bool
+	expires  time.Time
+}
+
+func (p *interfaceSourceAddrProvider) SourceAddrs(_ context.Context, dsts []netip.Addr) []netip.Addr {
+	prefixes := p.interfacePrefixes()
+
+	srcs := make([]netip.Addr, len(dsts))
+	for i, dst := range dsts {
+		srcs[i] = addrselect.SelectSourceAddr(p.table, dst, prefixes)
+	}
+
+	return srcs
+}
+
+func (p *interfaceSourceAddrProvider) interfacePrefixes() []netip.Prefix {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.valid && (p.refresh == 0 || time.Now().Before(p.expires)) {
+		return p.prefixes
+	}
+
+	prefixes, err := interfacePrefixes()
+	if err != nil {
+		// Keep using the stale addresses (if any), we'll try again next time.
+		return p.prefixes
+	}
+
+	p.prefixes = prefixes
+	p.valid = true
+	p.expires = time.Now().Add(p.refresh)
+
+	return p.prefixes
+}
+
+func (p *interfaceSourceAddrProvider) invalidate() {
+	p.mu.Lock()
+	p.valid = false
+	p.mu.Unlock()
+}
+
+// poll refreshes the addresses periodically, once changes are no longer
+// notified.
+func (p *interfaceSourceAddrProvider) poll(refresh time.Duration) {
+	p.mu.Lock()
+	p.refresh = refresh
+	p.valid = false
+	p.mu.Unlock()
+}
+
+// interfacePrefixes returns the addresses assigned to all up interfaces.
+func interfacePrefixes() ([]netip.Prefix, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []netip.Prefix
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+
+			ip, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+
+			bits, _ := ipNet.Mask.Size()
+			if ip.Is4In6() {
+				ip = ip.Unmap()
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(ip, bits))
+		}
+	}
+
+	return prefixes, nil
+}
+
+// sourceAddrProviderFor returns the source address provider used when
+// sorting addresses, given the caller supplied configuration. If only a
+// custom dialer is supplied, it is used to probe source addresses as it might
+// not use the host's network stack (eg. a userspace network).
+func sourceAddrProviderFor(srcAddrs SourceAddrProvider, dialContext DialContextFunc) SourceAddrProvider {
+	if srcAddrs != nil {
+		return srcAddrs
+	}
+
+	if dialContext != nil {
+		return DialSourceAddrProvider(dialContext)
+	}
+
+	// Interface addresses don't tell us whether there is a route.
+	return DefaultReachabilityProbe().Filter(InterfaceSourceAddrProvider())
+}
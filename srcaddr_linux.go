@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"log/slog"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchInterfaceAddrs subscribes to netlink link and address change
+// notifications, calling onChange whenever the interface addresses might have
+// changed. It returns false if notifications are unavailable (eg. netlink
+// sockets are forbidden by a seccomp policy). If notifications stop later on,
+// onStop is called (after a final onChange), so that the caller can fall back
+// to polling.
+func watchInterfaceAddrs(onChange, onStop func()) bool {
+	return watchNetlink(unix.RTMGRP_LINK|unix.RTMGRP_IPV4_IFADDR|unix.RTMGRP_IPV6_IFADDR, onChange, onStop)
+}
+
+// watchRoutes is like watchInterfaceAddrs, but also calls onChange whenever
+// the routing table might have changed.
+func watchRoutes(onChange, onStop func()) bool {
+	return watchNetlink(unix.RTMGRP_LINK|unix.RTMGRP_IPV4_IFADDR|unix.RTMGRP_IPV6_IFADDR|
+		unix.RTMGRP_IPV4_ROUTE|unix.RTMGRP_IPV6_ROUTE, onChange, onStop)
+}
+
+// watchNetlink subscribes to the netlink route multicast groups, calling
+// onChange whenever a notification is received, and onStop if the socket
+// fails.
+func watchNetlink(groups uint32, onChange, onStop func()) bool {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return false
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: groups,
+	}); err != nil {
+		_ = unix.Close(fd)
+		return false
+	}
+
+	go func() {
+		defer unix.Close(fd)
+
+		buf := make([]byte, 4096)
+		for {
+			if _, _, err := unix.Recvfrom(fd, buf, 0); err != nil && err != unix.EINTR {
+				// Includes ENOBUFS (we missed some messages), either way the cached
+				// addresses can no longer be trusted.
+				onChange()
+
+				if err != unix.ENOBUFS {
+					slog.Warn("Stopped watching for network changes, falling back to polling",
+						slog.Any("error", err))
+					onStop()
+					return
+				}
+				continue
+			}
+
+			onChange()
+		}
+	}()
+
+	return true
+}
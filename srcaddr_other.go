@@ -0,0 +1,24 @@
+//go:build !linux
+
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+// watchInterfaceAddrs is not supported on this platform, interface addresses
+// are periodically refreshed instead.
+func watchInterfaceAddrs(_, _ func()) bool {
+	return false
+}
+
+// watchRoutes is not supported on this platform, reachability is periodically
+// re-probed instead.
+func watchRoutes(_, _ func()) bool {
+	return false
+}
@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"net/netip"
+
+	"github.com/noisysockets/util/ptr"
+)
+
+// Static returns a resolver that answers only for the names in hosts, eg. for
+// tests and embedded fixtures. Names are matched case-insensitively, with or
+// without a trailing dot, and a name prefixed with "*." is a wildcard that
+// matches any subdomain of the suffix. Addresses are returned in the order
+// given, and reverse lookups return the names mapping to an address.
+func Static(hosts map[string][]netip.Addr) (*HostsResolver, error) {
+	return Hosts(&HostsResolverConfig{
+		AddressOrder: ptr.To(AddressOrderNone),
+		NoHostsFile:  ptr.To(true),
+		Hosts:        hosts,
+	})
+}
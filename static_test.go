@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticResolver(t *testing.T) {
+	ctx := context.Background()
+
+	res, err := resolver.Static(map[string][]netip.Addr{
+		"Web.Example.Internal": {
+			netip.MustParseAddr("2001:db8::1"),
+			netip.MustParseAddr("192.0.2.1"),
+		},
+		"db.example.internal.":    {netip.MustParseAddr("192.0.2.2")},
+		"*.apps.example.internal": {netip.MustParseAddr("192.0.2.3")},
+	})
+	require.NoError(t, err)
+
+	t.Run("Case Insensitive", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip", "web.example.internal.")
+		require.NoError(t, err)
+
+		// The given order is preserved.
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("2001:db8::1"),
+			netip.MustParseAddr("192.0.2.1"),
+		}, addrs)
+
+		addrs, err = res.LookupNetIP(ctx, "ip4", "WEB.EXAMPLE.INTERNAL")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, addrs)
+	})
+
+	t.Run("Trailing Dot", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip", "DB.example.internal")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.2")}, addrs)
+	})
+
+	t.Run("Wildcard", func(t *testing.T) {
+		addrs, err := res.LookupNetIP(ctx, "ip", "Foo.Apps.Example.Internal")
+		require.NoError(t, err)
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.3")}, addrs)
+	})
+
+	t.Run("Reverse", func(t *testing.T) {
+		names, err := res.LookupAddr(ctx, "192.0.2.1")
+		require.NoError(t, err)
+		require.Equal(t, []string{"Web.Example.Internal."}, names)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		_, err := res.LookupNetIP(ctx, "ip", "missing.example.internal")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+}
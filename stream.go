@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// StreamResult is a partial result of a streaming lookup.
+type StreamResult struct {
+	// Addrs are addresses of the host, that have not been part of an earlier
+	// result.
+	Addrs []netip.Addr
+	// Err is set (and Addrs is empty) if the lookup failed without producing
+	// any addresses. It is always part of the last result.
+	Err error
+}
+
+// StreamResolver is implemented by resolvers that can produce partial results
+// (eg. the addresses of one address family before those of the other).
+type StreamResolver interface {
+	// LookupNetIPStream looks up host, sending the addresses over the returned
+	// channel as they become available. The channel is closed once the
+	// lookup is complete.
+	LookupNetIPStream(ctx context.Context, network, host string) <-chan StreamResult
+}
+
+// LookupNetIPStream looks up host using the resolver, sending the addresses
+// over the returned channel as they become available. This allows dialers to
+// start connecting (eg. Happy Eyeballs, RFC 8305) before the slowest address
+// family has been answered. The channel is closed once the lookup is
+// complete.
+//
+// Resolvers that don't implement StreamResolver look up the IPv4 and IPv6
+// addresses of "ip" lookups concurrently, sending each as they arrive. Other
+// lookups produce a single result.
+func LookupNetIPStream(ctx context.Context, resolver Resolver, network, host string) <-chan StreamResult {
+	if sr, ok := resolver.(StreamResolver); ok {
+		return sr.LookupNetIPStream(ctx, network, host)
+	}
+
+	// Large enough for every result, so that the lookups never block (even if
+	// the caller stops receiving).
+	results := make(chan StreamResult, 2)
+
+	ipNet, ok := ipNetwork(network)
+	if !ok {
+		results <- StreamResult{Err: &net.DNSError{
+			Err:  ErrUnsupportedNetwork.Error(),
+			Name: host,
+		}}
+		close(results)
+		return results
+	}
+
+	if ipNet != "ip" {
+		go func() {
+			defer close(results)
+
+			addrs, err := resolver.LookupNetIP(ctx, network, host)
+			if err != nil {
+				results <- StreamResult{Err: err}
+				return
+			}
+
+			results <- StreamResult{Addrs: addrs}
+		}()
+
+		return results
+	}
+
+	families := make(chan StreamResult, 2)
+
+	var wg sync.WaitGroup
+	for _, familyNet := range []string{"ip4", "ip6"} {
+		wg.Add(1)
+		go func(familyNet string) {
+			defer wg.Done()
+
+			addrs, err := resolver.LookupNetIP(ctx, familyNet, host)
+			families <- StreamResult{Addrs: addrs, Err: err}
+		}(familyNet)
+	}
+
+	go func() {
+		wg.Wait()
+		close(families)
+	}()
+
+	go func() {
+		defer close(results)
+
+		var found bool
+		var errs []error
+		for result := range families {
+			if result.Err != nil {
+				errs = append(errs, result.Err)
+				continue
+			}
+
+			if len(result.Addrs) == 0 {
+				continue
+			}
+
+			found = true
+			results <- StreamResult{Addrs: result.Addrs}
+		}
+
+		if !found {
+			err := errors.Join(errs...)
+			if err == nil {
+				err = &net.DNSError{
+					Err:        ErrNoSuchHost.Error(),
+					Name:       host,
+					IsNotFound: true,
+				}
+			}
+
+			results <- StreamResult{Err: err}
+		}
+	}()
+
+	return results
+}
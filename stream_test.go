@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupNetIPStream(t *testing.T) {
+	collect := func(results <-chan resolver.StreamResult) []resolver.StreamResult {
+		var collected []resolver.StreamResult
+		for result := range results {
+			collected = append(collected, result)
+		}
+		return collected
+	}
+
+	t.Run("Fastest Family First", func(t *testing.T) {
+		fake := resolvertest.NewFake()
+		fake.Script("example.com", dns.TypeA, resolvertest.Response{
+			Addrs:   []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+			Latency: 100 * time.Millisecond,
+		})
+		fake.Script("example.com", dns.TypeAAAA, resolvertest.Response{
+			Addrs: []netip.Addr{netip.MustParseAddr("2001:db8::1")},
+		})
+
+		results := resolver.LookupNetIPStream(context.Background(), fake, "tcp", "example.com")
+
+		require.Equal(t, []resolver.StreamResult{
+			{Addrs: []netip.Addr{netip.MustParseAddr("2001:db8::1")}},
+			{Addrs: []netip.Addr{netip.MustParseAddr("10.0.0.1")}},
+		}, collect(results))
+	})
+
+	t.Run("One Family", func(t *testing.T) {
+		fake := resolvertest.NewFake()
+		fake.Script("example.com", dns.TypeAAAA, resolvertest.Response{
+			Addrs: []netip.Addr{netip.MustParseAddr("2001:db8::1")},
+		})
+
+		results := resolver.LookupNetIPStream(context.Background(), fake, "ip", "example.com")
+
+		// The IPv4 lookup failing doesn't fail the lookup.
+		require.Equal(t, []resolver.StreamResult{
+			{Addrs: []netip.Addr{netip.MustParseAddr("2001:db8::1")}},
+		}, collect(results))
+
+		results = resolver.LookupNetIPStream(context.Background(), fake, "ip6", "example.com")
+
+		require.Equal(t, []resolver.StreamResult{
+			{Addrs: []netip.Addr{netip.MustParseAddr("2001:db8::1")}},
+		}, collect(results))
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		results := collect(resolver.LookupNetIPStream(context.Background(), resolvertest.NewFake(), "ip", "example.com"))
+		require.Len(t, results, 1)
+		require.Empty(t, results[0].Addrs)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, results[0].Err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("Unsupported Network", func(t *testing.T) {
+		results := collect(resolver.LookupNetIPStream(context.Background(), resolvertest.NewFake(), "unix", "example.com"))
+		require.Len(t, results, 1)
+		require.Error(t, results[0].Err)
+	})
+}
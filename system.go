@@ -15,41 +15,241 @@ import (
 	"net"
 	"net/netip"
 	"os"
+	"runtime"
+	"slices"
 	"time"
 
+	"github.com/noisysockets/resolver/internal/defaults"
 	"github.com/noisysockets/resolver/internal/dnsconfig"
-	"github.com/noisysockets/util/defaults"
+	"github.com/noisysockets/resolver/internal/gaiconf"
 	"github.com/noisysockets/util/ptr"
 )
 
+// SystemResolverMode is the libc whose stub resolver behavior is emulated by
+// a system resolver.
+type SystemResolverMode string
+
+const (
+	// SystemResolverModeGlibc queries the servers one at a time, moving on to
+	// the next server on failure (with glibc's retransmission schedule).
+	SystemResolverModeGlibc SystemResolverMode = "glibc"
+	// SystemResolverModeMusl queries all of the servers in parallel and
+	// accepts the first answer, retrying every timeout/attempts (as musl libc
+	// does). This is the latency profile expected by Alpine based containers.
+	// The rotate, single-request, use-vc, and sortlist options are ignored, as
+	// they are by musl.
+	SystemResolverModeMusl SystemResolverMode = "musl"
+)
+
+// ConfigDiagnostic is a problem found while parsing the system's DNS
+// configuration, eg. a name server or option that was ignored.
+type ConfigDiagnostic = dnsconfig.Diagnostic
+
 // SystemResolverConfig is the configuration for a system resolver.
 type SystemResolverConfig struct {
 	// HostsFilePath is the optional path to the hosts file.
 	// By default, the system's hosts file is used.
 	HostsFilePath string
+	// ResolvConfPath is the optional path to the resolv.conf file.
+	// By default, the system's DNS configuration is used.
+	ResolvConfPath string
+	// Mode is the libc whose stub resolver behavior is emulated.
+	// By default, glibc.
+	Mode *SystemResolverMode
+	// GAIConfPath is the optional path to the gai.conf file, used to
+	// customize the RFC 6724 address selection policy table (as glibc does).
+	// By default, the system's gai.conf file is used (on Linux).
+	GAIConfPath string
 	// DialContext is used to establish a connection to a DNS server.
 	DialContext DialContextFunc
+	// Dialers are optional per transport dialers, overriding DialContext for
+	// the transport used to query the system's DNS servers (and source
+	// address probes).
+	Dialers *TransportDialers
+	// AddressOrder is the policy used to order the returned addresses.
+	// By default, addresses are sorted according to RFC 6724. Use
+	// AddressOrderNone to avoid the UDP socket probes used by RFC 6724 sorting
+	// (eg. in sandboxes that forbid them).
+	AddressOrder *AddressOrder
+	// PolicyTable is an optional RFC 6724 address selection policy table used
+	// when sorting addresses. By default, the table from gai.conf (if present)
+	// or RFC 6724 is used.
+	PolicyTable []PolicyTableEntry
+	// SourceAddrProvider is an optional provider of the source addresses used
+	// when sorting addresses according to RFC 6724. By default, source
+	// addresses are probed using DialContext (if provided) or selected from
+	// the host's interface addresses.
+	SourceAddrProvider SourceAddrProvider
+	// QueryLog is an optional log that records every query sent to the
+	// system's DNS servers.
+	QueryLog *QueryLog
+	// TCPFallback retries queries that fail without a response from a server
+	// (eg. a timeout) over TCP, before moving on to the next server.
+	// By default, disabled.
+	TCPFallback *bool
+	// QueryLimiter is an optional limiter of the total number of concurrent
+	// queries sent to the system's DNS servers, it may be shared with other
+	// resolvers.
+	QueryLimiter *QueryLimiter
+	// WorkerPool is an optional pool of workers used to send the queries of
+	// a lookup concurrently, it may be shared with other resolvers to cap the
+	// total number of goroutines (see DNSResolverConfig.WorkerPool).
+	WorkerPool *WorkerPool
+	// Events is an optional event distributor, that is notified when a DNS
+	// server becomes unhealthy (or healthy again), when DNS over HTTPS falls
+	// back to unencrypted DNS, or of malformed lines in the hosts file.
+	Events *Events
+	// NoHosts disables the hosts file resolver, eg. in containers where the
+	// hosts file is wrong. By default, the hosts file is used.
+	NoHosts *bool
+	// NoLiteral disables the resolution of IP literals (and "localhost")
+	// before querying the system's DNS servers. By default, IP literals are
+	// resolved.
+	NoLiteral *bool
+	// DNSOnly is shorthand for NoHosts and NoLiteral, only the system's DNS
+	// servers (with the search and ndots options of the system's DNS
+	// configuration) are used.
+	DNSOnly *bool
+	// Search, if not empty, replaces the search domains of the system's DNS
+	// configuration.
+	Search []string
+	// ExtraSearch are search domains appended to those of the system's DNS
+	// configuration (or Search), eg. the domain of an overlay network.
+	ExtraSearch []string
+	// NDots overrides the ndots option of the system's DNS configuration, the
+	// number of dots a name must contain to be tried as an absolute name
+	// before the search domains are applied.
+	NDots *int
+	// SearchConcurrency is the maximum number of search domain expansions of a
+	// name looked up concurrently. By default, 1 (expansions are looked up one
+	// at a time, like libc does).
+	SearchConcurrency *int
+	// SearchMissTTL is how long not found results of search domain expansions
+	// are cached. By default, 0 (search misses are not cached).
+	SearchMissTTL *time.Duration
+	// QueryHook is an optional hook that can rewrite or veto each name tried
+	// when applying the search domains, eg. NoSearchForTLDs.
+	QueryHook QueryHook
+	// MaxServers is an optional limit of the number of name servers used, eg.
+	// 3 to only use the servers that libc would. By default, every name
+	// server in the system's DNS configuration is used.
+	MaxServers *int
+	// OnConfigDiagnostic is an optional callback invoked with each problem
+	// found while parsing the system's DNS configuration (malformed lines and
+	// options are ignored rather than failing the resolver), so operators can
+	// learn why their configuration isn't taking effect.
+	OnConfigDiagnostic func(ConfigDiagnostic)
+	// RA is an optional resolver for the DNS servers and search domains
+	// advertised by IPv6 routers (see RA), eg. as supplied by a userspace
+	// network stack. It's consulted (with its own search domains) when the
+	// system's DNS servers can't answer, eg. on IPv6-only networks without
+	// any configured name servers.
+	RA *RAResolver
+	// DHCP is an optional resolver for the DNS servers and search domains
+	// provided by DHCP leases (see DHCP), eg. as supplied by a userspace
+	// network stack that handles DHCP itself. Like RA, it's consulted when
+	// the system's DNS servers can't answer (before RA).
+	DHCP *DHCPResolver
 }
 
 // System returns a Resolver that uses the system's default DNS configuration.
 func System(conf *SystemResolverConfig) (Resolver, error) {
+	return system(conf, nil)
+}
+
+// system returns a system resolver, adjust (if not nil) is called to modify
+// the system's DNS configuration before it is used.
+func system(conf *SystemResolverConfig, adjust func(*dnsconfig.Config)) (Resolver, error) {
+	// Resolved before applying defaults, as a caller supplied dialer changes
+	// how source addresses are determined.
+	var srcAddrs SourceAddrProvider
+	if conf != nil {
+		srcAddrs = sourceAddrProviderFor(conf.SourceAddrProvider, conf.Dialers.probe(conf.DialContext))
+	} else {
+		srcAddrs = InterfaceSourceAddrProvider()
+	}
+
 	conf, err := defaults.WithDefaults(conf, &SystemResolverConfig{
-		DialContext: (&net.Dialer{}).DialContext,
+		DialContext:    (&net.Dialer{}).DialContext,
+		GAIConfPath:    gaiconf.Location,
+		ResolvConfPath: dnsconfig.Location,
+		Mode:           ptr.To(SystemResolverModeGlibc),
+		AddressOrder:   ptr.To(AddressOrderRFC6724),
+		NoHosts:        ptr.To(false),
+		NoLiteral:      ptr.To(false),
+		DNSOnly:        ptr.To(false),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to apply defaults to system resolver config: %w", err)
 	}
+	conf.SourceAddrProvider = srcAddrs
 
-	systemDNSConf, err := dnsconfig.Read(dnsconfig.Location)
+	if *conf.Mode != SystemResolverModeGlibc && *conf.Mode != SystemResolverModeMusl {
+		return nil, fmt.Errorf("invalid system resolver mode %q", *conf.Mode)
+	}
+	musl := *conf.Mode == SystemResolverModeMusl
+
+	if len(conf.PolicyTable) == 0 {
+		policyTable, err := gaiconf.Read(conf.GAIConfPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gai.conf: %w", err)
+		}
+
+		conf.PolicyTable = policyTable
+	}
+
+	systemDNSConf, err := dnsconfig.Read(conf.ResolvConfPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read system DNS configuration: %w", err)
 	}
 
+	if conf.OnConfigDiagnostic != nil {
+		for _, diagnostic := range systemDNSConf.Diagnostics {
+			conf.OnConfigDiagnostic(diagnostic)
+		}
+	}
+
+	// Unlike libc, use the servers beyond the standard limit of 3 (unless
+	// limited), rather than silently dropping them.
+	systemDNSConf.Servers = append(systemDNSConf.Servers, systemDNSConf.ExtraServers...)
+	systemDNSConf.ExtraServers = nil
+
+	if conf.MaxServers != nil {
+		if *conf.MaxServers < 1 {
+			return nil, fmt.Errorf("max servers must be positive")
+		}
+
+		if len(systemDNSConf.Servers) > *conf.MaxServers {
+			systemDNSConf.Servers = systemDNSConf.Servers[:*conf.MaxServers]
+		}
+	}
+
+	if adjust != nil {
+		adjust(systemDNSConf)
+	}
+
+	if musl {
+		systemDNSConf.Rotate = false
+		systemDNSConf.SingleRequest = false
+		systemDNSConf.UseTCP = false
+		systemDNSConf.SortList = nil
+	}
+
 	transport := DNSTransportUDP
 	if systemDNSConf.UseTCP {
 		transport = DNSTransportTCP
 	}
 
+	attempts := max(systemDNSConf.Attempts, 1)
+
+	// Bounded by the retransmission schedule, so allow for the longest
+	// timeout of the schedule.
+	timeout := systemDNSConf.RetransmitTimeout(attempts - 1)
+	if musl {
+		// musl treats the timeout as the total time to wait for an answer.
+		timeout = systemDNSConf.Timeout / time.Duration(attempts)
+	}
+
 	var resolvers []Resolver
 	for _, server := range systemDNSConf.Servers {
 		addrPort, err := netip.ParseAddrPort(server)
@@ -57,50 +257,177 @@ func System(conf *SystemResolverConfig) (Resolver, error) {
 			return nil, fmt.Errorf("failed to parse server address %q: %w", server, err)
 		}
 
-		var timeout *time.Duration
-		if systemDNSConf.Timeout > 0 {
-			timeout = &systemDNSConf.Timeout
+		dnsConf := DNSResolverConfig{
+			Server:             addrPort,
+			Transport:          &transport,
+			Timeout:            &timeout,
+			DialContext:        conf.DialContext,
+			Dialers:            conf.Dialers,
+			AddressOrder:       conf.AddressOrder,
+			PolicyTable:        conf.PolicyTable,
+			SourceAddrProvider: conf.SourceAddrProvider,
+			SingleRequest:      &systemDNSConf.SingleRequest,
+			TrustAD:            &systemDNSConf.TrustAD,
+			QueryLog:           conf.QueryLog,
+			QueryLimiter:       conf.QueryLimiter,
+			WorkerPool:         conf.WorkerPool,
+			TCPFallback:        conf.TCPFallback,
+		}
+
+		var dnsResolver Resolver
+		dnsResolver, err = DNS(dnsConf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dns resolver for %q: %w", server, err)
+		}
+
+		// Windows may be configured to use DNS over HTTPS for the server.
+		if doh, ok := systemDNSConf.DoH[server]; ok {
+			dnsResolver, err = systemDoH(dnsConf, doh, dnsResolver, conf.Events)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create dns over https resolver for %q: %w", server, err)
+			}
+		}
+
+		if musl {
+			resolvers = append(resolvers, dnsResolver)
+			continue
+		}
+
+		penaltyBoxResolver, err := PenaltyBox(dnsResolver, &PenaltyBoxResolverConfig{
+			Events: conf.Events,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create penalty box resolver: %w", err)
 		}
 
-		resolvers = append(resolvers, DNS(DNSResolverConfig{
-			Server:        addrPort,
-			Transport:     &transport,
-			Timeout:       timeout,
-			DialContext:   conf.DialContext,
-			SingleRequest: &systemDNSConf.SingleRequest,
-		}))
+		resolvers = append(resolvers, penaltyBoxResolver)
 	}
 
 	var resolver Resolver
-	if systemDNSConf.Rotate {
-		resolver = RoundRobin(resolvers...)
+	if musl {
+		resolver = newRetransmitResolver([]Resolver{Parallel(resolvers...)}, attempts,
+			false, func(int) time.Duration { return timeout })
 	} else {
-		resolver = Sequential(resolvers...)
+		// Attempts are counted per set of servers, with glibc's retransmission
+		// schedule.
+		resolver = newRetransmitResolver(resolvers, attempts,
+			systemDNSConf.Rotate, systemDNSConf.RetransmitTimeout)
 	}
 
-	// TODO: I'm pretty sure that glibc counts attempts differently, eg. not on a
-	// per nameserver basis.
-	var attempts *int
-	if systemDNSConf.Attempts > 0 {
-		attempts = &systemDNSConf.Attempts
+	search := systemDNSConf.Search
+	if len(conf.Search) > 0 {
+		search = conf.Search
 	}
+	search = append(slices.Clone(search), conf.ExtraSearch...)
 
-	resolver = Retry(resolver, &RetryResolverConfig{
-		Attempts: attempts,
-	})
-
-	if len(systemDNSConf.Search) > 0 {
-		var nDots *int
-		if systemDNSConf.NDots >= 0 {
+	if len(search) > 0 {
+		nDots := conf.NDots
+		if nDots == nil && systemDNSConf.NDots >= 0 {
 			nDots = ptr.To(systemDNSConf.NDots)
 		}
 
-		resolver = Relative(resolver, &RelativeResolverConfig{
-			Search: systemDNSConf.Search,
-			NDots:  nDots,
+		resolver, err = Relative(resolver, &RelativeResolverConfig{
+			Search:            search,
+			NDots:             nDots,
+			SearchConcurrency: conf.SearchConcurrency,
+			SearchMissTTL:     conf.SearchMissTTL,
+			QueryHook:         conf.QueryHook,
 		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create relative resolver: %w", err)
+		}
+	}
+
+	// Resolvers for the DNS configuration supplied by the embedder's network
+	// stack.
+	if conf.DHCP != nil || conf.RA != nil {
+		chain := []Resolver{resolver}
+		if conf.DHCP != nil {
+			chain = append(chain, conf.DHCP)
+		}
+		if conf.RA != nil {
+			chain = append(chain, conf.RA)
+		}
+
+		resolver = SequentialWithConfig(&SequentialResolverConfig{
+			Failover: FailoverOnServerError,
+		}, chain...)
+	}
+
+	if len(systemDNSConf.SortList) > 0 {
+		resolver = TransformAnswers(resolver, func(_ string, addrs []netip.Addr) []netip.Addr {
+			systemDNSConf.ApplySortList(addrs)
+			return addrs
+		})
+	}
+
+	// The hosts file is consulted before DNS, except on OpenBSD where the
+	// order is configurable (using the lookup option).
+	sources := []string{"file", "bind"}
+	if runtime.GOOS == "openbsd" {
+		sources = systemDNSConf.LookupSources()
 	}
 
+	var chain []Resolver
+	if !*conf.NoLiteral && !*conf.DNSOnly {
+		chain = append(chain, Literal())
+	}
+
+	for _, source := range sources {
+		switch source {
+		case "file":
+			if *conf.NoHosts || *conf.DNSOnly {
+				continue
+			}
+
+			hostsResolver, err := systemHosts(conf)
+			if err != nil {
+				return nil, err
+			}
+
+			chain = append(chain, hostsResolver)
+		case "bind":
+			chain = append(chain, resolver)
+		}
+	}
+
+	switch len(chain) {
+	case 0:
+		return nil, fmt.Errorf("no host lookup sources configured")
+	case 1:
+		return chain[0], nil
+	default:
+		return Sequential(chain...), nil
+	}
+}
+
+// systemDoH returns a resolver that queries the server of dnsConf using DNS
+// over HTTPS, falling back to the unencrypted resolver only if the server's
+// settings allow it.
+func systemDoH(dnsConf DNSResolverConfig, doh dnsconfig.DoHServer, unencrypted Resolver, events *Events) (Resolver, error) {
+	dnsConf.Server = netip.AddrPortFrom(dnsConf.Server.Addr(), 0)
+	dnsConf.Transport = ptr.To(DNSTransportHTTPS)
+	dnsConf.URL = doh.Template
+	dnsConf.TCPFallback = nil
+
+	dohResolver, err := DNS(dnsConf)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := EncryptedDNSModeStrict
+	if doh.Fallback {
+		mode = EncryptedDNSModeOpportunistic
+	}
+
+	return Encrypted(dohResolver, unencrypted, &EncryptedResolverConfig{
+		Mode:   &mode,
+		Events: events,
+	})
+}
+
+// systemHosts returns the hosts file resolver of a system resolver.
+func systemHosts(conf *SystemResolverConfig) (*HostsResolver, error) {
 	var hostsFileReader io.Reader
 	if conf.HostsFilePath != "" {
 		f, err := os.Open(conf.HostsFilePath)
@@ -113,11 +440,16 @@ func System(conf *SystemResolverConfig) (Resolver, error) {
 	}
 
 	hostsResolver, err := Hosts(&HostsResolverConfig{
-		HostsFileReader: hostsFileReader,
+		HostsFileReader:    hostsFileReader,
+		DialContext:        conf.Dialers.probe(conf.DialContext),
+		AddressOrder:       conf.AddressOrder,
+		PolicyTable:        conf.PolicyTable,
+		SourceAddrProvider: conf.SourceAddrProvider,
+		Events:             conf.Events,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create hosts file resolver: %w", err)
 	}
 
-	return Sequential(Literal(), hostsResolver, resolver), nil
+	return hostsResolver, nil
 }
@@ -11,10 +11,18 @@ package resolver_test
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/noisysockets/util/ptr"
 	"github.com/stretchr/testify/require"
 )
 
@@ -64,3 +72,237 @@ func TestSystemResolver(t *testing.T) {
 		require.ElementsMatch(t, expected, addrs)
 	})
 }
+
+func TestSystemResolverLayers(t *testing.T) {
+	hasType := func(d resolver.Description, typ string) bool {
+		for _, line := range strings.Split(d.String(), "\n") {
+			if strings.Fields(line)[0] == typ {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		res, err := resolver.System(&resolver.SystemResolverConfig{
+			HostsFilePath: "testdata/hosts",
+		})
+		require.NoError(t, err)
+
+		d := resolver.Describe(res)
+		require.True(t, hasType(d, "literal"))
+		require.True(t, hasType(d, "hosts"))
+	})
+
+	t.Run("No Hosts", func(t *testing.T) {
+		res, err := resolver.System(&resolver.SystemResolverConfig{
+			NoHosts: ptr.To(true),
+		})
+		require.NoError(t, err)
+
+		d := resolver.Describe(res)
+		require.True(t, hasType(d, "literal"))
+		require.False(t, hasType(d, "hosts"))
+
+		addrs, err := res.LookupNetIP(context.Background(), "ip", "10.0.0.1")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+	})
+
+	t.Run("DNS Only", func(t *testing.T) {
+		res, err := resolver.System(&resolver.SystemResolverConfig{
+			DNSOnly: ptr.To(true),
+		})
+		require.NoError(t, err)
+
+		d := resolver.Describe(res)
+		require.False(t, hasType(d, "literal"))
+		require.False(t, hasType(d, "hosts"))
+		require.True(t, hasType(d, "dns"))
+		require.True(t, hasType(d, "retransmit"))
+	})
+}
+
+func TestSystemResolverSearch(t *testing.T) {
+	res, err := resolver.System(&resolver.SystemResolverConfig{
+		Search:      []string{"corp.example."},
+		ExtraSearch: []string{"wg.internal."},
+		NDots:       ptr.To(2),
+	})
+	require.NoError(t, err)
+
+	d := resolver.Describe(res)
+	for d.Type != "relative" {
+		require.NotEmpty(t, d.Children)
+		d = d.Children[len(d.Children)-1]
+	}
+
+	require.Equal(t, "corp.example. wg.internal.", d.Attributes["search"])
+	require.Equal(t, "2", d.Attributes["ndots"])
+}
+
+func TestSystemResolverResolvConfSearch(t *testing.T) {
+	srv := resolvertest.NewServer(t, nil)
+	srv.AddAddrs("api.default.svc.cluster.local.", time.Minute, netip.MustParseAddr("10.0.0.1"))
+
+	res, err := resolver.System(&resolver.SystemResolverConfig{
+		ResolvConfPath: "testdata/kubernetes-resolv.conf",
+		DNSOnly:        ptr.To(true),
+		AddressOrder:   ptr.To(resolver.AddressOrderNone),
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, srv.Addr().String())
+		},
+	})
+	require.NoError(t, err)
+
+	d := resolver.Describe(res)
+	for d.Type != "relative" {
+		require.NotEmpty(t, d.Children)
+		d = d.Children[len(d.Children)-1]
+	}
+
+	require.Equal(t, "default.svc.cluster.local. svc.cluster.local. cluster.local. example.internal.", d.Attributes["search"])
+
+	// The short name is resolved using the search list of resolv.conf.
+	addrs, err := res.LookupNetIP(context.Background(), "ip4", "api")
+	require.NoError(t, err)
+
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, addrs)
+}
+
+func TestSystemResolverGAIConf(t *testing.T) {
+	newResolver := func(t *testing.T, gaiConfPath string) resolver.Resolver {
+		res, err := resolver.System(&resolver.SystemResolverConfig{
+			HostsFilePath: "testdata/hosts",
+			GAIConfPath:   gaiConfPath,
+			SourceAddrProvider: staticSourceAddrs{
+				netip.MustParseAddr("192.168.1.10"): netip.MustParseAddr("192.168.1.100"),
+				netip.MustParseAddr("2001:db8::1"):  netip.MustParseAddr("2001:db8::100"),
+			},
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		emptyGAIConfPath := filepath.Join(t.TempDir(), "gai.conf")
+		require.NoError(t, os.WriteFile(emptyGAIConfPath, nil, 0o644))
+
+		addrs, err := newResolver(t, emptyGAIConfPath).LookupNetIP(context.Background(), "ip", "testserver.local")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("2001:db8::1"),
+			netip.MustParseAddr("192.168.1.10"),
+		}, addrs)
+	})
+
+	t.Run("Prefer IPv4", func(t *testing.T) {
+		addrs, err := newResolver(t, "testdata/prefer-ipv4-gai.conf").LookupNetIP(context.Background(), "ip", "testserver.local")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("192.168.1.10"),
+			netip.MustParseAddr("2001:db8::1"),
+		}, addrs)
+	})
+}
+
+func TestSystemResolverMusl(t *testing.T) {
+	srv := resolvertest.NewServer(t, nil)
+	srv.AddAddrs("example.com.", time.Minute, netip.MustParseAddr("93.184.216.34"))
+
+	// The first server never answers.
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, silent.Close())
+	})
+
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if strings.HasPrefix(address, "192.0.2.1:") {
+			address = silent.LocalAddr().String()
+		} else {
+			address = srv.Addr().String()
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+
+	res, err := resolver.System(&resolver.SystemResolverConfig{
+		ResolvConfPath: "testdata/musl-resolv.conf",
+		Mode:           ptr.To(resolver.SystemResolverModeMusl),
+		DNSOnly:        ptr.To(true),
+		AddressOrder:   ptr.To(resolver.AddressOrderNone),
+		DialContext:    dialContext,
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com")
+	require.NoError(t, err)
+
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("93.184.216.34")}, addrs)
+
+	// Answered without waiting for the first server to time out.
+	require.Less(t, time.Since(start), time.Second)
+
+	_, err = resolver.System(&resolver.SystemResolverConfig{
+		ResolvConfPath: "testdata/musl-resolv.conf",
+		Mode:           ptr.To(resolver.SystemResolverMode("uclibc")),
+	})
+	require.Error(t, err)
+}
+
+func TestSystemResolverMaxServers(t *testing.T) {
+	srv := resolvertest.NewServer(t, nil)
+	srv.AddAddrs("example.com.", time.Minute, netip.MustParseAddr("93.184.216.34"))
+
+	// Only the fourth name server (ignored by libc) is reachable.
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if !strings.HasPrefix(address, "10.96.0.13:") {
+			return nil, errors.New("unreachable")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Addr().String())
+	}
+
+	res, err := resolver.System(&resolver.SystemResolverConfig{
+		ResolvConfPath: "testdata/kubernetes-resolv.conf",
+		DNSOnly:        ptr.To(true),
+		AddressOrder:   ptr.To(resolver.AddressOrderNone),
+		DialContext:    dialContext,
+	})
+	require.NoError(t, err)
+
+	addrs, err := res.LookupNetIP(context.Background(), "ip4", "example.com.")
+	require.NoError(t, err)
+
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("93.184.216.34")}, addrs)
+
+	res, err = resolver.System(&resolver.SystemResolverConfig{
+		ResolvConfPath: "testdata/kubernetes-resolv.conf",
+		DNSOnly:        ptr.To(true),
+		AddressOrder:   ptr.To(resolver.AddressOrderNone),
+		DialContext:    dialContext,
+		MaxServers:     ptr.To(3),
+	})
+	require.NoError(t, err)
+
+	_, err = res.LookupNetIP(context.Background(), "ip4", "example.com.")
+	require.Error(t, err)
+}
+
+func TestSystemResolverConfigDiagnostics(t *testing.T) {
+	var diagnostics []resolver.ConfigDiagnostic
+	_, err := resolver.System(&resolver.SystemResolverConfig{
+		ResolvConfPath: "testdata/kubernetes-resolv.conf",
+		DNSOnly:        ptr.To(true),
+		OnConfigDiagnostic: func(diagnostic resolver.ConfigDiagnostic) {
+			diagnostics = append(diagnostics, diagnostic)
+		},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, diagnostics, 1)
+	require.Equal(t, "line 4: nameserver 10.96.0.13 is beyond the limit of 3 name servers (ignored by libc)", diagnostics[0].String())
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"github.com/miekg/dns"
+	"github.com/noisysockets/util/address"
+)
+
+var _ Resolver = (*transformResolver)(nil)
+
+// TransformFunc post-processes the addresses resolved for host. It may
+// rewrite, remove, or add addresses, and must not retain addrs.
+type TransformFunc func(host string, addrs []netip.Addr) []netip.Addr
+
+// transformResolver is a resolver that post-processes the answers of another
+// resolver.
+type transformResolver struct {
+	resolver  Resolver
+	transform TransformFunc
+}
+
+// TransformAnswers returns a resolver that passes the addresses resolved by the
+// provided resolver through transform, eg. for NAT translation, or to drop
+// private addresses from public lookups (see DropPrivateAddrs). Addresses that
+// don't match the requested network are removed from the result, and if no
+// addresses remain the host is reported as not found.
+func TransformAnswers(resolver Resolver, transform TransformFunc) *transformResolver {
+	return &transformResolver{
+		resolver:  resolver,
+		transform: transform,
+	}
+}
+
+func (r *transformResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, err := r.resolver.LookupNetIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+
+	network, _ = ipNetwork(network)
+	addrs = address.FilterByNetwork(r.transform(host, addrs), network)
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{
+			Err:        ErrNoSuchHost.Error(),
+			Name:       host,
+			IsNotFound: true,
+		}
+	}
+
+	return addrs, nil
+}
+
+// LookupAddr performs a reverse lookup, reverse lookups are not transformed.
+func (r *transformResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return lookupAddr(ctx, r.resolver, addr)
+}
+
+// Lookup answers the question, only address (A and AAAA) answers are
+// transformed.
+func (r *transformResolver) Lookup(ctx context.Context, q Question) (Answer, error) {
+	if q.Type == dns.TypeA || q.Type == dns.TypeAAAA {
+		return lookupBasic(ctx, r, q)
+	}
+
+	return Lookup(ctx, r.resolver, q)
+}
+
+func (r *transformResolver) Describe() Description {
+	return Description{
+		Type:     "transform",
+		Children: []Description{Describe(r.resolver)},
+	}
+}
+
+// DropPrivateAddrs is a TransformFunc that removes private (RFC 1918 and
+// RFC 4193), loopback, link-local, and unspecified addresses. It protects
+// against DNS rebinding attacks, where a public name resolves to an address
+// on the local network.
+func DropPrivateAddrs(_ string, addrs []netip.Addr) []netip.Addr {
+	public := addrs[:0:0]
+	for _, addr := range addrs {
+		if a := addr.Unmap(); a.IsPrivate() || a.IsLoopback() || a.IsLinkLocalUnicast() || a.IsUnspecified() {
+			continue
+		}
+		public = append(public, addr)
+	}
+
+	return public
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/noisysockets/resolver"
+	"github.com/noisysockets/resolver/resolvertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformAnswers(t *testing.T) {
+	ctx := context.Background()
+
+	upstream := resolvertest.NewFake()
+	upstream.SetAddrs("example.com", netip.MustParseAddr("93.184.216.34"), netip.MustParseAddr("192.168.1.1"))
+	upstream.SetAddrs("rebind.example.com", netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("fe80::1"))
+
+	t.Run("Drop Private", func(t *testing.T) {
+		res := resolver.TransformAnswers(upstream, resolver.DropPrivateAddrs)
+
+		addrs, err := res.LookupNetIP(ctx, "ip", "example.com")
+		require.NoError(t, err)
+
+		require.Equal(t, []netip.Addr{netip.MustParseAddr("93.184.216.34")}, addrs)
+
+		_, err = res.LookupNetIP(ctx, "ip", "rebind.example.com")
+		require.Error(t, err)
+
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("NAT", func(t *testing.T) {
+		res := resolver.TransformAnswers(upstream, func(host string, addrs []netip.Addr) []netip.Addr {
+			translated := make([]netip.Addr, 0, len(addrs))
+			for _, addr := range addrs {
+				if addr == netip.MustParseAddr("192.168.1.1") {
+					addr = netip.MustParseAddr("100.64.1.1")
+				}
+				translated = append(translated, addr, netip.MustParseAddr("2001:db8::1"))
+			}
+			return translated
+		})
+
+		addrs, err := res.LookupNetIP(ctx, "ip4", "example.com")
+		require.NoError(t, err)
+
+		// Injected addresses of the wrong family are removed.
+		require.Equal(t, []netip.Addr{
+			netip.MustParseAddr("93.184.216.34"),
+			netip.MustParseAddr("100.64.1.1"),
+		}, addrs)
+	})
+}
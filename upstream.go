@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"context"
+	"net/netip"
+)
+
+type upstreamKey struct{}
+
+// WithUpstream returns a context that forces DNS resolvers to send the queries
+// of lookups performed with it to upstream (rather than their configured
+// server), eg. for diagnostics or "query this server" tools. The transport,
+// TLS settings and other options of each resolver are reused, and so are the
+// connections and in-flight limits of each upstream, eg.
+//
+//	ctx = resolver.WithUpstream(ctx, netip.MustParseAddrPort("9.9.9.9:853"))
+//	addrs, err := res.LookupNetIP(ctx, "ip", host)
+//
+// Note that every DNS resolver in the chain queries upstream, and that
+// lookups answered without querying a server (eg. from a cache or the hosts
+// file) are unaffected. If the port is zero, the default port of the
+// resolver's transport is used.
+func WithUpstream(ctx context.Context, upstream netip.AddrPort) context.Context {
+	return context.WithValue(ctx, upstreamKey{}, upstream)
+}
+
+// upstreamFromContext returns the upstream override of ctx (if any).
+func upstreamFromContext(ctx context.Context) (netip.AddrPort, bool) {
+	upstream, ok := ctx.Value(upstreamKey{}).(netip.AddrPort)
+	return upstream, ok
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (C) 2024 The Noisy Sockets Authors.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolver
+
+import (
+	"sync/atomic"
+)
+
+// WorkerPool limits the number of goroutines spawned to parallelize lookups
+// (eg. to send the A and AAAA queries of a lookup concurrently), across all
+// of the resolvers it is shared with. Once every worker is busy, the queries
+// of further lookups are sent sequentially by the goroutine performing the
+// lookup instead. Lookups never wait for a worker (so nested lookups can't
+// deadlock), a burst of lookups just loses its parallelism.
+type WorkerPool struct {
+	max  int
+	busy atomic.Int64
+}
+
+// NewWorkerPool returns a pool that allows at most max concurrent workers.
+// A max less than one is treated as one.
+func NewWorkerPool(max int) *WorkerPool {
+	if max < 1 {
+		max = 1
+	}
+
+	return &WorkerPool{
+		max: max,
+	}
+}
+
+// Max returns the maximum number of concurrent workers.
+func (p *WorkerPool) Max() int {
+	return p.max
+}
+
+// Busy returns the number of workers currently running.
+func (p *WorkerPool) Busy() int {
+	return int(p.busy.Load())
+}
+
+// tryAcquire reserves a worker, returning false if every worker is busy. A
+// nil pool has an unlimited number of workers.
+func (p *WorkerPool) tryAcquire() bool {
+	if p == nil {
+		return true
+	}
+
+	for {
+		busy := p.busy.Load()
+		if busy >= int64(p.max) {
+			return false
+		}
+
+		if p.busy.CompareAndSwap(busy, busy+1) {
+			return true
+		}
+	}
+}
+
+// release returns a worker reserved with tryAcquire.
+func (p *WorkerPool) release() {
+	if p != nil {
+		p.busy.Add(-1)
+	}
+}